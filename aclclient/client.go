@@ -1,7 +1,13 @@
 package aclclient
 
 import (
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
 
 	errgo "gopkg.in/errgo.v1"
 	"gopkg.in/httprequest.v1"
@@ -9,11 +15,27 @@ import (
 	"github.com/juju/aclstore/v2/params"
 )
 
+// ErrConflict is the error cause returned by SetIfUnchanged when
+// the ACL has been changed since its version token was obtained.
+var ErrConflict = errgo.Newf("ACL has been modified")
+
+// ErrExists is the error cause returned by Create when
+// the ACL already exists and failIfExists is true.
+var ErrExists = errgo.Newf("ACL already exists")
+
+// ErrTruncated is the error cause returned by ListMembers when
+// only some of the matching ACLs were returned.
+var ErrTruncated = errgo.Newf("response truncated")
+
 //go:generate httprequest-generate-client github.com/juju/aclstore/v2 handler1 client
 
 // Client represents an ACL store client.
 type Client struct {
 	client
+
+	// usersField holds the name of the JSON field
+	// that holds the users of an ACL.
+	usersField string
 }
 
 // NewParams holds the parameters for creating a new client.
@@ -22,19 +44,71 @@ type NewParams struct {
 	BaseURL string
 	// Doer is used to make HTTP requests to the ACL store.
 	Doer httprequest.Doer
+	// UsersField holds the name of the JSON field that holds the
+	// users of an ACL, which must match the UsersField configured
+	// in the server's aclstore.HandlerParams. If it is empty,
+	// params.DefaultUsersField is used. It is used by the
+	// convenience methods, such as Get and Set, but not by the
+	// methods that take params types directly.
+	UsersField string
 }
 
 // New returns a new client.
 func New(p NewParams) *Client {
 	var c Client
 	c.Client.BaseURL = p.BaseURL
-	c.Client.Doer = p.Doer
+	c.Client.Doer = gzipDoer{p.Doer}
+	c.usersField = p.UsersField
 	return &c
 }
 
+// gzipDoer is an httprequest.Doer that requests gzip-compressed
+// responses and transparently decompresses them.
+type gzipDoer struct {
+	doer httprequest.Doer
+}
+
+// Do implements httprequest.Doer.Do.
+func (d gzipDoer) Do(req *http.Request) (*http.Response, error) {
+	doer := d.doer
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := doer.Do(req)
+	if err != nil || resp.Header.Get("Content-Encoding") != "gzip" {
+		return resp, err
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		resp.Body.Close()
+		return nil, errgo.Notef(err, "cannot decompress response")
+	}
+	resp.Body = gzipBody{
+		Reader: zr,
+		body:   resp.Body,
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// gzipBody is the body of a decompressed response.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+// Close implements io.Closer.
+func (b gzipBody) Close() error {
+	return b.body.Close()
+}
+
 // Get retrieves the contents of the given ACL.
 func (c *Client) Get(ctx context.Context, name string) ([]string, error) {
-	resp, err := c.GetACL(ctx, &params.GetACLRequest{
+	resp, err := c.getACL(ctx, &params.GetACLRequest{
 		Name: name,
 	})
 	if err != nil {
@@ -43,17 +117,159 @@ func (c *Client) Get(ctx context.Context, name string) ([]string, error) {
 	return resp.Users, nil
 }
 
+// getACL is like GetACL except that it decodes the response using
+// c.usersField and, if p.Version is zero, requests the latest
+// response envelope version.
+func (c *Client) getACL(ctx context.Context, p *params.GetACLRequest) (*params.GetACLResponse, error) {
+	if p.Version == 0 {
+		p.Version = params.LatestResponseVersion
+	}
+	r := &params.GetACLResponse{
+		UsersField: c.usersField,
+	}
+	if err := c.Client.Call(ctx, p, &r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetWithToken is like Get except that it also returns a version token
+// for the contents of the ACL that can be passed to SetIfUnchanged.
+// The token depends only on the members of the ACL, so it remains
+// valid across server restarts and with any server sharing the store.
+func (c *Client) GetWithToken(ctx context.Context, name string) (users []string, token string, err error) {
+	var httpResp *http.Response
+	err = c.Client.Call(ctx, &params.GetACLRequest{
+		Name: name,
+	}, &httpResp)
+	if err != nil {
+		return nil, "", errgo.Mask(err, isRemoteError)
+	}
+	defer httpResp.Body.Close()
+	resp := params.GetACLResponse{
+		UsersField: c.usersField,
+	}
+	if err := httprequest.UnmarshalJSONResponse(httpResp, &resp); err != nil {
+		return nil, "", errgo.Mask(err)
+	}
+	return resp.Users, httpResp.Header.Get("ETag"), nil
+}
+
+// GetWithManagers is like Get except that it also returns the members
+// of the ACL's meta-ACL, which determines who may manage it.
+// Only administrators may make this request.
+func (c *Client) GetWithManagers(ctx context.Context, name string) (users, managers []string, err error) {
+	resp, err := c.getACL(ctx, &params.GetACLRequest{
+		Name:     name,
+		WithMeta: true,
+	})
+	if err != nil {
+		return nil, nil, errgo.Mask(err, isRemoteError)
+	}
+	if resp.Managers == nil {
+		resp.Managers = []string{}
+	}
+	return resp.Users, resp.Managers, nil
+}
+
+// Managers returns the users that may change the membership of the
+// given ACL: the members of its meta-ACL and the administrators.
+func (c *Client) Managers(ctx context.Context, name string) ([]string, error) {
+	resp, err := c.GetManagers(ctx, &params.GetManagersRequest{
+		Name: name,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	return resp.Users, nil
+}
+
+// GetCSV retrieves the contents of the given ACL as CSV, with a header
+// row followed by a row for each member, as it would be downloaded for
+// use in a spreadsheet.
+func (c *Client) GetCSV(ctx context.Context, name string) ([]byte, error) {
+	var httpResp *http.Response
+	err := c.Client.Call(ctx, &params.GetACLCSVRequest{
+		Name: name,
+	}, &httpResp)
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	defer httpResp.Body.Close()
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot read CSV")
+	}
+	return data, nil
+}
+
+// SetIfUnchanged updates the contents of the given ACL to the given
+// user list only if the ACL has not been changed since the given
+// version token was returned by GetWithToken. If it has been changed,
+// it returns an error with an ErrConflict cause and the ACL is left
+// unchanged.
+func (c *Client) SetIfUnchanged(ctx context.Context, name string, users []string, token string) error {
+	if token == "" {
+		return errgo.Newf("empty version token")
+	}
+	err := c.SetACL(ctx, &params.SetACLRequest{
+		Name: name,
+		Body: params.SetACLRequestBody{
+			Users:      users,
+			UsersField: c.usersField,
+		},
+		IfMatch: token,
+	})
+	if rerr, ok := errgo.Cause(err).(*httprequest.RemoteError); ok && rerr.Code == params.CodePreconditionFailed {
+		return errgo.WithCausef(err, ErrConflict, "cannot set ACL %q", name)
+	}
+	return errgo.Mask(err, isRemoteError)
+}
+
+// Create creates the given ACL with the given initial members. If the
+// ACL already exists, it does nothing unless failIfExists is true, in
+// which case it returns an error with an ErrExists cause.
+func (c *Client) Create(ctx context.Context, name string, users []string, failIfExists bool) error {
+	err := c.CreateACL(ctx, &params.CreateACLRequest{
+		Name:         name,
+		FailIfExists: failIfExists,
+		Body: params.CreateACLRequestBody{
+			Users: users,
+		},
+	})
+	if rerr, ok := errgo.Cause(err).(*httprequest.RemoteError); ok && rerr.Code == params.CodeACLExists {
+		return errgo.WithCausef(err, ErrExists, "cannot create ACL %q", name)
+	}
+	return errgo.Mask(err, isRemoteError)
+}
+
 // Set updates the contents of the given ACL to the given user list.
 func (c *Client) Set(ctx context.Context, name string, users []string) error {
 	err := c.SetACL(ctx, &params.SetACLRequest{
 		Name: name,
 		Body: params.SetACLRequestBody{
-			Users: users,
+			Users:      users,
+			UsersField: c.usersField,
 		},
 	})
 	return errgo.Mask(err, isRemoteError)
 }
 
+// ModifyACL modifies the members of the ACL with the requested name.
+// The response is discarded; use ModifyACLReport to find out which
+// users were added or removed.
+func (c *Client) ModifyACL(ctx context.Context, p *params.ModifyACLRequest) error {
+	_, err := c.client.ModifyACL(ctx, p)
+	return err
+}
+
+// ModifyACLReport is like ModifyACL except that it returns the
+// response, which reports the number of users that were added and
+// the users that were removed.
+func (c *Client) ModifyACLReport(ctx context.Context, p *params.ModifyACLRequest) (*params.ModifyACLResponse, error) {
+	return c.client.ModifyACL(ctx, p)
+}
+
 // Add updates the contents of the given ACL to include the given user
 // list.
 func (c *Client) Add(ctx context.Context, name string, users []string) error {
@@ -78,6 +294,171 @@ func (c *Client) Remove(ctx context.Context, name string, users []string) error
 	return errgo.Mask(err, isRemoteError)
 }
 
+// Clear removes all the members of the given ACL. The ACL itself
+// continues to exist.
+func (c *Client) Clear(ctx context.Context, name string) error {
+	err := c.ModifyACL(ctx, &params.ModifyACLRequest{
+		Name:   name,
+		Action: params.ActionClear,
+	})
+	return errgo.Mask(err, isRemoteError)
+}
+
+// Import adds the users returned by next to the given ACL, and returns
+// the number of them that were not already members. The users are
+// streamed to the server as they are returned, so that very large
+// numbers of users may be added without holding them all in memory.
+// The next function is called from a separate goroutine and should
+// return io.EOF when there are no more users; any other error aborts
+// the import. The server adds the users in
+// batches, so if the import fails, some of them may have been added.
+func (c *Client) Import(ctx context.Context, name string, next func() (string, error)) (int, error) {
+	pr, pw := io.Pipe()
+	go func() {
+		enc := json.NewEncoder(pw)
+		for {
+			user, err := next()
+			if err == io.EOF {
+				pw.Close()
+				return
+			}
+			if err == nil {
+				// Encode writes a newline after each value.
+				err = enc.Encode(user)
+			}
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+	}()
+	// Closing the reader stops the writer if the request
+	// finishes before all the users have been read.
+	defer pr.Close()
+	req, err := http.NewRequest("POST", url.PathEscape(name)+"/import", pr)
+	if err != nil {
+		return 0, errgo.Mask(err)
+	}
+	req.Header.Set("Content-Type", params.NDJSONContentType)
+	var resp params.ImportMembersResponse
+	if err := c.Client.Do(ctx, req, &resp); err != nil {
+		return 0, errgo.Mask(err, isRemoteError)
+	}
+	return resp.Added, nil
+}
+
+// CountMembers returns the number of members of every ACL with a name
+// starting with the given prefix, keyed by ACL name.
+func (c *Client) CountMembers(ctx context.Context, prefix string) (map[string]int, error) {
+	resp, err := c.GetACLs(ctx, &params.GetACLsRequest{
+		Prefix: prefix,
+		Counts: true,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	counts := resp.Counts
+	if counts == nil {
+		counts = make(map[string]int)
+	}
+	return counts, nil
+}
+
+// Swap atomically replaces oldUser with newUser in the given ACL.
+// Unless force is true, it returns an error if oldUser is not a member
+// of the ACL.
+func (c *Client) Swap(ctx context.Context, name, oldUser, newUser string, force bool) error {
+	err := c.ModifyACL(ctx, &params.ModifyACLRequest{
+		Name:   name,
+		Action: params.ActionSwap,
+		Body: params.ModifyACLRequestBody{
+			OldUser: oldUser,
+			NewUser: newUser,
+			Force:   force,
+		},
+	})
+	return errgo.Mask(err, isRemoteError)
+}
+
+// AmIAdmin reports whether the authenticated caller
+// is an administrator of the ACL store.
+func (c *Client) AmIAdmin(ctx context.Context) (bool, error) {
+	resp, err := c.IsAdmin(ctx, &params.IsAdminRequest{})
+	if err != nil {
+		return false, errgo.Mask(err, isRemoteError)
+	}
+	return resp.Admin, nil
+}
+
+// AuthorizeUser reports whether the given user is allowed by each of
+// the given ACLs, keyed by ACL name. ACLs that do not exist do not
+// allow the user. If user is empty, the caller is authorized.
+func (c *Client) AuthorizeUser(ctx context.Context, user string, acls []string) (map[string]bool, error) {
+	resp, err := c.Authorize(ctx, &params.AuthorizeRequest{
+		Body: params.AuthorizeRequestBody{
+			User: user,
+			ACLs: acls,
+		},
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	allowed := make(map[string]bool, len(resp.Decisions))
+	for _, d := range resp.Decisions {
+		allowed[d.ACL] = d.Allowed
+	}
+	return allowed, nil
+}
+
+// Search returns the members of the given ACL that contain
+// the given query, ignoring case. The server limits the number
+// of members returned.
+func (c *Client) Search(ctx context.Context, name, query string) ([]string, error) {
+	resp, err := c.SearchMembers(ctx, &params.SearchMembersRequest{
+		Name:  name,
+		Query: query,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	return resp.Users, nil
+}
+
+// Manageable returns the names of the ACLs that the authenticated
+// user may change, sorted by name. Unlike listing all ACLs, this does
+// not require the user to be an administrator.
+func (c *Client) Manageable(ctx context.Context) ([]string, error) {
+	resp, err := c.GetACLs(ctx, &params.GetACLsRequest{
+		Manageable: true,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	return resp.ACLs, nil
+}
+
+// ListMembers returns the members of every ACL with a name starting
+// with the given prefix, keyed by ACL name. If the store returned only
+// some of the matching ACLs because there were too many, it returns
+// those with an error with an ErrTruncated cause.
+func (c *Client) ListMembers(ctx context.Context, prefix string) (map[string][]string, error) {
+	resp, err := c.GetACLs(ctx, &params.GetACLsRequest{
+		Prefix: prefix,
+		Detail: true,
+	})
+	if err != nil {
+		return nil, errgo.Mask(err, isRemoteError)
+	}
+	members := resp.Members
+	if members == nil {
+		members = make(map[string][]string)
+	}
+	if resp.Truncated {
+		return members, errgo.WithCausef(nil, ErrTruncated, "only %d ACLs returned", len(members))
+	}
+	return members, nil
+}
+
 // isRemoteError determines whether the given error is a
 // httprequest.RemoteError.
 func isRemoteError(err error) bool {
@@ -13,33 +13,270 @@ type client struct {
 	Client httprequest.Client
 }
 
+// Authorize reports, for each of the requested ACLs, whether the
+// requested user is allowed by it, as Manager.Check decides, so that,
+// for example, a gateway can authorize a request against many ACLs in
+// a single round trip. The user is identified by name, by a token, or,
+// if neither is given, is the caller. Users given by name are found
+// with HandlerParams.UserIdentity and tokens are resolved with
+// HandlerParams.TokenIdentity.
+// Any authenticated user may authorize themselves. To authorize
+// another user, the caller must be allowed to check membership of
+// every requested ACL: administrators, members of the meta-ACL for
+// each name and members of the checker ACL may do so. Users who
+// authorize themselves are not told which ACLs exist.
+//
+// At most 1000 ACLs may be requested at once, and
+// requests are rate limited per caller.
+func (c *client) Authorize(ctx context.Context, p *params.AuthorizeRequest) (*params.AuthorizeResponse, error) {
+	var r *params.AuthorizeResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// CreateACL creates an ACL with the requested name and initial
+// members, as Manager.CreateACL does. Creating an ACL that already
+// exists does nothing unless the failIfExists flag is set, in which
+// case a 409 Conflict error is returned.
+// Only administrators may access this endpoint.
+func (c *client) CreateACL(ctx context.Context, p *params.CreateACLRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// DeleteACLs deletes all the ACLs whose names start with the requested
+// prefix, together with their meta-ACLs, and returns the names of
+// those deleted. Any ACLs that could not be deleted are reported in
+// the response. The prefix must not match the admin ACL.
+// Only administrators may access this endpoint.
+func (c *client) DeleteACLs(ctx context.Context, p *params.DeleteACLsRequest) (*params.DeleteACLsResponse, error) {
+	var r *params.DeleteACLsResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // GetACL returns the members of the ACL with the requested name.
+// If WithMeta is set, the members of its meta-ACL are returned too.
+// The ETag response header holds a version token for the
+// returned members. It depends only on the members, so it is the same
+// from every handler that shares the store, including after a restart.
+// The v parameter selects the version of the response envelope:
+// version 1, the default, holds only the members, and version 2
+// also holds the version token and the number of members.
+// If the format parameter is "csv", the request is served by GetACLCSV
+// instead; WithMeta may not be set with it.
 // Only administrators and members of the meta-ACL for the name
 // may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+// If WithMeta is set, the meta-ACL must also be readable, so
+// only administrators may use it.
 func (c *client) GetACL(ctx context.Context, p *params.GetACLRequest) (*params.GetACLResponse, error) {
 	var r *params.GetACLResponse
 	err := c.Client.Call(ctx, p, &r)
 	return r, err
 }
 
-// GetACLs returns the list of all ACLs.
-// Only administrators may access this endpoint.
+// GetACLCSV returns the members of the ACL with the requested name as
+// CSV, with a header row followed by a row for each member, and the
+// Content-Disposition header suggests a file name for them. Members
+// that start with a character that spreadsheets treat as the start of
+// a formula are prefixed with a single quote. GetACL requests with the
+// format parameter set to "csv" are served by this endpoint too.
+// Access is as for GetACL.
+func (c *client) GetACLCSV(ctx context.Context, p *params.GetACLCSVRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// GetACLs returns the list of all ACLs. Meta-ACLs are
+// only included if the IncludeMeta flag is set, and only
+// ACLs starting with the prefix parameter are included if it
+// is set. The ACLs are sorted by name unless the sort parameter
+// is "size", in which case they are sorted by number of members,
+// which requires every ACL to be read. If the desc flag is set,
+// the order is reversed, except that ACLs with the same number of
+// members are still sorted by name. If the detail flag is set, the members
+// of each ACL are also returned; if too many ACLs match, only the
+// first of them are returned and the response is marked as
+// truncated. If the counts flag is set, the number of members of
+// each ACL is returned, which is cheaper than returning the
+// members themselves.
+// If the offset or limit parameters are set, only that page of the
+// matching ACLs, in the requested order, is returned. If the count
+// flag is set, the total number of matching ACLs is returned too, so
+// that clients need not make a separate request to find it. The total
+// is computed when the first page of a listing is read and reported
+// again for its later pages for a while, so it may be approximate
+// under concurrent changes.
+// The ETag response header holds a version token for the response;
+// if it matches the If-None-Match header, a 304 Not Modified response
+// with no body is returned instead.
+// Only administrators may access this endpoint unless the manageable
+// flag is set, in which case any authenticated user may, and only the
+// ACLs that the user may change are included. This requires each
+// matching ACL to be authorized separately, so it is more expensive,
+// and such requests are rate limited per caller.
 func (c *client) GetACLs(ctx context.Context, p *params.GetACLsRequest) (*params.GetACLsResponse, error) {
 	var r *params.GetACLsResponse
 	err := c.Client.Call(ctx, p, &r)
 	return r, err
 }
 
+// GetEffectiveACLs returns the names of the ACLs that the requested
+// user is a direct member of. If the user is an administrator, the
+// list also includes "*", signifying access to every ACL.
+// Only administrators may access this endpoint.
+func (c *client) GetEffectiveACLs(ctx context.Context, p *params.GetEffectiveACLsRequest) (*params.GetEffectiveACLsResponse, error) {
+	var r *params.GetEffectiveACLsResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// GetManagers returns the users that may change the membership of
+// the ACL with the requested name: the members of its meta-ACL
+// and the administrators.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (c *client) GetManagers(ctx context.Context, p *params.GetManagersRequest) (*params.GetManagersResponse, error) {
+	var r *params.GetManagersResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// GetMemberOrigins returns the members of the ACL with the requested
+// name together with the users that may change it, each annotated with
+// how it is related to the ACL: as a member, a member of the meta-ACL
+// or an administrator.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (c *client) GetMemberOrigins(ctx context.Context, p *params.GetMemberOriginsRequest) (*params.GetMemberOriginsResponse, error) {
+	var r *params.GetMemberOriginsResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// GetStats returns statistics about the ACLs in the store.
+// Only administrators may access this endpoint.
+func (c *client) GetStats(ctx context.Context, p *params.GetStatsRequest) (*params.GetStatsResponse, error) {
+	var r *params.GetStatsResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// ImportMembers adds the users in the request body to the ACL with the
+// requested name, as Manager.ImportMembers does, and returns the number
+// of them that were not already members. The body, which must have the
+// media type params.NDJSONContentType, holds a JSON string for each
+// user, one per line. It is read incrementally and is not limited by
+// HandlerParams.MaxBodyBytes, but each line is limited to 64KiB.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (c *client) ImportMembers(ctx context.Context, p *params.ImportMembersRequest) (*params.ImportMembersResponse, error) {
+	var r *params.ImportMembersResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// IsAdmin reports whether the authenticated caller is allowed by the
+// admin ACL, so that, for example, a user interface can decide whether
+// to show administrative controls. The members of the admin ACL are
+// not returned. Any authenticated user may access this endpoint.
+func (c *client) IsAdmin(ctx context.Context, p *params.IsAdminRequest) (*params.IsAdminResponse, error) {
+	var r *params.IsAdminResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// IsMember reports whether the requested user is a direct member
+// of the ACL with the requested name.
+// Only administrators, members of the meta-ACL for the name and members
+// of the checker ACL may access this endpoint. The meta-ACL for
+// meta-ACLs is "admin".
+func (c *client) IsMember(ctx context.Context, p *params.IsMemberRequest) (*params.IsMemberResponse, error) {
+	var r *params.IsMemberResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// MembersIn returns those of the candidate users in the request
+// body that are direct members of the ACL with the requested name.
+// Only administrators, members of the meta-ACL for the name and members
+// of the checker ACL may access this endpoint. The meta-ACL for
+// meta-ACLs is "admin".
+func (c *client) MembersIn(ctx context.Context, p *params.MembersInRequest) (*params.MembersInResponse, error) {
+	var r *params.MembersInResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // ModifyACL modifies the members of the ACL with the requested name.
+// If the action parameter is "clear", all the members are removed.
+// If it is "swap", the old user is atomically replaced with the new
+// user; unless force is set, the old user must be a member.
+// When users are added, the response holds the number of them that
+// were not already members; when users are removed, it holds those
+// of them that were members.
+// A request that is well formed but cannot be carried out, because it
+// asks for conflicting changes, for no change at all, or omits the
+// users that its action requires, fails with a 422 Unprocessable Entity
+// response whose code is CodeConflictingChanges, CodeNoChanges or
+// CodeMissingUsers respectively.
 // Only administrators and members of the meta-ACL for the name
 // may access this endpoint. The meta-ACL for meta-ACLs is "admin".
-func (c *client) ModifyACL(ctx context.Context, p *params.ModifyACLRequest) error {
+func (c *client) ModifyACL(ctx context.Context, p *params.ModifyACLRequest) (*params.ModifyACLResponse, error) {
+	var r *params.ModifyACLResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
+// PatchACL changes the members of the ACL with the requested name
+// as described by a JSON Patch or JSON Merge Patch document. The
+// patch is applied atomically to the members as returned by GetACL;
+// if a test operation fails, no change is made and a precondition
+// failed error is returned.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (c *client) PatchACL(ctx context.Context, p *params.PatchACLRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// RebuildIndex regenerates the index of the ACLs that hold each
+// user, returning when it is complete. Only administrators may
+// access this endpoint.
+func (c *client) RebuildIndex(ctx context.Context, p *params.RebuildIndexRequest) error {
 	return c.Client.Call(ctx, p, nil)
 }
 
+// ReplaceAdmins atomically replaces the members of the admin ACL.
+// Unless the Force flag is set, at least one of the current admin
+// users must remain. Only administrators may access this endpoint.
+func (c *client) ReplaceAdmins(ctx context.Context, p *params.ReplaceAdminsRequest) error {
+	return c.Client.Call(ctx, p, nil)
+}
+
+// SearchMembers returns the members of the ACL with the requested name
+// that contain the query, ignoring case. If there are more than the
+// configured maximum number of matching members, only that many are
+// returned and the truncated flag is set in the response.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (c *client) SearchMembers(ctx context.Context, p *params.SearchMembersRequest) (*params.SearchMembersResponse, error) {
+	var r *params.SearchMembersResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
+
 // SetACL sets the members of the ACL with the requested name.
+// If the If-Match header is set, the members are only changed
+// if it matches the current version token of the ACL.
 // Only administrators and members of the meta-ACL for the name
 // may access this endpoint. The meta-ACL for meta-ACLs is "admin".
 func (c *client) SetACL(ctx context.Context, p *params.SetACLRequest) error {
 	return c.Client.Call(ctx, p, nil)
 }
+
+// WhoAmI returns the names of the ACLs that the authenticated
+// caller may read and those that they may change. Any
+// authenticated user may access this endpoint.
+func (c *client) WhoAmI(ctx context.Context, p *params.WhoAmIRequest) (*params.WhoAmIResponse, error) {
+	var r *params.WhoAmIResponse
+	err := c.Client.Call(ctx, p, &r)
+	return r, err
+}
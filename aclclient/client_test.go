@@ -2,9 +2,13 @@ package aclclient_test
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"sort"
+	"strings"
 	"testing"
 
 	qt "github.com/frankban/quicktest"
@@ -37,13 +41,66 @@ func TestGetError(t *testing.T) {
 	defer srv.Close()
 
 	users, err := client.Get(ctx, "test")
-	c.Assert(err, qt.ErrorMatches, `Get http.*/test: ACL not found`)
+	c.Assert(err, qt.ErrorMatches, `Get http.*/test\?v=2: ACL not found`)
 	rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
 	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error cause %T", errgo.Cause(err)))
 	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
 	c.Assert(users, qt.IsNil)
 }
 
+func TestGetResponseVersion(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1", "test2")
+	c.Assert(err, qt.Equals, nil)
+
+	// The generated method leaves the version to the caller.
+	resp, err := client.GetACL(ctx, &params.GetACLRequest{
+		Name: "test",
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.Version, qt.Equals, 0)
+	c.Assert(resp.ETag, qt.Equals, "")
+
+	// The convenience methods request the latest version.
+	var versions []string
+	client = aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			versions = append(versions, req.URL.Query().Get("v"))
+			return srv.Client().Do(req)
+		}),
+	})
+	users, err := client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2"})
+	c.Assert(versions, qt.DeepEquals, []string{fmt.Sprint(params.LatestResponseVersion)})
+}
+
+func TestGetWithManagers(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1", "test2")
+	c.Assert(err, qt.Equals, nil)
+	users, managers, err := client.GetWithManagers(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2"})
+	c.Assert(managers, qt.DeepEquals, []string{})
+
+	err = client.Set(ctx, "_test", []string{"test3"})
+	c.Assert(err, qt.Equals, nil)
+	users, managers, err = client.GetWithManagers(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2"})
+	c.Assert(managers, qt.DeepEquals, []string{"test3"})
+}
+
 func TestSet(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
@@ -72,6 +129,117 @@ func TestSetError(t *testing.T) {
 	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
 }
 
+func TestManagers(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1")
+	c.Assert(err, qt.Equals, nil)
+	err = client.Set(ctx, "_test", []string{"test2", "test3"})
+	c.Assert(err, qt.Equals, nil)
+	managers, err := client.Managers(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(managers, qt.DeepEquals, []string{"test-admin", "test2", "test3"})
+
+	managers, err = client.Managers(ctx, "nonexistent")
+	c.Assert(err, qt.ErrorMatches, `Get http.*/nonexistent/managers: ACL not found`)
+	rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error cause %T", errgo.Cause(err)))
+	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
+	c.Assert(managers, qt.IsNil)
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	_, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := client.Create(ctx, "test", []string{"test1"}, true)
+	c.Assert(err, qt.Equals, nil)
+	users, err := client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1"})
+
+	// Creating it again does nothing.
+	err = client.Create(ctx, "test", []string{"test2"}, false)
+	c.Assert(err, qt.Equals, nil)
+
+	// Unless the caller asks to be told about the conflict.
+	err = client.Create(ctx, "test", []string{"test2"}, true)
+	c.Assert(err, qt.ErrorMatches, `cannot create ACL "test": Put http.*/test/create\?failIfExists=true: ACL "test" already exists`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclclient.ErrExists)
+
+	users, err = client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1"})
+}
+
+func TestSetIfUnchanged(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1", "test2")
+	c.Assert(err, qt.Equals, nil)
+	users, token, err := client.GetWithToken(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2"})
+	c.Assert(token, qt.Not(qt.Equals), "")
+
+	err = client.SetIfUnchanged(ctx, "test", append(users, "test3"), token)
+	c.Assert(err, qt.Equals, nil)
+	users, newToken, err := client.GetWithToken(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2", "test3"})
+	c.Assert(newToken, qt.Not(qt.Equals), token)
+
+	// The old token no longer matches.
+	err = client.SetIfUnchanged(ctx, "test", []string{"test4"}, token)
+	c.Assert(errgo.Cause(err), qt.Equals, aclclient.ErrConflict)
+}
+
+func TestSetIfUnchangedConcurrentModification(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1")
+	c.Assert(err, qt.Equals, nil)
+	users, token, err := client.GetWithToken(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+
+	// Another client adds a user between the read and the write.
+	err = client.Add(ctx, "test", []string{"test2"})
+	c.Assert(err, qt.Equals, nil)
+
+	err = client.SetIfUnchanged(ctx, "test", append(users, "test3"), token)
+	c.Assert(err, qt.ErrorMatches, `cannot set ACL "test": Put http.*/test: ACL "test" has been modified`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclclient.ErrConflict)
+
+	// The concurrent change has not been overwritten.
+	users, err = client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2"})
+}
+
+func TestSetIfUnchangedError(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	_, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := client.SetIfUnchanged(ctx, "test", []string{"test1"}, `"0"`)
+	c.Assert(err, qt.ErrorMatches, `Put http.*/test: ACL not found`)
+	rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error cause %T", errgo.Cause(err)))
+	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
+}
+
 func TestAdd(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
@@ -87,6 +255,82 @@ func TestAdd(t *testing.T) {
 	c.Assert(users, qt.DeepEquals, []string{"test1", "test2", "test3", "test4", "test5", "test6"})
 }
 
+func TestModifyACLReport(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1", "test2")
+	c.Assert(err, qt.Equals, nil)
+	resp, err := client.ModifyACLReport(ctx, &params.ModifyACLRequest{
+		Name: "test",
+		Body: params.ModifyACLRequestBody{
+			Add: []string{"test2", "test3"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp, qt.DeepEquals, &params.ModifyACLResponse{
+		Added: 1,
+	})
+	err = client.ModifyACL(ctx, &params.ModifyACLRequest{
+		Name: "test",
+		Body: params.ModifyACLRequestBody{
+			Add: []string{"test4"},
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	users, err := client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test1", "test2", "test3", "test4"})
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "user0010")
+	c.Assert(err, qt.Equals, nil)
+	const n = 5000
+	i := 0
+	added, err := client.Import(ctx, "test", func() (string, error) {
+		if i == n {
+			return "", io.EOF
+		}
+		i++
+		return fmt.Sprintf("user%04d", i-1), nil
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(added, qt.Equals, n-1)
+	count, err := manager.Count(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(count, qt.Equals, n)
+}
+
+func TestImportError(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	_, err := client.Import(ctx, "test", func() (string, error) {
+		return "", io.EOF
+	})
+	c.Assert(err, qt.ErrorMatches, `Post http.*/test/import: ACL not found`)
+	rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error cause %T", errgo.Cause(err)))
+	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
+
+	err = manager.CreateACL(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	_, err = client.Import(ctx, "test", func() (string, error) {
+		return "", errgo.New("no more users")
+	})
+	c.Assert(err, qt.ErrorMatches, `Post .*/test/import"?: no more users`)
+}
+
 func TestAddError(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
@@ -128,6 +372,96 @@ func TestRemoveError(t *testing.T) {
 	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
 }
 
+func TestClear(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1", "test2", "test3")
+	c.Assert(err, qt.Equals, nil)
+	err = client.Clear(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	users, err := client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.HasLen, 0)
+}
+
+func TestClearError(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	_, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := client.Clear(ctx, "test")
+	c.Assert(err, qt.ErrorMatches, `Post http.*/test\?action=clear: ACL not found`)
+	rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error cause %T", errgo.Cause(err)))
+	c.Assert(rerr.Code, qt.Equals, aclstore.CodeACLNotFound)
+}
+
+func TestSwap(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "test1", "test2")
+	c.Assert(err, qt.Equals, nil)
+	err = client.Swap(ctx, "test", "test1", "test3", false)
+	c.Assert(err, qt.Equals, nil)
+	users, err := client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test2", "test3"})
+
+	err = client.Swap(ctx, "test", "test1", "test4", false)
+	c.Assert(err, qt.ErrorMatches, `Post http.*/test\?action=swap: user "test1" not found`)
+	rerr, ok := errgo.Cause(err).(*httprequest.RemoteError)
+	c.Assert(ok, qt.Equals, true, qt.Commentf("unexpected error cause %T", errgo.Cause(err)))
+	c.Assert(rerr.Code, qt.Equals, aclstore.CodeUserNotFound)
+
+	err = client.Swap(ctx, "test", "test1", "test4", true)
+	c.Assert(err, qt.Equals, nil)
+	users, err = client.Get(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test2", "test3", "test4"})
+}
+
+func TestSearch(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "alice", "bob", "Malice")
+	c.Assert(err, qt.Equals, nil)
+	users, err := client.Search(ctx, "test", "ALI")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"Malice", "alice"})
+	users, err = client.Search(ctx, "test", "zed")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{})
+}
+
+func TestAuthorizeUser(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test1", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = manager.CreateACL(ctx, "test2", "bob")
+	c.Assert(err, qt.Equals, nil)
+	allowed, err := client.AuthorizeUser(ctx, "alice", []string{"test1", "test2", "test3"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(allowed, qt.DeepEquals, map[string]bool{
+		"test1": true,
+		"test2": false,
+		"test3": false,
+	})
+}
+
 func TestGetACLs(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
@@ -144,9 +478,272 @@ func TestGetACLs(t *testing.T) {
 	acls, err := client.GetACLs(ctx, &params.GetACLsRequest{})
 	c.Assert(err, qt.Equals, nil)
 	sort.Strings(acls.ACLs)
+	c.Assert(acls.ACLs, qt.DeepEquals, []string{"admin", "test1", "test2", "test3"})
+
+	acls, err = client.GetACLs(ctx, &params.GetACLsRequest{
+		IncludeMeta: true,
+	})
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(acls.ACLs)
 	c.Assert(acls.ACLs, qt.DeepEquals, []string{"_test1", "_test2", "_test3", "admin", "test1", "test2", "test3"})
 }
 
+func TestManageable(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test1", "test1")
+	c.Assert(err, qt.Equals, nil)
+	err = manager.CreateACL(ctx, "test2", "test2")
+	c.Assert(err, qt.Equals, nil)
+	acls, err := client.Manageable(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"admin", "test1", "test2"})
+}
+
+func TestGetCSV(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "test", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	data, err := client.GetCSV(ctx, "test")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, "user\nalice\nbob\n")
+
+	_, err = client.GetCSV(ctx, "nonexistent")
+	c.Assert(err, qt.ErrorMatches, `Get http.*/nonexistent/csv: ACL not found`)
+}
+
+func TestListMembers(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, client := newServer(ctx, c)
+	defer srv.Close()
+
+	err := manager.CreateACL(ctx, "team-a", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	err = manager.CreateACL(ctx, "team-b")
+	c.Assert(err, qt.Equals, nil)
+	err = manager.CreateACL(ctx, "team-c", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	err = manager.CreateACL(ctx, "other", "daisy")
+	c.Assert(err, qt.Equals, nil)
+
+	members, err := client.ListMembers(ctx, "team-")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, map[string][]string{
+		"team-a": {"alice", "bob"},
+		"team-b": {},
+		"team-c": {"charlie"},
+	})
+
+	members, err = client.ListMembers(ctx, "nothing")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.HasLen, 0)
+}
+
+func TestListMembersTruncated(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"team-a", "team-b", "team-c"} {
+		err := manager.CreateACL(ctx, name, "alice")
+		c.Assert(err, qt.Equals, nil)
+	}
+	srv := httptest.NewServer(manager.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		MaxDetailACLs: 2,
+	}))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+	members, err := client.ListMembers(ctx, "team-")
+	c.Assert(errgo.Cause(err), qt.Equals, aclclient.ErrTruncated)
+	c.Assert(members, qt.DeepEquals, map[string][]string{
+		"team-a": {"alice"},
+		"team-b": {"alice"},
+	})
+}
+
+func TestGzipResponse(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, srv, _ := newServer(ctx, c)
+	defer srv.Close()
+
+	var expectACLs []string
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("acl-%03d", i)
+		err := manager.CreateACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+		expectACLs = append(expectACLs, name)
+	}
+	expectACLs = append(expectACLs, "admin")
+	var encodings []string
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := http.DefaultClient.Do(req)
+			if err == nil {
+				encodings = append(encodings, resp.Header.Get("Content-Encoding"))
+			}
+			return resp, err
+		}),
+	})
+	resp, err := client.GetACLs(ctx, &params.GetACLsRequest{})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.ACLs, qt.DeepEquals, expectACLs)
+
+	users, err := client.Get(ctx, "admin")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"test-admin"})
+
+	// Only the large response was compressed.
+	c.Assert(encodings, qt.DeepEquals, []string{"gzip", ""})
+}
+
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+var usersFieldTests = []struct {
+	testName    string
+	usersField  string
+	expectField string
+}{{
+	testName:    "default",
+	expectField: "users",
+}, {
+	testName:    "members",
+	usersField:  "members",
+	expectField: "members",
+}}
+
+func TestUsersField(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	for _, test := range usersFieldTests {
+		c.Run(test.testName, func(c *qt.C) {
+			manager, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"test-admin"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			srv := httptest.NewServer(manager.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return allowed{}, nil
+				},
+				UsersField: test.usersField,
+			}))
+			defer srv.Close()
+			client := aclclient.New(aclclient.NewParams{
+				BaseURL:    srv.URL,
+				Doer:       srv.Client(),
+				UsersField: test.usersField,
+			})
+
+			err = manager.CreateACL(ctx, "test", "test1")
+			c.Assert(err, qt.Equals, nil)
+			err = client.Set(ctx, "test", []string{"test2", "test3"})
+			c.Assert(err, qt.Equals, nil)
+			users, err := manager.ACL(ctx, "test")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, []string{"test2", "test3"})
+
+			users, err = client.Get(ctx, "test")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, []string{"test2", "test3"})
+			users, token, err := client.GetWithToken(ctx, "test")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, []string{"test2", "test3"})
+			err = client.SetIfUnchanged(ctx, "test", []string{"test4"}, token)
+			c.Assert(err, qt.Equals, nil)
+			users, _, err = client.GetWithManagers(ctx, "test")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, []string{"test4"})
+
+			// Check the field name used on the wire.
+			resp, err := srv.Client().Get(srv.URL + "/test")
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			var body map[string]interface{}
+			err = json.NewDecoder(resp.Body).Decode(&body)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(body, qt.DeepEquals, map[string]interface{}{
+				test.expectField: []interface{}{"test4"},
+			})
+
+			// Other request bodies are left alone.
+			req, err := http.NewRequest("PUT", srv.URL+"/other/create", strings.NewReader(`{"users": ["test5"], "members": ["test6"]}`))
+			c.Assert(err, qt.Equals, nil)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err = srv.Client().Do(req)
+			c.Assert(err, qt.Equals, nil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+			users, err = manager.ACL(ctx, "other")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, []string{"test5"})
+		})
+	}
+}
+
+func TestAmIAdmin(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	manager, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"test-admin"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	var user string
+	srv := httptest.NewServer(manager.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return userIdentity(user), nil
+		},
+	}))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+
+	user = "test-admin"
+	ok, err := client.AmIAdmin(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+
+	user = "test1"
+	ok, err = client.AmIAdmin(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+}
+
+type userIdentity string
+
+func (u userIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, a := range acl {
+		if a == string(u) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 func newServer(ctx context.Context, c *qt.C) (*aclstore.Manager, *httptest.Server, *aclclient.Client) {
 	store := aclstore.NewACLStore(memsimplekv.NewStore())
 
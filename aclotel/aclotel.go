@@ -0,0 +1,433 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+// Package aclotel provides OpenTelemetry tracing for the ACL store
+// HTTP handler and for ACL stores.
+//
+// To trace requests, set aclstore.HandlerParams.Tracer to the result
+// of NewTracer. To also trace the store calls made while handling a
+// request, wrap the store passed to aclstore.NewManager with
+// WrapStore.
+//
+// The package is in a module of its own so that programs that use
+// aclstore without tracing do not depend on OpenTelemetry.
+package aclotel
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+// instrumentationName is the name of the tracers
+// obtained from trace providers.
+const instrumentationName = "github.com/juju/aclstore/v2"
+
+// The following attribute keys are set on spans.
+const (
+	OperationKey  = attribute.Key("acl.operation")
+	ACLNameKey    = attribute.Key("acl.name")
+	MethodKey     = attribute.Key("http.method")
+	StatusCodeKey = attribute.Key("http.status_code")
+)
+
+// NewTracer returns an aclstore.Tracer that creates a span from the
+// given provider for each request. The trace context is extracted from
+// the request headers with the given propagator, so the span becomes a
+// child of the caller's span. If propagator is nil, W3C trace context
+// headers are used.
+//
+// Each span is named by the operation and ACL name of the request,
+// for example "read someacl", and records the HTTP status of the
+// response. Responses with a status of 400 or higher mark the span
+// as failed.
+func NewTracer(provider trace.TracerProvider, propagator propagation.TextMapPropagator) aclstore.Tracer {
+	if propagator == nil {
+		propagator = propagation.TraceContext{}
+	}
+	return &tracer{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagator,
+	}
+}
+
+type tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// StartRequest implements aclstore.Tracer.StartRequest.
+func (t *tracer) StartRequest(req *http.Request) (context.Context, func(op aclstore.Operation, aclName string, status int)) {
+	ctx := t.propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	ctx, span := t.tracer.Start(ctx, req.Method+" "+req.URL.Path,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(MethodKey.String(req.Method)),
+	)
+	return ctx, func(op aclstore.Operation, aclName string, status int) {
+		if op != "" {
+			span.SetName(strings.TrimSpace(string(op) + " " + aclName))
+			span.SetAttributes(OperationKey.String(string(op)))
+		}
+		if aclName != "" {
+			span.SetAttributes(ACLNameKey.String(aclName))
+		}
+		span.SetAttributes(StatusCodeKey.Int(status))
+		if status >= 400 {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+		span.End()
+	}
+}
+
+// WrapStore returns an ACL store that calls the given store, creating
+// a span from the given provider for each call. The spans are children
+// of any span in the context passed to the call.
+//
+// The returned store implements all of the optional store interfaces
+// defined by the aclstore package. Calls to methods of interfaces that
+// the given store does not implement fail.
+func WrapStore(store aclstore.ACLStore, provider trace.TracerProvider) aclstore.ACLStore {
+	return &tracingStore{
+		store:  store,
+		tracer: provider.Tracer(instrumentationName),
+	}
+}
+
+type tracingStore struct {
+	store  aclstore.ACLStore
+	tracer trace.Tracer
+}
+
+// start starts a span for a call to the store method with the given
+// name on the ACL with the given name. The returned function must be
+// called with the error returned by the call.
+func (s *tracingStore) start(ctx context.Context, method, aclName string) (context.Context, func(error)) {
+	attrs := []attribute.KeyValue{}
+	if aclName != "" {
+		attrs = append(attrs, ACLNameKey.String(aclName))
+	}
+	ctx, span := s.tracer.Start(ctx, "aclstore."+method, trace.WithAttributes(attrs...))
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+// CreateACL implements aclstore.ACLStore.CreateACL.
+func (s *tracingStore) CreateACL(ctx context.Context, aclName string, initialUsers []string) (err error) {
+	ctx, end := s.start(ctx, "CreateACL", aclName)
+	defer func() { end(err) }()
+	return s.store.CreateACL(ctx, aclName, initialUsers)
+}
+
+// CreateACLIfNotExists implements
+// aclstore.ACLCreator.CreateACLIfNotExists. If the wrapped store does
+// not implement it, the ACL is read before it is created.
+func (s *tracingStore) CreateACLIfNotExists(ctx context.Context, aclName string, initialUsers []string) (err error) {
+	ctx, end := s.start(ctx, "CreateACLIfNotExists", aclName)
+	defer func() { end(err) }()
+	if creator, ok := s.store.(aclstore.ACLCreator); ok {
+		return creator.CreateACLIfNotExists(ctx, aclName, initialUsers)
+	}
+	_, err = s.store.Get(ctx, aclName)
+	if err == nil {
+		return errgo.WithCausef(nil, aclstore.ErrACLExists, "ACL %q already exists", aclName)
+	}
+	if errgo.Cause(err) != aclstore.ErrACLNotFound {
+		return err
+	}
+	return s.store.CreateACL(ctx, aclName, initialUsers)
+}
+
+// Add implements aclstore.ACLStore.Add.
+func (s *tracingStore) Add(ctx context.Context, aclName string, users []string) (err error) {
+	ctx, end := s.start(ctx, "Add", aclName)
+	defer func() { end(err) }()
+	return s.store.Add(ctx, aclName, users)
+}
+
+// AddReport implements aclstore.ACLAddReporter.AddReport.
+func (s *tracingStore) AddReport(ctx context.Context, aclName string, users []string) (_, _ []string, err error) {
+	reporter, ok := s.store.(aclstore.ACLAddReporter)
+	if !ok {
+		return nil, nil, errgo.Newf("cannot report added users")
+	}
+	ctx, end := s.start(ctx, "AddReport", aclName)
+	defer func() { end(err) }()
+	return reporter.AddReport(ctx, aclName, users)
+}
+
+// Remove implements aclstore.ACLStore.Remove.
+func (s *tracingStore) Remove(ctx context.Context, aclName string, users []string) (err error) {
+	ctx, end := s.start(ctx, "Remove", aclName)
+	defer func() { end(err) }()
+	return s.store.Remove(ctx, aclName, users)
+}
+
+// RemoveReport implements aclstore.ACLRemoveReporter.RemoveReport.
+func (s *tracingStore) RemoveReport(ctx context.Context, aclName string, users []string) (_, _ []string, err error) {
+	reporter, ok := s.store.(aclstore.ACLRemoveReporter)
+	if !ok {
+		return nil, nil, errgo.Newf("cannot report removed users")
+	}
+	ctx, end := s.start(ctx, "RemoveReport", aclName)
+	defer func() { end(err) }()
+	return reporter.RemoveReport(ctx, aclName, users)
+}
+
+// Set implements aclstore.ACLStore.Set.
+func (s *tracingStore) Set(ctx context.Context, aclName string, users []string) (err error) {
+	ctx, end := s.start(ctx, "Set", aclName)
+	defer func() { end(err) }()
+	return s.store.Set(ctx, aclName, users)
+}
+
+// Get implements aclstore.ACLStore.Get.
+func (s *tracingStore) Get(ctx context.Context, aclName string) (_ []string, err error) {
+	ctx, end := s.start(ctx, "Get", aclName)
+	defer func() { end(err) }()
+	return s.store.Get(ctx, aclName)
+}
+
+// ACLs implements aclstore.ACLLister.ACLs.
+func (s *tracingStore) ACLs(ctx context.Context) (_ []string, err error) {
+	lister, ok := s.store.(aclstore.ACLLister)
+	if !ok {
+		return nil, errgo.Newf("cannot list ACLs")
+	}
+	ctx, end := s.start(ctx, "ACLs", "")
+	defer func() { end(err) }()
+	return lister.ACLs(ctx)
+}
+
+// Update implements aclstore.ACLUpdater.Update.
+func (s *tracingStore) Update(ctx context.Context, aclName string, f func(users []string) ([]string, error)) (err error) {
+	updater, ok := s.store.(aclstore.ACLUpdater)
+	if !ok {
+		return errgo.Newf("cannot update ACLs")
+	}
+	ctx, end := s.start(ctx, "Update", aclName)
+	defer func() { end(err) }()
+	return updater.Update(ctx, aclName, f)
+}
+
+// CountACL implements aclstore.ACLCounter.CountACL.
+func (s *tracingStore) CountACL(ctx context.Context, aclName string) (_ int, err error) {
+	ctx, end := s.start(ctx, "CountACL", aclName)
+	defer func() { end(err) }()
+	if counter, ok := s.store.(aclstore.ACLCounter); ok {
+		return counter.CountACL(ctx, aclName)
+	}
+	users, err := s.store.Get(ctx, aclName)
+	return len(users), err
+}
+
+// Contains implements aclstore.ACLMembershipChecker.Contains.
+func (s *tracingStore) Contains(ctx context.Context, aclName, user string) (_ bool, err error) {
+	ctx, end := s.start(ctx, "Contains", aclName)
+	defer func() { end(err) }()
+	if checker, ok := s.store.(aclstore.ACLMembershipChecker); ok {
+		return checker.Contains(ctx, aclName, user)
+	}
+	users, err := s.store.Get(ctx, aclName)
+	if err != nil {
+		return false, err
+	}
+	for _, u := range users {
+		if u == user || s.FoldsCase() && aclstore.FoldUser(u) == aclstore.FoldUser(user) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetDetails implements aclstore.ACLDetailer.GetDetails.
+func (s *tracingStore) GetDetails(ctx context.Context, aclName string) (_ []aclstore.Member, err error) {
+	ctx, end := s.start(ctx, "GetDetails", aclName)
+	defer func() { end(err) }()
+	if detailer, ok := s.store.(aclstore.ACLDetailer); ok {
+		return detailer.GetDetails(ctx, aclName)
+	}
+	users, err := s.store.Get(ctx, aclName)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]aclstore.Member, len(users))
+	for i, u := range users {
+		members[i] = aclstore.Member{User: u}
+	}
+	return members, nil
+}
+
+// GetWithVersion implements aclstore.ACLVersioner.GetWithVersion.
+func (s *tracingStore) GetWithVersion(ctx context.Context, aclName string) (_ []string, _ uint64, err error) {
+	versioner, ok := s.store.(aclstore.ACLVersioner)
+	if !ok {
+		return nil, 0, errgo.Newf("cannot get ACL versions")
+	}
+	ctx, end := s.start(ctx, "GetWithVersion", aclName)
+	defer func() { end(err) }()
+	return versioner.GetWithVersion(ctx, aclName)
+}
+
+// DeleteACL implements aclstore.ACLDeleter.DeleteACL.
+func (s *tracingStore) DeleteACL(ctx context.Context, aclName string) (err error) {
+	deleter, ok := s.store.(aclstore.ACLDeleter)
+	if !ok {
+		return errgo.Newf("cannot delete ACLs")
+	}
+	ctx, end := s.start(ctx, "DeleteACL", aclName)
+	defer func() { end(err) }()
+	return deleter.DeleteACL(ctx, aclName)
+}
+
+// ResetACL implements aclstore.ACLResetter.ResetACL.
+func (s *tracingStore) ResetACL(ctx context.Context, aclName string, users []string) (err error) {
+	resetter, ok := s.store.(aclstore.ACLResetter)
+	if !ok {
+		return errgo.Newf("cannot reset ACLs")
+	}
+	ctx, end := s.start(ctx, "ResetACL", aclName)
+	defer func() { end(err) }()
+	return resetter.ResetACL(ctx, aclName, users)
+}
+
+// RestoreACL implements aclstore.ACLRestorer.RestoreACL.
+func (s *tracingStore) RestoreACL(ctx context.Context, aclName string) (err error) {
+	restorer, ok := s.store.(aclstore.ACLRestorer)
+	if !ok {
+		return errgo.Newf("cannot restore ACLs")
+	}
+	ctx, end := s.start(ctx, "RestoreACL", aclName)
+	defer func() { end(err) }()
+	return restorer.RestoreACL(ctx, aclName)
+}
+
+// PurgeDeleted implements aclstore.ACLRestorer.PurgeDeleted.
+func (s *tracingStore) PurgeDeleted(ctx context.Context) (_ int, err error) {
+	restorer, ok := s.store.(aclstore.ACLRestorer)
+	if !ok {
+		return 0, errgo.Newf("cannot purge ACLs")
+	}
+	ctx, end := s.start(ctx, "PurgeDeleted", "")
+	defer func() { end(err) }()
+	return restorer.PurgeDeleted(ctx)
+}
+
+// Migrate implements aclstore.ACLMigrator.Migrate.
+func (s *tracingStore) Migrate(ctx context.Context) (err error) {
+	migrator, ok := s.store.(aclstore.ACLMigrator)
+	if !ok {
+		return errgo.Newf("cannot migrate ACLs")
+	}
+	ctx, end := s.start(ctx, "Migrate", "")
+	defer func() { end(err) }()
+	return migrator.Migrate(ctx)
+}
+
+// FoldsCase implements aclstore.ACLCaseFolder.FoldsCase.
+func (s *tracingStore) FoldsCase() bool {
+	f, ok := s.store.(aclstore.ACLCaseFolder)
+	return ok && f.FoldsCase()
+}
+
+// EscapesUsers implements aclstore.ACLUserEscaper.EscapesUsers.
+func (s *tracingStore) EscapesUsers() bool {
+	e, ok := s.store.(aclstore.ACLUserEscaper)
+	return ok && e.EscapesUsers()
+}
+
+// IndexesUsers implements aclstore.ACLIndexer.IndexesUsers.
+func (s *tracingStore) IndexesUsers() bool {
+	indexer, ok := s.store.(aclstore.ACLIndexer)
+	return ok && indexer.IndexesUsers()
+}
+
+// IndexedACLs implements aclstore.ACLIndexer.IndexedACLs.
+func (s *tracingStore) IndexedACLs(ctx context.Context, user string) (_ []string, err error) {
+	indexer, ok := s.store.(aclstore.ACLIndexer)
+	if !ok {
+		return nil, errgo.Newf("cannot index users")
+	}
+	ctx, end := s.start(ctx, "IndexedACLs", "")
+	defer func() { end(err) }()
+	return indexer.IndexedACLs(ctx, user)
+}
+
+// RebuildIndex implements aclstore.ACLIndexer.RebuildIndex.
+func (s *tracingStore) RebuildIndex(ctx context.Context) (err error) {
+	indexer, ok := s.store.(aclstore.ACLIndexer)
+	if !ok {
+		return errgo.Newf("cannot index users")
+	}
+	ctx, end := s.start(ctx, "RebuildIndex", "")
+	defer func() { end(err) }()
+	return indexer.RebuildIndex(ctx)
+}
+
+// SetQuorum implements aclstore.ACLApprover.SetQuorum.
+func (s *tracingStore) SetQuorum(ctx context.Context, aclName string, n int) (err error) {
+	approver, ok := s.store.(aclstore.ACLApprover)
+	if !ok {
+		return errgo.Newf("cannot record approvals")
+	}
+	ctx, end := s.start(ctx, "SetQuorum", aclName)
+	defer func() { end(err) }()
+	return approver.SetQuorum(ctx, aclName, n)
+}
+
+// Approve implements aclstore.ACLApprover.Approve.
+func (s *tracingStore) Approve(ctx context.Context, aclName, user string) (err error) {
+	approver, ok := s.store.(aclstore.ACLApprover)
+	if !ok {
+		return errgo.Newf("cannot record approvals")
+	}
+	ctx, end := s.start(ctx, "Approve", aclName)
+	defer func() { end(err) }()
+	return approver.Approve(ctx, aclName, user)
+}
+
+// Approvals implements aclstore.ACLApprover.Approvals.
+func (s *tracingStore) Approvals(ctx context.Context, aclName string) (_ int, _ []string, err error) {
+	approver, ok := s.store.(aclstore.ACLApprover)
+	if !ok {
+		return 0, nil, errgo.Newf("cannot record approvals")
+	}
+	ctx, end := s.start(ctx, "Approvals", aclName)
+	defer func() { end(err) }()
+	return approver.Approvals(ctx, aclName)
+}
+
+// SetAlias implements aclstore.ACLAliaser.SetAlias.
+func (s *tracingStore) SetAlias(ctx context.Context, alias, target string) (err error) {
+	aliaser, ok := s.store.(aclstore.ACLAliaser)
+	if !ok {
+		return errgo.Newf("cannot create aliases")
+	}
+	ctx, end := s.start(ctx, "SetAlias", alias)
+	defer func() { end(err) }()
+	return aliaser.SetAlias(ctx, alias, target)
+}
+
+// AliasTarget implements aclstore.ACLAliaser.AliasTarget. If the
+// wrapped store does not implement it, no name is an alias.
+func (s *tracingStore) AliasTarget(ctx context.Context, name string) (_ string, err error) {
+	aliaser, ok := s.store.(aclstore.ACLAliaser)
+	if !ok {
+		return "", nil
+	}
+	ctx, end := s.start(ctx, "AliasTarget", name)
+	defer func() { end(err) }()
+	return aliaser.AliasTarget(ctx, name)
+}
@@ -0,0 +1,145 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclotel_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/aclotel"
+)
+
+type allowed struct{}
+
+func (allowed) Allow(context.Context, []string) (bool, error) {
+	return true, nil
+}
+
+func TestGetSpans(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclotel.WrapStore(aclstore.NewACLStore(memsimplekv.NewStore()), provider),
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	exporter.Reset()
+
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		Tracer: aclotel.NewTracer(provider, nil),
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest("GET", srv.URL+"/someacl", nil)
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	spans := exporter.GetSpans()
+	var requestSpan *tracetest.SpanStub
+	var getSpans []tracetest.SpanStub
+	for i, span := range spans {
+		switch span.Name {
+		case "read someacl":
+			requestSpan = &spans[i]
+		case "aclstore.Get":
+			getSpans = append(getSpans, span)
+		}
+	}
+	c.Assert(requestSpan, qt.Not(qt.IsNil), qt.Commentf("spans: %v", spanNames(spans)))
+	c.Assert(requestSpan.SpanKind, qt.Equals, trace.SpanKindServer)
+	c.Assert(requestSpan.SpanContext.TraceID().String(), qt.Equals, "0af7651916cd43dd8448eb211c80319c")
+	c.Assert(requestSpan.Parent.SpanID().String(), qt.Equals, "b7ad6b7169203331")
+	c.Assert(attrs(requestSpan.Attributes), qt.DeepEquals, map[attribute.Key]string{
+		aclotel.MethodKey:     "GET",
+		aclotel.OperationKey:  "read",
+		aclotel.ACLNameKey:    "someacl",
+		aclotel.StatusCodeKey: "200",
+	})
+
+	// The store calls, including the Get of the ACL itself, are
+	// children of the request span.
+	var getSomeACL bool
+	for _, span := range getSpans {
+		c.Assert(span.Parent.SpanID(), qt.Equals, requestSpan.SpanContext.SpanID())
+		if attrs(span.Attributes)[aclotel.ACLNameKey] == "someacl" {
+			getSomeACL = true
+		}
+	}
+	c.Assert(getSomeACL, qt.Equals, true)
+}
+
+func TestFailedRequestSpan(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclotel.WrapStore(aclstore.NewACLStore(memsimplekv.NewStore()), provider),
+	})
+	c.Assert(err, qt.Equals, nil)
+	exporter.Reset()
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		Tracer: aclotel.NewTracer(provider, nil),
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/someacl")
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotFound)
+
+	spans := exporter.GetSpans()
+	names := spanNames(spans)
+	c.Assert(names, qt.Contains, "read someacl")
+	for _, span := range spans {
+		switch span.Name {
+		case "read someacl":
+			c.Assert(span.Status.Code, qt.Equals, codes.Error)
+			c.Assert(attrs(span.Attributes)[aclotel.StatusCodeKey], qt.Equals, "404")
+		case "aclstore.Get":
+			c.Assert(span.Status.Code, qt.Equals, codes.Error)
+		}
+	}
+}
+
+func spanNames(spans tracetest.SpanStubs) []string {
+	names := make([]string, len(spans))
+	for i, span := range spans {
+		names[i] = span.Name
+	}
+	return names
+}
+
+func attrs(kvs []attribute.KeyValue) map[attribute.Key]string {
+	m := make(map[attribute.Key]string)
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.Emit()
+	}
+	return m
+}
@@ -0,0 +1,15 @@
+module github.com/juju/aclstore/v2/aclotel
+
+go 1.16
+
+require (
+	github.com/frankban/quicktest v1.14.0
+	github.com/juju/aclstore/v2 v2.0.0
+	github.com/juju/simplekv v1.1.0
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	gopkg.in/errgo.v1 v1.0.1
+)
+
+replace github.com/juju/aclstore/v2 => ../
@@ -0,0 +1,600 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+// Package aclstoretest provides a test suite that checks that an
+// implementation of aclstore.ACLStore conforms to the interface
+// contract.
+package aclstoretest
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+// RunStoreTests runs the conformance tests against stores returned by
+// newStore, which is called to obtain a new empty store for each test.
+//
+// The stores are expected to keep users sorted lexically. If the
+// stores implement aclstore.ACLLister, aclstore.ACLUpdater,
+// aclstore.ACLDeleter, aclstore.ACLResetter, aclstore.ACLCounter,
+// aclstore.ACLVersioner,
+// aclstore.ACLIndexer, aclstore.ACLApprover, aclstore.ACLUserEscaper,
+// aclstore.ACLAddReporter, aclstore.ACLRemoveReporter or
+// aclstore.ACLMembershipChecker, those interfaces are tested too.
+func RunStoreTests(t *testing.T, newStore func() aclstore.ACLStore) {
+	c := qt.New(t)
+	for _, test := range storeTests {
+		c.Run(test.testName, func(c *qt.C) {
+			test.run(c, context.Background(), newStore())
+		})
+	}
+}
+
+var storeTests = []struct {
+	testName string
+	run      func(c *qt.C, ctx context.Context, store aclstore.ACLStore)
+}{{
+	testName: "create",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"y", "x"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"x", "y"})
+	},
+}, {
+	testName: "create_existing",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"x", "y"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.CreateACL(ctx, "foo", []string{"z", "w"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"x", "y"})
+	},
+}, {
+	testName: "create_with_duplicates",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"y", "x", "y"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"x", "y"})
+	},
+}, {
+	testName: "create_with_bad_username",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"x", ""})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	},
+}, {
+	testName: "add",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"e", "c"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.Add(ctx, "foo", []string{"a", "d", "f", "e", "a"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"a", "c", "d", "e", "f"})
+	},
+}, {
+	testName: "add_to_nonexistent_ACL",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.Add(ctx, "foo", []string{"x"})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	},
+}, {
+	testName: "add_bad_username",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.Add(ctx, "foo", []string{"y", ""})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+		assertACL(c, ctx, store, "foo", []string{"x"})
+	},
+}, {
+	testName: "remove",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"a", "b", "c", "d"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.Remove(ctx, "foo", []string{"c", "b"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"a", "d"})
+	},
+}, {
+	testName: "remove_from_nonexistent_ACL",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.Remove(ctx, "foo", []string{"x"})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	},
+}, {
+	testName: "set",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"a", "b", "c", "d"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.Set(ctx, "foo", []string{"e", "c", "b", "e"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"b", "c", "e"})
+	},
+}, {
+	testName: "set_empty",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"a", "b"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.Set(ctx, "foo", nil)
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", nil)
+	},
+}, {
+	testName: "set_nonexistent_ACL",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.Set(ctx, "foo", []string{"x"})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	},
+}, {
+	testName: "set_bad_username",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		bad := "a\nb"
+		if escaper, ok := store.(aclstore.ACLUserEscaper); ok && escaper.EscapesUsers() {
+			// The store can hold users with newlines.
+			bad = ""
+		}
+		err = store.Set(ctx, "foo", []string{"y", bad})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+		assertACL(c, ctx, store, "foo", []string{"x"})
+	},
+}, {
+	testName: "get_nonexistent_ACL",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		acl, err := store.Get(ctx, "foo")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+		c.Assert(acl, qt.IsNil)
+	},
+}, {
+	testName: "get_empty",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", nil)
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", nil)
+	},
+}, {
+	testName: "independent_ACLs",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.CreateACL(ctx, "bar", []string{"y"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.Add(ctx, "foo", []string{"z"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"x", "z"})
+		assertACL(c, ctx, store, "bar", []string{"y"})
+	},
+}, {
+	testName: "cancelled_context",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		err := store.CreateACL(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		cctx, cancel := context.WithCancel(ctx)
+		cancel()
+		err = store.Add(cctx, "foo", []string{"y"})
+		c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+		err = store.Set(cctx, "foo", []string{"y"})
+		c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+		err = store.Remove(cctx, "foo", []string{"x"})
+		c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+		err = store.CreateACL(cctx, "bar", nil)
+		c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+		assertACL(c, ctx, store, "foo", []string{"x"})
+	},
+}, {
+	testName: "lister",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		lister, ok := store.(aclstore.ACLLister)
+		if !ok {
+			c.Skip("store does not implement ACLLister")
+		}
+		acls, err := lister.ACLs(ctx)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acls, qt.HasLen, 0)
+		for _, name := range []string{"foo", "bar", "_foo"} {
+			err := store.CreateACL(ctx, name, []string{"x"})
+			c.Assert(err, qt.Equals, nil)
+		}
+		acls, err = lister.ACLs(ctx)
+		c.Assert(err, qt.Equals, nil)
+		sort.Strings(acls)
+		c.Assert(acls, qt.DeepEquals, []string{"_foo", "bar", "foo"})
+	},
+}, {
+	testName: "updater",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		updater, ok := store.(aclstore.ACLUpdater)
+		if !ok {
+			c.Skip("store does not implement ACLUpdater")
+		}
+		err := updater.Update(ctx, "foo", func(users []string) ([]string, error) {
+			return users, nil
+		})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", []string{"x", "y"})
+		c.Assert(err, qt.Equals, nil)
+		err = updater.Update(ctx, "foo", func(users []string) ([]string, error) {
+			c.Check(users, qt.DeepEquals, []string{"x", "y"})
+			return append(users, "a"), nil
+		})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"a", "x", "y"})
+
+		errFailed := errgo.New("failed")
+		err = updater.Update(ctx, "foo", func(users []string) ([]string, error) {
+			return nil, errFailed
+		})
+		c.Assert(errgo.Cause(err), qt.Equals, errFailed)
+		assertACL(c, ctx, store, "foo", []string{"a", "x", "y"})
+	},
+}, {
+	testName: "deleter",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		deleter, ok := store.(aclstore.ACLDeleter)
+		if !ok {
+			c.Skip("store does not implement ACLDeleter")
+		}
+		err := deleter.DeleteACL(ctx, "foo")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		err = store.CreateACL(ctx, "bar", []string{"y"})
+		c.Assert(err, qt.Equals, nil)
+		err = deleter.DeleteACL(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		_, err = store.Get(ctx, "foo")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+		err = store.Add(ctx, "foo", []string{"z"})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+		err = deleter.DeleteACL(ctx, "foo")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+		assertACL(c, ctx, store, "bar", []string{"y"})
+		if lister, ok := store.(aclstore.ACLLister); ok {
+			acls, err := lister.ACLs(ctx)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acls, qt.DeepEquals, []string{"bar"})
+		}
+
+		// The ACL can be created again.
+		err = store.CreateACL(ctx, "foo", []string{"w"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"w"})
+	},
+}, {
+	testName: "resetter",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		resetter, ok := store.(aclstore.ACLResetter)
+		if !ok {
+			c.Skip("store does not implement ACLResetter")
+		}
+		// A missing ACL is created.
+		err := resetter.ResetACL(ctx, "foo", []string{"y", "x", "y"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"x", "y"})
+
+		// An existing ACL is replaced.
+		err = resetter.ResetACL(ctx, "foo", []string{"z"})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"z"})
+
+		// A deleted ACL is created again.
+		if deleter, ok := store.(aclstore.ACLDeleter); ok {
+			err = deleter.DeleteACL(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			err = resetter.ResetACL(ctx, "foo", []string{"w"})
+			c.Assert(err, qt.Equals, nil)
+			assertACL(c, ctx, store, "foo", []string{"w"})
+		}
+	},
+}, {
+	testName: "counter",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		counter, ok := store.(aclstore.ACLCounter)
+		if !ok {
+			c.Skip("store does not implement ACLCounter")
+		}
+		_, err := counter.CountACL(ctx, "foo")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", nil)
+		c.Assert(err, qt.Equals, nil)
+		n, err := counter.CountACL(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(n, qt.Equals, 0)
+
+		err = store.Add(ctx, "foo", []string{"x", "y", "z", "x"})
+		c.Assert(err, qt.Equals, nil)
+		n, err = counter.CountACL(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(n, qt.Equals, 3)
+	},
+}, {
+	testName: "membership_checker",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		checker, ok := store.(aclstore.ACLMembershipChecker)
+		if !ok {
+			c.Skip("store does not implement ACLMembershipChecker")
+		}
+		_, err := checker.Contains(ctx, "foo", "x")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", nil)
+		c.Assert(err, qt.Equals, nil)
+		ok, err = checker.Contains(ctx, "foo", "x")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, false)
+
+		err = store.Add(ctx, "foo", []string{"x", "xy", "z"})
+		c.Assert(err, qt.Equals, nil)
+		for _, u := range []string{"x", "xy", "z"} {
+			ok, err = checker.Contains(ctx, "foo", u)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, true, qt.Commentf("user %q", u))
+		}
+		for _, u := range []string{"y", "xyz", "", "x\nxy"} {
+			ok, err = checker.Contains(ctx, "foo", u)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, false, qt.Commentf("user %q", u))
+		}
+
+		err = store.Remove(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		ok, err = checker.Contains(ctx, "foo", "x")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, false)
+	},
+}, {
+	testName: "versioner",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		versioner, ok := store.(aclstore.ACLVersioner)
+		if !ok {
+			c.Skip("store does not implement ACLVersioner")
+		}
+		_, _, err := versioner.GetWithVersion(ctx, "foo")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", []string{"x"})
+		c.Assert(err, qt.Equals, nil)
+		acl, v1, err := versioner.GetWithVersion(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acl, qt.DeepEquals, []string{"x"})
+		_, v, err := versioner.GetWithVersion(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(v, qt.Equals, v1)
+
+		err = store.Add(ctx, "foo", []string{"y"})
+		c.Assert(err, qt.Equals, nil)
+		acl, v2, err := versioner.GetWithVersion(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acl, qt.DeepEquals, []string{"x", "y"})
+		c.Assert(v2 > v1, qt.Equals, true)
+	},
+}, {
+	testName: "add_reporter",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		reporter, ok := store.(aclstore.ACLAddReporter)
+		if !ok {
+			c.Skip("store does not implement ACLAddReporter")
+		}
+		_, _, err := reporter.AddReport(ctx, "foo", []string{"x"})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", []string{"x", "y"})
+		c.Assert(err, qt.Equals, nil)
+		added, present, err := reporter.AddReport(ctx, "foo", []string{"z", "x", "a", "z"})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(added, qt.DeepEquals, []string{"z", "a"})
+		c.Assert(present, qt.DeepEquals, []string{"x"})
+		assertACL(c, ctx, store, "foo", []string{"a", "x", "y", "z"})
+
+		added, present, err = reporter.AddReport(ctx, "foo", []string{"y"})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(added, qt.HasLen, 0)
+		c.Assert(present, qt.DeepEquals, []string{"y"})
+		assertACL(c, ctx, store, "foo", []string{"a", "x", "y", "z"})
+
+		_, _, err = reporter.AddReport(ctx, "foo", []string{"b", ""})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+		assertACL(c, ctx, store, "foo", []string{"a", "x", "y", "z"})
+	},
+}, {
+	testName: "remove_reporter",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		reporter, ok := store.(aclstore.ACLRemoveReporter)
+		if !ok {
+			c.Skip("store does not implement ACLRemoveReporter")
+		}
+		_, _, err := reporter.RemoveReport(ctx, "foo", []string{"x"})
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", []string{"x", "y", "z"})
+		c.Assert(err, qt.Equals, nil)
+		removed, absent, err := reporter.RemoveReport(ctx, "foo", []string{"z", "x", "z"})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(removed, qt.DeepEquals, []string{"z", "x"})
+		c.Assert(absent, qt.HasLen, 0)
+		assertACL(c, ctx, store, "foo", []string{"y"})
+
+		// Strict stores fail when a user is absent, leaving the ACL unchanged.
+		removed, absent, err = reporter.RemoveReport(ctx, "foo", []string{"a", "y"})
+		if errgo.Cause(err) == aclstore.ErrUserNotFound {
+			assertACL(c, ctx, store, "foo", []string{"y"})
+			return
+		}
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(removed, qt.DeepEquals, []string{"y"})
+		c.Assert(absent, qt.DeepEquals, []string{"a"})
+		assertACL(c, ctx, store, "foo", []string{})
+	},
+}, {
+	testName: "escaper",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		escaper, ok := store.(aclstore.ACLUserEscaper)
+		if !ok || !escaper.EscapesUsers() {
+			c.Skip("store does not escape users")
+		}
+		users := []string{"a\nb", `a\nb`, `c\`}
+		err := store.CreateACL(ctx, "foo", users)
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", users)
+		err = store.Remove(ctx, "foo", []string{`a\nb`})
+		c.Assert(err, qt.Equals, nil)
+		assertACL(c, ctx, store, "foo", []string{"a\nb", `c\`})
+	},
+}, {
+	testName: "indexer",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		indexer, ok := store.(aclstore.ACLIndexer)
+		if !ok || !indexer.IndexesUsers() {
+			c.Skip("store does not maintain a user index")
+		}
+		users := []string{"x", "y", "z"}
+		steps := []func() error{
+			func() error { return store.CreateACL(ctx, "foo", []string{"x", "y"}) },
+			func() error { return store.CreateACL(ctx, "bar", []string{"y"}) },
+			func() error { return store.Add(ctx, "foo", []string{"z", "x"}) },
+			func() error { return store.Remove(ctx, "foo", []string{"y"}) },
+			func() error { return store.Set(ctx, "bar", []string{"x", "z"}) },
+			func() error { return store.Set(ctx, "bar", nil) },
+		}
+		if updater, ok := store.(aclstore.ACLUpdater); ok {
+			steps = append(steps, func() error {
+				return updater.Update(ctx, "bar", func(acl []string) ([]string, error) {
+					return append(acl, "y"), nil
+				})
+			})
+		}
+		if deleter, ok := store.(aclstore.ACLDeleter); ok {
+			steps = append(steps,
+				func() error { return deleter.DeleteACL(ctx, "foo") },
+				func() error { return store.CreateACL(ctx, "foo", []string{"y"}) },
+			)
+		}
+		for i, step := range steps {
+			err := step()
+			c.Assert(err, qt.Equals, nil, qt.Commentf("step %d", i))
+			for _, u := range users {
+				acls, err := indexer.IndexedACLs(ctx, u)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(acls, qt.DeepEquals, aclsHolding(c, ctx, store, []string{"bar", "foo"}, u), qt.Commentf("step %d, user %q", i, u))
+			}
+		}
+		if lister, ok := store.(aclstore.ACLLister); ok {
+			// The index is not listed as ACLs.
+			acls, err := lister.ACLs(ctx)
+			c.Assert(err, qt.Equals, nil)
+			sort.Strings(acls)
+			c.Assert(acls, qt.DeepEquals, []string{"bar", "foo"})
+		}
+
+		// Rebuilding a consistent index leaves it unchanged.
+		err := indexer.RebuildIndex(ctx)
+		c.Assert(err, qt.Equals, nil)
+		for _, u := range users {
+			acls, err := indexer.IndexedACLs(ctx, u)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acls, qt.DeepEquals, aclsHolding(c, ctx, store, []string{"bar", "foo"}, u), qt.Commentf("user %q", u))
+		}
+	},
+}, {
+	testName: "approver",
+	run: func(c *qt.C, ctx context.Context, store aclstore.ACLStore) {
+		approver, ok := store.(aclstore.ACLApprover)
+		if !ok {
+			c.Skip("store does not implement ACLApprover")
+		}
+		err := approver.SetQuorum(ctx, "foo", 2)
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+		err = store.CreateACL(ctx, "foo", []string{"x", "y", "z"})
+		c.Assert(err, qt.Equals, nil)
+		quorum, approvals, err := approver.Approvals(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(quorum, qt.Equals, 0)
+		c.Assert(approvals, qt.HasLen, 0)
+
+		// Approvals are not recorded without a quorum.
+		err = approver.Approve(ctx, "foo", "x")
+		c.Assert(err, qt.Not(qt.IsNil))
+
+		err = approver.SetQuorum(ctx, "foo", 2)
+		c.Assert(err, qt.Equals, nil)
+		err = approver.Approve(ctx, "foo", "z")
+		c.Assert(err, qt.Equals, nil)
+		err = approver.Approve(ctx, "foo", "x")
+		c.Assert(err, qt.Equals, nil)
+		err = approver.Approve(ctx, "foo", "x")
+		c.Assert(err, qt.Equals, nil)
+		err = approver.Approve(ctx, "foo", "w")
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrUserNotFound)
+		quorum, approvals, err = approver.Approvals(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(quorum, qt.Equals, 2)
+		c.Assert(approvals, qt.DeepEquals, []string{"x", "z"})
+
+		// Approvals do not change the members, and approvals
+		// by removed members are not returned, even when they
+		// are added back.
+		assertACL(c, ctx, store, "foo", []string{"x", "y", "z"})
+		err = store.Remove(ctx, "foo", []string{"z"})
+		c.Assert(err, qt.Equals, nil)
+		_, approvals, err = approver.Approvals(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(approvals, qt.DeepEquals, []string{"x"})
+		err = store.Add(ctx, "foo", []string{"z"})
+		c.Assert(err, qt.Equals, nil)
+		_, approvals, err = approver.Approvals(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(approvals, qt.DeepEquals, []string{"x"})
+
+		// Setting the quorum again discards the approvals.
+		err = approver.SetQuorum(ctx, "foo", 1)
+		c.Assert(err, qt.Equals, nil)
+		quorum, approvals, err = approver.Approvals(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(quorum, qt.Equals, 1)
+		c.Assert(approvals, qt.HasLen, 0)
+	},
+}}
+
+// aclsHolding returns the sorted names of the ACLs out of the given
+// ACLs that hold the given user. ACLs that do not exist are ignored.
+func aclsHolding(c *qt.C, ctx context.Context, store aclstore.ACLStore, names []string, user string) []string {
+	var found []string
+	for _, name := range names {
+		acl, err := store.Get(ctx, name)
+		if errgo.Cause(err) == aclstore.ErrACLNotFound {
+			continue
+		}
+		c.Assert(err, qt.Equals, nil)
+		for _, u := range acl {
+			if u == user {
+				found = append(found, name)
+				break
+			}
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// assertACL asserts that the ACL with the given name
+// holds the given users.
+func assertACL(c *qt.C, ctx context.Context, store aclstore.ACLStore, name string, expect []string) {
+	acl, err := store.Get(ctx, name)
+	c.Assert(err, qt.Equals, nil)
+	if len(expect) == 0 {
+		c.Assert(acl, qt.HasLen, 0)
+		return
+	}
+	c.Assert(acl, qt.DeepEquals, expect)
+}
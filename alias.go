@@ -0,0 +1,225 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+)
+
+// ErrAliasCycle is the error cause used when creating an alias
+// would make a chain of aliases that refers back to itself.
+var ErrAliasCycle = errgo.Newf("alias cycle")
+
+// ErrAliasExists is the error cause used when an ACL cannot be
+// created because its name is already used by an alias.
+var ErrAliasExists = errgo.Newf("alias already exists")
+
+// maxAliasDepth holds the greatest number of aliases that are followed
+// when resolving a name. Longer chains can only be made by concurrent
+// calls to CreateAlias, and are treated as cycles.
+const maxAliasDepth = 16
+
+// CreateAlias makes alias another name for the ACL with the target
+// name, so that reading or changing the alias reads or changes the
+// members of the target ACL. Access to the alias is decided by the
+// meta-ACL of the target; no meta-ACL is created for the alias itself.
+// The target may itself be an alias. If the alias already exists, it is
+// changed to refer to the new target.
+//
+// Aliases are held by the underlying store, which must implement
+// ACLAliaser, so they are seen by every Manager that shares it. An ACL
+// cannot be created with the name of an alias.
+//
+// It returns an error with an ErrAliasCycle cause if the target
+// refers back to the alias, with an ErrACLExists cause if there is
+// already an ACL with the alias name, or with an ErrBadACLName cause
+// if the alias or the target is not a valid ACL name.
+func (m *Manager) CreateAlias(ctx context.Context, alias, target string) error {
+	if err := m.ValidateACLName(alias); err != nil {
+		return errgo.NoteMask(err, "invalid alias name", errgo.Is(ErrBadACLName))
+	}
+	if err := m.ValidateACLName(target); err != nil {
+		return errgo.NoteMask(err, "invalid alias target", errgo.Is(ErrBadACLName))
+	}
+	aliaser, ok := m.p.Store.(ACLAliaser)
+	if !ok {
+		return errgo.Newf("cannot create aliases")
+	}
+	// Check for an ACL first so that its error is returned in
+	// preference to a cycle through aliases to it. SetAlias checks
+	// again after the alias is set.
+	if _, err := m.p.Store.Get(ctx, alias); err == nil {
+		return errgo.WithCausef(nil, ErrACLExists, "cannot create alias %q: ACL %q already exists", alias, alias)
+	} else if errgo.Cause(err) != ErrACLNotFound {
+		return errgo.Mask(err, isContextError)
+	}
+	name := target
+	for i := 0; ; i++ {
+		if name == alias || i == maxAliasDepth {
+			return errgo.WithCausef(nil, ErrAliasCycle, "cannot create alias %q to %q", alias, target)
+		}
+		next, err := aliaser.AliasTarget(ctx, name)
+		if err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+		if next == "" {
+			break
+		}
+		name = next
+	}
+	if err := aliaser.SetAlias(ctx, alias, target); err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("cannot create alias %q", alias), errgo.Is(ErrACLExists), isContextError)
+	}
+	return nil
+}
+
+// resolveAlias returns the name of the ACL that the given name refers
+// to, following any aliases. Names that are not aliases are returned
+// unchanged, as are all names if the store does not implement
+// ACLAliaser.
+func (m *Manager) resolveAlias(ctx context.Context, name string) (string, error) {
+	aliaser, ok := m.p.Store.(ACLAliaser)
+	if !ok || isMetaName(name) || m.isSystemACL(name) {
+		// Meta-ACLs and system ACLs cannot be aliases,
+		// so there is no need to look them up.
+		return name, nil
+	}
+	resolved := name
+	for i := 0; ; i++ {
+		target, err := aliaser.AliasTarget(ctx, resolved)
+		if err != nil {
+			return "", errgo.Mask(err, isContextError)
+		}
+		if target == "" {
+			return resolved, nil
+		}
+		if i == maxAliasDepth {
+			return "", errgo.WithCausef(nil, ErrAliasCycle, "cannot resolve alias %q", name)
+		}
+		resolved = target
+	}
+}
+
+// aliasPrefix is prefixed, after the namespace, to the key of the
+// entry that holds the target of each alias. As with indexPrefix, no
+// ACL created by a Manager can start with it.
+const aliasPrefix = "__alias:"
+
+// aliasKey returns the key in s.kv of the entry for the alias with
+// the given name for an operation with the given context.
+func (s *kvStore) aliasKey(ctx context.Context, alias string) string {
+	return s.namespace(ctx) + aliasPrefix + alias
+}
+
+// isAliasKey reports whether the given key in s.kv holds an alias
+// rather than an ACL for an operation with the given context.
+func (s *kvStore) isAliasKey(ctx context.Context, key string) bool {
+	return strings.HasPrefix(key, s.namespace(ctx)+aliasPrefix)
+}
+
+// AliasTarget implements ACLAliaser.AliasTarget.
+func (s *kvStore) AliasTarget(ctx context.Context, name string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	val, err := s.kv.Get(ctx, s.aliasKey(ctx, name))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return "", nil
+		}
+		return "", errgo.Mask(err, isContextError)
+	}
+	return string(val), nil
+}
+
+// SetAlias implements ACLAliaser.SetAlias.
+//
+// As the alias and the ACL with the same name are held in different
+// entries, they cannot be changed together. Instead, the alias is set
+// before checking for the ACL, and CreateACLIfNotExists creates the
+// ACL before checking for the alias, so that when both are made
+// concurrently at least one of them sees the other and is undone.
+func (s *kvStore) SetAlias(ctx context.Context, alias, target string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key := s.aliasKey(ctx, alias)
+	var old []byte
+	err := s.kv.Update(ctx, key, time.Time{}, func(val []byte) ([]byte, error) {
+		old = val
+		return []byte(target), nil
+	})
+	if err != nil {
+		return errgo.NoteMask(err, "cannot set alias", isContextError)
+	}
+	_, err = s.Get(ctx, alias)
+	if errgo.Cause(err) == ErrACLNotFound {
+		return nil
+	}
+	if err == nil {
+		err = errgo.WithCausef(nil, ErrACLExists, "ACL %q already exists", alias)
+	}
+	// Put back the earlier target, unless the alias
+	// has been changed again since.
+	undoErr := s.kv.Update(ctx, key, time.Time{}, func(val []byte) ([]byte, error) {
+		if !bytes.Equal(val, []byte(target)) {
+			return val, nil
+		}
+		if old == nil {
+			// The store cannot delete keys, so an
+			// empty target means there is no alias.
+			return []byte{}, nil
+		}
+		return old, nil
+	})
+	if undoErr != nil {
+		return errgo.NoteMask(undoErr, "cannot undo alias", isContextError)
+	}
+	return errgo.Mask(err, errgo.Is(ErrACLExists), isContextError)
+}
+
+// undoCreate undoes the creation of the ACL with the given name, which
+// was stored as created, replacing the given old value. It is left
+// unchanged if it has been changed since.
+func (s *kvStore) undoCreate(ctx context.Context, aclName string, created, old []byte) error {
+	if old == nil {
+		h, _, err := decodeValue(created)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+		// Leave a marker, as DeleteACL does, so that
+		// the generation is not reused.
+		old, err = s.encodeValue(valueHeader{
+			Deleted:    true,
+			Generation: h.Generation,
+		}, nil)
+		if err != nil {
+			return errgo.Mask(err)
+		}
+	}
+	undone := false
+	err := s.kv.Update(ctx, s.key(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		undone = bytes.Equal(val, created)
+		if !undone {
+			return val, nil
+		}
+		return old, nil
+	})
+	if err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("cannot undo creation of ACL %q", aclName), isContextError)
+	}
+	if undone && isDeleted(old) {
+		if err := s.markDeleted(ctx, aclName); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	return nil
+}
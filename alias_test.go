@@ -0,0 +1,229 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+func TestAlias(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "prod-deploy", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "production-deploy", "prod-deploy")
+	c.Assert(err, qt.Equals, nil)
+
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+
+	// A write via the alias is visible via the target.
+	assertJSONCallAs(c, "boss", "POST", srv.URL+"/production-deploy", params.ModifyACLRequestBody{
+		Add: []string{"bob"},
+	}, http.StatusOK, params.ModifyACLResponse{
+		Added: 1,
+	})
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/prod-deploy", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice", "bob"},
+	})
+
+	// A write via the target is visible via the alias.
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/prod-deploy", params.SetACLRequestBody{
+		Users: []string{"charlie"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/production-deploy", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"charlie"},
+	})
+	users, err := m.ACL(ctx, "production-deploy")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"charlie"})
+
+	// Access to the alias is decided by the target's meta-ACL.
+	err = m.CreateACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACL(ctx, "_production-deploy")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	err = m.CreateAlias(ctx, "other-alias", "other")
+	c.Assert(err, qt.Equals, nil)
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/_prod-deploy", params.SetACLRequestBody{
+		Users: []string{"daisy"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "daisy", "GET", srv.URL+"/production-deploy", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"charlie"},
+	})
+	assertJSONCallAs(c, "daisy", "GET", srv.URL+"/other-alias", nil, http.StatusForbidden, &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+}
+
+func TestAliasChain(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "a", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "b", "a")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "c", "b")
+	c.Assert(err, qt.Equals, nil)
+	ok, err := m.IsMember(ctx, "c", "alice")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+	err = m.ClearACL(ctx, "c")
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "a")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.HasLen, 0)
+}
+
+var createAliasErrorTests = []struct {
+	testName    string
+	alias       string
+	target      string
+	expectError string
+	expectCause error
+}{{
+	testName:    "self",
+	alias:       "x",
+	target:      "x",
+	expectError: `cannot create alias "x" to "x"`,
+	expectCause: aclstore.ErrAliasCycle,
+}, {
+	testName:    "cycle",
+	alias:       "b",
+	target:      "c",
+	expectError: `cannot create alias "b" to "c"`,
+	expectCause: aclstore.ErrAliasCycle,
+}, {
+	testName:    "existing_ACL",
+	alias:       "a",
+	target:      "b",
+	expectError: `cannot create alias "a": ACL "a" already exists`,
+	expectCause: aclstore.ErrACLExists,
+}, {
+	testName:    "meta_alias",
+	alias:       "_b",
+	target:      "a",
+	expectError: `invalid alias name: invalid ACL name "_b"`,
+}, {
+	testName:    "meta_target",
+	alias:       "d",
+	target:      "_a",
+	expectError: `invalid alias target: invalid ACL name "_a"`,
+	expectCause: aclstore.ErrBadACLName,
+}, {
+	testName:    "empty_target",
+	alias:       "d",
+	target:      "",
+	expectError: `invalid alias target: empty ACL name`,
+	expectCause: aclstore.ErrBadACLName,
+}, {
+	testName:    "path_unsafe_target",
+	alias:       "d",
+	target:      "a/b",
+	expectError: `invalid alias target: invalid ACL name "a/b": not safe to use in a URL path`,
+	expectCause: aclstore.ErrBadACLName,
+}}
+
+func TestCreateAliasError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "a")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "b", "a")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "c", "b")
+	c.Assert(err, qt.Equals, nil)
+	for _, test := range createAliasErrorTests {
+		c.Run(test.testName, func(c *qt.C) {
+			err := m.CreateAlias(ctx, test.alias, test.target)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			if test.expectCause != nil {
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+			}
+		})
+	}
+	// The failed attempts leave the aliases unchanged.
+	users, err := m.ACL(ctx, "c")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.HasLen, 0)
+}
+
+func TestAliasSharedByManagers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m1, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: store,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m1.CreateACL(ctx, "a", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m1.CreateAlias(ctx, "b", "a")
+	c.Assert(err, qt.Equals, nil)
+
+	// A Manager created later on the same store sees the alias.
+	m2, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: store,
+	})
+	c.Assert(err, qt.Equals, nil)
+	users, err := m2.ACL(ctx, "b")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	// The alias is not listed as an ACL.
+	names, err := m2.ACLNames(ctx, false)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.Not(qt.Contains), "b")
+}
+
+func TestCreateACLWithAliasName(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "a", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "b", "a")
+	c.Assert(err, qt.Equals, nil)
+
+	err = m.CreateACL(ctx, "b", "bob")
+	c.Assert(err, qt.ErrorMatches, `cannot create ACL "b": name is an alias`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrAliasExists)
+
+	// The alias still refers to the target.
+	users, err := m.ACL(ctx, "b")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+}
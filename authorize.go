@@ -0,0 +1,141 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// maxAuthorizeACLs holds the maximum number of ACLs
+// that may be named in a single Authorize request.
+const maxAuthorizeACLs = 1000
+
+// Authorize reports, for each of the requested ACLs, whether the
+// requested user is allowed by it, as Manager.Check decides, so that,
+// for example, a gateway can authorize a request against many ACLs in
+// a single round trip. The user is identified by name, by a token, or,
+// if neither is given, is the caller. Users given by name are found
+// with HandlerParams.UserIdentity and tokens are resolved with
+// HandlerParams.TokenIdentity.
+// Any authenticated user may authorize themselves. To authorize
+// another user, the caller must be allowed to check membership of
+// every requested ACL: administrators, members of the meta-ACL for
+// each name and members of the checker ACL may do so. Users who
+// authorize themselves are not told which ACLs exist.
+//
+// At most 1000 ACLs may be requested at once, and
+// requests are rate limited per caller.
+func (h handler1) Authorize(p httprequest.Params, req *params.AuthorizeRequest) (*params.AuthorizeResponse, error) {
+	caller, _ := IdentityFromContext(p.Context)
+	if err := h.h.checkCallerRateLimit(p.Response, caller); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(errRateLimited))
+	}
+	if len(req.Body.ACLs) > maxAuthorizeACLs {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "too many ACLs (%d > %d)", len(req.Body.ACLs), maxAuthorizeACLs)
+	}
+	for _, name := range req.Body.ACLs {
+		if err := h.h.m.validateAccessedACLName(name); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrBadACLName))
+		}
+	}
+	subject, err := h.subjectIdentity(p.Context, req.Body.User, req.Body.Token)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	self := subject == nil
+	if self {
+		subject = caller
+	}
+	resp := &params.AuthorizeResponse{
+		Decisions: make([]params.AuthorizeDecision, len(req.Body.ACLs)),
+	}
+	for i, name := range req.Body.ACLs {
+		d := &resp.Decisions[i]
+		d.ACL = name
+		if !self {
+			ok, err := h.h.authorize(p.Context, caller, name, OperationCheck)
+			if errgo.Cause(err) == ErrACLNotFound {
+				d.NotFound = true
+				continue
+			}
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			if !ok {
+				return nil, httprequest.Errorf(httprequest.CodeForbidden, "cannot authorize other users against ACL %q", name)
+			}
+		}
+		d.Allowed, err = h.h.m.Check(p.Context, subject, name)
+		if errgo.Cause(err) == ErrACLNotFound {
+			// Only callers who may check the ACL are told
+			// that it doesn't exist.
+			d.NotFound = !self
+			continue
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	return resp, nil
+}
+
+// subjectIdentity returns the identity of the user named by user or
+// identified by token in an Authorize request. It returns a nil
+// identity if neither is set, so that the caller is authorized.
+func (h handler1) subjectIdentity(ctx context.Context, user, token string) (Identity, error) {
+	switch {
+	case user != "" && token != "":
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "cannot specify both user and token")
+	case user != "":
+		if h.h.p.UserIdentity != nil {
+			identity, err := h.h.p.UserIdentity(ctx, user)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot find identity of user %q", user)
+			}
+			return identity, nil
+		}
+		if h.h.m.foldsCase() {
+			user = FoldUser(user)
+		}
+		return userIdentity(user), nil
+	case token != "":
+		if h.h.p.TokenIdentity == nil {
+			return nil, httprequest.Errorf(httprequest.CodeBadRequest, "tokens are not supported")
+		}
+		identity, err := h.h.p.TokenIdentity(ctx, token)
+		if errgo.Cause(err) == ErrUnauthorized {
+			return nil, httprequest.Errorf(httprequest.CodeBadRequest, "invalid token: %v", err)
+		}
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot resolve token")
+		}
+		return identity, nil
+	}
+	return nil, nil
+}
+
+// userIdentity is the identity of a user named in an Authorize request
+// when HandlerParams.UserIdentity is nil. It is allowed only by entries
+// that hold its name.
+type userIdentity string
+
+// Name implements NamedIdentity.Name.
+func (u userIdentity) Name() string {
+	return string(u)
+}
+
+// Allow implements Identity.Allow.
+func (u userIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, a := range acl {
+		if a == string(u) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,291 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var authorizeTests = []struct {
+	testName       string
+	caller         string
+	body           params.AuthorizeRequestBody
+	expectStatus   int
+	expectResponse interface{}
+}{{
+	testName: "self",
+	caller:   "alice",
+	body: params.AuthorizeRequestBody{
+		ACLs: []string{"deploy", "read", "nonexistent"},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{
+			{ACL: "deploy", Allowed: true},
+			{ACL: "read", Allowed: false},
+			{ACL: "nonexistent", Allowed: false},
+		},
+	},
+}, {
+	testName: "self_without_ACLs",
+	caller:   "alice",
+	body: params.AuthorizeRequestBody{
+		ACLs: []string{},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{},
+	},
+}, {
+	testName: "checker_asks_about_user",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		User: "alice",
+		ACLs: []string{"deploy", "read", "deploy", "nonexistent"},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{
+			{ACL: "deploy", Allowed: true},
+			{ACL: "read", Allowed: false},
+			{ACL: "deploy", Allowed: true},
+			{ACL: "nonexistent", NotFound: true},
+		},
+	},
+}, {
+	testName: "managers_are_allowed",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		User: "carol",
+		ACLs: []string{"deploy", "read"},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{
+			{ACL: "deploy", Allowed: true},
+			{ACL: "read", Allowed: false},
+		},
+	},
+}, {
+	testName: "admin_asks_about_user",
+	caller:   "boss",
+	body: params.AuthorizeRequestBody{
+		User: "bob",
+		ACLs: []string{"deploy", "read"},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{
+			{ACL: "deploy", Allowed: false},
+			{ACL: "read", Allowed: true},
+		},
+	},
+}, {
+	testName: "user_in_group",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		User: "dave",
+		ACLs: []string{"deploy", "read"},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{
+			{ACL: "deploy", Allowed: false},
+			{ACL: "read", Allowed: true},
+		},
+	},
+}, {
+	testName: "token",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		Token: "token-alice",
+		ACLs:  []string{"deploy", "read"},
+	},
+	expectStatus: http.StatusOK,
+	expectResponse: params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{
+			{ACL: "deploy", Allowed: true},
+			{ACL: "read", Allowed: false},
+		},
+	},
+}, {
+	testName: "invalid_token",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		Token: "bad",
+		ACLs:  []string{"deploy"},
+	},
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Message: "invalid token: unknown token",
+		Code:    httprequest.CodeBadRequest,
+	},
+}, {
+	testName: "user_and_token",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		User:  "alice",
+		Token: "token-alice",
+		ACLs:  []string{"deploy"},
+	},
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Message: "cannot specify both user and token",
+		Code:    httprequest.CodeBadRequest,
+	},
+}, {
+	testName: "invalid_ACL_name",
+	caller:   "alice",
+	body: params.AuthorizeRequestBody{
+		ACLs: []string{"deploy", "bad name"},
+	},
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Message: `invalid ACL name "bad name": not safe to use in a URL path`,
+		Code:    httprequest.CodeBadRequest,
+	},
+}, {
+	testName: "too_many_ACLs",
+	caller:   "gateway",
+	body: params.AuthorizeRequestBody{
+		User: "alice",
+		ACLs: make([]string, 1001),
+	},
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Message: "too many ACLs (1001 > 1000)",
+		Code:    httprequest.CodeBadRequest,
+	},
+}, {
+	testName: "meta_member_asks_about_other_ACL",
+	caller:   "carol",
+	body: params.AuthorizeRequestBody{
+		User: "alice",
+		ACLs: []string{"deploy", "read"},
+	},
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Message: `cannot authorize other users against ACL "read"`,
+		Code:    httprequest.CodeForbidden,
+	},
+}, {
+	testName: "member_asks_about_user",
+	caller:   "alice",
+	body: params.AuthorizeRequestBody{
+		User: "bob",
+		ACLs: []string{"read"},
+	},
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Message: `cannot authorize other users against ACL "read"`,
+		Code:    httprequest.CodeForbidden,
+	},
+}}
+
+func TestAuthorize(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		CheckerACL:        "checkers",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "deploy", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "read", "bob", "team")
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.AddReport(ctx, "_deploy", []string{"carol"})
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.AddReport(ctx, "checkers", []string{"gateway"})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+		UserIdentity: func(ctx context.Context, user string) (aclstore.Identity, error) {
+			if user == "dave" {
+				return groupIdentity{
+					name:   user,
+					groups: []string{"team"},
+				}, nil
+			}
+			return &namedIdentity{user}, nil
+		},
+		TokenIdentity: func(ctx context.Context, token string) (aclstore.Identity, error) {
+			if token == "token-alice" {
+				return &namedIdentity{"alice"}, nil
+			}
+			return nil, errgo.WithCausef(nil, aclstore.ErrUnauthorized, "unknown token")
+		},
+	}))
+	defer srv.Close()
+	for _, test := range authorizeTests {
+		c.Run(test.testName, func(c *qt.C) {
+			assertJSONCallAs(c, test.caller, "POST", srv.URL+"/authorize", test.body, test.expectStatus, test.expectResponse)
+		})
+	}
+}
+
+func TestAuthorizeWithoutIdentityHooks(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:              memsimplekv.NewStore(),
+			CaseInsensitive: true,
+		}),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "read", "bob", "team")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{"boss"}, nil
+		},
+	}))
+	defer srv.Close()
+
+	// Without UserIdentity, users are only allowed
+	// by their names, ignoring case if the store does.
+	assertJSONCall(c, "POST", srv.URL+"/authorize", params.AuthorizeRequestBody{
+		User: "Bob",
+		ACLs: []string{"read"},
+	}, http.StatusOK, params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{{ACL: "read", Allowed: true}},
+	})
+	assertJSONCall(c, "POST", srv.URL+"/authorize", params.AuthorizeRequestBody{
+		User: "team",
+		ACLs: []string{"read"},
+	}, http.StatusOK, params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{{ACL: "read", Allowed: true}},
+	})
+	assertJSONCall(c, "POST", srv.URL+"/authorize", params.AuthorizeRequestBody{
+		User: "dave",
+		ACLs: []string{"read"},
+	}, http.StatusOK, params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{{ACL: "read", Allowed: false}},
+	})
+
+	// Without TokenIdentity, tokens are rejected.
+	assertJSONCall(c, "POST", srv.URL+"/authorize", params.AuthorizeRequestBody{
+		Token: "token-alice",
+		ACLs:  []string{"read"},
+	}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: "tokens are not supported",
+		Code:    httprequest.CodeBadRequest,
+	})
+}
@@ -0,0 +1,222 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Cache holds the configuration for caching the members of ACLs read
+// by a Manager. Changes made through the Manager or its handler remove
+// the changed ACL from the cache, but changes made directly to the
+// underlying store, or through another Manager, are not seen until the
+// cached entry expires or Manager.Reload or Manager.InvalidateCache
+// is called. Manager.ACLConsistent can be used to read the current
+// members of an ACL regardless.
+//
+// Concurrent reads of an ACL that is not cached share a single read
+// from the store, so the store is read at most once for each ACL in
+// each TTL period, plus once for each change to it.
+type Cache struct {
+	// TTL holds the longest time that an ACL is cached for.
+	TTL time.Duration
+
+	// Jitter holds the fraction of TTL, between 0 and 1, by which
+	// the lifetime of each entry is randomly reduced, so that entries
+	// that were cached at the same time do not all expire together.
+	Jitter float64
+
+	// RefreshAhead, if non-zero, enables background refreshing of
+	// frequently read entries. When an entry is read within this
+	// time of its expiry, it is reloaded from the store in the
+	// background so that readers do not have to wait for it.
+	RefreshAhead time.Duration
+}
+
+// aclCache implements a cache of ACL members in front of an ACL store.
+type aclCache struct {
+	p     Cache
+	store ACLStore
+	now   func() time.Time
+
+	mu      sync.Mutex
+	rand    *rand.Rand
+	entries map[string]cacheEntry
+
+	// loads holds the reads from the store that are in progress.
+	loads map[string]*cacheLoad
+}
+
+type cacheEntry struct {
+	users      []string
+	expire     time.Time
+	refreshing bool
+}
+
+// cacheLoad represents a read from the store. The users and err
+// fields are set before done is closed.
+type cacheLoad struct {
+	done  chan struct{}
+	users []string
+	err   error
+}
+
+func newACLCache(p Cache, store ACLStore, now func() time.Time) *aclCache {
+	if p.Jitter < 0 {
+		p.Jitter = 0
+	}
+	if p.Jitter > 1 {
+		p.Jitter = 1
+	}
+	return &aclCache{
+		p:       p,
+		store:   store,
+		now:     now,
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		entries: make(map[string]cacheEntry),
+		loads:   make(map[string]*cacheLoad),
+	}
+}
+
+// get returns the members of the ACL with the given name, reading
+// them from the store if they are not cached.
+func (c *aclCache) get(ctx context.Context, aclName string) ([]string, error) {
+	for {
+		users, err := c.get1(ctx, aclName)
+		if err != nil && isContextError(errgo.Cause(err)) && ctx.Err() == nil {
+			// The read was started by a caller whose
+			// context has since been cancelled.
+			continue
+		}
+		return users, err
+	}
+}
+
+func (c *aclCache) get1(ctx context.Context, aclName string) ([]string, error) {
+	c.mu.Lock()
+	now := c.now()
+	if e, ok := c.entries[aclName]; ok && now.Before(e.expire) {
+		if c.p.RefreshAhead > 0 && !e.refreshing && e.expire.Sub(now) <= c.p.RefreshAhead && c.loads[aclName] == nil {
+			e.refreshing = true
+			c.entries[aclName] = e
+			l := c.startLoad(aclName)
+			go c.load(context.Background(), aclName, l)
+		}
+		c.mu.Unlock()
+		return copyUsers(e.users), nil
+	}
+	l := c.loads[aclName]
+	if l == nil {
+		l = c.startLoad(aclName)
+		c.mu.Unlock()
+		c.load(ctx, aclName, l)
+	} else {
+		c.mu.Unlock()
+	}
+	select {
+	case <-l.done:
+		if l.err != nil {
+			return nil, errgo.Mask(l.err, errgo.Is(ErrACLNotFound), isContextError)
+		}
+		return copyUsers(l.users), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// refresh reads the members of the ACL with the given name from the
+// store, whether or not they are cached, and caches the result. Reads
+// of the ACL that are already in progress will not be cached, and
+// reads started before refresh returns share its result.
+func (c *aclCache) refresh(ctx context.Context, aclName string) ([]string, error) {
+	c.mu.Lock()
+	delete(c.entries, aclName)
+	l := c.startLoad(aclName)
+	c.mu.Unlock()
+	c.load(ctx, aclName, l)
+	if l.err != nil {
+		return nil, errgo.Mask(l.err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return copyUsers(l.users), nil
+}
+
+// startLoad records that a read of the given ACL is in progress.
+// It must be called with c.mu held.
+func (c *aclCache) startLoad(aclName string) *cacheLoad {
+	l := &cacheLoad{
+		done: make(chan struct{}),
+	}
+	c.loads[aclName] = l
+	return l
+}
+
+// load reads the given ACL from the store and caches the result,
+// unless the ACL has been invalidated since the load was started.
+func (c *aclCache) load(ctx context.Context, aclName string, l *cacheLoad) {
+	users, err := c.store.Get(ctx, aclName)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l.users, l.err = users, err
+	defer close(l.done)
+	if c.loads[aclName] != l {
+		return
+	}
+	delete(c.loads, aclName)
+	switch {
+	case err == nil:
+		c.entries[aclName] = cacheEntry{
+			users:  users,
+			expire: c.now().Add(c.ttl()),
+		}
+	case errgo.Cause(err) == ErrACLNotFound:
+		delete(c.entries, aclName)
+	default:
+		// Keep any existing entry until it expires so that
+		// a failed refresh can be retried.
+		if e, ok := c.entries[aclName]; ok {
+			e.refreshing = false
+			c.entries[aclName] = e
+		}
+	}
+}
+
+// ttl returns the lifetime of a new cache entry.
+// It must be called with c.mu held.
+func (c *aclCache) ttl() time.Duration {
+	return c.p.TTL - time.Duration(c.rand.Float64()*c.p.Jitter*float64(c.p.TTL))
+}
+
+// invalidate removes the given ACLs from the cache. Any reads
+// of them that are in progress will not be cached.
+func (c *aclCache) invalidate(aclNames ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, name := range aclNames {
+		delete(c.entries, name)
+		delete(c.loads, name)
+	}
+}
+
+// clear removes all the ACLs from the cache. Any reads
+// that are in progress will not be cached.
+func (c *aclCache) clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = make(map[string]cacheEntry)
+	c.loads = make(map[string]*cacheLoad)
+}
+
+// copyUsers returns a copy of the given users so
+// that callers cannot change the cached slice.
+func copyUsers(users []string) []string {
+	if users == nil {
+		return nil
+	}
+	return append([]string(nil), users...)
+}
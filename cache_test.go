@@ -0,0 +1,301 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestCacheConcurrentReads(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newCountingStore()
+	m := cachingManager(c, store, clock, aclstore.Cache{
+		TTL:    time.Minute,
+		Jitter: 0.5,
+	})
+	err := m.CreateACL(ctx, "someacl", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+
+	readConcurrently := func() {
+		var wg sync.WaitGroup
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 20; j++ {
+					users, err := m.ACL(ctx, "someacl")
+					c.Check(err, qt.Equals, nil)
+					c.Check(users, qt.DeepEquals, []string{"alice", "bob"})
+				}
+			}()
+		}
+		wg.Wait()
+	}
+	// All the concurrent reads share a single read from the store.
+	readConcurrently()
+	c.Assert(store.count(), qt.Equals, 1)
+
+	// The store is read once more in each TTL period.
+	clock.advance(time.Minute)
+	readConcurrently()
+	c.Assert(store.count(), qt.Equals, 2)
+	clock.advance(time.Minute)
+	readConcurrently()
+	c.Assert(store.count(), qt.Equals, 3)
+}
+
+func TestCacheJitter(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newCountingStore()
+	m := cachingManager(c, store, clock, aclstore.Cache{
+		TTL:    100 * time.Second,
+		Jitter: 0.5,
+	})
+	const n = 100
+	readAll := func() {
+		for i := 0; i < n; i++ {
+			_, err := m.ACL(ctx, fmt.Sprint("acl", i))
+			c.Assert(err, qt.Equals, nil)
+		}
+	}
+	for i := 0; i < n; i++ {
+		err := m.CreateACL(ctx, fmt.Sprint("acl", i))
+		c.Assert(err, qt.Equals, nil)
+	}
+	readAll()
+	c.Assert(store.count(), qt.Equals, n)
+
+	// No entry expires before the jittered minimum lifetime.
+	clock.advance(49 * time.Second)
+	readAll()
+	c.Assert(store.count(), qt.Equals, n)
+
+	// Part way through the jitter window, only some
+	// of the entries have expired.
+	clock.advance(26 * time.Second)
+	readAll()
+	c.Assert(store.count() > n, qt.Equals, true)
+	c.Assert(store.count() < 2*n, qt.Equals, true)
+}
+
+func TestCacheRefreshAhead(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newCountingStore()
+	m := cachingManager(c, store, clock, aclstore.Cache{
+		TTL:          time.Minute,
+		RefreshAhead: 10 * time.Second,
+	})
+	err := m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(store.count(), qt.Equals, 1)
+	<-store.gets
+
+	// A read close to expiry is served from the cache
+	// and triggers a refresh in the background.
+	clock.advance(55 * time.Second)
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+	select {
+	case <-store.gets:
+	case <-time.After(5 * time.Second):
+		c.Fatalf("cache was not refreshed")
+	}
+
+	// After the original expiry time, the refreshed
+	// entry is used without reading the store again.
+	clock.advance(10 * time.Second)
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+	c.Assert(store.count(), qt.Equals, 2)
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	store := newCountingStore()
+	m := cachingManager(c, store, clock, aclstore.Cache{
+		TTL: time.Minute,
+	})
+	err := m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+
+	// Changes made through the Manager are seen immediately.
+	err = m.SwapMember(ctx, "someacl", "alice", "bob", false)
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+
+	// Changes made directly to the store are only
+	// seen when the entry expires.
+	err = store.Set(ctx, "someacl", []string{"charlie"})
+	c.Assert(err, qt.Equals, nil)
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+	clock.advance(time.Minute)
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"charlie"})
+
+	// The cached slice cannot be changed by callers.
+	users[0] = "eve"
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"charlie"})
+}
+
+func TestReload(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := newCountingStore()
+	m := cachingManager(c, store, nil, aclstore.Cache{
+		TTL: time.Hour,
+	})
+	err := m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "otheracl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"someacl", "otheracl"} {
+		_, err = m.ACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+	}
+
+	// Change the ACLs behind the Manager's back.
+	for _, name := range []string{"someacl", "otheracl"} {
+		err = store.Set(ctx, name, []string{"bob"})
+		c.Assert(err, qt.Equals, nil)
+	}
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	m.InvalidateCache("someacl")
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+	users, err = m.ACL(ctx, "otheracl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	m.Reload()
+	users, err = m.ACL(ctx, "otheracl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+}
+
+func TestACLConsistent(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := newCountingStore()
+	m := cachingManager(c, store, nil, aclstore.Cache{
+		TTL: time.Hour,
+	})
+	err := m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+	c.Assert(store.count(), qt.Equals, 1)
+
+	// Change the ACL behind the Manager's back, as
+	// another process sharing the store would.
+	err = store.Set(ctx, "someacl", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	// A consistent read always reads the store.
+	users, err = m.ACLConsistent(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+	c.Assert(store.count(), qt.Equals, 2)
+
+	// The fresh value is cached.
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+	c.Assert(store.count(), qt.Equals, 2)
+
+	// Deletion is seen too.
+	err = store.ACLStore.(aclstore.ACLDeleter).DeleteACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACLConsistent(ctx, "someacl")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func cachingManager(c *qt.C, store aclstore.ACLStore, clock aclstore.Clock, cache aclstore.Cache) *aclstore.Manager {
+	m, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+		Cache:             &cache,
+		Clock:             clock,
+	})
+	c.Assert(err, qt.Equals, nil)
+	return m
+}
+
+// countingStore wraps an ACL store and counts the calls to Get.
+// Each call is also sent on the gets channel if there is room.
+type countingStore struct {
+	aclstore.ACLStore
+	n    int64
+	gets chan string
+}
+
+func newCountingStore() *countingStore {
+	return &countingStore{
+		ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+		gets:     make(chan string, 1),
+	}
+}
+
+func (s *countingStore) Get(ctx context.Context, aclName string) ([]string, error) {
+	users, err := s.ACLStore.Get(ctx, aclName)
+	atomic.AddInt64(&s.n, 1)
+	select {
+	case s.gets <- aclName:
+	default:
+	}
+	return users, err
+}
+
+func (s *countingStore) count() int {
+	return int(atomic.LoadInt64(&s.n))
+}
+
+func (s *countingStore) Update(ctx context.Context, aclName string, f func(users []string) ([]string, error)) error {
+	return s.ACLStore.(aclstore.ACLUpdater).Update(ctx, aclName, f)
+}
+
+func (s *countingStore) CreateACLIfNotExists(ctx context.Context, aclName string, initialUsers []string) error {
+	return s.ACLStore.(aclstore.ACLCreator).CreateACLIfNotExists(ctx, aclName, initialUsers)
+}
@@ -0,0 +1,25 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import "time"
+
+// Clock is used to find the current time. Tests can provide their
+// own implementation to control time-dependent behavior such as
+// cache expiry and the retention of deleted ACLs.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// WallClock is a Clock that returns the current system time.
+var WallClock Clock = ClockFunc(time.Now)
+
+// ClockFunc implements Clock by calling the function.
+type ClockFunc func() time.Time
+
+// Now implements Clock.Now by calling f.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
@@ -0,0 +1,70 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestClock(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	kvStore := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:              memsimplekv.NewStore(),
+		DeleteRetention: time.Hour,
+		Clock:           clock,
+	})
+	store := newCountingStore()
+	store.ACLStore = kvStore
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+		Cache: &aclstore.Cache{
+			TTL: time.Minute,
+		},
+		Clock: clock,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+
+	// The cache uses the Manager's clock, so its entries
+	// expire only when the clock is advanced.
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	n := store.count()
+	clock.advance(59 * time.Second)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(store.count(), qt.Equals, n)
+	clock.advance(time.Second)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(store.count(), qt.Equals, n+1)
+
+	// The store uses its own clock to decide when
+	// deleted ACLs can no longer be restored.
+	err = kvStore.(aclstore.ACLDeleter).DeleteACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	clock.advance(time.Hour)
+	err = kvStore.(aclstore.ACLRestorer).RestoreACL(ctx, "someacl")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestWallClock(t *testing.T) {
+	c := qt.New(t)
+	before := time.Now()
+	now := aclstore.WallClock.Now()
+	c.Assert(now.Before(before), qt.Equals, false)
+	c.Assert(now.After(time.Now()), qt.Equals, false)
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"sync"
+
+	"gopkg.in/errgo.v1"
+)
+
+// mutationLimiter limits the number of concurrent changes made to
+// each ACL. Each ACL that is being changed has its own semaphore,
+// which is discarded when it is no longer in use, so the number of
+// semaphores is bounded by the number of ACLs being changed.
+type mutationLimiter struct {
+	limit int
+
+	mu   sync.Mutex
+	sems map[string]*mutationSemaphore
+}
+
+type mutationSemaphore struct {
+	// slots holds a value for each change in progress.
+	slots chan struct{}
+
+	// n holds the number of callers waiting for or
+	// holding a slot. It is guarded by mutationLimiter.mu.
+	n int
+}
+
+func newMutationLimiter(limit int) *mutationLimiter {
+	return &mutationLimiter{
+		limit: limit,
+		sems:  make(map[string]*mutationSemaphore),
+	}
+}
+
+// acquire waits until fewer than the limit of changes to the ACL with
+// the given name are in progress and returns a function that must be
+// called when the change is complete. If the context is done first, it
+// returns the context's error.
+func (l *mutationLimiter) acquire(ctx context.Context, aclName string) (release func(), err error) {
+	l.mu.Lock()
+	sem := l.sems[aclName]
+	if sem == nil {
+		sem = &mutationSemaphore{
+			slots: make(chan struct{}, l.limit),
+		}
+		l.sems[aclName] = sem
+	}
+	sem.n++
+	l.mu.Unlock()
+	done := func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		sem.n--
+		if sem.n == 0 {
+			delete(l.sems, aclName)
+		}
+	}
+	select {
+	case sem.slots <- struct{}{}:
+	case <-ctx.Done():
+		done()
+		return nil, ctx.Err()
+	}
+	return func() {
+		<-sem.slots
+		done()
+	}, nil
+}
+
+// acquireMutation waits until a request with the given operation may
+// change the ACL with the given name without exceeding
+// HandlerParams.MaxConcurrentMutations, and returns a function that
+// must be called when the request is complete. Read operations never
+// wait. If the request's context is done first, it returns an error
+// with an errRateLimited cause.
+func (h *handler) acquireMutation(ctx context.Context, op Operation, aclName string) (release func(), err error) {
+	if h.mutations == nil || isReadOperation(op) {
+		return func() {}, nil
+	}
+	release, err = h.mutations.acquire(ctx, aclName)
+	if err != nil {
+		return nil, errgo.WithCausef(err, errRateLimited, "too many concurrent changes to ACL %q", aclName)
+	}
+	return release, nil
+}
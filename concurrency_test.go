@@ -0,0 +1,221 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+// concurrencyStore records the maximum number of
+// concurrent calls to Add.
+type concurrencyStore struct {
+	aclstore.ACLStore
+
+	// block, if non-nil, is received from by each call
+	// to Add for the ACL named "foo" until its context is done.
+	block chan struct{}
+
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (s *concurrencyStore) SetAlias(ctx context.Context, alias, target string) error {
+	return s.ACLStore.(aclstore.ACLAliaser).SetAlias(ctx, alias, target)
+}
+
+func (s *concurrencyStore) AliasTarget(ctx context.Context, name string) (string, error) {
+	return s.ACLStore.(aclstore.ACLAliaser).AliasTarget(ctx, name)
+}
+
+func (s *concurrencyStore) Add(ctx context.Context, aclName string, users []string) error {
+	s.mu.Lock()
+	s.current++
+	if s.current > s.max {
+		s.max = s.current
+	}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.current--
+		s.mu.Unlock()
+	}()
+	if s.block != nil && aclName == "foo" {
+		select {
+		case <-s.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	} else {
+		time.Sleep(time.Millisecond)
+	}
+	return s.ACLStore.Add(ctx, aclName, users)
+}
+
+func TestMaxConcurrentMutations(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := &concurrencyStore{
+		ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+	}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		MaxConcurrentMutations: 2,
+	}))
+	defer srv.Close()
+
+	const n = 50
+	var wg sync.WaitGroup
+	statuses := make(chan int, n)
+	expectUsers := make([]string, n)
+	for i := 0; i < n; i++ {
+		user := fmt.Sprintf("user%02d", i)
+		expectUsers[i] = user
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, _ := json.Marshal(params.ModifyACLRequestBody{
+				Add: []string{user},
+			})
+			resp, err := http.Post(srv.URL+"/foo", "application/json", bytes.NewReader(data))
+			if err != nil {
+				statuses <- 0
+				return
+			}
+			resp.Body.Close()
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+	for status := range statuses {
+		c.Assert(status, qt.Equals, http.StatusOK)
+	}
+	users, err := m.ACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, expectUsers)
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	c.Assert(store.max <= 2, qt.Equals, true, qt.Commentf("max concurrency %d", store.max))
+}
+
+func TestMaxConcurrentMutationsTimeout(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := &concurrencyStore{
+		ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+		block:    make(chan struct{}),
+	}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "foo-alias", "foo")
+	c.Assert(err, qt.Equals, nil)
+	h := m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		MaxConcurrentMutations: 1,
+	})
+	add := func(ctx context.Context, aclName string) *httptest.ResponseRecorder {
+		data, err := json.Marshal(params.ModifyACLRequestBody{
+			Add: []string{"bob"},
+		})
+		c.Assert(err, qt.Equals, nil)
+		req := httptest.NewRequest("POST", "/"+aclName, bytes.NewReader(data)).WithContext(ctx)
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// Start a change that holds the only slot for foo.
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- add(ctx, "foo")
+	}()
+	for {
+		store.mu.Lock()
+		current := store.current
+		store.mu.Unlock()
+		if current == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// Another change to foo waits until its context is done.
+	ctx1, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	rec := add(ctx1, "foo")
+	c.Assert(rec.Code, qt.Equals, http.StatusTooManyRequests)
+	var remoteErr httprequest.RemoteError
+	err = json.Unmarshal(rec.Body.Bytes(), &remoteErr)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(remoteErr.Message, qt.Equals, `too many concurrent changes to ACL "foo": context deadline exceeded`)
+	c.Assert(remoteErr.Code, qt.Equals, aclstore.CodeTooManyRequests)
+
+	// Changes through an alias wait for the target ACL.
+	ctx2, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	rec = add(ctx2, "foo-alias")
+	c.Assert(rec.Code, qt.Equals, http.StatusTooManyRequests)
+	err = json.Unmarshal(rec.Body.Bytes(), &remoteErr)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(remoteErr.Message, qt.Equals, `too many concurrent changes to ACL "foo": context deadline exceeded`)
+
+	// Checks don't wait even though they use POST.
+	data, err := json.Marshal(params.MembersInRequestBody{
+		Candidates: []string{"bob"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	ctx3, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	req := httptest.NewRequest("POST", "/foo/members", bytes.NewReader(data)).WithContext(ctx3)
+	req.Header.Set("Content-Type", "application/json")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	c.Assert(rec.Code, qt.Equals, http.StatusOK)
+
+	// Other ACLs are limited independently.
+	c.Assert(add(ctx, "bar").Code, qt.Equals, http.StatusOK)
+
+	// Once the first change completes, foo can be changed again.
+	store.block <- struct{}{}
+	c.Assert((<-done).Code, qt.Equals, http.StatusOK)
+	go func() {
+		store.block <- struct{}{}
+	}()
+	c.Assert(add(ctx, "foo").Code, qt.Equals, http.StatusOK)
+}
@@ -0,0 +1,88 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+)
+
+// authorizeByName reports whether the user with the given name may
+// perform the given operation on the ACL with the given name because
+// the name is a member of one of the ACLs that operationACL would
+// combine. It is used instead of Identity.Allow when
+// Params.AuthorizeByName is set.
+func (m *Manager) authorizeByName(ctx context.Context, name, aclName string, op Operation) (bool, error) {
+	if allowed, ok := m.containsName(ctx, name, aclName, op); ok {
+		return allowed, nil
+	}
+	acl, err := m.operationACL(ctx, aclName, op)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return name != "" && m.isMember(acl, name), nil
+}
+
+// containsName is like authorizeByName except that it uses
+// ACLMembershipChecker so that large ACLs need not be retrieved and
+// decoded. It reports false for ok when it cannot decide, including
+// when any of the ACLs cannot be checked, so that the full check can
+// be made and return the appropriate error.
+func (m *Manager) containsName(ctx context.Context, name, aclName string, op Operation) (allowed, ok bool) {
+	checker, ok := m.p.Store.(ACLMembershipChecker)
+	if !ok {
+		return false, false
+	}
+	if _, ok := TenantFromContext(ctx); m.cache != nil && !ok {
+		// The cached members are cheaper to check.
+		return false, false
+	}
+	if m.p.DenyPrefix != "" {
+		// Entries other than the name may deny the user.
+		return false, false
+	}
+	if name == "" {
+		return false, true
+	}
+	names, err := m.operationACLNames(ctx, aclName, op)
+	if err != nil {
+		return false, false
+	}
+	for _, n := range names {
+		// All the ACLs are checked so that an error that the full
+		// check would return is not hidden.
+		contains, err := checker.Contains(ctx, n, name)
+		if err != nil {
+			return false, false
+		}
+		allowed = allowed || contains
+	}
+	return allowed, true
+}
+
+// operationACLNames returns the names of the ACLs whose members are
+// combined by operationACL for the given ACL name and operation.
+func (m *Manager) operationACLNames(ctx context.Context, aclName string, op Operation) ([]string, error) {
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	var names []string
+	if m.isSystemACL(aclName) || isMetaName(aclName) || m.p.DisableMetaACLs {
+		names = append(names, m.p.AdminACLName)
+	} else {
+		names = append(names, metaName(aclName))
+		if m.adminBypass() {
+			names = append(names, m.p.AdminACLName)
+		}
+	}
+	if op == OperationCheck && m.p.CheckerACL != "" {
+		names = append(names, m.p.CheckerACL)
+	}
+	if m.p.ReadOnlyAdminACL != "" && isReadOperation(op) {
+		names = append(names, m.p.ReadOnlyAdminACL)
+	}
+	return names, nil
+}
@@ -0,0 +1,335 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+// checkingStore counts the calls to Get and Contains
+// made on the store that it wraps.
+type checkingStore struct {
+	aclstore.ACLStore
+
+	mu       sync.Mutex
+	gets     int
+	contains int
+}
+
+func (s *checkingStore) Get(ctx context.Context, aclName string) ([]string, error) {
+	s.mu.Lock()
+	s.gets++
+	s.mu.Unlock()
+	return s.ACLStore.Get(ctx, aclName)
+}
+
+func (s *checkingStore) Contains(ctx context.Context, aclName, user string) (bool, error) {
+	s.mu.Lock()
+	s.contains++
+	s.mu.Unlock()
+	return s.ACLStore.(aclstore.ACLMembershipChecker).Contains(ctx, aclName, user)
+}
+
+func (s *checkingStore) FoldsCase() bool {
+	folder, ok := s.ACLStore.(aclstore.ACLCaseFolder)
+	return ok && folder.FoldsCase()
+}
+
+func (s *checkingStore) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.gets, s.contains = 0, 0
+}
+
+// groupIdentity is a named identity that is also
+// allowed by entries for the groups it is in.
+type groupIdentity struct {
+	name   string
+	groups []string
+}
+
+func (id groupIdentity) Name() string {
+	return id.name
+}
+
+func (id groupIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, a := range acl {
+		if a == id.name {
+			return true, nil
+		}
+		for _, g := range id.groups {
+			if a == g {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+var authorizeByNameTests = []struct {
+	testName    string
+	params      aclstore.Params
+	storeParams aclstore.StoreParams
+	// disabled holds whether Params.AuthorizeByName is left unset.
+	disabled    bool
+	identity    aclstore.Identity
+	aclName     string
+	op          aclstore.Operation
+	expect      bool
+	expectError error
+	// expectGets holds whether the ACLs are expected to be
+	// retrieved rather than just checked for the name.
+	expectGets bool
+}{{
+	testName: "meta_member",
+	identity: &namedIdentity{"alice"},
+	aclName:  "someacl",
+	op:       aclstore.OperationModify,
+	expect:   true,
+}, {
+	testName: "admin",
+	identity: &namedIdentity{"boss"},
+	aclName:  "someacl",
+	op:       aclstore.OperationModify,
+	expect:   true,
+}, {
+	testName: "admin_of_admin_ACL",
+	identity: &namedIdentity{"boss"},
+	aclName:  "admin",
+	op:       aclstore.OperationRead,
+	expect:   true,
+}, {
+	testName: "meta_member_of_admin_ACL",
+	identity: &namedIdentity{"alice"},
+	aclName:  "admin",
+	op:       aclstore.OperationRead,
+}, {
+	testName: "not_a_member",
+	identity: &namedIdentity{"bob"},
+	aclName:  "someacl",
+	op:       aclstore.OperationModify,
+}, {
+	testName: "groups_are_ignored",
+	identity: groupIdentity{
+		name:   "charlie",
+		groups: []string{"team"},
+	},
+	aclName: "someacl",
+	op:      aclstore.OperationModify,
+}, {
+	testName: "allowed_by_group_when_disabled",
+	disabled: true,
+	identity: groupIdentity{
+		name:   "charlie",
+		groups: []string{"team"},
+	},
+	aclName:    "someacl",
+	op:         aclstore.OperationModify,
+	expect:     true,
+	expectGets: true,
+}, {
+	testName:   "disabled",
+	disabled:   true,
+	identity:   &namedIdentity{"alice"},
+	aclName:    "someacl",
+	op:         aclstore.OperationModify,
+	expect:     true,
+	expectGets: true,
+}, {
+	testName: "anonymous",
+	identity: identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+		return true, nil
+	}),
+	aclName:    "someacl",
+	op:         aclstore.OperationModify,
+	expect:     true,
+	expectGets: true,
+}, {
+	testName: "checker",
+	params: aclstore.Params{
+		CheckerACL: "checkers",
+	},
+	identity: &namedIdentity{"daisy"},
+	aclName:  "someacl",
+	op:       aclstore.OperationCheck,
+	expect:   true,
+}, {
+	testName: "checker_cannot_modify",
+	params: aclstore.Params{
+		CheckerACL: "checkers",
+	},
+	identity: &namedIdentity{"daisy"},
+	aclName:  "someacl",
+	op:       aclstore.OperationModify,
+}, {
+	testName:    "no_meta_ACL",
+	identity:    &namedIdentity{"boss"},
+	aclName:     "nometa",
+	op:          aclstore.OperationModify,
+	expectError: aclstore.ErrACLNotFound,
+	expectGets:  true,
+}, {
+	testName: "deny_prefix",
+	params: aclstore.Params{
+		DenyPrefix: "-",
+	},
+	identity:   &namedIdentity{"alice"},
+	aclName:    "someacl",
+	op:         aclstore.OperationModify,
+	expect:     true,
+	expectGets: true,
+}, {
+	testName: "denied",
+	params: aclstore.Params{
+		DenyPrefix: "-",
+	},
+	identity:   &namedIdentity{"frank"},
+	aclName:    "someacl",
+	op:         aclstore.OperationModify,
+	expectGets: true,
+}, {
+	testName: "case_folded_name",
+	storeParams: aclstore.StoreParams{
+		CaseInsensitive: true,
+	},
+	identity: &namedIdentity{"erin"},
+	aclName:  "someacl",
+	op:       aclstore.OperationModify,
+	expect:   true,
+}, {
+	testName: "case_unfolded_name",
+	storeParams: aclstore.StoreParams{
+		CaseInsensitive: true,
+	},
+	identity: &namedIdentity{"Alice"},
+	aclName:  "someacl",
+	op:       aclstore.OperationModify,
+	expect:   true,
+}}
+
+func TestAuthorizeByName(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range authorizeByNameTests {
+		c.Run(test.testName, func(c *qt.C) {
+			sp := test.storeParams
+			sp.KV = memsimplekv.NewStore()
+			store := &checkingStore{
+				ACLStore: aclstore.NewACLStoreWithParams(sp),
+			}
+			p := test.params
+			p.Store = store
+			p.InitialAdminUsers = []string{"boss"}
+			p.AuthorizeByName = !test.disabled
+			m, err := aclstore.NewManager(ctx, p)
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl")
+			c.Assert(err, qt.Equals, nil)
+			err = store.Set(ctx, "_someacl", []string{"alice", "team", "Erin", "frank", "-frank"})
+			c.Assert(err, qt.Equals, nil)
+			err = store.CreateACL(ctx, "nometa", nil)
+			c.Assert(err, qt.Equals, nil)
+			if p.CheckerACL != "" {
+				err = store.Set(ctx, p.CheckerACL, []string{"daisy"})
+				c.Assert(err, qt.Equals, nil)
+			}
+			store.reset()
+
+			ok, err := m.Authorize(ctx, test.identity, test.aclName, test.op)
+			if test.expectError != nil {
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectError)
+			} else {
+				c.Assert(err, qt.Equals, nil)
+			}
+			c.Assert(ok, qt.Equals, test.expect)
+			c.Assert(store.gets > 0, qt.Equals, test.expectGets, qt.Commentf("%d gets", store.gets))
+			if test.disabled {
+				c.Assert(store.contains, qt.Equals, 0)
+			}
+		})
+	}
+}
+
+func TestAuthorizeByNameWithCache(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := &checkingStore{
+		ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+	}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+		AuthorizeByName:   true,
+		Cache: &aclstore.Cache{
+			TTL: time.Minute,
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	store.reset()
+	// The cached members are checked instead.
+	for i := 0; i < 2; i++ {
+		ok, err := m.Authorize(ctx, &namedIdentity{"boss"}, "admin", aclstore.OperationRead)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, true)
+	}
+	c.Assert(store.contains, qt.Equals, 0)
+	c.Assert(store.gets, qt.Equals, 1)
+}
+
+func BenchmarkAuthorizeNamed(b *testing.B) {
+	benchmarkAuthorize(b, &namedIdentity{"user00050000"})
+}
+
+func BenchmarkAuthorizeAnonymous(b *testing.B) {
+	benchmarkAuthorize(b, identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+		for _, a := range acl {
+			if a == "user00050000" {
+				return true, nil
+			}
+		}
+		return false, nil
+	}))
+}
+
+// benchmarkAuthorize benchmarks authorizing the given identity, which
+// must be allowed by its name, to change an ACL with a large meta-ACL.
+func benchmarkAuthorize(b *testing.B, identity aclstore.Identity) {
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+		AuthorizeByName:   true,
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := m.CreateACL(ctx, "someacl"); err != nil {
+		b.Fatal(err)
+	}
+	managers := make([]string, 100000)
+	for i := range managers {
+		managers[i] = fmt.Sprintf("user%08d", i)
+	}
+	if err := store.Set(ctx, "_someacl", managers); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ok, err := m.Authorize(ctx, identity, "someacl", aclstore.OperationModify)
+		if err != nil || !ok {
+			b.Fatalf("unexpected result %v, %v", ok, err)
+		}
+	}
+}
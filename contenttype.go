@@ -0,0 +1,86 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// CodeUnsupportedMediaType holds the error code returned from the HTTP
+// endpoints when HandlerParams.StrictContentType is set and a request
+// body does not have a content type that its endpoint accepts.
+const CodeUnsupportedMediaType = params.CodeUnsupportedMediaType
+
+// errUnsupportedMediaType is the error cause used when a request body
+// is rejected because of its content type.
+var errUnsupportedMediaType = errgo.Newf("unsupported media type")
+
+// bodyMediaTypes holds the media types accepted for the bodies of PUT
+// and POST requests, other than to the import endpoint.
+var bodyMediaTypes = []string{
+	"application/json",
+	"application/x-www-form-urlencoded",
+}
+
+// patchMediaTypes holds the media types accepted
+// for the bodies of PATCH requests.
+var patchMediaTypes = []string{
+	"application/json",
+	params.JSONPatchContentType,
+	params.MergePatchContentType,
+}
+
+// checkContentType returns an error with an errUnsupportedMediaType
+// cause if the given request changes ACLs and has a body without a
+// content type that its endpoint accepts. Requests without a body,
+// such as those that clear an ACL, need no content type.
+func (h *handler) checkContentType(req *http.Request) error {
+	var accepted []string
+	switch req.Method {
+	case "PUT":
+		accepted = bodyMediaTypes
+	case "POST":
+		accepted = bodyMediaTypes
+		if h.isImportPath(req.URL.Path) {
+			accepted = []string{params.NDJSONContentType}
+		}
+	case "PATCH":
+		accepted = patchMediaTypes
+	default:
+		return nil
+	}
+	if req.ContentLength == 0 {
+		return nil
+	}
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		return errgo.WithCausef(nil, errUnsupportedMediaType, "missing Content-Type")
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil {
+		for _, t := range accepted {
+			if mediaType == t {
+				return nil
+			}
+		}
+	}
+	return errgo.WithCausef(nil, errUnsupportedMediaType, "unsupported content type %q", contentType)
+}
+
+// isImportPath reports whether the given URL path is that of the
+// import endpoint of an ACL. As ACL names served by the handler
+// cannot contain a slash, an ACL named "import" is not mistaken
+// for it.
+func (h *handler) isImportPath(p string) bool {
+	prefix := strings.TrimSuffix(path.Join(h.p.RootPath, "/"), "/")
+	rest := strings.TrimPrefix(p, prefix+"/")
+	return strings.Count(rest, "/") == 1 && path.Base(rest) == "import"
+}
@@ -0,0 +1,211 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var strictContentTypeTests = []struct {
+	testName      string
+	lenient       bool
+	method        string
+	path          string
+	contentType   string
+	body          string
+	expectStatus  int
+	expectMessage string
+}{{
+	testName:     "put_JSON",
+	method:       "PUT",
+	path:         "/someacl",
+	contentType:  "application/json",
+	body:         `{"users": ["alice"]}`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "put_JSON_with_charset",
+	method:       "PUT",
+	path:         "/someacl",
+	contentType:  "application/json; charset=utf-8",
+	body:         `{"users": ["alice"]}`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "put_form",
+	method:       "PUT",
+	path:         "/someacl",
+	contentType:  "application/x-www-form-urlencoded",
+	body:         `users=alice`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:      "put_missing_content_type",
+	method:        "PUT",
+	path:          "/someacl",
+	body:          `{"users": ["alice"]}`,
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `missing Content-Type`,
+}, {
+	testName:      "put_wrong_content_type",
+	method:        "PUT",
+	path:          "/someacl",
+	contentType:   "text/plain",
+	body:          `{"users": ["alice"]}`,
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `unsupported content type "text/plain"`,
+}, {
+	testName:      "put_invalid_content_type",
+	method:        "PUT",
+	path:          "/someacl",
+	contentType:   "application/json; =",
+	body:          `{"users": ["alice"]}`,
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `unsupported content type "application/json; ="`,
+}, {
+	testName:     "post_JSON",
+	method:       "POST",
+	path:         "/someacl",
+	contentType:  "application/json",
+	body:         `{"add": ["alice"]}`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:      "post_missing_content_type",
+	method:        "POST",
+	path:          "/someacl",
+	body:          `{"add": ["alice"]}`,
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `missing Content-Type`,
+}, {
+	testName:      "post_NDJSON",
+	method:        "POST",
+	path:          "/someacl",
+	contentType:   params.NDJSONContentType,
+	body:          "\"alice\"\n",
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `unsupported content type "application/x-ndjson"`,
+}, {
+	testName:     "post_without_body",
+	method:       "POST",
+	path:         "/someacl?action=clear",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "post_to_ACL_named_import",
+	method:       "POST",
+	path:         "/import",
+	contentType:  "application/json",
+	body:         `{"add": ["alice"]}`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "import_NDJSON",
+	method:       "POST",
+	path:         "/someacl/import",
+	contentType:  params.NDJSONContentType,
+	body:         "\"alice\"\n",
+	expectStatus: http.StatusOK,
+}, {
+	testName:      "import_JSON",
+	method:        "POST",
+	path:          "/someacl/import",
+	contentType:   "application/json",
+	body:          `["alice"]`,
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `unsupported content type "application/json"`,
+}, {
+	testName:     "patch_merge_patch",
+	method:       "PATCH",
+	path:         "/someacl",
+	contentType:  params.MergePatchContentType,
+	body:         `{"users": ["alice"]}`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:      "patch_form",
+	method:        "PATCH",
+	path:          "/someacl",
+	contentType:   "application/x-www-form-urlencoded",
+	body:          `users=alice`,
+	expectStatus:  http.StatusUnsupportedMediaType,
+	expectMessage: `unsupported content type "application/x-www-form-urlencoded"`,
+}, {
+	testName:     "get_is_not_checked",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "lenient_put_missing_content_type",
+	lenient:      true,
+	method:       "PUT",
+	path:         "/someacl",
+	body:         `{"users": ["alice"]}`,
+	expectStatus: http.StatusBadRequest,
+}, {
+	testName:     "lenient_post_wrong_content_type",
+	lenient:      true,
+	method:       "POST",
+	path:         "/someacl",
+	contentType:  "text/plain",
+	body:         `{"add": ["alice"]}`,
+	expectStatus: http.StatusBadRequest,
+}}
+
+func TestStrictContentType(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range strictContentTypeTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl", "bob")
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "import")
+			c.Assert(err, qt.Equals, nil)
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return &namedIdentity{"boss"}, nil
+				},
+				StrictContentType: !test.lenient,
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(test.body))
+			c.Assert(err, qt.Equals, nil)
+			if test.contentType != "" {
+				req.Header.Set("Content-Type", test.contentType)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			data, err := ioutil.ReadAll(resp.Body)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus, qt.Commentf("body: %s", data))
+			if test.expectMessage == "" {
+				return
+			}
+			var rerr httprequest.RemoteError
+			err = json.Unmarshal(data, &rerr)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(rerr, qt.DeepEquals, httprequest.RemoteError{
+				Message: test.expectMessage,
+				Code:    aclstore.CodeUnsupportedMediaType,
+			})
+			// The ACL is left unchanged.
+			acl, err := m.ACL(ctx, "someacl")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, []string{"bob"})
+		})
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"encoding/csv"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// writeCSV writes the members of the ACL with the given name to w.
+// The Content-Disposition header suggests a file name derived from
+// the ACL name, so that browsers download it.
+func writeCSV(w http.ResponseWriter, aclName string, users []string) {
+	w.Header().Set("Content-Type", params.CSVContentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": aclName + ".csv",
+	}))
+	cw := csv.NewWriter(w)
+	cw.Write([]string{params.CSVUserHeader})
+	for _, u := range users {
+		// Any error is kept by cw, and there is nothing
+		// that can be done about it once the response
+		// has been started.
+		cw.Write([]string{csvCell(u)})
+	}
+	cw.Flush()
+}
+
+// csvCell returns s quoted so that spreadsheets that open the CSV
+// don't interpret it as a formula.
+func csvCell(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
@@ -0,0 +1,146 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var getCSVTests = []struct {
+	testName          string
+	user              string
+	path              string
+	expectStatus      int
+	expectBody        string
+	expectDisposition string
+	expectError       *httprequest.RemoteError
+}{{
+	testName:          "members",
+	user:              "alice",
+	path:              "/someacl?format=csv",
+	expectStatus:      http.StatusOK,
+	expectBody:        "user\nbob\n\"charlie,jr\"\n",
+	expectDisposition: `attachment; filename=someacl.csv`,
+}, {
+	testName:          "empty",
+	user:              "boss",
+	path:              "/empty?format=csv",
+	expectStatus:      http.StatusOK,
+	expectBody:        "user\n",
+	expectDisposition: `attachment; filename=empty.csv`,
+}, {
+	testName:          "admin_ACL",
+	user:              "boss",
+	path:              "/admin?format=csv",
+	expectStatus:      http.StatusOK,
+	expectBody:        "user\nboss\n",
+	expectDisposition: `attachment; filename=admin.csv`,
+}, {
+	testName:          "formulas",
+	user:              "boss",
+	path:              "/formulas?format=csv",
+	expectStatus:      http.StatusOK,
+	expectBody:        "user\n'+1\n'-2\n'=cmd\n'@sum\nx=1\n",
+	expectDisposition: `attachment; filename=formulas.csv`,
+}, {
+	testName:          "csv_endpoint",
+	user:              "alice",
+	path:              "/someacl/csv",
+	expectStatus:      http.StatusOK,
+	expectBody:        "user\nbob\n\"charlie,jr\"\n",
+	expectDisposition: `attachment; filename=someacl.csv`,
+}, {
+	testName:     "not_a_manager",
+	user:         "bob",
+	path:         "/someacl?format=csv",
+	expectStatus: http.StatusForbidden,
+	expectError: &httprequest.RemoteError{
+		Message: "forbidden",
+		Code:    httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "not_found",
+	user:         "boss",
+	path:         "/nonexistent?format=csv",
+	expectStatus: http.StatusNotFound,
+	expectError: &httprequest.RemoteError{
+		Message: "ACL not found",
+		Code:    aclstore.CodeACLNotFound,
+	},
+}, {
+	testName:     "with_meta",
+	user:         "boss",
+	path:         "/someacl?format=csv&withMeta=true",
+	expectStatus: http.StatusBadRequest,
+	expectError: &httprequest.RemoteError{
+		Message: "cannot return meta-ACL members as CSV",
+		Code:    httprequest.CodeBadRequest,
+	},
+}, {
+	testName:     "unknown_format",
+	user:         "boss",
+	path:         "/someacl?format=xml",
+	expectStatus: http.StatusBadRequest,
+	expectError: &httprequest.RemoteError{
+		Message: `unknown format "xml"`,
+		Code:    httprequest.CodeBadRequest,
+	},
+}}
+
+func TestGetCSV(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "bob", "charlie,jr")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "empty")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "formulas", "=cmd", "+1", "-2", "@sum", "x=1")
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.AddReport(ctx, "_someacl", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+
+	for _, test := range getCSVTests {
+		c.Run(test.testName, func(c *qt.C) {
+			if test.expectError != nil {
+				assertJSONCallAs(c, test.user, "GET", srv.URL+test.path, nil, test.expectStatus, *test.expectError)
+				return
+			}
+			req, err := http.NewRequest("GET", srv.URL+test.path, nil)
+			c.Assert(err, qt.Equals, nil)
+			req.Header.Set("User", test.user)
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			data, err := ioutil.ReadAll(resp.Body)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus, qt.Commentf("body: %s", data))
+			c.Assert(string(data), qt.Equals, test.expectBody)
+			c.Assert(resp.Header.Get("Content-Type"), qt.Equals, params.CSVContentType)
+			c.Assert(resp.Header.Get("Content-Disposition"), qt.Equals, test.expectDisposition)
+			c.Assert(resp.Header.Get("ETag"), qt.Not(qt.Equals), "")
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gopkg.in/errgo.v1"
+)
+
+// DeleteACL deletes the ACL with the given name together with its
+// meta-ACL. The admin ACL, the checker ACL, the read-only admin ACL
+// and meta-ACLs cannot be deleted.
+//
+// The underlying store must implement ACLDeleter. See CreateACL
+// for how it interacts with concurrent calls.
+//
+// It returns an error with an ErrACLNotFound cause if
+// the ACL does not exist.
+func (m *Manager) DeleteACL(ctx context.Context, name string) error {
+	name, err := m.resolveAlias(ctx, name)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if m.isSystemACL(name) || isMetaName(name) {
+		return errgo.WithCausef(nil, ErrBadACLName, "cannot delete ACL %q", name)
+	}
+	deleter, ok := m.p.Store.(ACLDeleter)
+	if !ok {
+		return errgo.Newf("cannot delete ACLs")
+	}
+	return errgo.Mask(m.deleteACL(ctx, deleter, name), errgo.Is(ErrACLNotFound), isContextError)
+}
+
+// DeleteResult holds the result of a Manager.DeleteACLs call.
+type DeleteResult struct {
+	// Deleted holds the sorted names of the ACLs that were deleted.
+	Deleted []string
+
+	// Failed maps the name of each ACL that could not be
+	// deleted to the reason why.
+	Failed map[string]error
+}
+
+// DeleteACLs deletes all the ACLs with names starting with the given
+// prefix, together with their meta-ACLs. Every ACL is attempted even
+// if deleting some of them fails; the ACLs that could not be deleted
+// are reported in the Failed field of the result.
+//
+// The prefix must not match the admin ACL, the checker ACL or the
+// read-only admin ACL. If the
+// prefix matches the admin ACL, an error with an ErrAdminLockout cause
+// is returned and nothing is deleted.
+//
+// The underlying store must implement ACLLister and ACLDeleter.
+func (m *Manager) DeleteACLs(ctx context.Context, prefix string) (*DeleteResult, error) {
+	switch {
+	case strings.HasPrefix(m.p.AdminACLName, prefix):
+		return nil, errgo.WithCausef(nil, ErrAdminLockout, "prefix %q matches the admin ACL", prefix)
+	case m.p.CheckerACL != "" && strings.HasPrefix(m.p.CheckerACL, prefix):
+		return nil, errgo.WithCausef(nil, ErrBadACLName, "prefix %q matches the checker ACL", prefix)
+	case m.p.ReadOnlyAdminACL != "" && strings.HasPrefix(m.p.ReadOnlyAdminACL, prefix):
+		return nil, errgo.WithCausef(nil, ErrBadACLName, "prefix %q matches the read-only admin ACL", prefix)
+	case isMetaName(prefix):
+		return nil, errgo.WithCausef(nil, ErrBadACLName, "invalid prefix %q", prefix)
+	}
+	deleter, ok := m.p.Store.(ACLDeleter)
+	if !ok {
+		return nil, errgo.Newf("cannot delete ACLs")
+	}
+	names, err := m.ACLNames(ctx, false)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	result := &DeleteResult{
+		Deleted: []string{},
+	}
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		err := m.deleteACL(ctx, deleter, name)
+		switch {
+		case err == nil:
+			result.Deleted = append(result.Deleted, name)
+		case errgo.Cause(err) == ErrACLNotFound:
+			// The ACL has been removed since it was listed.
+		default:
+			if result.Failed == nil {
+				result.Failed = make(map[string]error)
+			}
+			result.Failed[name] = err
+		}
+	}
+	return result, nil
+}
+
+// deleteACL deletes the ACL with the given name and its meta-ACL.
+func (m *Manager) deleteACL(ctx context.Context, deleter ACLDeleter, name string) error {
+	defer m.nameLocks.lock(name)()
+	if err := deleter.DeleteACL(ctx, name); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	// Make sure the deleted ACL is not cached even
+	// if its meta-ACL cannot be deleted.
+	m.invalidate(name)
+	if err := deleter.DeleteACL(ctx, metaName(name)); err != nil && errgo.Cause(err) != ErrACLNotFound {
+		return errgo.NoteMask(err, "cannot delete meta-ACL", isContextError)
+	}
+	m.changed(ctx, name, OpDelete, nil)
+	return nil
+}
+
+// RestoreACL restores the deleted ACL with the given name together
+// with its meta-ACL, with the members that they had when they were
+// deleted. This is only possible while the deletion retention period
+// of the underlying store has not expired.
+//
+// The underlying store must implement ACLRestorer.
+//
+// It returns an error with an ErrACLNotFound cause if
+// there is no restorable ACL with the given name.
+func (m *Manager) RestoreACL(ctx context.Context, name string) error {
+	name, err := m.resolveAlias(ctx, name)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if isMetaName(name) {
+		return errgo.WithCausef(nil, ErrBadACLName, "cannot restore ACL %q", name)
+	}
+	restorer, ok := m.p.Store.(ACLRestorer)
+	if !ok {
+		return errgo.Newf("cannot restore ACLs")
+	}
+	defer m.nameLocks.lock(name)()
+	if err := restorer.RestoreACL(ctx, name); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	if err := restorer.RestoreACL(ctx, metaName(name)); err != nil && errgo.Cause(err) != ErrACLNotFound {
+		return errgo.NoteMask(err, "cannot restore meta-ACL", isContextError)
+	}
+	m.changed(ctx, name, OpRestore, nil)
+	return nil
+}
+
+// PurgeDeleted permanently removes the deleted ACLs whose retention
+// period has expired and returns how many were removed.
+//
+// The underlying store must implement ACLRestorer.
+func (m *Manager) PurgeDeleted(ctx context.Context) (int, error) {
+	restorer, ok := m.p.Store.(ACLRestorer)
+	if !ok {
+		return 0, errgo.Newf("cannot purge ACLs")
+	}
+	n, err := restorer.PurgeDeleted(ctx)
+	return n, errgo.Mask(err, isContextError)
+}
+
+// RunPurger calls PurgeDeleted at the given interval until the
+// context is cancelled. It is usually run in its own goroutine.
+// Errors from PurgeDeleted are ignored as the purge will be
+// retried after the next interval.
+func (m *Manager) RunPurger(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			m.PurgeDeleted(ctx)
+		}
+	}
+}
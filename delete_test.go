@@ -0,0 +1,500 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+func TestDeleteACLsEndpoint(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, srv := deleteTestServer(c, aclstore.NewACLStore(memsimplekv.NewStore()))
+	defer srv.Close()
+
+	assertJSONCall(c, "DELETE", srv.URL+"/?prefix=team-foo-", nil, http.StatusOK, params.DeleteACLsResponse{
+		Count:   2,
+		Deleted: []string{"team-foo-a", "team-foo-b"},
+	})
+	assertJSONCall(c, "GET", srv.URL+"/?includeMeta=true", nil, http.StatusOK, params.GetACLsResponse{
+		ACLs: []string{"_other", "_team-bar", "_team-foo", "admin", "other", "team-bar", "team-foo"},
+	})
+	assertJSONCall(c, "GET", srv.URL+"/team-foo-a", nil, http.StatusNotFound, &httprequest.RemoteError{
+		Code:    aclstore.CodeACLNotFound,
+		Message: "ACL not found",
+	})
+
+	// A deleted ACL can be created again, with no trace of its old members.
+	err := m.CreateACL(ctx, "team-foo-a", "daisy")
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "team-foo-a")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"daisy"})
+	users, err = m.ACL(ctx, "_team-foo-a")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.HasLen, 0)
+
+	// Nothing matching.
+	assertJSONCall(c, "DELETE", srv.URL+"/?prefix=nothing", nil, http.StatusOK, params.DeleteACLsResponse{
+		Deleted: []string{},
+	})
+}
+
+var deleteACLsErrorTests = []struct {
+	testName    string
+	prefix      string
+	expectError string
+}{{
+	testName:    "empty_prefix",
+	prefix:      "",
+	expectError: `prefix "" matches the admin ACL`,
+}, {
+	testName:    "admin_prefix",
+	prefix:      "adm",
+	expectError: `prefix "adm" matches the admin ACL`,
+}, {
+	testName:    "meta_prefix",
+	prefix:      "_team",
+	expectError: `invalid prefix "_team"`,
+}}
+
+func TestDeleteACLsError(t *testing.T) {
+	c := qt.New(t)
+	_, srv := deleteTestServer(c, aclstore.NewACLStore(memsimplekv.NewStore()))
+	defer srv.Close()
+	for _, test := range deleteACLsErrorTests {
+		c.Run(test.testName, func(c *qt.C) {
+			assertJSONCall(c, "DELETE", srv.URL+"/?prefix="+test.prefix, nil, http.StatusBadRequest, &httprequest.RemoteError{
+				Code:    httprequest.CodeBadRequest,
+				Message: test.expectError,
+			})
+		})
+	}
+	assertJSONCall(c, "GET", srv.URL+"/", nil, http.StatusOK, params.GetACLsResponse{
+		ACLs: []string{"admin", "other", "team-bar", "team-foo", "team-foo-a", "team-foo-b"},
+	})
+}
+
+func TestDeleteACLsPartialFailure(t *testing.T) {
+	c := qt.New(t)
+	store := &failingDeleteStore{
+		ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+		fail:     "team-foo-a",
+	}
+	_, srv := deleteTestServer(c, store)
+	defer srv.Close()
+	assertJSONCall(c, "DELETE", srv.URL+"/?prefix=team-", nil, http.StatusOK, params.DeleteACLsResponse{
+		Count:   3,
+		Deleted: []string{"team-bar", "team-foo", "team-foo-b"},
+		Failed: map[string]string{
+			"team-foo-a": "cannot delete team-foo-a",
+		},
+	})
+}
+
+func TestManagerDeleteACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, srv := deleteTestServer(c, aclstore.NewACLStore(memsimplekv.NewStore()))
+	srv.Close()
+	err := m.DeleteACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACL(ctx, "other")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	_, err = m.ACL(ctx, "_other")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	err = m.DeleteACL(ctx, "other")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	err = m.DeleteACL(ctx, "admin")
+	c.Assert(err, qt.ErrorMatches, `cannot delete ACL "admin"`)
+	names, err := m.ACLNames(ctx, true)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(names)
+	c.Assert(names, qt.DeepEquals, []string{"_team-bar", "_team-foo", "_team-foo-a", "_team-foo-b", "admin", "team-bar", "team-foo", "team-foo-a", "team-foo-b"})
+}
+
+func TestRestoreACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:              memsimplekv.NewStore(),
+		DeleteRetention: time.Hour,
+		Clock:           aclstore.ClockFunc(func() time.Time { return now }),
+	})
+	m, srv := deleteTestServer(c, store)
+	srv.Close()
+	err := store.Add(ctx, "other", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	err = store.Add(ctx, "_other", []string{"charlie"})
+	c.Assert(err, qt.Equals, nil)
+
+	err = m.RestoreACL(ctx, "other")
+	c.Assert(err, qt.ErrorMatches, `ACL "other" has not been deleted`)
+
+	err = m.DeleteACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACL(ctx, "other")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	names, err := m.ACLNames(ctx, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.Not(qt.Contains), "other")
+
+	now = now.Add(59 * time.Minute)
+	err = m.RestoreACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice", "bob"})
+	users, err = m.ACL(ctx, "_other")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"charlie"})
+
+	// Once the retention period has passed, the ACL can no longer be restored.
+	err = m.DeleteACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	now = now.Add(time.Hour)
+	err = m.RestoreACL(ctx, "other")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+	err = m.RestoreACL(ctx, "nonexistent")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	m, srv := deleteTestServer(c, aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:              memsimplekv.NewStore(),
+		DeleteRetention: time.Hour,
+		Clock:           aclstore.ClockFunc(func() time.Time { return now }),
+	}))
+	srv.Close()
+	err := m.DeleteACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	now = now.Add(30 * time.Minute)
+	err = m.DeleteACL(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+
+	// Nothing has expired yet.
+	n, err := m.PurgeDeleted(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 0)
+
+	// Only team-foo and its meta-ACL have expired.
+	now = now.Add(45 * time.Minute)
+	n, err = m.PurgeDeleted(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 2)
+	err = m.RestoreACL(ctx, "team-foo")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	err = m.RestoreACL(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+
+	// Purged ACLs are not purged again.
+	n, err = m.PurgeDeleted(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 0)
+}
+
+func TestACLsSkipsDeleted(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := newDeletingKV()
+	store := aclstore.NewACLStore(kv)
+	m, srv := deleteTestServer(c, store)
+	srv.Close()
+	err := m.DeleteACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	err = m.DeleteACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "other", "bob")
+	c.Assert(err, qt.Equals, nil)
+
+	// Only the ACLs marked as deleted are read.
+	kv.gets = 0
+	names, err := store.(aclstore.ACLLister).ACLs(ctx)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(names)
+	c.Assert(names, qt.DeepEquals, []string{
+		"_other",
+		"_team-bar",
+		"_team-foo-a",
+		"_team-foo-b",
+		"admin",
+		"other",
+		"team-bar",
+		"team-foo-a",
+		"team-foo-b",
+	})
+	c.Assert(kv.gets, qt.Equals, 2)
+}
+
+func TestPurgeDeletedReclaims(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	now := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	kv := newDeletingKV()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:              kv,
+		DeleteRetention: time.Hour,
+		Clock:           aclstore.ClockFunc(func() time.Time { return now }),
+	})
+	m, srv := deleteTestServer(c, store)
+	srv.Close()
+	err := m.DeleteACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	now = now.Add(30 * time.Minute)
+	err = m.DeleteACL(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+
+	// The markers of ACLs that can still be restored are kept.
+	n, err := m.PurgeDeleted(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 0)
+	c.Assert(kv.keys(), qt.Contains, "team-foo")
+
+	// Once team-foo can no longer be restored, its marker
+	// and that of its meta-ACL are removed.
+	now = now.Add(45 * time.Minute)
+	n, err = m.PurgeDeleted(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 2)
+	c.Assert(kv.keys(), qt.DeepEquals, []string{
+		"__deleted:_team-bar",
+		"__deleted:team-bar",
+		"_other",
+		"_team-bar",
+		"_team-foo-a",
+		"_team-foo-b",
+		"admin",
+		"other",
+		"team-bar",
+		"team-foo-a",
+		"team-foo-b",
+	})
+
+	// The ACL can be created again.
+	err = m.CreateACL(ctx, "team-foo", "bob")
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+
+	// Restoring an ACL and its meta-ACL removes their marks.
+	err = m.RestoreACL(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(kv.keys(), qt.Not(qt.Contains), "__deleted:team-bar")
+	c.Assert(kv.keys(), qt.Not(qt.Contains), "__deleted:_team-bar")
+}
+
+func TestRestoreACLWithoutRetention(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, srv := deleteTestServer(c, aclstore.NewACLStore(memsimplekv.NewStore()))
+	srv.Close()
+	err := m.DeleteACL(ctx, "other")
+	c.Assert(err, qt.Equals, nil)
+	err = m.RestoreACL(ctx, "other")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+// deleteTestServer returns a manager using the given store, primed
+// with some ACLs, and a server running its handler that allows
+// all requests.
+func deleteTestServer(c *qt.C, store aclstore.ACLStore) (*aclstore.Manager, *httptest.Server) {
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"team-foo-a", "team-foo-b", "team-foo", "team-bar", "other"} {
+		err := m.CreateACL(ctx, name, "alice")
+		c.Assert(err, qt.Equals, nil)
+	}
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+	}))
+	return m, srv
+}
+
+// failingDeleteStore wraps an ACL store so that
+// deleting the ACL named fail always fails.
+type failingDeleteStore struct {
+	aclstore.ACLStore
+	fail string
+}
+
+func (s *failingDeleteStore) ACLs(ctx context.Context) ([]string, error) {
+	return s.ACLStore.(aclstore.ACLLister).ACLs(ctx)
+}
+
+func (s *failingDeleteStore) DeleteACL(ctx context.Context, name string) error {
+	if name == s.fail {
+		return errgo.Newf("cannot delete %s", name)
+	}
+	return s.ACLStore.(aclstore.ACLDeleter).DeleteACL(ctx, name)
+}
+
+func TestCreateDeleteRace(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := &yieldingStore{aclstore.NewACLStore(memsimplekv.NewStore())}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for i := 0; i < 100; i++ {
+		if i%2 == 0 {
+			// Start some iterations with the ACL present.
+			err := m.CreateACL(ctx, "foo", "alice")
+			c.Assert(err, qt.Equals, nil)
+		}
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			err := m.CreateACL(ctx, "foo", "alice")
+			c.Check(err, qt.Equals, nil)
+		}()
+		go func() {
+			defer wg.Done()
+			err := m.DeleteACL(ctx, "foo")
+			if err != nil {
+				c.Check(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+			}
+		}()
+		wg.Wait()
+
+		// The ACL either exists with its initial users
+		// together with its meta-ACL, or neither exists.
+		users, err := store.Get(ctx, "foo")
+		_, metaErr := store.Get(ctx, "_foo")
+		if err == nil {
+			c.Assert(users, qt.DeepEquals, []string{"alice"})
+			c.Assert(metaErr, qt.Equals, nil, qt.Commentf("iteration %d", i))
+			err = m.DeleteACL(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+		} else {
+			c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+			c.Assert(errgo.Cause(metaErr), qt.Equals, aclstore.ErrACLNotFound, qt.Commentf("iteration %d", i))
+		}
+	}
+}
+
+// yieldingStore wraps an ACL store and pauses briefly around
+// each change so that concurrent calls interleave.
+type yieldingStore struct {
+	aclstore.ACLStore
+}
+
+func (s *yieldingStore) CreateACL(ctx context.Context, aclName string, initialUsers []string) error {
+	defer pause()
+	pause()
+	return s.ACLStore.CreateACL(ctx, aclName, initialUsers)
+}
+
+func (s *yieldingStore) DeleteACL(ctx context.Context, aclName string) error {
+	defer pause()
+	pause()
+	return s.ACLStore.(aclstore.ACLDeleter).DeleteACL(ctx, aclName)
+}
+
+func pause() {
+	time.Sleep(time.Duration(rand.Intn(50)) * time.Microsecond)
+}
+
+// deletingKV is an in-memory simplekv store that implements
+// aclstore.KeyDeleter and counts the calls to Get.
+type deletingKV struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	gets    int
+}
+
+func newDeletingKV() *deletingKV {
+	return &deletingKV{
+		entries: make(map[string][]byte),
+	}
+}
+
+func (kv *deletingKV) Context(ctx context.Context) (context.Context, func()) {
+	return ctx, func() {}
+}
+
+func (kv *deletingKV) Get(ctx context.Context, key string) ([]byte, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.gets++
+	val, ok := kv.entries[key]
+	if !ok {
+		return nil, errgo.WithCausef(nil, simplekv.ErrNotFound, "%q not found", key)
+	}
+	return val, nil
+}
+
+func (kv *deletingKV) Set(ctx context.Context, key string, value []byte, expire time.Time) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.entries[key] = value
+	return nil
+}
+
+func (kv *deletingKV) Update(ctx context.Context, key string, expire time.Time, getVal func(old []byte) ([]byte, error)) error {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	val, err := getVal(kv.entries[key])
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	kv.entries[key] = val
+	return nil
+}
+
+func (kv *deletingKV) Keys(ctx context.Context) ([]string, error) {
+	return kv.keys(), nil
+}
+
+func (kv *deletingKV) DeleteIfUnchanged(ctx context.Context, key string, value []byte) (bool, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	if val, ok := kv.entries[key]; !ok || string(val) != string(value) {
+		return false, nil
+	}
+	delete(kv.entries, key)
+	return true, nil
+}
+
+// keys returns the sorted keys of the entries in kv.
+func (kv *deletingKV) keys() []string {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	keys := make([]string, 0, len(kv.entries))
+	for key := range kv.entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+)
+
+// detailsPrefix is prefixed, after the namespace, to the key of the
+// entry that holds the member details of each ACL when
+// StoreParams.RecordMembers is enabled. The details are kept apart
+// from the ACL so that reading or counting its members doesn't mean
+// reading them too. As with indexPrefix, no ACL created by a Manager
+// can start with it.
+const detailsPrefix = "__members:"
+
+// detailsKey returns the key in s.kv of the details entry for the ACL
+// with the given name for an operation with the given context.
+func (s *kvStore) detailsKey(ctx context.Context, aclName string) string {
+	return s.namespace(ctx) + detailsPrefix + aclName
+}
+
+// isDetailsKey reports whether the given key in s.kv holds a details
+// entry rather than an ACL for an operation with the given context.
+func (s *kvStore) isDetailsKey(ctx context.Context, key string) bool {
+	return strings.HasPrefix(key, s.namespace(ctx)+detailsPrefix)
+}
+
+// memberRecord holds the stored details of a member of an ACL.
+type memberRecord struct {
+	AddedBy string    `json:"by,omitempty"`
+	AddedAt time.Time `json:"at"`
+}
+
+// detailsChange holds a change to the members of an ACL that must be
+// applied to its details entry once the ACL itself has been stored.
+type detailsChange struct {
+	// reset records that the details entry does not hold the details
+	// of the members of the ACL before the change, so that it must be
+	// replaced rather than updated.
+	reset bool
+
+	// base holds details to keep for the members after the change
+	// when reset is true. These are the details held in the header
+	// of a value stored by an earlier version of this package.
+	base map[string]memberRecord
+
+	// oldACL and newACL hold the members of the ACL before
+	// and after the change.
+	oldACL, newACL []string
+}
+
+// recordMembers updates h for a change of the members of an ACL from
+// oldACL to newACL and returns the change to make to its details
+// entry, or nil if member details are not recorded. If reset is true,
+// the details of the members before the change are discarded.
+func (s *kvStore) recordMembers(h *valueHeader, oldACL, newACL []string, reset bool) *detailsChange {
+	base := h.Members
+	h.Members = nil
+	if !s.p.RecordMembers {
+		h.Details = false
+		return nil
+	}
+	if reset {
+		base = nil
+	}
+	c := &detailsChange{
+		reset:  reset || !h.Details || base != nil,
+		base:   base,
+		oldACL: oldACL,
+		newACL: newACL,
+	}
+	h.Details = true
+	return c
+}
+
+// updateDetails applies the given change to the details entry of the
+// ACL with the given name. Users added by the change are recorded as
+// added now by the identity in ctx; the details of other members are
+// left unchanged.
+func (s *kvStore) updateDetails(ctx context.Context, aclName string, c *detailsChange) error {
+	if c == nil {
+		return nil
+	}
+	added := memberRecord{
+		AddedAt: s.p.Clock.Now().UTC(),
+	}
+	if identity, ok := IdentityFromContext(ctx); ok {
+		if identity, ok := identity.(NamedIdentity); ok {
+			added.AddedBy = identity.Name()
+		}
+	}
+	oldKeys := s.userKeySet(c.oldACL)
+	newKeys := s.userKeySet(c.newACL)
+	err := s.kv.Update(ctx, s.detailsKey(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		details := make(map[string]memberRecord, len(newKeys))
+		if c.reset {
+			for key := range newKeys {
+				if r, ok := c.base[key]; ok {
+					details[key] = r
+				} else {
+					details[key] = added
+				}
+			}
+			return json.Marshal(details)
+		}
+		if val != nil {
+			if err := json.Unmarshal(val, &details); err != nil {
+				return nil, errgo.Notef(err, "cannot decode member details")
+			}
+		}
+		for key := range oldKeys {
+			if !newKeys[key] {
+				delete(details, key)
+			}
+		}
+		for key := range newKeys {
+			if _, ok := details[key]; !ok || !oldKeys[key] {
+				details[key] = added
+			}
+		}
+		return json.Marshal(details)
+	})
+	if err != nil {
+		return errgo.NoteMask(err, "cannot update member details", isContextError)
+	}
+	return nil
+}
+
+// getDetails returns the member details recorded for the ACL with the
+// given name and header.
+func (s *kvStore) getDetails(ctx context.Context, aclName string, h valueHeader) (map[string]memberRecord, error) {
+	if h.Members != nil || !h.Details {
+		return h.Members, nil
+	}
+	val, err := s.kv.Get(ctx, s.detailsKey(ctx, aclName))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return nil, nil
+		}
+		return nil, errgo.Mask(err, isContextError)
+	}
+	var details map[string]memberRecord
+	if err := json.Unmarshal(val, &details); err != nil {
+		return nil, errgo.Notef(err, "cannot decode member details of ACL %q", aclName)
+	}
+	return details, nil
+}
+
+// discardDetails discards the details entry of the ACL with the given
+// name, which must no longer hold the details of any members. As the
+// underlying store cannot delete keys, the entry is emptied and set to
+// expire so that the store may garbage collect it.
+func (s *kvStore) discardDetails(ctx context.Context, aclName string) error {
+	err := s.kv.Set(ctx, s.detailsKey(ctx, aclName), []byte("{}"), s.p.Clock.Now())
+	if err != nil {
+		return errgo.NoteMask(err, "cannot discard member details", isContextError)
+	}
+	return nil
+}
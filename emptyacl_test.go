@@ -0,0 +1,210 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+var emptyACLPolicyTests = []struct {
+	testName string
+	policy   aclstore.EmptyACLPolicy
+	// authorize holds whether HandlerParams.Authorize is set
+	// to a custom policy that calls Manager.Authorize.
+	authorize    bool
+	caller       string
+	method       string
+	path         string
+	body         string
+	expectStatus int
+}{{
+	testName:     "closed_get",
+	caller:       "bob",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "closed_modify",
+	caller:       "bob",
+	method:       "PUT",
+	path:         "/someacl",
+	body:         `{"users": ["bob"]}`,
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "closed_admin",
+	caller:       "boss",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "explicitly_closed_get",
+	policy:       aclstore.EmptyACLClosed,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "open_get",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "open_modify",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "PUT",
+	path:         "/someacl",
+	body:         `{"users": ["bob"]}`,
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "open_check",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "POST",
+	path:         "/someacl/members",
+	body:         `{"candidates": ["alice"]}`,
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "open_managed_ACL",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/managed",
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "open_managed_ACL_manager",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "carol",
+	method:       "GET",
+	path:         "/managed",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "open_empty_meta_ACL",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/_someacl",
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "open_admin_ACL",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/admin",
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "open_nonexistent_ACL",
+	policy:       aclstore.EmptyACLOpen,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/nonexistent",
+	expectStatus: http.StatusNotFound,
+}, {
+	testName:     "open_with_custom_authorize",
+	policy:       aclstore.EmptyACLOpen,
+	authorize:    true,
+	caller:       "bob",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "open_with_custom_authorize_modify",
+	policy:       aclstore.EmptyACLOpen,
+	authorize:    true,
+	caller:       "bob",
+	method:       "PUT",
+	path:         "/someacl",
+	body:         `{"users": ["bob"]}`,
+	expectStatus: http.StatusForbidden,
+}}
+
+func TestEmptyACLPolicy(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range emptyACLPolicyTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+				EmptyACLPolicy:    test.policy,
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl", "alice")
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "managed", "alice")
+			c.Assert(err, qt.Equals, nil)
+			_, _, err = m.AddReport(ctx, "_managed", []string{"carol"})
+			c.Assert(err, qt.Equals, nil)
+			hp := aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return &namedIdentity{req.Header.Get("User")}, nil
+				},
+			}
+			if test.authorize {
+				hp.Authorize = func(ctx context.Context, identity aclstore.Identity, aclName string, op aclstore.Operation) (bool, error) {
+					return m.Authorize(ctx, identity, aclName, op)
+				}
+			}
+			srv := httptest.NewServer(m.NewHandler(hp))
+			defer srv.Close()
+
+			req, err := http.NewRequest(test.method, srv.URL+test.path, strings.NewReader(test.body))
+			c.Assert(err, qt.Equals, nil)
+			if test.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			req.Header.Set("User", test.caller)
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus)
+		})
+	}
+}
+
+func TestEmptyACLPolicyManager(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		EmptyACLPolicy:    aclstore.EmptyACLOpen,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	bob := &namedIdentity{"bob"}
+
+	// The default policy lets anyone read the ACL...
+	for _, op := range []aclstore.Operation{aclstore.OperationRead, aclstore.OperationCheck} {
+		ok, err := m.Authorize(ctx, bob, "someacl", op)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, true, qt.Commentf("%s", op))
+	}
+	// ... but not change it.
+	for _, op := range []aclstore.Operation{aclstore.OperationModify, aclstore.OperationDelete} {
+		ok, err := m.Authorize(ctx, bob, "someacl", op)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, false, qt.Commentf("%s", op))
+	}
+	acl, err := m.EffectiveCheckACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"boss"})
+
+	// Reading the ACL doesn't make bob a member.
+	ok, err := m.Check(ctx, bob, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+}
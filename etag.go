@@ -0,0 +1,99 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// aclETag returns the version token for an ACL with the given members.
+// It is a hash of the canonical form of the members, so it depends
+// only on the set of members: it does not change when an ACL is
+// rewritten with the same members, and it is the same in every process
+// that reads the ACL, including after a restart, so that tokens
+// obtained from one Manager may be used with any other Manager that
+// shares its store.
+func aclETag(users []string) string {
+	users = canonicalACL(users)
+	data := strings.Join(users, separator)
+	for _, u := range users {
+		if strings.Contains(u, separator) {
+			// Stores that escape users may hold users that contain
+			// the separator, so escape them all to keep the members
+			// unambiguous. The leading separator, which cannot start
+			// the members of any other ACL, keeps the tokens of
+			// ACLs without such users unchanged.
+			escaped := make([]string, len(users))
+			for i, u := range users {
+				escaped[i] = escapeUser(u)
+			}
+			data = separator + strings.Join(escaped, separator)
+			break
+		}
+	}
+	sum := sha256.Sum256([]byte(data))
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", sum[:16]))
+}
+
+// errNotModified is the error cause used to send a
+// 304 Not Modified response.
+var errNotModified = errgo.Newf("not modified")
+
+// listETag returns the version token for the given ACL listing.
+// It depends on everything in the listing, so it changes when an
+// ACL is created or deleted, or, if members or counts are included,
+// when their members change.
+func listETag(resp *params.GetACLsResponse) (string, error) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return "", errgo.Mask(err)
+	}
+	sum := sha256.Sum256(data)
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", sum[:16])), nil
+}
+
+// etagMatches reports whether the given If-None-Match header value
+// matches etag. The header may hold a comma-separated list of
+// version tokens, which may be weak, or "*", which matches any
+// version.
+func etagMatches(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == "*" || t == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// setIfMatch atomically sets the members of the ACL with the given name
+// if its current version token matches etag. If it does not, it returns
+// an error with an ErrPreconditionFailed cause and leaves the ACL
+// unchanged. An etag of "*" matches any version.
+//
+// The underlying store must implement ACLUpdater.
+func (m *Manager) setIfMatch(ctx context.Context, aclName string, users []string, etag string) error {
+	updater, ok := m.p.Store.(ACLUpdater)
+	if !ok {
+		return errgo.Newf("cannot update ACL conditionally")
+	}
+	if err := validateUsers(users, escapesUsers(m.p.Store)); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername))
+	}
+	err := updater.Update(ctx, aclName, func(current []string) ([]string, error) {
+		if etag != "*" && etag != aclETag(current) {
+			return nil, errgo.WithCausef(nil, ErrPreconditionFailed, "ACL %q has been modified", aclName)
+		}
+		return users, nil
+	})
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrPreconditionFailed), isContextError)
+}
@@ -0,0 +1,140 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv"
+	"github.com/juju/simplekv/memsimplekv"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+// etagServer returns a server for a new Manager using the given key
+// value store, as a separate process sharing the store would have.
+func etagServer(c *qt.C, kv simplekv.Store, sp aclstore.StoreParams, cache *aclstore.Cache) *httptest.Server {
+	sp.KV = kv
+	m, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store:             aclstore.NewACLStoreWithParams(sp),
+		InitialAdminUsers: []string{"boss"},
+		Cache:             cache,
+	})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{"boss"}, nil
+		},
+	}))
+	c.Cleanup(srv.Close)
+	return srv
+}
+
+// etagRequest makes a request to the given URL with the given If-Match
+// header and JSON body, if they are non-empty.
+func etagRequest(c *qt.C, method, url, etag string, body interface{}) *http.Response {
+	var data []byte
+	if body != nil {
+		var err error
+		data, err = json.Marshal(body)
+		c.Assert(err, qt.Equals, nil)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(data))
+	c.Assert(err, qt.Equals, nil)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	return resp
+}
+
+// getETag returns the ETag header of a GET request to the given URL.
+func getETag(c *qt.C, url string) string {
+	resp := etagRequest(c, "GET", url, "", nil)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	return resp.Header.Get("ETag")
+}
+
+func TestETagAcrossManagers(t *testing.T) {
+	c := qt.New(t)
+	kv := memsimplekv.NewStore()
+	srv1 := etagServer(c, kv, aclstore.StoreParams{}, &aclstore.Cache{
+		TTL: time.Minute,
+	})
+	resp := etagRequest(c, "PUT", srv1.URL+"/someacl/create", "", params.CreateACLRequestBody{
+		Users: []string{"charlie", "bob"},
+	})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	etag := getETag(c, srv1.URL+"/someacl")
+
+	// The token is a hash of the members alone, so it does not
+	// change between releases.
+	c.Assert(etag, qt.Equals, `"ae202662436b76ac9f290f9d6e191004"`)
+
+	// A Manager created later over the same store, as it would be
+	// after a restart, returns the same token.
+	srv2 := etagServer(c, kv, aclstore.StoreParams{}, nil)
+	c.Assert(getETag(c, srv2.URL+"/someacl"), qt.Equals, etag)
+
+	// The token obtained from one Manager can be used
+	// to change the ACL conditionally through the other.
+	resp = etagRequest(c, "PUT", srv2.URL+"/someacl", etag, params.SetACLRequestBody{
+		Users: []string{"daisy"},
+	})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	newETag := getETag(c, srv2.URL+"/someacl")
+	c.Assert(newETag, qt.Not(qt.Equals), etag)
+	resp = etagRequest(c, "PUT", srv1.URL+"/someacl", etag, params.SetACLRequestBody{
+		Users: []string{"edward"},
+	})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusPreconditionFailed)
+	resp = etagRequest(c, "PUT", srv1.URL+"/someacl", newETag, params.SetACLRequestBody{
+		Users: []string{"edward"},
+	})
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(getETag(c, srv2.URL+"/someacl"), qt.Equals, getETag(c, srv1.URL+"/someacl"))
+}
+
+func TestETagWithEscapedUsers(t *testing.T) {
+	c := qt.New(t)
+	kv := memsimplekv.NewStore()
+	sp := aclstore.StoreParams{
+		EscapeUsers: true,
+	}
+	srv := etagServer(c, kv, sp, nil)
+	for name, users := range map[string][]string{
+		"plain":   {"bob", "charlie"},
+		"escaped": {"bob\ncharlie"},
+	} {
+		resp := etagRequest(c, "PUT", srv.URL+"/"+name+"/create", "", params.CreateACLRequestBody{
+			Users: users,
+		})
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	}
+	// A user holding the separator is not confused
+	// with the users either side of it.
+	etag := getETag(c, srv.URL+"/escaped")
+	c.Assert(etag, qt.Not(qt.Equals), getETag(c, srv.URL+"/plain"))
+
+	// Tokens for ACLs without such users are the
+	// same as for stores that do not escape users.
+	c.Assert(getETag(c, srv.URL+"/plain"), qt.Equals, `"ae202662436b76ac9f290f9d6e191004"`)
+
+	// The token is the same from another Manager.
+	srv2 := etagServer(c, kv, sp, nil)
+	c.Assert(getETag(c, srv2.URL+"/escaped"), qt.Equals, etag)
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"sync/atomic"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// Event describes a change to an ACL, as delivered
+// on the channel returned by Manager.Events.
+type Event struct {
+	// Name holds the name of the ACL that was changed.
+	Name string
+
+	// Operation holds the operation that changed the ACL,
+	// one of the Op constants.
+	Operation string
+
+	// Tenant holds the tenant whose ACL was changed, as returned
+	// by TenantFromContext, or is empty if there was none.
+	Tenant string
+
+	// Users holds the users specified in the operation, as
+	// in params.ACLChange. It is nil for operations that
+	// don't specify any, such as deleting or clearing an ACL.
+	Users []string
+}
+
+// Events returns the channel on which an Event is sent after each
+// successful change to an ACL made through the Manager or its
+// handler. All callers share the same channel, so each event is
+// received by only one of them. It returns nil if Params.EventBuffer
+// is zero.
+//
+// Sending an event never blocks: if the channel's buffer is full,
+// the event is dropped and counted by DroppedEvents.
+func (m *Manager) Events() <-chan Event {
+	return m.events
+}
+
+// DroppedEvents returns the number of events that have
+// not been sent because the events channel was full.
+func (m *Manager) DroppedEvents() uint64 {
+	return atomic.LoadUint64(&m.droppedEvents)
+}
+
+// sendEvent sends an event for the given change to an ACL,
+// if events are enabled.
+func (m *Manager) sendEvent(change *params.ACLChange) {
+	if m.events == nil {
+		return
+	}
+	if len(m.events) == cap(m.events) {
+		// Don't bother building an event that can't be sent.
+		atomic.AddUint64(&m.droppedEvents, 1)
+		return
+	}
+	e := Event{
+		Name:      change.Name,
+		Operation: change.Operation,
+		Tenant:    change.Tenant,
+		Users:     change.Users,
+	}
+	select {
+	case m.events <- e:
+	default:
+		atomic.AddUint64(&m.droppedEvents, 1)
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestEvents(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		EventBuffer:       10,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.SwapMember(ctx, "someacl", "alice", "bob", false)
+	c.Assert(err, qt.Equals, nil)
+	err = m.ReplaceAdmins(ctx, []string{"boss", "charlie"}, false)
+	c.Assert(err, qt.Equals, nil)
+	err = m.ClearACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	err = m.DeleteACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+
+	var events []aclstore.Event
+	for len(events) < 5 {
+		events = append(events, <-m.Events())
+	}
+	c.Assert(events, qt.DeepEquals, []aclstore.Event{{
+		Name:      "someacl",
+		Operation: aclstore.OpCreate,
+		Users:     []string{"alice"},
+	}, {
+		Name:      "someacl",
+		Operation: aclstore.OpSwap,
+		Users:     []string{"alice", "bob"},
+	}, {
+		Name:      "admin",
+		Operation: aclstore.OpSet,
+		Users:     []string{"boss", "charlie"},
+	}, {
+		Name:      "someacl",
+		Operation: aclstore.OpClear,
+		Users:     nil,
+	}, {
+		Name:      "someacl",
+		Operation: aclstore.OpDelete,
+	}})
+	c.Assert(m.DroppedEvents(), qt.Equals, uint64(0))
+}
+
+func TestEventsOverflow(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		EventBuffer:       2,
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		err := m.CreateACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+	}
+	// Changes are not blocked when the buffer is full,
+	// and the events that do not fit are dropped.
+	c.Assert(m.DroppedEvents(), qt.Equals, uint64(3))
+	c.Assert((<-m.Events()).Name, qt.Equals, "a")
+	c.Assert((<-m.Events()).Name, qt.Equals, "b")
+	err = m.CreateACL(ctx, "f")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert((<-m.Events()).Name, qt.Equals, "f")
+}
+
+func TestEventsDisabled(t *testing.T) {
+	c := qt.New(t)
+	m, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(m.Events(), qt.IsNil)
+}
@@ -0,0 +1,67 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"net/http"
+	"time"
+)
+
+var NewRateLimiter = newRateLimiter
+
+func RateLimiterAllow(l *rateLimiter, aclName string) (bool, time.Duration) {
+	return l.allow(aclName)
+}
+
+func RateLimiterBuckets(l *rateLimiter) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+func SetImportBatchSize(n int) (restore func()) {
+	old := importBatchSize
+	importBatchSize = n
+	return func() {
+		importBatchSize = old
+	}
+}
+
+func EncodeValue(store ACLStore, acl []string) ([]byte, error) {
+	return store.(*kvStore).encodeValue(valueHeader{}, acl)
+}
+
+func DecodeValue(data []byte) ([]string, error) {
+	_, acl, err := decodeValue(data)
+	return acl, err
+}
+
+func CountValue(data []byte) (int, error) {
+	_, n, err := countValue(data)
+	return n, err
+}
+
+var ErrorMapper = errorMapper
+
+var CanonicalACL = canonicalACL
+
+func NewGzipResponseWriter(w http.ResponseWriter, minBytes int) interface {
+	http.ResponseWriter
+	http.Flusher
+	Close() error
+} {
+	return &gzipResponseWriter{
+		w:        w,
+		minBytes: minBytes,
+	}
+}
+
+func NewStatusResponseWriter(w http.ResponseWriter) interface {
+	http.ResponseWriter
+	http.Flusher
+} {
+	return &statusResponseWriter{
+		ResponseWriter: w,
+	}
+}
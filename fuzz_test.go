@@ -0,0 +1,115 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+//go:build go1.18
+// +build go1.18
+
+package aclstore_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+// FuzzValueRoundTrip checks that any valid list of users survives
+// being encoded and decoded. The users are separated by NUL bytes
+// in the fuzzed input.
+func FuzzValueRoundTrip(f *testing.F) {
+	f.Add("alice")
+	f.Add("bob\x00alice\x00bob")
+	f.Add("alice\x00")
+	f.Add("alice\nbob")
+	f.Add("{\"v\":1}\x00\"")
+	f.Fuzz(func(t *testing.T, input string) {
+		store := aclstore.NewACLStore(memsimplekv.NewStore())
+		users := strings.Split(input, "\x00")
+		data, err := aclstore.EncodeValue(store, users)
+		valid := true
+		for _, u := range users {
+			if u == "" || strings.Contains(u, "\n") {
+				valid = false
+			}
+		}
+		if !valid {
+			if errgo.Cause(err) != aclstore.ErrBadUsername {
+				t.Fatalf("unexpected error encoding invalid users %q: %v", users, err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("cannot encode %q: %v", users, err)
+		}
+		got, err := aclstore.DecodeValue(data)
+		if err != nil {
+			t.Fatalf("cannot decode %q: %v", data, err)
+		}
+		expect := canonicalUsers(users)
+		if !equalStrings(got, expect) {
+			t.Fatalf("round trip of %q gave %q; want %q", users, got, expect)
+		}
+		n, err := aclstore.CountValue(data)
+		if err != nil || n != len(expect) {
+			t.Fatalf("count of %q gave %d, %v; want %d", data, n, err, len(expect))
+		}
+	})
+}
+
+// FuzzDecodeValue checks that arbitrary stored values can be decoded
+// without panicking and never produce invalid users.
+func FuzzDecodeValue(f *testing.F) {
+	f.Add([]byte("alice\nbob"))
+	f.Add([]byte("alice\n"))
+	f.Add([]byte("\n{\"v\":1}\nalice\nbob"))
+	f.Add([]byte("\n{\"v\":1,\"deleted\":true}"))
+	f.Add([]byte("\n{\"v\":1}\n\n"))
+	f.Add([]byte("\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		users, err := aclstore.DecodeValue(data)
+		n, countErr := aclstore.CountValue(data)
+		if (err == nil) != (countErr == nil) {
+			t.Fatalf("inconsistent errors for %q: %v; %v", data, err, countErr)
+		}
+		if err != nil {
+			return
+		}
+		for _, u := range users {
+			if u == "" || strings.Contains(u, "\n") {
+				t.Fatalf("invalid user %q decoded from %q", u, data)
+			}
+		}
+		if n != len(users) {
+			t.Fatalf("count of %q gave %d; want %d", data, n, len(users))
+		}
+	})
+}
+
+// canonicalUsers returns the given users sorted with duplicates removed.
+func canonicalUsers(users []string) []string {
+	users = append([]string(nil), users...)
+	sort.Strings(users)
+	out := users[:0]
+	for i, u := range users {
+		if i == 0 || u != users[i-1] {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
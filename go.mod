@@ -4,6 +4,7 @@ go 1.16
 
 require (
 	github.com/frankban/quicktest v1.14.0
+	github.com/google/go-cmp v0.5.7 // indirect
 	github.com/juju/simplekv v1.1.0
 	github.com/julienschmidt/httprouter v1.3.0
 	golang.org/x/xerrors v0.0.0-20200804184101-5ec99f83aff1 // indirect
@@ -0,0 +1,132 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"strings"
+)
+
+// DefaultGzipMinBytes holds the minimum size of a response that is
+// compressed when HandlerParams.GzipMinBytes is zero.
+const DefaultGzipMinBytes = 1024
+
+// acceptsGzip reports whether the client that made
+// the given request accepts gzip-encoded responses.
+func acceptsGzip(req *http.Request) bool {
+	for _, h := range req.Header["Accept-Encoding"] {
+		for _, enc := range strings.Split(h, ",") {
+			enc = strings.TrimSpace(enc)
+			if i := strings.Index(enc, ";"); i >= 0 {
+				if strings.TrimSpace(enc[i+1:]) == "q=0" {
+					continue
+				}
+				enc = strings.TrimSpace(enc[:i])
+			}
+			if enc == "gzip" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter is an http.ResponseWriter that compresses the
+// response body with gzip if it is at least minBytes long. The body is
+// buffered until that size is reached, so that small responses can be
+// sent uncompressed.
+type gzipResponseWriter struct {
+	w        http.ResponseWriter
+	minBytes int
+	status   int
+	buf      bytes.Buffer
+
+	// gz holds the compressor once the response
+	// has been found to be large enough.
+	gz *gzip.Writer
+
+	// flushed holds whether the response has been flushed
+	// before it was large enough to compress, in which case
+	// the rest of it is sent uncompressed.
+	flushed bool
+}
+
+// Header implements http.ResponseWriter.Header.
+func (w *gzipResponseWriter) Header() http.Header {
+	return w.w.Header()
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+// The header is not sent until the body has been written.
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+// Write implements io.Writer.
+func (w *gzipResponseWriter) Write(data []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	if w.flushed {
+		return w.w.Write(data)
+	}
+	w.buf.Write(data)
+	if w.buf.Len() < w.minBytes || w.Header().Get("Content-Encoding") != "" {
+		return len(data), nil
+	}
+	h := w.Header()
+	h.Set("Content-Encoding", "gzip")
+	h.Add("Vary", "Accept-Encoding")
+	h.Del("Content-Length")
+	w.writeHeader()
+	w.gz = gzip.NewWriter(w.w)
+	if _, err := w.gz.Write(w.buf.Bytes()); err != nil {
+		return 0, err
+	}
+	w.buf.Reset()
+	return len(data), nil
+}
+
+// Flush implements http.Flusher. If the response has not
+// yet been found to be large enough to compress, the data
+// written so far is sent uncompressed, as is the rest of
+// the response.
+func (w *gzipResponseWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	} else if !w.flushed {
+		w.flushed = true
+		w.writeHeader()
+		w.w.Write(w.buf.Bytes())
+		w.buf.Reset()
+	}
+	if f, ok := w.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends any part of the response that has
+// not yet been sent. It must be called once the
+// response is complete.
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	if w.flushed {
+		return nil
+	}
+	w.writeHeader()
+	_, err := w.w.Write(w.buf.Bytes())
+	return err
+}
+
+func (w *gzipResponseWriter) writeHeader() {
+	if w.status != 0 {
+		w.w.WriteHeader(w.status)
+	}
+}
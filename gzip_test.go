@@ -0,0 +1,177 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var gzipTests = []struct {
+	testName       string
+	gzipMinBytes   int
+	acceptEncoding string
+	path           string
+	expectGzip     bool
+}{{
+	testName:       "large_response",
+	acceptEncoding: "gzip, deflate",
+	path:           "/",
+	expectGzip:     true,
+}, {
+	testName:       "small_response",
+	acceptEncoding: "gzip",
+	path:           "/admin",
+	expectGzip:     false,
+}, {
+	testName:   "gzip_not_accepted",
+	path:       "/",
+	expectGzip: false,
+}, {
+	testName:       "gzip_refused",
+	acceptEncoding: "gzip;q=0, identity",
+	path:           "/",
+	expectGzip:     false,
+}, {
+	testName:       "gzip_disabled",
+	gzipMinBytes:   -1,
+	acceptEncoding: "gzip",
+	path:           "/",
+	expectGzip:     false,
+}, {
+	testName:       "low_threshold",
+	gzipMinBytes:   1,
+	acceptEncoding: "gzip",
+	path:           "/admin",
+	expectGzip:     true,
+}}
+
+func TestGzip(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	var expectACLs []string
+	for i := 0; i < 200; i++ {
+		name := fmt.Sprintf("acl-%03d", i)
+		err := m.CreateACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+		expectACLs = append(expectACLs, name)
+	}
+	expectACLs = append(expectACLs, "admin")
+	for _, test := range gzipTests {
+		c.Run(test.testName, func(c *qt.C) {
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return allowed{}, nil
+				},
+				GzipMinBytes: test.gzipMinBytes,
+			}))
+			defer srv.Close()
+			req, err := http.NewRequest("GET", srv.URL+test.path, nil)
+			c.Assert(err, qt.Equals, nil)
+			if test.acceptEncoding != "" {
+				req.Header.Set("Accept-Encoding", test.acceptEncoding)
+			}
+			// Use a transport that doesn't decompress
+			// responses automatically.
+			client := &http.Client{
+				Transport: &http.Transport{
+					DisableCompression: true,
+				},
+			}
+			resp, err := client.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+			c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+			var body io.Reader = resp.Body
+			if test.expectGzip {
+				c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "gzip")
+				zr, err := gzip.NewReader(resp.Body)
+				c.Assert(err, qt.Equals, nil)
+				body = zr
+			} else {
+				c.Assert(resp.Header.Get("Content-Encoding"), qt.Equals, "")
+			}
+			if test.path == "/" {
+				var r params.GetACLsResponse
+				err = json.NewDecoder(body).Decode(&r)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(r.ACLs, qt.DeepEquals, expectACLs)
+			} else {
+				var r params.GetACLResponse
+				err = json.NewDecoder(body).Decode(&r)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(r.Users, qt.DeepEquals, []string{"alice"})
+			}
+		})
+	}
+}
+
+func TestGzipFlushBeforeMinBytes(t *testing.T) {
+	c := qt.New(t)
+	rec := httptest.NewRecorder()
+	w := aclstore.NewGzipResponseWriter(rec, 10)
+	w.WriteHeader(http.StatusCreated)
+	_, err := w.Write([]byte("hello"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(rec.Flushed, qt.Equals, false)
+	c.Assert(rec.Body.String(), qt.Equals, "")
+
+	// Flushing sends the data written so far uncompressed.
+	w.Flush()
+	c.Assert(rec.Flushed, qt.Equals, true)
+	c.Assert(rec.Code, qt.Equals, http.StatusCreated)
+	c.Assert(rec.Body.String(), qt.Equals, "hello")
+
+	// The rest of the response is sent uncompressed too.
+	_, err = w.Write([]byte(", this is more than ten bytes"))
+	c.Assert(err, qt.Equals, nil)
+	err = w.Close()
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(rec.Header().Get("Content-Encoding"), qt.Equals, "")
+	c.Assert(rec.Body.String(), qt.Equals, "hello, this is more than ten bytes")
+}
+
+func TestGzipFlushCompressed(t *testing.T) {
+	c := qt.New(t)
+	rec := httptest.NewRecorder()
+	w := aclstore.NewGzipResponseWriter(rec, 5)
+	_, err := w.Write([]byte("hello, world"))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(rec.Header().Get("Content-Encoding"), qt.Equals, "gzip")
+	n := rec.Body.Len()
+
+	// Flushing sends the compressed data written so far.
+	w.Flush()
+	c.Assert(rec.Flushed, qt.Equals, true)
+	c.Assert(rec.Body.Len() > n, qt.Equals, true)
+
+	_, err = w.Write([]byte("!"))
+	c.Assert(err, qt.Equals, nil)
+	err = w.Close()
+	c.Assert(err, qt.Equals, nil)
+	zr, err := gzip.NewReader(rec.Body)
+	c.Assert(err, qt.Equals, nil)
+	data, err := ioutil.ReadAll(zr)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, "hello, world!")
+}
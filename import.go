@@ -0,0 +1,251 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// importBatchSize holds the number of members that ImportLines
+// holds in memory before adding them to their ACLs.
+var importBatchSize = 1000
+
+// maxImportLineBytes holds the maximum length of a line read by
+// ImportMembers, so that a single user cannot exhaust memory.
+var maxImportLineBytes = 64 * 1024
+
+// ImportLines imports ACL members from r, which holds lines of the
+// form:
+//
+//	aclname<TAB>username
+//
+// as exported by some legacy systems. Empty lines are ignored. Each ACL
+// is created, together with its meta-ACL, if it does not already
+// exist, and the users are added to it. The input is read
+// incrementally and members are added in batches, so arbitrarily large
+// inputs may be imported.
+//
+// Lines with an invalid ACL name or user name are skipped; the other
+// lines are still imported. ImportLines returns the number of lines
+// that were imported and the error for the first invalid line, if any,
+// which will have an ErrBadACLName or ErrBadUsername cause. If the
+// store fails, the import stops and that error is returned instead;
+// the imported count then includes only the members that were added
+// before the failure.
+func (m *Manager) ImportLines(ctx context.Context, r io.Reader) (imported int, err error) {
+	imp := &importer{
+		m:       m,
+		created: make(map[string]bool),
+		pending: make(map[string][]string),
+	}
+	var lineErr error
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		name, user, err := m.parseImportLine(line)
+		if err != nil {
+			if lineErr == nil {
+				lineErr = errgo.NoteMask(err, fmt.Sprintf("line %d", lineNum), errgo.Any)
+			}
+			continue
+		}
+		if err := imp.add(ctx, name, user); err != nil {
+			return imp.imported, errgo.Mask(err, errgo.Is(ErrTooManyACLs), isContextError)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return imp.imported, errgo.Notef(err, "cannot read line %d", lineNum+1)
+	}
+	if err := imp.flush(ctx); err != nil {
+		return imp.imported, errgo.Mask(err, errgo.Is(ErrTooManyACLs), isContextError)
+	}
+	return imp.imported, errgo.Mask(lineErr, errgo.Is(ErrBadACLName), errgo.Is(ErrBadUsername))
+}
+
+// ImportMembers adds the users in the request body to the ACL with the
+// requested name, as Manager.ImportMembers does, and returns the number
+// of them that were not already members. The body, which must have the
+// media type params.NDJSONContentType, holds a JSON string for each
+// user, one per line. It is read incrementally and is not limited by
+// HandlerParams.MaxBodyBytes, but each line is limited to 64KiB.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) ImportMembers(p httprequest.Params, req *params.ImportMembersRequest) (*params.ImportMembersResponse, error) {
+	if mediaType, _, _ := mime.ParseMediaType(req.ContentType); mediaType != params.NDJSONContentType {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "unsupported content type %q", mediaType)
+	}
+	added, err := h.h.m.ImportMembers(p.Context, req.Name, p.Request.Body)
+	if err != nil {
+		return nil, errgo.NoteMask(err, fmt.Sprintf("imported %d users", added), errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
+	}
+	return &params.ImportMembersResponse{
+		Added: added,
+	}, nil
+}
+
+// parseImportLine parses and validates a line read by ImportLines.
+func (m *Manager) parseImportLine(line string) (name, user string, err error) {
+	i := strings.Index(line, "\t")
+	if i < 0 {
+		return "", "", errgo.WithCausef(nil, ErrBadUsername, "no user name in %q", line)
+	}
+	name, user = line[:i], line[i+1:]
+	if err := m.ValidateACLName(name); err != nil {
+		return "", "", errgo.Mask(err, errgo.Is(ErrBadACLName))
+	}
+	if !validUser(user, escapesUsers(m.p.Store)) {
+		return "", "", errgo.WithCausef(nil, ErrBadUsername, "invalid user name %q", user)
+	}
+	return name, user, nil
+}
+
+// importer holds the state of an ImportLines call.
+type importer struct {
+	m *Manager
+
+	// created holds the ACLs that have been created.
+	created map[string]bool
+
+	// pending holds the users that have yet to be added to
+	// each ACL, and npending holds the total number of them.
+	pending  map[string][]string
+	npending int
+
+	// imported holds the number of users that have been added.
+	imported int
+}
+
+// add records that the given user should be added to the given ACL,
+// adding all the pending users if there are enough of them.
+func (imp *importer) add(ctx context.Context, name, user string) error {
+	imp.pending[name] = append(imp.pending[name], user)
+	imp.npending++
+	if imp.npending < importBatchSize {
+		return nil
+	}
+	return errgo.Mask(imp.flush(ctx), errgo.Is(ErrTooManyACLs), isContextError)
+}
+
+// flush adds all the pending users to their ACLs,
+// creating the ACLs first if necessary.
+func (imp *importer) flush(ctx context.Context) error {
+	names := make([]string, 0, len(imp.pending))
+	for name := range imp.pending {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		users := imp.pending[name]
+		if !imp.created[name] {
+			if err := imp.m.CreateACL(ctx, name); err != nil {
+				return errgo.NoteMask(err, fmt.Sprintf("cannot create ACL %q", name), errgo.Is(ErrTooManyACLs), isContextError)
+			}
+			imp.created[name] = true
+		}
+		if err := imp.m.checkUsersAllowed(ctx, users); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot add users to ACL %q", name), errgo.Is(ErrBadUsername), isContextError)
+		}
+		if err := imp.m.p.Store.Add(ctx, name, users); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot add users to ACL %q", name), isContextError)
+		}
+		imp.m.changed(ctx, name, OpAdd, users)
+		imp.imported += len(users)
+		imp.npending -= len(users)
+		delete(imp.pending, name)
+	}
+	return nil
+}
+
+// ImportMembers adds the users read from r to the ACL with the given
+// name and returns the number of them that were not already members.
+// The input holds a JSON string for each user, one per line (NDJSON);
+// empty lines are ignored. It is read incrementally and the users are
+// added in batches, each with a single change to the store, so
+// arbitrarily large inputs may be imported. Lines longer than 64KiB
+// are rejected.
+//
+// If a user is invalid or the input is malformed, the import stops and
+// an error with an ErrBadUsername cause is returned; the users in
+// earlier batches will have been added, and the added count includes
+// them. It returns an error with an ErrACLNotFound cause if the ACL
+// does not exist.
+func (m *Manager) ImportMembers(ctx context.Context, aclName string, r io.Reader) (added int, err error) {
+	aclName, err = m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return 0, errgo.Mask(err, isContextError)
+	}
+	escaped := escapesUsers(m.p.Store)
+	flushed := false
+	flush := func(users []string) error {
+		flushed = true
+		newUsers, _, err := m.AddReport(ctx, aclName, users)
+		if err != nil {
+			return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+		}
+		added += len(newUsers)
+		return nil
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, maxImportLineBytes)
+	var users []string
+	n := 0
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		n++
+		var user string
+		if err := json.Unmarshal(line, &user); err != nil {
+			return added, errgo.WithCausef(nil, ErrBadUsername, "cannot read user %d: %v", n, err)
+		}
+		if !validUser(user, escaped) {
+			return added, errgo.WithCausef(nil, ErrBadUsername, "invalid user name %q", user)
+		}
+		users = append(users, user)
+		if len(users) < importBatchSize {
+			continue
+		}
+		if err := flush(users); err != nil {
+			return added, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+		}
+		users = nil
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		return added, errgo.WithCausef(nil, ErrBadUsername, "cannot read user %d: line too long", n+1)
+	} else if err != nil {
+		return added, errgo.NoteMask(err, fmt.Sprintf("cannot read user %d", n+1), isContextError)
+	}
+	if len(users) > 0 {
+		if err := flush(users); err != nil {
+			return added, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+		}
+	}
+	if !flushed {
+		// Nothing was added, but we should still report
+		// whether the ACL exists.
+		if _, err := m.Count(ctx, aclName); err != nil {
+			return 0, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+		}
+	}
+	return added, nil
+}
@@ -0,0 +1,362 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var importLinesTests = []struct {
+	testName       string
+	input          string
+	expectImported int
+	expectError    string
+	expectCause    error
+	expectACLs     map[string][]string
+}{{
+	testName: "multiple_ACLs",
+	input: "one\talice\n" +
+		"two\tbob\n" +
+		"one\tcharlie\r\n" +
+		"\n" +
+		"three\tdavid\n" +
+		"two\talice\n",
+	expectImported: 5,
+	expectACLs: map[string][]string{
+		"one":   {"alice", "charlie"},
+		"two":   {"alice", "bob"},
+		"three": {"david"},
+	},
+}, {
+	testName: "invalid_lines",
+	input: "one\talice\n" +
+		"_one\tbob\n" +
+		"two\tbob\n" +
+		"two\n" +
+		"one\tcharlie\n",
+	expectImported: 3,
+	expectError:    `line 2: invalid ACL name "_one"`,
+	expectCause:    aclstore.ErrBadACLName,
+	expectACLs: map[string][]string{
+		"one": {"alice", "charlie"},
+		"two": {"bob"},
+	},
+}, {
+	testName:       "invalid_user",
+	input:          "one\talice\none\t\n",
+	expectImported: 1,
+	expectError:    `line 2: invalid user name ""`,
+	expectCause:    aclstore.ErrBadUsername,
+	expectACLs: map[string][]string{
+		"one": {"alice"},
+	},
+}, {
+	testName:       "existing_ACL",
+	input:          "existing\tbob\n",
+	expectImported: 1,
+	expectACLs: map[string][]string{
+		"existing": {"alice", "bob"},
+	},
+}}
+
+func TestImportLines(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	// Use a small batch size so that the
+	// members are added in several batches.
+	c.Cleanup(aclstore.SetImportBatchSize(2))
+	for _, test := range importLinesTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var changes []*params.ACLChange
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+				Audit: func(ctx context.Context, change *params.ACLChange) {
+					changes = append(changes, change)
+				},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "existing", "alice")
+			c.Assert(err, qt.Equals, nil)
+			changes = nil
+
+			n, err := m.ImportLines(ctx, strings.NewReader(test.input))
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+			} else {
+				c.Assert(err, qt.Equals, nil)
+			}
+			c.Assert(n, qt.Equals, test.expectImported)
+			for name, users := range test.expectACLs {
+				got, err := m.ACL(ctx, name)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(got, qt.DeepEquals, users, qt.Commentf("ACL %q", name))
+				_, err = m.ACL(ctx, "_"+name)
+				c.Assert(err, qt.Equals, nil)
+			}
+			added := 0
+			for _, change := range changes {
+				if change.Operation == aclstore.OpAdd {
+					added += len(change.Users)
+				}
+			}
+			c.Assert(added, qt.Equals, test.expectImported)
+		})
+	}
+}
+
+func TestImportLinesStoreError(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	n, err := m.ImportLines(ctx, strings.NewReader("one\talice\n"))
+	c.Assert(err, qt.ErrorMatches, `cannot create ACL "one": context canceled`)
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+	c.Assert(n, qt.Equals, 0)
+}
+
+// addReportCountingStore counts the calls to AddReport.
+type addReportCountingStore struct {
+	aclstore.ACLStore
+	n int
+}
+
+func (s *addReportCountingStore) AddReport(ctx context.Context, aclName string, users []string) ([]string, []string, error) {
+	s.n++
+	return s.ACLStore.(aclstore.ACLAddReporter).AddReport(ctx, aclName, users)
+}
+
+// userStream returns NDJSON input holding the users
+// user0000 to userNNNN, where NNNN is n-1, followed
+// by the given extra users.
+func userStream(n int, extra ...string) (string, []string) {
+	var buf strings.Builder
+	users := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		users = append(users, fmt.Sprintf("user%04d", i))
+	}
+	for _, u := range append(users, extra...) {
+		fmt.Fprintf(&buf, "%q\n", u)
+	}
+	return buf.String(), users
+}
+
+func TestImportMembers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := &addReportCountingStore{
+		ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+	}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "user0001", "user0002")
+	c.Assert(err, qt.Equals, nil)
+
+	input, users := userStream(5000, "user0003")
+	added, err := m.ImportMembers(ctx, "someacl", strings.NewReader(input))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(added, qt.Equals, 4998)
+	// The users are added in batches of 1000.
+	c.Assert(store.n, qt.Equals, 6)
+	acl, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, users)
+
+	// Importing again adds nothing.
+	added, err = m.ImportMembers(ctx, "someacl", strings.NewReader(input))
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(added, qt.Equals, 0)
+}
+
+var importMembersErrorTests = []struct {
+	testName    string
+	aclName     string
+	input       string
+	expectAdded int
+	expectError string
+	expectCause error
+	expectACL   []string
+}{{
+	testName:    "nonexistent_ACL",
+	aclName:     "nothere",
+	input:       `"alice"`,
+	expectError: `ACL not found`,
+	expectCause: aclstore.ErrACLNotFound,
+}, {
+	testName:    "empty_input_nonexistent_ACL",
+	aclName:     "nothere",
+	expectError: `ACL not found`,
+	expectCause: aclstore.ErrACLNotFound,
+}, {
+	testName:    "invalid_user",
+	aclName:     "someacl",
+	input:       "\"alice\"\n\"bob\"\n\"\"\n\"charlie\"\n",
+	expectAdded: 2,
+	expectError: `invalid user name ""`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"alice", "bob", "dave"},
+}, {
+	testName:    "not_a_string",
+	aclName:     "someacl",
+	input:       "\"alice\"\n\"bob\"\n{}\n",
+	expectAdded: 2,
+	expectError: `cannot read user 3: json: cannot unmarshal object into Go value of type string`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"alice", "bob", "dave"},
+}, {
+	testName:    "malformed_JSON",
+	aclName:     "someacl",
+	input:       "\"alice\"\n\"bob",
+	expectAdded: 1,
+	expectError: `cannot read user 2: unexpected end of JSON input`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"alice", "dave"},
+}, {
+	testName:    "empty_lines",
+	aclName:     "someacl",
+	input:       "\"alice\"\n\n  \n{}\n",
+	expectAdded: 1,
+	expectError: `cannot read user 2: json: cannot unmarshal object into Go value of type string`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"alice", "dave"},
+}, {
+	testName:    "line_too_long",
+	aclName:     "someacl",
+	input:       "\"alice\"\n\"" + strings.Repeat("b", 70000) + "\"\n",
+	expectAdded: 1,
+	expectError: `cannot read user 2: line too long`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"alice", "dave"},
+}}
+
+func TestImportMembersErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	defer aclstore.SetImportBatchSize(1)()
+	for _, test := range importMembersErrorTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl", "dave")
+			c.Assert(err, qt.Equals, nil)
+			added, err := m.ImportMembers(ctx, test.aclName, strings.NewReader(test.input))
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+			c.Assert(added, qt.Equals, test.expectAdded)
+			if test.expectACL != nil {
+				acl, err := m.ACL(ctx, "someacl")
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(acl, qt.DeepEquals, test.expectACL)
+			}
+		})
+	}
+}
+
+func TestImportMembersEndpoint(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "user0001")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+		// The streamed body is much larger than this,
+		// but it should not be limited.
+		MaxBodyBytes: 1024,
+	}))
+	defer srv.Close()
+
+	importUsers := func(user, aclName, contentType, body string, expectStatus int, expectResponse interface{}) {
+		req, err := http.NewRequest("POST", srv.URL+"/"+aclName+"/import", strings.NewReader(body))
+		c.Assert(err, qt.Equals, nil)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("User", user)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, qt.Equals, nil)
+		defer resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, expectStatus)
+		respValue := reflect.New(reflect.TypeOf(expectResponse))
+		err = json.NewDecoder(resp.Body).Decode(respValue.Interface())
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(respValue.Elem().Interface(), qt.DeepEquals, expectResponse)
+	}
+
+	input, users := userStream(3000)
+	importUsers("boss", "someacl", params.NDJSONContentType, input, http.StatusOK, params.ImportMembersResponse{
+		Added: 2999,
+	})
+	acl, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, users)
+
+	importUsers("boss", "someacl", "application/json", `"alice"`, http.StatusBadRequest, httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `unsupported content type "application/json"`,
+	})
+	importUsers("boss", "someacl", params.NDJSONContentType, "\"alice\"\n\"\"\n", http.StatusBadRequest, httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `imported 0 users: invalid user name ""`,
+	})
+	importUsers("boss", "nothere", params.NDJSONContentType, `"alice"`, http.StatusNotFound, httprequest.RemoteError{
+		Code:    aclstore.CodeACLNotFound,
+		Message: `ACL not found`,
+	})
+	importUsers("alice", "someacl", params.NDJSONContentType, `"alice"`, http.StatusForbidden, httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+	acl, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, users)
+
+	// Bodies sent to other endpoints are limited
+	// whatever their content type.
+	data, err := json.Marshal(params.ModifyACLRequestBody{
+		Add: users,
+	})
+	c.Assert(err, qt.Equals, nil)
+	req, err := http.NewRequest("POST", srv.URL+"/someacl", bytes.NewReader(data))
+	c.Assert(err, qt.Equals, nil)
+	req.Header.Set("Content-Type", params.NDJSONContentType)
+	req.Header.Set("User", "boss")
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusRequestEntityTooLarge)
+}
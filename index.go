@@ -0,0 +1,276 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+)
+
+// indexPrefix is prefixed, after the namespace, to the key of each
+// entry in the user index. No ACL created by a Manager can start with
+// it, because ACL names cannot start with an underscore and meta-ACL
+// names hold a single underscore followed by an ACL name.
+const indexPrefix = "__users:"
+
+// indexKey returns the key in s.kv of the index entry for the user
+// with the given key, as returned by s.userKey, for an operation with
+// the given context.
+func (s *kvStore) indexKey(ctx context.Context, userKey string) string {
+	return s.namespace(ctx) + indexPrefix + userKey
+}
+
+// isIndexKey reports whether the given key in s.kv holds an index
+// entry rather than an ACL for an operation with the given context.
+func (s *kvStore) isIndexKey(ctx context.Context, key string) bool {
+	return strings.HasPrefix(key, s.namespace(ctx)+indexPrefix)
+}
+
+// IndexesUsers implements ACLIndexer.IndexesUsers.
+func (s *kvStore) IndexesUsers() bool {
+	return s.p.IndexUsers
+}
+
+// IndexedACLs implements ACLIndexer.IndexedACLs.
+func (s *kvStore) IndexedACLs(ctx context.Context, user string) ([]string, error) {
+	if !s.p.IndexUsers {
+		return nil, errgo.Newf("user index not enabled")
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	names, err := s.getIndexEntry(ctx, s.userKey(user))
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	return names, nil
+}
+
+// getIndexEntry returns the ACL names held in the index
+// entry for the user with the given key.
+func (s *kvStore) getIndexEntry(ctx context.Context, userKey string) ([]string, error) {
+	val, err := s.kv.Get(ctx, s.indexKey(ctx, userKey))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return nil, nil
+		}
+		return nil, errgo.Mask(err, isContextError)
+	}
+	names, err := decodeIndexEntry(val)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get index entry for %q", userKey)
+	}
+	return names, nil
+}
+
+// updateIndex updates the index after the members of the ACL with the
+// given name have changed from oldACL to newACL. Only the entries of
+// the users that have been added or removed are changed.
+func (s *kvStore) updateIndex(ctx context.Context, aclName string, oldACL, newACL []string) error {
+	if !s.p.IndexUsers {
+		return nil
+	}
+	oldKeys := s.userKeySet(oldACL)
+	newKeys := s.userKeySet(newACL)
+	var changed []string
+	for key := range oldKeys {
+		if !newKeys[key] {
+			changed = append(changed, key)
+		}
+	}
+	for key := range newKeys {
+		if !oldKeys[key] {
+			changed = append(changed, key)
+		}
+	}
+	sort.Strings(changed)
+	for _, key := range changed {
+		add := newKeys[key]
+		err := s.updateIndexEntry(ctx, key, func(names map[string]bool) {
+			if add {
+				names[aclName] = true
+			} else {
+				delete(names, aclName)
+			}
+		})
+		if err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	return nil
+}
+
+// updateIndexEntry atomically updates the index entry for the user
+// with the given key by calling f on the set of ACL names it holds.
+func (s *kvStore) updateIndexEntry(ctx context.Context, userKey string, f func(names map[string]bool)) error {
+	err := s.kv.Update(ctx, s.indexKey(ctx, userKey), time.Time{}, func(val []byte) ([]byte, error) {
+		names := make(map[string]bool)
+		if val != nil {
+			current, err := decodeIndexEntry(val)
+			if err != nil {
+				return nil, errgo.Notef(err, "cannot update index entry for %q", userKey)
+			}
+			for _, name := range current {
+				names[name] = true
+			}
+		}
+		f(names)
+		return encodeIndexEntry(names)
+	})
+	return errgo.Mask(err, isContextError)
+}
+
+// RebuildIndex implements ACLIndexer.RebuildIndex. The index is
+// regenerated from the members of every ACL. So that changes made
+// while the ACLs are being read are not lost, an indexed ACL that
+// was not found to hold a user is read again before it is removed
+// from the user's entry, and ACLs added to an entry while it is
+// being rebuilt are kept.
+func (s *kvStore) RebuildIndex(ctx context.Context) error {
+	if !s.p.IndexUsers {
+		return errgo.Newf("user index not enabled")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	lister, ok := s.kv.(simplekv.KeyLister)
+	if !ok {
+		return errgo.Newf("cannot list ACLs")
+	}
+	allKeys, err := lister.Keys(ctx)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	ns := s.namespace(ctx)
+	index := make(map[string]map[string]bool)
+	for _, key := range allKeys {
+		if !strings.HasPrefix(key, ns) {
+			continue
+		}
+		if s.isIndexKey(ctx, key) {
+			userKey := strings.TrimPrefix(key, ns+indexPrefix)
+			if index[userKey] == nil {
+				index[userKey] = make(map[string]bool)
+			}
+			continue
+		}
+		if s.isDetailsKey(ctx, key) || s.isAliasKey(ctx, key) || s.isDeletedKey(ctx, key) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		aclName := strings.TrimPrefix(key, ns)
+		acl, err := s.Get(ctx, aclName)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				continue
+			}
+			return errgo.Mask(err, isContextError)
+		}
+		for userKey := range s.userKeySet(acl) {
+			if index[userKey] == nil {
+				index[userKey] = make(map[string]bool)
+			}
+			index[userKey][aclName] = true
+		}
+	}
+	userKeys := make([]string, 0, len(index))
+	for userKey := range index {
+		userKeys = append(userKeys, userKey)
+	}
+	sort.Strings(userKeys)
+	for _, userKey := range userKeys {
+		if err := s.rebuildIndexEntry(ctx, userKey, index[userKey]); err != nil {
+			return errgo.NoteMask(err, "cannot rebuild index", isContextError)
+		}
+	}
+	return nil
+}
+
+// rebuildIndexEntry replaces the index entry for the user with the
+// given key with the given ACL names, keeping any other indexed ACLs
+// that still hold the user.
+func (s *kvStore) rebuildIndexEntry(ctx context.Context, userKey string, found map[string]bool) error {
+	current, err := s.getIndexEntry(ctx, userKey)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	names := make(map[string]bool, len(found))
+	for name := range found {
+		names[name] = true
+	}
+	seen := make(map[string]bool, len(current))
+	for _, name := range current {
+		seen[name] = true
+		if names[name] {
+			continue
+		}
+		acl, err := s.Get(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				continue
+			}
+			return errgo.Mask(err, isContextError)
+		}
+		if s.userKeySet(acl)[userKey] {
+			names[name] = true
+		}
+	}
+	return s.updateIndexEntry(ctx, userKey, func(latest map[string]bool) {
+		for name := range latest {
+			if seen[name] && !names[name] {
+				delete(latest, name)
+			}
+		}
+		// Any other names in latest were added
+		// to the entry since it was read.
+		for name := range names {
+			latest[name] = true
+		}
+	})
+}
+
+// userKeySet returns the set of the keys of the given users.
+func (s *kvStore) userKeySet(users []string) map[string]bool {
+	keys := make(map[string]bool, len(users))
+	for _, u := range users {
+		keys[s.userKey(u)] = true
+	}
+	return keys
+}
+
+// encodeIndexEntry returns the stored form of an
+// index entry holding the given ACL names.
+func encodeIndexEntry(names map[string]bool) ([]byte, error) {
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	data, err := json.Marshal(sorted)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return data, nil
+}
+
+// decodeIndexEntry returns the ACL names
+// held in the given stored index entry.
+func decodeIndexEntry(data []byte) ([]string, error) {
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	return names, nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestRebuildIndex(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := memsimplekv.NewStore()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:         kv,
+			IndexUsers: true,
+		}),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for name, users := range map[string][]string{
+		"one":   {"alice", "bob"},
+		"two":   {"alice"},
+		"three": {"bob"},
+	} {
+		err := m.CreateACL(ctx, name, users...)
+		c.Assert(err, qt.Equals, nil)
+	}
+	acls, err := m.ACLsForUser(ctx, "alice")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"one", "two"})
+
+	// Change the ACLs directly in the underlying
+	// store so that the index is out of date.
+	direct := aclstore.NewACLStore(kv)
+	err = direct.Add(ctx, "three", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	err = direct.Remove(ctx, "one", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	acls, err = m.ACLsForUser(ctx, "alice")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"two"})
+
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+
+	// Only administrators may rebuild the index.
+	assertJSONCallAs(c, "alice", "POST", srv.URL+"/admin/rebuild-index", nil, http.StatusForbidden, &httprequest.RemoteError{
+		Message: "forbidden",
+		Code:    httprequest.CodeForbidden,
+	})
+	assertJSONCallAs(c, "boss", "POST", srv.URL+"/admin/rebuild-index", nil, http.StatusOK, nil)
+	for user, expect := range map[string][]string{
+		"alice": {"three", "two"},
+		"bob":   {"one", "three"},
+		"boss":  {"admin"},
+	} {
+		acls, err := m.ACLsForUser(ctx, user)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acls, qt.DeepEquals, expect, qt.Commentf("user %q", user))
+	}
+}
+
+func TestUserIndexConsistency(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := memsimplekv.NewStore()
+	storeParams := aclstore.StoreParams{
+		KV:              kv,
+		CaseInsensitive: true,
+		DeleteRetention: time.Hour,
+		IndexUsers:      true,
+	}
+	store := aclstore.NewACLStoreWithParams(storeParams)
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	// The unindexed Manager reads every ACL, so it
+	// finds the ACLs for a user without the index.
+	storeParams.IndexUsers = false
+	unindexed, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStoreWithParams(storeParams),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	steps := []struct {
+		about string
+		f     func() error
+	}{{
+		about: "create",
+		f:     func() error { return m.CreateACL(ctx, "one", "Alice", "bob") },
+	}, {
+		about: "create another",
+		f:     func() error { return m.CreateACL(ctx, "two", "alice") },
+	}, {
+		about: "add",
+		f:     func() error { return store.Add(ctx, "two", []string{"BOB", "ALICE"}) },
+	}, {
+		about: "remove with different case",
+		f:     func() error { return store.Remove(ctx, "one", []string{"alice"}) },
+	}, {
+		about: "set",
+		f:     func() error { return store.Set(ctx, "one", []string{"charlie", "Bob"}) },
+	}, {
+		about: "swap",
+		f:     func() error { return m.SwapMember(ctx, "one", "charlie", "alice", false) },
+	}, {
+		about: "clear",
+		f:     func() error { return m.ClearACL(ctx, "two") },
+	}, {
+		about: "delete",
+		f:     func() error { return m.DeleteACL(ctx, "one") },
+	}, {
+		about: "restore",
+		f:     func() error { return m.RestoreACL(ctx, "one") },
+	}}
+	for _, step := range steps {
+		err := step.f()
+		c.Assert(err, qt.Equals, nil, qt.Commentf("%s", step.about))
+		for _, user := range []string{"alice", "bob", "charlie", "boss"} {
+			expect, err := unindexed.ACLsForUser(ctx, user)
+			c.Assert(err, qt.Equals, nil)
+			acls, err := m.ACLsForUser(ctx, user)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acls, qt.DeepEquals, expect, qt.Commentf("%s: user %q", step.about, user))
+		}
+	}
+	acls, err := m.ACLsForUser(ctx, "ALICE")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"one"})
+}
+
+func TestRebuildIndexNotEnabled(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.RebuildIndex(ctx)
+	c.Assert(err, qt.ErrorMatches, `cannot rebuild user index`)
+}
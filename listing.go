@@ -0,0 +1,85 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"sync"
+	"time"
+)
+
+// listingTotalTTL holds how long the total number of ACLs in a
+// listing is kept for the later pages of the listing.
+const listingTotalTTL = time.Minute
+
+// maxListingTotals holds the maximum number of listing
+// totals that are kept at once.
+const maxListingTotals = 1000
+
+// listingKey identifies the listings whose pages
+// share a total.
+type listingKey struct {
+	tenant      string
+	prefix      string
+	includeMeta bool
+	// caller holds the name of the caller for listings of manageable
+	// ACLs, which depend on who is asking. It is empty otherwise.
+	caller     string
+	manageable bool
+}
+
+// listingTotals holds the total number of ACLs found when the first
+// page of each listing was read, so that the later pages report the
+// same total.
+type listingTotals struct {
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[listingKey]listingTotal
+}
+
+type listingTotal struct {
+	total   int
+	expires time.Time
+}
+
+func newListingTotals(now func() time.Time) *listingTotals {
+	return &listingTotals{
+		now:     now,
+		entries: make(map[listingKey]listingTotal),
+	}
+}
+
+// get returns the total recorded for the listing with the given key,
+// and reports whether there is one that has not expired.
+func (t *listingTotals) get(key listingKey) (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e, ok := t.entries[key]
+	if !ok || !t.now().Before(e.expires) {
+		return 0, false
+	}
+	return e.total, true
+}
+
+// set records the total for the listing with the given key.
+func (t *listingTotals) set(key listingKey, total int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	if len(t.entries) >= maxListingTotals {
+		for k, e := range t.entries {
+			if !now.Before(e.expires) {
+				delete(t.entries, k)
+			}
+		}
+		if len(t.entries) >= maxListingTotals {
+			// Still too many; start again.
+			t.entries = make(map[listingKey]listingTotal)
+		}
+	}
+	t.entries[key] = listingTotal{
+		total:   total,
+		expires: now.Add(listingTotalTTL),
+	}
+}
@@ -4,11 +4,21 @@
 package aclstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
 	"net/http"
 	"path"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"unicode"
 
 	"github.com/julienschmidt/httprouter"
 	"gopkg.in/errgo.v1"
@@ -25,8 +35,173 @@ type Params struct {
 	// InitialAdminUsers holds the contents of the admin ACL
 	// when it is first created.
 	InitialAdminUsers []string
+
+	// DenyPrefix, if non-empty, enables deny entries in ACLs. An
+	// ACL entry starting with DenyPrefix denies access to the user
+	// or group named by the rest of the entry, even when another
+	// entry would allow it. For example, with a DenyPrefix of "-",
+	// the entry "-bob" denies access to bob.
+	DenyPrefix string
+
+	// Webhook, if non-nil, configures an HTTP endpoint that is
+	// notified asynchronously after each successful change to an
+	// ACL made through the Manager or its handler. Creating an ACL
+	// that already exists is not a change.
+	Webhook *Webhook
+
+	// Audit, if non-nil, is called synchronously after each
+	// successful change to an ACL made through the Manager or its
+	// handler, with the context used to make the change. For
+	// changes made through the handler, IdentityFromContext
+	// returns the identity that made the change; if it implements
+	// NamedIdentity, its name is recorded in the change's Actor
+	// field.
+	Audit func(ctx context.Context, change *params.ACLChange)
+
+	// CheckerACL, if non-empty, holds the name of an ACL whose
+	// members may check whether a user is a member of any ACL,
+	// but may not otherwise read or change ACLs unless another
+	// ACL allows them to. Like the admin ACL, it is created
+	// when the Manager is created and only administrators
+	// may change it.
+	CheckerACL string
+
+	// ReadOnlyAdminACL, if non-empty, holds the name of an ACL
+	// whose members may read, list and check membership of any
+	// ACL, but may not change ACLs unless another ACL allows them
+	// to. Like the admin ACL, it is created when the Manager is
+	// created and only administrators may change it.
+	ReadOnlyAdminACL string
+
+	// AdminACLName holds the name of the administrator ACL.
+	// If this is empty, AdminACL is used. Managers that share
+	// a store can use different names to keep their
+	// administrators separate.
+	AdminACLName string
+
+	// TypedPrincipals specifies that ACL entries represent typed
+	// principals, as returned by Principal.String. When it is set,
+	// identities that implement PrincipalIdentity are checked
+	// against the parsed principals rather than the plain entries.
+	TypedPrincipals bool
+
+	// ACLNamePattern, if non-nil, restricts the names of the ACLs
+	// that may be created or accessed through the handler to those
+	// that it matches. It is not applied to the leading underscore of
+	// a meta-ACL name. It must match the admin, checker and
+	// read-only admin ACL names.
+	ACLNamePattern *regexp.Regexp
+
+	// AllowPathUnsafeACLNames specifies that ACL names may hold
+	// characters that cannot be used unescaped in a URL path
+	// segment, as described in ValidateACLName. Such ACLs cannot be
+	// reached through the handler, so this should only be set when
+	// ACLs are only accessed through the Manager.
+	AllowPathUnsafeACLNames bool
+
+	// AllowReset enables Manager.Reset, which removes every ACL.
+	// It should only be set for tests and local development, never
+	// in production.
+	AllowReset bool
+
+	// Cache, if non-nil, enables caching of the members of the
+	// ACLs read by the Manager.
+	Cache *Cache
+
+	// MaxSearchResults holds the maximum number of members
+	// returned by SearchMembers. If this is zero,
+	// DefaultMaxSearchResults is used.
+	MaxSearchResults int
+
+	// MaxACLs, if non-zero, holds the maximum number of ACLs that
+	// the store may hold. Once it is reached, CreateACL fails with
+	// an ErrTooManyACLs error. Meta-ACLs are not counted, as every
+	// ACL has exactly one, but the admin ACL and the other ACLs
+	// created by NewManager are. The limit is checked before each
+	// ACL is created, so concurrent calls to CreateACL may exceed
+	// it. The underlying store must implement ACLLister.
+	MaxACLs int
+
+	// EventBuffer, if non-zero, enables the channel returned by
+	// Events and holds the number of events that it can buffer.
+	// Events that arrive when the buffer is full are dropped.
+	EventBuffer int
+
+	// UserAllowed, if non-nil, is called to check each user that
+	// is added to an ACL through the Manager or its handler, so
+	// that, for example, members can be restricted to the users
+	// known to an external directory. Users for which it returns
+	// false are rejected with an ErrBadUsername error. Unlike the
+	// checks on the format of a user, it is not applied by the
+	// store, so it is not applied to ACLs changed directly in the
+	// store or to the initial admin users.
+	UserAllowed func(ctx context.Context, user string) (bool, error)
+
+	// Templates holds templates used to choose the initial members
+	// of new ACLs. When CreateACL creates an ACL whose name matches
+	// the pattern of a template, the ACL starts with the current
+	// members of the template's source ACL as well as the initial
+	// users given. If several templates match, only the first of
+	// them is used. A template whose source ACL does not exist
+	// adds no members.
+	Templates []Template
+
+	// AdminBypass specifies whether members of the admin ACL may
+	// access every ACL. If it is nil, they may; if it points to
+	// false, administrators may only access normal ACLs whose
+	// meta-ACLs hold them, although the admin ACL, the checker
+	// and read-only admin ACLs and all meta-ACLs still require
+	// administrator access.
+	AdminBypass *bool
+
+	// DisableMetaACLs specifies that CreateACL does not create a
+	// meta-ACL for each ACL and that the default authorization
+	// policy does not consult meta-ACLs, so that only members of
+	// the admin ACL may access any ACL. Handlers created with
+	// NewHandler must set HandlerParams.Authorize to decide who may
+	// access each ACL. Existing meta-ACLs are left in the store
+	// but are ignored.
+	DisableMetaACLs bool
+
+	// AuthorizeByName specifies that the default authorization
+	// policy allows an identity that implements NamedIdentity
+	// exactly when its name is a member of one of the ACLs that
+	// would be checked, rather than calling Identity.Allow. Other
+	// entries, such as groups, then never allow such identities.
+	// This lets stores that implement ACLMembershipChecker
+	// authorize users without retrieving large ACLs.
+	AuthorizeByName bool
+
+	// EmptyACLPolicy specifies how the default authorization policy,
+	// Manager.Authorize, treats a normal ACL whose meta-ACL is
+	// empty, so that only administrators would otherwise be allowed
+	// to access it. If it is EmptyACLOpen, any identity may perform
+	// read operations on such an ACL; changes are still restricted.
+	// The zero value is EmptyACLClosed. The admin, checker and
+	// read-only admin ACLs and meta-ACLs are not affected.
+	EmptyACLPolicy EmptyACLPolicy
+
+	// Clock is used to find the current time. If this is nil,
+	// WallClock is used. It is used by the cache and by the rate
+	// limiter of handlers created by NewHandler. The store has its
+	// own clock, which can be set with StoreParams.Clock.
+	Clock Clock
 }
 
+// EmptyACLPolicy specifies who may access an ACL when
+// the ACL that authorizes access to it is empty.
+type EmptyACLPolicy string
+
+const (
+	// EmptyACLClosed allows nobody but administrators to access
+	// an ACL whose meta-ACL is empty.
+	EmptyACLClosed EmptyACLPolicy = ""
+
+	// EmptyACLOpen allows any authenticated user to read
+	// an ACL whose meta-ACL is empty.
+	EmptyACLOpen EmptyACLPolicy = "open"
+)
+
 // Identity represents an authenticated user.
 type Identity interface {
 	// Allow reports whether the user should be allowed to access
@@ -34,26 +209,252 @@ type Identity interface {
 	Allow(ctx context.Context, acl []string) (bool, error)
 }
 
-// AdminACL holds the name of the administrator ACL.
+// NamedIdentity may be implemented by an Identity that has a name.
+// The name is recorded as the adder of ACL members by stores that
+// record member details, and as the actor of the changes passed to
+// Params.Audit and Params.Webhook. If Params.AuthorizeByName is set,
+// the name is also used to authorize the identity.
+type NamedIdentity interface {
+	Identity
+
+	// Name returns the name of the identity.
+	Name() string
+}
+
+// Operation identifies the kind of access that
+// an HTTP request makes to an ACL.
+type Operation string
+
+// The following operations are distinguished when
+// authorizing HTTP requests.
+const (
+	// OperationCheck checks whether a user is a member of an ACL.
+	OperationCheck Operation = "check"
+
+	// OperationRead reads the members of an ACL.
+	OperationRead Operation = "read"
+
+	// OperationModify changes the members of an ACL.
+	OperationModify Operation = "modify"
+
+	// OperationList lists ACLs.
+	OperationList Operation = "list"
+
+	// OperationDelete deletes an ACL.
+	OperationDelete Operation = "delete"
+)
+
+type identityKey struct{}
+
+// IdentityFromContext returns the identity authenticated by the HTTP
+// handler for the request with the given context, and reports whether
+// there is one. The identity is available to the Authorize and Audit
+// functions and to the endpoint implementations.
+func IdentityFromContext(ctx context.Context) (Identity, bool) {
+	identity, ok := ctx.Value(identityKey{}).(Identity)
+	return identity, ok
+}
+
+// ContextWithIdentity returns a context with the given identity
+// attached, as returned by IdentityFromContext. The HTTP handler
+// attaches the authenticated identity to the context of each
+// request; this can be used to do the same for changes made
+// directly through the Manager.
+func ContextWithIdentity(ctx context.Context, identity Identity) context.Context {
+	return context.WithValue(ctx, identityKey{}, identity)
+}
+
+// AdminACL holds the default name of the administrator ACL.
 const AdminACL = "admin"
 
 // CodeACLNotFound holds the error code returned from
 // the HTTP endpoints when an ACL name has not been
 // created.
-const CodeACLNotFound = "ACL not found"
+const CodeACLNotFound = params.CodeACLNotFound
+
+// CodeUserNotFound holds the error code returned from
+// the HTTP endpoints when a user to be removed is
+// not a member of an ACL.
+const CodeUserNotFound = params.CodeUserNotFound
+
+// CodeRequestTooLarge holds the error code returned from
+// the HTTP endpoints when a request body is larger than
+// the configured maximum.
+const CodeRequestTooLarge = params.CodeRequestTooLarge
+
+// CodePreconditionFailed holds the error code returned from
+// the HTTP endpoints when a conditional request's If-Match
+// header does not match the current version of an ACL.
+const CodePreconditionFailed = params.CodePreconditionFailed
+
+// CodeTooManyACLs holds the error code returned from the HTTP
+// endpoints when an ACL cannot be created because the limit on
+// the number of ACLs has been reached.
+const CodeTooManyACLs = params.CodeTooManyACLs
+
+// CodeACLExists holds the error code returned from the HTTP
+// endpoints when an ACL that is to be created already exists.
+const CodeACLExists = params.CodeACLExists
+
+// CodeAliasExists holds the error code returned from the HTTP
+// endpoints when an ACL cannot be created because its name is
+// used by an alias.
+const CodeAliasExists = params.CodeAliasExists
+
+// CodeConflictingChanges holds the error code returned from the HTTP
+// endpoints when a modify request asks for changes that cannot be
+// made together, such as adding and removing users at the same time.
+const CodeConflictingChanges = params.CodeConflictingChanges
+
+// CodeNoChanges holds the error code returned from the HTTP endpoints
+// when a modify request does not ask for any change to be made.
+const CodeNoChanges = params.CodeNoChanges
+
+// CodeMissingUsers holds the error code returned from the HTTP
+// endpoints when a modify request does not specify the users that
+// its action requires.
+const CodeMissingUsers = params.CodeMissingUsers
+
+// Errors with these causes are returned when a modify request is well
+// formed but cannot be carried out; they are mapped to 422 Unprocessable
+// Entity responses with the matching code so that clients can tell them
+// apart from malformed requests.
+var (
+	errConflictingChanges = errgo.Newf("conflicting changes")
+	errNoChanges          = errgo.Newf("no changes")
+	errMissingUsers       = errgo.Newf("missing users")
+)
+
+// DefaultMaxDetailACLs holds the maximum number of ACLs whose members
+// are returned by a GetACLs request when HandlerParams.MaxDetailACLs
+// is zero.
+const DefaultMaxDetailACLs = 1000
+
+// DefaultMaxSearchResults holds the maximum number of members returned
+// by Manager.SearchMembers when Params.MaxSearchResults is zero.
+const DefaultMaxSearchResults = 100
+
+// DefaultMaxBodyBytes holds the maximum request body size used
+// when HandlerParams.MaxBodyBytes is zero.
+const DefaultMaxBodyBytes = 1024 * 1024
 
 // Manager implements an ACL manager.
 type Manager struct {
+	// droppedEvents holds the number of events that could not be
+	// sent. It is accessed atomically, so it is kept first to
+	// ensure its alignment.
+	droppedEvents uint64
+
 	p Params
+
+	// cache holds the ACL cache, or nil if ACLs
+	// are not cached.
+	cache *aclCache
+
+	// nameLocks serializes the creation, deletion and
+	// restoration of each ACL and its meta-ACL.
+	nameLocks nameLocker
+
+	// events holds the channel that change events are sent
+	// on, or nil if events are not enabled.
+	events chan Event
+
+	// webhook holds the queue of notifications for the
+	// webhook, or nil if there is no webhook.
+	webhook *webhookQueue
+
+	// tenantMu guards tenants.
+	tenantMu sync.Mutex
+
+	// tenants holds the tenants whose system
+	// ACLs have been created by the handler.
+	tenants map[string]bool
+}
+
+// nameLocker holds a mutex for each ACL name that is in use.
+type nameLocker struct {
+	mu    sync.Mutex
+	locks map[string]*nameLock
+}
+
+type nameLock struct {
+	mu sync.Mutex
+	// n holds the number of callers using the lock.
+	// It is guarded by nameLocker.mu.
+	n int
+}
+
+// lock acquires the mutex for the given name and returns
+// a function that releases it.
+func (l *nameLocker) lock(name string) (unlock func()) {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*nameLock)
+	}
+	nl := l.locks[name]
+	if nl == nil {
+		nl = new(nameLock)
+		l.locks[name] = nl
+	}
+	nl.n++
+	l.mu.Unlock()
+	nl.mu.Lock()
+	return func() {
+		nl.mu.Unlock()
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		nl.n--
+		if nl.n == 0 {
+			delete(l.locks, name)
+		}
+	}
 }
 
 var errAuthenticationFailed = errgo.Newf("authentication failed")
 
+// ErrUnauthorized may be returned as the cause of an error from
+// HandlerParams.Authenticate to have the handler write a 401
+// Unauthorized response.
+var ErrUnauthorized = errgo.Newf("unauthorized")
+
+// ErrBadACLName is the error cause used when
+// an ACL name is not valid.
+var ErrBadACLName = errgo.Newf("bad ACL name")
+
+// ErrAdminLockout is the error cause used when an operation
+// would leave the admin ACL without any of its current members.
+var ErrAdminLockout = errgo.Newf("admin lockout")
+
+// ErrTooManyACLs is the error cause used when an ACL cannot
+// be created because the store holds Params.MaxACLs ACLs.
+var ErrTooManyACLs = errgo.Newf("too many ACLs")
+
+// ErrPreconditionFailed is the error cause used when a conditional
+// change is made to an ACL that has been changed since the version
+// token was obtained.
+var ErrPreconditionFailed = errgo.Newf("precondition failed")
+
+// ErrACLExists is the error cause used when an ACL cannot
+// be created because it already exists.
+var ErrACLExists = errgo.Newf("ACL already exists")
+
 var reqServer = &httprequest.Server{
 	ErrorWriter: func(ctx context.Context, w http.ResponseWriter, err error) {
-		if errgo.Cause(err) == errAuthenticationFailed {
+		switch errgo.Cause(err) {
+		case errAuthenticationFailed:
 			// The Authenticate method has already written its response.
 			return
+		case errNotModified:
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		if b, _ := ctx.Value(limitedBodyKey{}).(*limitedBody); b != nil && b.exceeded() {
+			// Whatever the error, it was caused by the body being truncated.
+			httprequest.WriteJSON(w, http.StatusRequestEntityTooLarge, &httprequest.RemoteError{
+				Message: "request body too large",
+				Code:    CodeRequestTooLarge,
+			})
+			return
 		}
 		status, body := errorMapper(ctx, err)
 		httprequest.WriteJSON(w, status, body)
@@ -67,7 +468,59 @@ func errorMapper(ctx context.Context, err error) (int, interface{}) {
 			Message: err.Error(),
 			Code:    CodeACLNotFound,
 		}
-	case ErrBadUsername:
+	case ErrUserNotFound:
+		return http.StatusBadRequest, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeUserNotFound,
+		}
+	case ErrPreconditionFailed:
+		return http.StatusPreconditionFailed, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodePreconditionFailed,
+		}
+	case errRateLimited:
+		return http.StatusTooManyRequests, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeTooManyRequests,
+		}
+	case ErrACLExists:
+		return http.StatusConflict, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeACLExists,
+		}
+	case ErrAliasExists:
+		return http.StatusConflict, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeAliasExists,
+		}
+	case ErrTooManyACLs:
+		return http.StatusForbidden, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeTooManyACLs,
+		}
+	case errConflictingChanges:
+		return http.StatusUnprocessableEntity, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeConflictingChanges,
+		}
+	case errNoChanges:
+		return http.StatusUnprocessableEntity, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeNoChanges,
+		}
+	case errMissingUsers:
+		return http.StatusUnprocessableEntity, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeMissingUsers,
+		}
+	case errUnsupportedMediaType:
+		return http.StatusUnsupportedMediaType, &httprequest.RemoteError{
+			Message: err.Error(),
+			Code:    CodeUnsupportedMediaType,
+		}
+	case ErrUnauthorized:
+		err = httprequest.Errorf(httprequest.CodeUnauthorized, "%v", err)
+	case ErrBadUsername, ErrBadACLName, ErrAdminLockout, errBadPatch, httprequest.ErrUnmarshal:
 		err = httprequest.Errorf(httprequest.CodeBadRequest, "%v", err)
 	}
 	return httprequest.DefaultErrorMapper(ctx, err)
@@ -75,212 +528,2571 @@ func errorMapper(ctx context.Context, err error) (int, interface{}) {
 
 // NewManager returns a new Manager instance that manages a
 // set of ACLs. It ensures there is at least one ACL
-// created, named "admin", which is given p.InitialAdminUsers
-// when it is first created.
+// created, named p.AdminACLName ("admin" by default), which
+// is given p.InitialAdminUsers when it is first created.
 func NewManager(ctx context.Context, p Params) (*Manager, error) {
-	if err := p.Store.CreateACL(ctx, AdminACL, p.InitialAdminUsers); err != nil {
-		return nil, errgo.Notef(err, "cannot create initial admin ACL")
+	if p.AdminACLName == "" {
+		p.AdminACLName = AdminACL
+	}
+	if isMetaName(p.AdminACLName) {
+		return nil, errgo.Newf("invalid admin ACL name %q", p.AdminACLName)
+	}
+	if p.CheckerACL != "" && (p.CheckerACL == p.AdminACLName || isMetaName(p.CheckerACL)) {
+		return nil, errgo.Newf("invalid checker ACL name %q", p.CheckerACL)
+	}
+	if p.ReadOnlyAdminACL != "" && (p.ReadOnlyAdminACL == p.AdminACLName || p.ReadOnlyAdminACL == p.CheckerACL || isMetaName(p.ReadOnlyAdminACL)) {
+		return nil, errgo.Newf("invalid read-only admin ACL name %q", p.ReadOnlyAdminACL)
+	}
+	if err := validateParams(p); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrBadUsername))
+	}
+	if err := createSystemACLs(ctx, p); err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if p.Clock == nil {
+		p.Clock = WallClock
 	}
 	m := &Manager{
 		p: p,
 	}
+	if p.EventBuffer > 0 {
+		m.events = make(chan Event, p.EventBuffer)
+	}
+	if p.Webhook != nil && p.Webhook.URL != "" {
+		m.webhook = newWebhookQueue(p.Webhook)
+	}
+	if p.Cache != nil {
+		m.cache = newACLCache(*p.Cache, p.Store, p.Clock.Now)
+	}
 	return m, nil
 }
 
-// ACL returns the members of the given ACL.
-func (m *Manager) ACL(ctx context.Context, name string) ([]string, error) {
-	// TODO implement a cache to avoid hitting the underlying
-	// store each time.
-	return m.p.Store.Get(ctx, name)
-}
-
-// CreateACL creates an ACL with the given name. It also creates an ACL
-// _name which is the ACL that guards membership of the ACL itself. Any
-// member of _name or any member of the admin ACL may change the
-// membership of ACL name. Only members of the admin ACL may change the
-// membership of _name.
-//
-// The name itself must not start with an underscore.
-//
-// This does nothing if an ACL with that name already exists.
-func (h *Manager) CreateACL(ctx context.Context, name string, initialUsers ...string) error {
-	if isMetaName(name) {
-		return errgo.Newf("invalid ACL name %q", name)
+// validateParams checks that the limits held in p are not negative,
+// that the initial admin users are valid and that the system ACL
+// names match any ACL name pattern and are safe to use in a URL path,
+// so that a misconfigured Manager is rejected when it is created
+// rather than when it is used.
+func validateParams(p Params) error {
+	limits := []struct {
+		name  string
+		value int
+	}{
+		{"MaxSearchResults", p.MaxSearchResults},
+		{"MaxACLs", p.MaxACLs},
+		{"EventBuffer", p.EventBuffer},
 	}
-	if err := h.p.Store.CreateACL(ctx, name, initialUsers); err != nil {
-		return errgo.Mask(err)
+	for _, l := range limits {
+		if l.value < 0 {
+			return errgo.Newf("invalid %s %d: must not be negative", l.name, l.value)
+		}
 	}
-	if err := h.p.Store.CreateACL(ctx, metaName(name), nil); err != nil {
-		return errgo.Mask(err)
+	switch p.EmptyACLPolicy {
+	case EmptyACLClosed, EmptyACLOpen:
+	default:
+		return errgo.Newf("invalid EmptyACLPolicy %q", p.EmptyACLPolicy)
+	}
+	if p.Cache != nil && (p.Cache.TTL < 0 || p.Cache.RefreshAhead < 0) {
+		return errgo.Newf("invalid cache durations: must not be negative")
+	}
+	if p.Webhook != nil && (p.Webhook.QueueSize < 0 || p.Webhook.Timeout < 0 || p.Webhook.RetryDelay < 0) {
+		return errgo.Newf("invalid webhook parameters: must not be negative")
+	}
+	for _, t := range p.Templates {
+		if _, err := path.Match(t.Pattern, ""); err != nil || t.Source == "" {
+			return errgo.Newf("invalid template %q for %q", t.Pattern, t.Source)
+		}
+	}
+	if err := validateUsers(p.InitialAdminUsers, escapesUsers(p.Store)); err != nil {
+		return errgo.NoteMask(err, "invalid initial admin users", errgo.Is(ErrBadUsername))
+	}
+	for _, name := range []string{p.AdminACLName, p.CheckerACL, p.ReadOnlyAdminACL} {
+		if name == "" {
+			continue
+		}
+		if p.ACLNamePattern != nil && !p.ACLNamePattern.MatchString(name) {
+			return errgo.Newf("system ACL name %q does not match %q", name, p.ACLNamePattern)
+		}
+		if !p.AllowPathUnsafeACLNames && !pathSafe(name) {
+			return errgo.Newf("system ACL name %q is not safe to use in a URL path", name)
+		}
+		if !p.AllowPathUnsafeACLNames && reservedACLNames[name] {
+			return errgo.Newf("system ACL name %q is reserved for another endpoint", name)
+		}
 	}
 	return nil
 }
 
-// aclName is implemented by the request parameters for all endpoints
-// to return the associated ACL name.
-type aclName interface {
-	ACLName() string
+// checkUsersAllowed returns an error with an ErrBadUsername cause if
+// Params.UserAllowed is set and does not allow any of the given users.
+func (m *Manager) checkUsersAllowed(ctx context.Context, users []string) error {
+	if m.p.UserAllowed == nil {
+		return nil
+	}
+	for _, u := range users {
+		ok, err := m.p.UserAllowed(ctx, u)
+		if err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot check user %q", u), isContextError)
+		}
+		if !ok {
+			return errgo.WithCausef(nil, ErrBadUsername, "user %q is not allowed", u)
+		}
+	}
+	return nil
 }
 
-// HandlerParams holds the parameters for a NewHandler call.
-type HandlerParams struct {
-	// RootPath holds the root URL path prefix to use
-	// for the ACL endpoints. All the endpoints will be
-	// prefixed with this path.
-	RootPath string
-
-	// Authenticate authenticates the given HTTP request and returns
-	// the resulting authenticated identity. If authentication
-	// fails, Authenticate should write its own response and return
-	// an error.
-	Authenticate func(ctx context.Context, w http.ResponseWriter, req *http.Request) (Identity, error)
+// createSystemACLs creates the admin ACL and any checker
+// and read-only admin ACLs configured by p.
+func createSystemACLs(ctx context.Context, p Params) error {
+	if err := p.Store.CreateACL(ctx, p.AdminACLName, p.InitialAdminUsers); err != nil {
+		return errgo.Notef(err, "cannot create initial admin ACL")
+	}
+	if p.CheckerACL != "" {
+		if err := p.Store.CreateACL(ctx, p.CheckerACL, nil); err != nil {
+			return errgo.Notef(err, "cannot create checker ACL")
+		}
+	}
+	if p.ReadOnlyAdminACL != "" {
+		if err := p.Store.CreateACL(ctx, p.ReadOnlyAdminACL, nil); err != nil {
+			return errgo.Notef(err, "cannot create read-only admin ACL")
+		}
+	}
+	return nil
 }
 
-// NewHandler creates an ACL administration interface that allows clients
-// to manipulate the ACLs. The set of ACLs that can be manipulated can be
-// changed with the Manager.CreateACL method.
-func (m *Manager) NewHandler(p HandlerParams) http.Handler {
-	h := &handler{
-		p:      p,
-		m:      m,
-		router: httprouter.New(),
-	}
-	h.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		httprequest.WriteJSON(w, http.StatusNotFound, &httprequest.RemoteError{
-			Message: "URL path not found",
-			Code:    httprequest.CodeNotFound,
-		})
-	})
-	for _, ep := range reqServer.Handlers(h.newHandler) {
-		h.router.Handle(ep.Method, path.Join(p.RootPath, ep.Path), ep.Handle)
+// ACL returns the members of the given ACL. If caching is enabled,
+// the members may be returned from the cache, unless the context
+// has a tenant attached.
+func (m *Manager) ACL(ctx context.Context, name string) ([]string, error) {
+	name, err := m.resolveAlias(ctx, name)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
 	}
-	return h
+	if _, ok := TenantFromContext(ctx); m.cache != nil && !ok {
+		return m.cache.get(ctx, name)
+	}
+	return m.p.Store.Get(ctx, name)
 }
 
-type handler struct {
-	p      HandlerParams
-	m      *Manager
-	router *httprouter.Router
+// ACLConsistent is like ACL except that the members are always read
+// from the store, even if they are cached, so that changes made other
+// than through the Manager, for example by another process sharing the
+// store, are seen. If caching is enabled, the cached entry is replaced
+// with the members read, so that later calls to ACL see them too.
+func (m *Manager) ACLConsistent(ctx context.Context, name string) ([]string, error) {
+	name, err := m.resolveAlias(ctx, name)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	if _, ok := TenantFromContext(ctx); m.cache != nil && !ok {
+		return m.cache.refresh(ctx, name)
+	}
+	return m.p.Store.Get(ctx, name)
 }
 
-// ServeHTTP implements http.Handler.
-func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
-	h.router.ServeHTTP(w, req)
+// Reload discards all the ACL members cached by the Manager, so that
+// the next read of each ACL reads it from the store. It should be
+// called when the store has been changed other than through the
+// Manager, for example by another Manager that shares the store.
+// It does nothing if caching is not enabled.
+func (m *Manager) Reload() {
+	if m.cache != nil {
+		m.cache.clear()
+	}
 }
 
-type handler1 struct {
-	h *handler
+// InvalidateCache is like Reload except that it only discards
+// the cached members of the ACL with the given name.
+func (m *Manager) InvalidateCache(name string) {
+	// Only the ACLs of requests without a tenant are
+	// cached, so only their aliases are followed. If the
+	// alias cannot be resolved, the name is invalidated
+	// as it is.
+	if target, err := m.resolveAlias(context.Background(), name); err == nil {
+		name = target
+	}
+	m.invalidate(name)
 }
 
-// newHandler returns a handler instance to serve a particular HTTP request.
-func (h *handler) newHandler(p httprequest.Params, arg aclName) (handler1, context.Context, error) {
-	ctx := p.Context
-	if err := h.authorizeRequest(ctx, p, arg.ACLName()); err != nil {
-		return handler1{}, nil, errgo.Mask(err, errgo.Any)
+// invalidate removes the given ACLs from the cache, if any.
+func (m *Manager) invalidate(aclNames ...string) {
+	if m.cache != nil {
+		m.cache.invalidate(aclNames...)
 	}
-	return handler1{
-		h: h,
-	}, p.Context, nil
 }
 
-// authorizeRequest checks that an HTTP request is authorized. If the
-// authorization failed because Authenticate failed, it returns an error
-// with an errAuthenticationFailed cause to signal that the desired
-// error response has already been written.
-func (h *handler) authorizeRequest(ctx context.Context, p httprequest.Params, aclName string) error {
-	if aclName == "" {
-		return httprequest.Errorf(httprequest.CodeBadRequest, "empty ACL name")
-	}
-	identity, err := h.p.Authenticate(ctx, p.Response, p.Request)
+// Count returns the number of members of the given ACL. If the
+// underlying store implements ACLCounter, it is used to avoid
+// retrieving the members.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL
+// does not exist.
+func (m *Manager) Count(ctx context.Context, name string) (int, error) {
+	name, err := m.resolveAlias(ctx, name)
 	if err != nil {
-		return errAuthenticationFailed
+		return 0, errgo.Mask(err, isContextError)
 	}
-	var checkACLName string
-	if aclName == AdminACL || isMetaName(aclName) {
-		// We're trying to access either the admin ACL or a meta-ACL; for either
-		// of these, admin privileges are needed.
-		checkACLName = AdminACL
-	} else {
-		// For all normal ACLs, access for a given ACL name is decided via membership
-		// of the meta-ACL for that name.
-		checkACLName = metaName(aclName)
+	if counter, ok := m.p.Store.(ACLCounter); ok {
+		n, err := counter.CountACL(ctx, name)
+		return n, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
 	}
-	acl, err := h.m.ACL(ctx, checkACLName)
+	users, err := m.ACL(ctx, name)
 	if err != nil {
-		return errgo.Mask(err, errgo.Is(ErrACLNotFound))
+		return 0, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
 	}
-	if checkACLName != AdminACL {
-		// Admin users always get permission to do anything.
-		adminACL, err := h.m.ACL(ctx, AdminACL)
-		if err != nil {
-			return errgo.Notef(err, "cannot get admin ACL")
-		}
-		acl = append(acl, adminACL...)
+	return len(users), nil
+}
+
+// MemberDetails returns the members of the given ACL together with who
+// added them and when. If the underlying store does not implement
+// ACLDetailer, only the users are filled in.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL
+// does not exist.
+func (m *Manager) MemberDetails(ctx context.Context, name string) ([]Member, error) {
+	name, err := m.resolveAlias(ctx, name)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	if detailer, ok := m.p.Store.(ACLDetailer); ok {
+		members, err := detailer.GetDetails(ctx, name)
+		return members, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
 	}
-	ok, err := identity.Allow(ctx, acl)
+	users, err := m.ACL(ctx, name)
 	if err != nil {
-		return errgo.Notef(err, "cannot check permissions")
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
 	}
-	if !ok {
-		return httprequest.Errorf(httprequest.CodeForbidden, "")
+	members := make([]Member, len(users))
+	for i, u := range users {
+		members[i] = Member{User: u}
 	}
-	return nil
+	return members, nil
 }
 
-// GetACL returns the members of the ACL with the requested name.
-// Only administrators and members of the meta-ACL for the name
-// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
-func (h handler1) GetACL(p httprequest.Params, req *params.GetACLRequest) (*params.GetACLResponse, error) {
-	users, err := h.h.m.p.Store.Get(p.Context, req.Name)
+// ACLWithVersion returns the members of the given ACL together with
+// its current generation, which increases every time the ACL is
+// changed. Unlike ACL, it always reads the store, so the members and
+// generation are consistent with one another. The underlying store
+// must implement ACLVersioner.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL
+// does not exist.
+func (m *Manager) ACLWithVersion(ctx context.Context, name string) ([]string, uint64, error) {
+	versioner, ok := m.p.Store.(ACLVersioner)
+	if !ok {
+		return nil, 0, errgo.Newf("cannot get ACL versions")
+	}
+	name, err := m.resolveAlias(ctx, name)
 	if err != nil {
-		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+		return nil, 0, errgo.Mask(err, isContextError)
 	}
-	return &params.GetACLResponse{
-		Users: users,
-	}, nil
-}
-
-// SetACL sets the members of the ACL with the requested name.
-// Only administrators and members of the meta-ACL for the name
-// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
-func (h handler1) SetACL(p httprequest.Params, req *params.SetACLRequest) error {
-	err := h.h.m.p.Store.Set(p.Context, req.Name, req.Body.Users)
-	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
+	users, version, err := versioner.GetWithVersion(ctx, name)
+	if err != nil {
+		return nil, 0, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return users, version, nil
 }
 
-// ModifyACL modifies the members of the ACL with the requested name.
-// Only administrators and members of the meta-ACL for the name
-// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
-func (h handler1) ModifyACL(p httprequest.Params, req *params.ModifyACLRequest) error {
-	switch {
-	case len(req.Body.Add) > 0 && len(req.Body.Remove) > 0:
-		return httprequest.Errorf(httprequest.CodeBadRequest, "cannot add and remove users at the same time")
-	case len(req.Body.Add) > 0:
-		err := h.h.m.p.Store.Add(p.Context, req.Name, req.Body.Add)
-		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
-	case len(req.Body.Remove) > 0:
-		err := h.h.m.p.Store.Remove(p.Context, req.Name, req.Body.Remove)
-		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
-	default:
-		return nil
+// checkHandlerParams returns an error if the given handler
+// parameters cannot be used with m.
+func (m *Manager) checkHandlerParams(p HandlerParams) error {
+	if m.p.DisableMetaACLs && p.Authorize == nil {
+		return errgo.Newf("aclstore: HandlerParams.Authorize must be set when meta-ACLs are disabled")
+	}
+	if p.MaxDetailACLs < 0 {
+		return errgo.Newf("aclstore: invalid HandlerParams.MaxDetailACLs %d: must not be negative", p.MaxDetailACLs)
 	}
+	return nil
 }
 
-// GetACLs returns the list of all ACLs.
-// Only administrators may access this endpoint.
-func (h handler1) GetACLs(p httprequest.Params, req *params.GetACLsRequest) (*params.GetACLsResponse, error) {
-	lister, ok := h.h.m.p.Store.(ACLLister)
-	if !ok {
-		return nil, errgo.Newf("cannot list ACLs")
+// AllowAny reports whether the given identity is allowed by any of the
+// ACLs with the given names. As with the HTTP endpoints, members of the
+// admin ACL are allowed by every ACL unless Params.AdminBypass is false.
+// The ACLs are checked in order and AllowAny returns as soon as one of
+// them allows the identity.
+//
+// It returns an error with an ErrACLNotFound cause if any of the ACLs
+// that are checked do not exist.
+func (m *Manager) AllowAny(ctx context.Context, identity Identity, aclNames []string) (bool, error) {
+	if len(aclNames) == 0 {
+		return false, nil
 	}
-	acls, err := lister.ACLs(p.Context)
-	if err != nil {
-		return nil, errgo.Mask(err)
+	var adminACL []string
+	if m.adminBypass() {
+		var err error
+		adminACL, err = m.ACL(ctx, m.p.AdminACLName)
+		if err != nil {
+			return false, errgo.NoteMask(err, "cannot get admin ACL", isContextError)
+		}
 	}
-	sort.Strings(acls)
-	return &params.GetACLsResponse{
-		ACLs: acls,
-	}, nil
+	for _, name := range aclNames {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		acl, err := m.ACL(ctx, name)
+		if err != nil {
+			return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+		}
+		if name != m.p.AdminACLName {
+			acl = append(acl, adminACL...)
+		}
+		ok, err := m.allow(ctx, identity, acl)
+		if err != nil {
+			return false, errgo.Notef(err, "cannot check permissions")
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Check reports whether the given identity is allowed by the ACL with
+// the given name, so that a service can authorize its own operations
+// against the ACLs held by the Manager. The identity is allowed if it
+// is allowed by the members of the ACL or by those of the ACL returned
+// by Managers: the members of its meta-ACL and, unless
+// Params.AdminBypass is false, the administrators.
+// Params.EmptyACLPolicy opens read access to an ACL, not membership
+// of it, so it does not affect the result.
+//
+// It returns an error with an ErrACLNotFound cause if
+// the ACL or its meta-ACL does not exist.
+func (m *Manager) Check(ctx context.Context, identity Identity, aclName string) (bool, error) {
+	members, err := m.ACL(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	managers, err := m.managerACL(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	ok, err := m.allow(ctx, identity, append(members, managers...))
+	if err != nil {
+		return false, errgo.Notef(err, "cannot check permissions")
+	}
+	return ok, nil
+}
+
+// allow reports whether the given identity is allowed by the given
+// ACL. If deny entries are enabled, an identity that matches any deny
+// entry is not allowed, regardless of the other entries.
+func (m *Manager) allow(ctx context.Context, identity Identity, acl []string) (bool, error) {
+	allowACL, denyACL := m.splitACL(acl)
+	if len(denyACL) > 0 {
+		denied, err := m.allowEntries(ctx, identity, denyACL)
+		if err != nil {
+			return false, errgo.Mask(err)
+		}
+		if denied {
+			return false, nil
+		}
+	}
+	return m.allowEntries(ctx, identity, allowACL)
+}
+
+// splitACL returns the entries of acl that are passed to Identity.Allow
+// to decide whether an identity is allowed, and the users denied by
+// any deny entries, which are checked first. If the store folds case,
+// the FoldUser form of each entry is included too.
+func (m *Manager) splitACL(acl []string) (allowACL, denyACL []string) {
+	if m.foldsCase() {
+		acl = withFoldedUsers(acl)
+	}
+	if m.p.DenyPrefix == "" {
+		return acl, nil
+	}
+	for _, a := range acl {
+		if !strings.HasPrefix(a, m.p.DenyPrefix) {
+			allowACL = append(allowACL, a)
+		} else if a := strings.TrimPrefix(a, m.p.DenyPrefix); a != "" {
+			denyACL = append(denyACL, a)
+		}
+	}
+	return allowACL, denyACL
+}
+
+// escapesUsers reports whether the given store can hold
+// users that contain the separator.
+func escapesUsers(store ACLStore) bool {
+	e, ok := store.(ACLUserEscaper)
+	return ok && e.EscapesUsers()
+}
+
+// foldsCase reports whether the store treats
+// users that differ only in case as the same.
+func (m *Manager) foldsCase() bool {
+	f, ok := m.p.Store.(ACLCaseFolder)
+	return ok && f.FoldsCase()
+}
+
+// withFoldedUsers returns acl with the FoldUser form
+// of each entry added where it differs from the entry.
+func withFoldedUsers(acl []string) []string {
+	acl1 := make([]string, len(acl), 2*len(acl))
+	copy(acl1, acl)
+	for _, a := range acl {
+		if f := FoldUser(a); f != a {
+			acl1 = append(acl1, f)
+		}
+	}
+	return acl1
+}
+
+// Managers returns the users that may change the membership of the
+// ACL with the given name: the members of its meta-ACL together with
+// the members of the admin ACL, unless Params.AdminBypass is false.
+// For the admin ACL and meta-ACLs, this
+// is just the members of the admin ACL. If deny entries are enabled,
+// denied users are excluded. The result is sorted and holds no
+// duplicates.
+//
+// It returns an error with an ErrACLNotFound cause if the
+// meta-ACL does not exist.
+func (m *Manager) Managers(ctx context.Context, aclName string) ([]string, error) {
+	acl, err := m.managerACL(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return canonicalACL(m.withoutDenied(acl)), nil
+}
+
+// withoutDenied returns the entries of acl that are not deny
+// entries and are not the users denied by them.
+func (m *Manager) withoutDenied(acl []string) []string {
+	denied := make(map[string]bool)
+	if m.p.DenyPrefix != "" {
+		for _, a := range acl {
+			if strings.HasPrefix(a, m.p.DenyPrefix) {
+				denied[a] = true
+				denied[strings.TrimPrefix(a, m.p.DenyPrefix)] = true
+			}
+		}
+	}
+	users := make([]string, 0, len(acl))
+	for _, a := range acl {
+		if !denied[a] {
+			users = append(users, a)
+		}
+	}
+	return users
+}
+
+// managerACL returns the ACL that is checked to decide whether an
+// identity may access the ACL with the given name.
+func (m *Manager) managerACL(ctx context.Context, aclName string) ([]string, error) {
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	var checkACLName string
+	if m.isSystemACL(aclName) || isMetaName(aclName) || m.p.DisableMetaACLs {
+		// We're trying to access either the admin ACL, the checker
+		// ACL, the read-only admin ACL or a meta-ACL, or meta-ACLs
+		// are disabled; for any of these, admin privileges are
+		// needed.
+		checkACLName = m.p.AdminACLName
+	} else {
+		// For all normal ACLs, access for a given ACL name is decided via membership
+		// of the meta-ACL for that name.
+		checkACLName = metaName(aclName)
+	}
+	acl, err := m.ACL(ctx, checkACLName)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	if checkACLName != m.p.AdminACLName && m.adminBypass() {
+		// Admin users always get permission to do anything.
+		adminACL, err := m.ACL(ctx, m.p.AdminACLName)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot get admin ACL", isContextError)
+		}
+		acl = append(acl, adminACL...)
+	}
+	return acl, nil
+}
+
+// isUnmanaged reports whether the ACL with the given name is a normal
+// ACL whose meta-ACL is empty, leaving aside the administrators that
+// managerACL adds to it. A missing meta-ACL is not treated as empty.
+func (m *Manager) isUnmanaged(ctx context.Context, aclName string) (bool, error) {
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, isContextError)
+	}
+	if m.isSystemACL(aclName) || isMetaName(aclName) || m.p.DisableMetaACLs {
+		return false, nil
+	}
+	acl, err := m.ACL(ctx, metaName(aclName))
+	if errgo.Cause(err) == ErrACLNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, errgo.NoteMask(err, "cannot get meta-ACL", isContextError)
+	}
+	return len(acl) == 0, nil
+}
+
+// adminBypass reports whether administrators may access every ACL.
+func (m *Manager) adminBypass() bool {
+	return m.p.AdminBypass == nil || *m.p.AdminBypass
+}
+
+// Authorize reports whether the given identity may perform the given
+// operation on the ACL with the given name using the HTTP endpoints.
+// Administrators may perform any operation on any ACL unless
+// Params.AdminBypass is false, and members of
+// the meta-ACL for a name may perform any operation on the ACL with
+// that name. The meta-ACL for meta-ACLs is the admin ACL. If a checker
+// ACL is configured, its members may also check membership of any ACL.
+// If a read-only admin ACL is configured, its members may perform any
+// operation that does not change an ACL on any ACL. If
+// Params.DisableMetaACLs is set, meta-ACLs are not consulted, so only
+// administrators may access an ACL; HandlerParams.Authorize can be
+// used to grant access to other users.
+//
+// If Params.AuthorizeByName is set and the identity implements
+// NamedIdentity, only its name is checked for membership, without
+// retrieving the ACLs if the store implements ACLMembershipChecker.
+// If Params.EmptyACLPolicy is EmptyACLOpen, any identity may perform
+// read operations on a normal ACL whose meta-ACL is empty.
+//
+// It returns an error with an ErrACLNotFound cause if
+// the meta-ACL does not exist.
+func (m *Manager) Authorize(ctx context.Context, identity Identity, aclName string, op Operation) (bool, error) {
+	ok, err := m.authorizeIdentity(ctx, identity, aclName, op)
+	if ok || err != nil {
+		return ok, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return m.allowedByEmptyACLPolicy(ctx, aclName, op)
+}
+
+// authorizeIdentity is like Authorize except that it
+// ignores Params.EmptyACLPolicy.
+func (m *Manager) authorizeIdentity(ctx context.Context, identity Identity, aclName string, op Operation) (bool, error) {
+	if named, ok := identity.(NamedIdentity); ok && m.p.AuthorizeByName {
+		return m.authorizeByName(ctx, named.Name(), aclName, op)
+	}
+	acl, err := m.operationACL(ctx, aclName, op)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	ok, err := m.allow(ctx, identity, acl)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot check permissions")
+	}
+	return ok, nil
+}
+
+// allowedByEmptyACLPolicy reports whether Params.EmptyACLPolicy allows
+// any identity to perform the given operation on the ACL with the
+// given name. Only read operations on ACLs that nobody manages are
+// allowed, and only by EmptyACLOpen.
+func (m *Manager) allowedByEmptyACLPolicy(ctx context.Context, aclName string, op Operation) (bool, error) {
+	if m.p.EmptyACLPolicy != EmptyACLOpen || !isReadOperation(op) {
+		return false, nil
+	}
+	ok, err := m.isUnmanaged(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, isContextError)
+	}
+	return ok, nil
+}
+
+// EffectiveCheckACL returns the entries that are passed to
+// Identity.Allow when the default policy, Manager.Authorize, decides
+// whether an identity may modify the ACL with the given name: the
+// members of its meta-ACL and, unless Params.AdminBypass is false, of
+// the admin ACL, or just the members of the admin ACL for system ACLs
+// and meta-ACLs. If the store folds case, the FoldUser form of each
+// entry is included too. If deny entries are enabled, they are
+// checked separately and are not included. Params.EmptyACLPolicy
+// never allows changes, so it does not affect the result.
+//
+// It returns an error with an ErrACLNotFound cause if
+// the meta-ACL does not exist.
+func (m *Manager) EffectiveCheckACL(ctx context.Context, aclName string) ([]string, error) {
+	acl, err := m.operationACL(ctx, aclName, OperationModify)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	allowACL, _ := m.splitACL(acl)
+	return allowACL, nil
+}
+
+// operationACL returns the ACL that is checked to decide whether an
+// identity may perform the given operation on the ACL with the given
+// name. Any operation is allowed by the managerACL; in addition, members
+// of the checker ACL may check membership and members of the read-only
+// admin ACL may perform any read operation.
+func (m *Manager) operationACL(ctx context.Context, aclName string, op Operation) ([]string, error) {
+	acl, err := m.managerACL(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	if op == OperationCheck && m.p.CheckerACL != "" {
+		checkers, err := m.ACL(ctx, m.p.CheckerACL)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot get checker ACL", isContextError)
+		}
+		acl = append(acl, checkers...)
+	}
+	if m.p.ReadOnlyAdminACL != "" && isReadOperation(op) {
+		readers, err := m.ACL(ctx, m.p.ReadOnlyAdminACL)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot get read-only admin ACL", isContextError)
+		}
+		acl = append(acl, readers...)
+	}
+	return acl, nil
+}
+
+// isReadOperation reports whether the given operation
+// leaves ACLs unchanged.
+func isReadOperation(op Operation) bool {
+	switch op {
+	case OperationRead, OperationCheck, OperationList:
+		return true
+	}
+	return false
+}
+
+// isSystemACL reports whether the ACL with the given name is one of
+// the ACLs created by the Manager to control access to other ACLs.
+func (m *Manager) isSystemACL(aclName string) bool {
+	return aclName == m.p.AdminACLName || aclName == m.p.CheckerACL || aclName == m.p.ReadOnlyAdminACL
+}
+
+// ACLNames returns the names of all the ACLs, sorted lexically
+// whatever order the store lists them in. Meta-ACLs are only
+// included if includeMeta is true.
+//
+// The underlying store must implement ACLLister.
+func (m *Manager) ACLNames(ctx context.Context, includeMeta bool) ([]string, error) {
+	lister, ok := m.p.Store.(ACLLister)
+	if !ok {
+		return nil, errgo.Newf("cannot list ACLs")
+	}
+	acls, err := lister.ACLs(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	sort.Strings(acls)
+	if includeMeta {
+		return acls, nil
+	}
+	names := make([]string, 0, len(acls))
+	for _, name := range acls {
+		if !isMetaName(name) {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// ForEachACL calls fn with the name and members of each ACL, including
+// meta-ACLs, in name order. Only the names are read up front; the
+// members of each ACL are read from the store just before fn is called
+// for it, so they are not all held in memory at once. ACLs that are
+// deleted while the iteration is in progress are skipped. If fn returns
+// an error, the iteration stops and ForEachACL returns the error with
+// its cause unchanged.
+//
+// The underlying store must implement ACLLister.
+func (m *Manager) ForEachACL(ctx context.Context, fn func(name string, members []string) error) error {
+	names, err := m.ACLNames(ctx, true)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		members, err := m.p.Store.Get(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				continue
+			}
+			return errgo.NoteMask(err, fmt.Sprintf("cannot get ACL %q", name), isContextError)
+		}
+		if err := fn(name, members); err != nil {
+			return errgo.Mask(err, errgo.Any)
+		}
+	}
+	return nil
+}
+
+// ACLsForUser returns the sorted names of all the ACLs, including
+// meta-ACLs, that hold the given user as a direct member. Membership
+// through groups is not taken into account. If deny entries are
+// enabled, an ACL that denies the user is not included.
+//
+// If the underlying store implements ACLIndexer and maintains its
+// index, only the ACLs that the index holds for the user are read.
+// Otherwise the store must implement ACLLister and every ACL is read,
+// so this may be slow for large stores.
+func (m *Manager) ACLsForUser(ctx context.Context, user string) ([]string, error) {
+	var names []string
+	var err error
+	if indexer, ok := m.p.Store.(ACLIndexer); ok && indexer.IndexesUsers() {
+		names, err = indexer.IndexedACLs(ctx, user)
+	} else {
+		names, err = m.ACLNames(ctx, true)
+	}
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	var found []string
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		acl, err := m.ACL(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				// The ACL has been removed since it was listed.
+				continue
+			}
+			return nil, errgo.Mask(err, isContextError)
+		}
+		if m.isMember(acl, user) {
+			found = append(found, name)
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+// RebuildIndex regenerates the index of the ACLs that hold each user
+// from the members of every ACL, correcting any inconsistencies, for
+// example after the underlying store has been changed directly. It is
+// safe to call while the store is in use; changes made while it runs
+// are kept in the index.
+//
+// The underlying store must implement ACLIndexer and maintain its index.
+func (m *Manager) RebuildIndex(ctx context.Context) error {
+	indexer, ok := m.p.Store.(ACLIndexer)
+	if !ok || !indexer.IndexesUsers() {
+		return errgo.Newf("cannot rebuild user index")
+	}
+	return errgo.Mask(indexer.RebuildIndex(ctx), isContextError)
+}
+
+// Stats holds aggregate statistics about the ACLs in a store.
+// Meta-ACLs are not included.
+type Stats struct {
+	// ACLs holds the number of ACLs.
+	ACLs int
+
+	// Users holds the number of distinct users
+	// that are members of any ACL.
+	Users int
+
+	// AverageSize holds the average number of members of an ACL.
+	AverageSize float64
+}
+
+// Stats returns statistics about the ACLs in the store. Every ACL
+// is read, so this may be slow for large stores.
+//
+// The underlying store must implement ACLLister.
+func (m *Manager) Stats(ctx context.Context) (Stats, error) {
+	names, err := m.ACLNames(ctx, false)
+	if err != nil {
+		return Stats{}, errgo.Mask(err, isContextError)
+	}
+	var stats Stats
+	users := make(map[string]bool)
+	members := 0
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return Stats{}, err
+		}
+		acl, err := m.ACL(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				// The ACL has been removed since it was listed.
+				continue
+			}
+			return Stats{}, errgo.Mask(err, isContextError)
+		}
+		stats.ACLs++
+		members += len(acl)
+		for _, u := range acl {
+			users[u] = true
+		}
+	}
+	stats.Users = len(users)
+	if stats.ACLs > 0 {
+		stats.AverageSize = float64(members) / float64(stats.ACLs)
+	}
+	return stats, nil
+}
+
+// sortBySize returns the given ACL names, which must be sorted, sorted
+// by the number of members of each ACL, largest first if desc is true,
+// with ACLs of the same size left in order. ACLs that have been removed
+// since they were listed are omitted.
+func (m *Manager) sortBySize(ctx context.Context, names []string, desc bool) ([]string, error) {
+	sizes := make(map[string]int, len(names))
+	sorted := make([]string, 0, len(names))
+	for _, name := range names {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		n, err := m.Count(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				continue
+			}
+			return nil, errgo.Mask(err, isContextError)
+		}
+		sizes[name] = n
+		sorted = append(sorted, name)
+	}
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if desc {
+			return sizes[sorted[i]] > sizes[sorted[j]]
+		}
+		return sizes[sorted[i]] < sizes[sorted[j]]
+	})
+	return sorted, nil
+}
+
+// AllACLs is the entry returned by EffectiveACLs to signify that a user
+// has access to every ACL because it is a member of the admin ACL.
+const AllACLs = "*"
+
+// EffectiveACLs returns the sorted names of all the ACLs that hold the
+// given user as a direct member, as returned by ACLsForUser. If the user
+// is a member of the admin ACL, and so has access to every ACL, the
+// result also starts with AllACLs.
+func (m *Manager) EffectiveACLs(ctx context.Context, user string) ([]string, error) {
+	acls, err := m.ACLsForUser(ctx, user)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	for _, name := range acls {
+		if name == m.p.AdminACLName {
+			return append([]string{AllACLs}, acls...), nil
+		}
+	}
+	return acls, nil
+}
+
+// IsMember reports whether the given user is a direct member of the ACL
+// with the given name. Membership through groups is not taken into
+// account. If deny entries are enabled, a user that is denied by the ACL
+// is not a member.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist.
+func (m *Manager) IsMember(ctx context.Context, aclName, user string) (bool, error) {
+	acl, err := m.ACL(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return m.isMember(acl, user), nil
+}
+
+// SearchMembers returns the members of the ACL with the given name that
+// contain the given query, ignoring case, in the order they are held in
+// the ACL. At most Params.MaxSearchResults members are returned. If
+// deny entries are enabled, they are not included.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist.
+func (m *Manager) SearchMembers(ctx context.Context, aclName, query string) ([]string, error) {
+	users, _, err := m.searchMembers(ctx, aclName, query)
+	return users, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+}
+
+// searchMembers implements SearchMembers. It also reports whether
+// any matching members were left out of the result.
+func (m *Manager) searchMembers(ctx context.Context, aclName, query string) (_ []string, truncated bool, _ error) {
+	acl, err := m.ACL(ctx, aclName)
+	if err != nil {
+		return nil, false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	limit := m.p.MaxSearchResults
+	if limit <= 0 {
+		limit = DefaultMaxSearchResults
+	}
+	query = strings.ToLower(query)
+	found := make([]string, 0)
+	for _, a := range acl {
+		if m.p.DenyPrefix != "" && strings.HasPrefix(a, m.p.DenyPrefix) {
+			continue
+		}
+		if !strings.Contains(strings.ToLower(a), query) {
+			continue
+		}
+		if len(found) == limit {
+			return found, true, nil
+		}
+		found = append(found, a)
+	}
+	return found, false, nil
+}
+
+// MembersIn returns those of the given candidate users that are direct
+// members of the ACL with the given name, in the order they were given.
+// Duplicate candidates are only returned once. If deny entries are
+// enabled, users that are denied by the ACL are not returned.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist.
+func (m *Manager) MembersIn(ctx context.Context, aclName string, candidates []string) ([]string, error) {
+	acl, err := m.ACL(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	key := func(u string) string { return u }
+	if m.foldsCase() {
+		key = FoldUser
+	}
+	members := make(map[string]bool, len(acl))
+	var denied map[string]bool
+	for _, a := range acl {
+		if m.p.DenyPrefix != "" && strings.HasPrefix(a, m.p.DenyPrefix) {
+			if denied == nil {
+				denied = make(map[string]bool)
+			}
+			denied[key(strings.TrimPrefix(a, m.p.DenyPrefix))] = true
+		}
+		members[key(a)] = true
+	}
+	found := make([]string, 0, len(candidates))
+	seen := make(map[string]bool, len(candidates))
+	for _, u := range candidates {
+		k := key(u)
+		if members[k] && !denied[k] && !seen[k] {
+			found = append(found, u)
+			seen[k] = true
+		}
+	}
+	return found, nil
+}
+
+// isMember reports whether the given user is a direct member of the
+// given ACL and is not denied by it.
+func (m *Manager) isMember(acl []string, user string) bool {
+	if m.foldsCase() {
+		acl, user = withFoldedUsers(acl), FoldUser(user)
+	}
+	member := false
+	for _, a := range acl {
+		if a == user {
+			member = true
+		} else if m.p.DenyPrefix != "" && a == m.p.DenyPrefix+user {
+			return false
+		}
+	}
+	return member
+}
+
+// ValidateACLName checks that the given name may be used for an ACL
+// created with CreateACL. The name must not be empty, must not start
+// with an underscore, which is reserved for meta-ACLs, and must match
+// Params.ACLNamePattern if that is set. If the name is not valid, it
+// returns an error with an ErrBadACLName cause that describes why.
+//
+// Because ACL names are used as URL path segments by the handler,
+// unless Params.AllowPathUnsafeACLNames is set the name must also be
+// safe to use in a path: it may hold any printable characters other
+// than white space and the characters / \ ? # and %, it must not
+// be "." or "..", and it must not be one of the names reserved for
+// other endpoints ("authorize", "stats" and "whoami").
+func (m *Manager) ValidateACLName(name string) error {
+	switch {
+	case name == "":
+		return errgo.WithCausef(nil, ErrBadACLName, "empty ACL name")
+	case isMetaName(name):
+		return errgo.WithCausef(nil, ErrBadACLName, "invalid ACL name %q", name)
+	case m.p.ACLNamePattern != nil && !m.p.ACLNamePattern.MatchString(name):
+		return errgo.WithCausef(nil, ErrBadACLName, "invalid ACL name %q: does not match %q", name, m.p.ACLNamePattern)
+	case !m.p.AllowPathUnsafeACLNames && !pathSafe(name):
+		return errgo.WithCausef(nil, ErrBadACLName, "invalid ACL name %q: not safe to use in a URL path", name)
+	case !m.p.AllowPathUnsafeACLNames && reservedACLNames[name]:
+		return errgo.WithCausef(nil, ErrBadACLName, "invalid ACL name %q: reserved for another endpoint", name)
+	}
+	return nil
+}
+
+// reservedACLNames holds the names that can't be used for ACLs
+// because they are also the paths of endpoints that take
+// precedence over the ACL endpoints with the same method.
+var reservedACLNames = map[string]bool{
+	"authorize": true,
+	"stats":     true,
+	"whoami":    true,
+}
+
+// pathSafe reports whether the given ACL name can be used
+// unescaped as a URL path segment and is routed unchanged.
+func pathSafe(name string) bool {
+	if name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if !unicode.IsPrint(r) || unicode.IsSpace(r) || strings.ContainsRune(`/\?#%`, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateAccessedACLName is like ValidateACLName except that
+// it also allows the names of meta-ACLs.
+func (m *Manager) validateAccessedACLName(name string) error {
+	if isMetaName(name) {
+		if err := m.ValidateACLName(strings.TrimPrefix(name, "_")); err != nil {
+			return errgo.WithCausef(nil, ErrBadACLName, "invalid ACL name %q", name)
+		}
+		return nil
+	}
+	return errgo.Mask(m.ValidateACLName(name), errgo.Is(ErrBadACLName))
+}
+
+// CreateACL creates an ACL with the given name. It also creates an ACL
+// _name which is the ACL that guards membership of the ACL itself. Any
+// member of _name or any member of the admin ACL may change the
+// membership of ACL name. Only members of the admin ACL may change the
+// membership of _name. If Params.DisableMetaACLs is set, _name is not
+// created and only members of the admin ACL may change ACL name.
+//
+// The name must be valid according to ValidateACLName. If
+// Params.MaxACLs is set and the limit has been reached, it returns
+// an error with an ErrTooManyACLs cause.
+//
+// This does nothing if an ACL with that name already exists. If
+// there is an alias with that name, it returns an error with an
+// ErrAliasExists cause.
+//
+// Calls to CreateACL, DeleteACL and RestoreACL for the same name made
+// through the same Manager are serialized, so an ACL and its meta-ACL
+// either both exist or are both absent when they return. Managers in
+// different processes that share a store are not coordinated.
+func (h *Manager) CreateACL(ctx context.Context, name string, initialUsers ...string) error {
+	err := h.createACL(ctx, name, initialUsers, createOptions{})
+	return errgo.Mask(err, errgo.Is(ErrBadACLName), errgo.Is(ErrTooManyACLs), errgo.Is(ErrBadUsername), errgo.Is(ErrAliasExists), isContextError)
+}
+
+// createOptions holds options for createACL.
+type createOptions struct {
+	// failIfExists causes createACL to return an error with an
+	// ErrACLExists cause if the ACL already exists, instead of
+	// doing nothing.
+	failIfExists bool
+
+	// noTemplate causes the ACL to be created with only the given
+	// users, whatever Params.Templates holds.
+	noTemplate bool
+}
+
+// createACL implements CreateACL.
+func (h *Manager) createACL(ctx context.Context, name string, initialUsers []string, opts createOptions) error {
+	if err := h.ValidateACLName(name); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadACLName))
+	}
+	defer h.nameLocks.lock(name)()
+	if h.p.MaxACLs > 0 {
+		if err := h.checkACLLimit(ctx, name); err != nil {
+			return errgo.Mask(err, errgo.Is(ErrTooManyACLs), isContextError)
+		}
+	}
+	if err := h.checkUsersAllowed(ctx, initialUsers); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	if !opts.noTemplate {
+		var err error
+		initialUsers, err = h.withTemplateUsers(ctx, name, initialUsers)
+		if err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	existed := false
+	if err := h.createIfNotExists(ctx, name, initialUsers); err != nil {
+		if errgo.Cause(err) != ErrACLExists || opts.failIfExists {
+			return errgo.Mask(err, errgo.Is(ErrACLExists), errgo.Is(ErrAliasExists), errgo.Is(ErrBadUsername), isContextError)
+		}
+		existed = true
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if !h.p.DisableMetaACLs {
+		// The meta-ACL may already exist even if the ACL was
+		// just created, for example if an earlier creation failed
+		// part way through, in which case this does nothing.
+		if err := h.p.Store.CreateACL(ctx, metaName(name), nil); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	if !existed {
+		h.changed(ctx, name, OpCreate, initialUsers)
+	}
+	return nil
+}
+
+// createIfNotExists creates the ACL with the given name in the store,
+// or returns an error with an ErrACLExists cause if it already exists.
+// If the store does not implement ACLCreator, the ACL is read first,
+// so the caller must hold the lock for the name.
+func (m *Manager) createIfNotExists(ctx context.Context, name string, initialUsers []string) error {
+	if creator, ok := m.p.Store.(ACLCreator); ok {
+		err := creator.CreateACLIfNotExists(ctx, name, initialUsers)
+		return errgo.Mask(err, errgo.Is(ErrACLExists), errgo.Is(ErrAliasExists), errgo.Is(ErrBadUsername), isContextError)
+	}
+	_, err := m.p.Store.Get(ctx, name)
+	if err == nil {
+		return errgo.WithCausef(nil, ErrACLExists, "ACL %q already exists", name)
+	}
+	if errgo.Cause(err) != ErrACLNotFound {
+		return errgo.Mask(err, isContextError)
+	}
+	err = m.p.Store.CreateACL(ctx, name, initialUsers)
+	return errgo.Mask(err, errgo.Is(ErrAliasExists), errgo.Is(ErrBadUsername), isContextError)
+}
+
+// checkACLLimit returns an error with an ErrTooManyACLs cause
+// if creating the ACL with the given name would take the number
+// of ACLs over Params.MaxACLs.
+func (m *Manager) checkACLLimit(ctx context.Context, name string) error {
+	names, err := m.ACLNames(ctx, false)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	for _, n := range names {
+		if n == name {
+			// Creating an existing ACL does nothing.
+			return nil
+		}
+	}
+	if len(names) >= m.p.MaxACLs {
+		return errgo.WithCausef(nil, ErrTooManyACLs, "limit of %d ACLs reached", m.p.MaxACLs)
+	}
+	return nil
+}
+
+// ClearACL removes all the members of the ACL with the given name.
+// Unlike deleting it, the ACL and its meta-ACL continue to exist.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL
+// does not exist.
+func (m *Manager) ClearACL(ctx context.Context, name string) error {
+	name, err := m.resolveAlias(ctx, name)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if err := m.p.Store.Set(ctx, name, nil); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	m.changed(ctx, name, OpClear, nil)
+	return nil
+}
+
+// ReplaceAdmins atomically replaces the members of the admin ACL with
+// the given users. The new set of users must not be empty and, unless
+// force is true, it must include at least one of the current members of
+// the admin ACL; otherwise an error with an ErrAdminLockout cause is
+// returned and the admin ACL is left unchanged. If any of the users are
+// not valid or allowed, an error with an ErrBadUsername cause is
+// returned.
+//
+// The underlying store must implement ACLUpdater.
+func (m *Manager) ReplaceAdmins(ctx context.Context, users []string, force bool) error {
+	if len(users) == 0 {
+		return errgo.WithCausef(nil, ErrAdminLockout, "cannot replace admin ACL with no users")
+	}
+	updater, ok := m.p.Store.(ACLUpdater)
+	if !ok {
+		return errgo.Newf("cannot update admin ACL atomically")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := m.checkUsersAllowed(ctx, users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	err := updater.Update(ctx, m.p.AdminACLName, func(current []string) ([]string, error) {
+		if force || len(current) == 0 {
+			return users, nil
+		}
+		for _, u := range users {
+			for _, c := range current {
+				if u == c {
+					return users, nil
+				}
+			}
+		}
+		return nil, errgo.WithCausef(nil, ErrAdminLockout, "new admin users do not include any current admin user")
+	})
+	if err == nil {
+		m.changed(ctx, m.p.AdminACLName, OpSet, users)
+	}
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrAdminLockout), isContextError)
+}
+
+// RecoverAdmin sets the members of the admin ACL to the given users,
+// whatever its current state, so that administrators can regain access
+// if the admin ACL has been emptied, deleted or corrupted. Unlike
+// ReplaceAdmins, the current members are not consulted. If the admin
+// ACL cannot be read, it is overwritten, which requires the underlying
+// store to implement ACLResetter, or else deleted, which requires it to
+// implement ACLDeleter, and created afresh.
+//
+// RecoverAdmin is intended to be called by server code, for example
+// from a command-line recovery tool; it is deliberately not available
+// through the HTTP handler.
+//
+// It returns an error with an ErrAdminLockout cause if no users are
+// given, or with an ErrBadUsername cause if any of the users are not
+// valid or allowed.
+func (m *Manager) RecoverAdmin(ctx context.Context, users []string) error {
+	if len(users) == 0 {
+		return errgo.WithCausef(nil, ErrAdminLockout, "cannot recover admin ACL with no users")
+	}
+	if err := validateUsers(users, escapesUsers(m.p.Store)); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername))
+	}
+	if err := m.checkUsersAllowed(ctx, users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	name := m.p.AdminACLName
+	err := m.p.Store.Set(ctx, name, users)
+	switch cause := errgo.Cause(err); {
+	case err == nil:
+	case cause == ErrACLNotFound:
+		err = m.recreateACL(ctx, name, users)
+	case cause != ErrBadUsername && !isContextError(cause):
+		// The admin ACL cannot be read, so start again.
+		err = m.overwriteACL(ctx, name, users, err)
+	}
+	if err != nil {
+		return errgo.NoteMask(err, "cannot recover admin ACL", errgo.Is(ErrBadUsername), isContextError)
+	}
+	m.changed(ctx, name, OpSet, users)
+	return nil
+}
+
+// recreateACL creates the ACL with the given name holding the given
+// users. If the ACL is created concurrently, its members are replaced.
+func (m *Manager) recreateACL(ctx context.Context, name string, users []string) error {
+	if err := m.p.Store.CreateACL(ctx, name, users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	if err := m.p.Store.Set(ctx, name, users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	return nil
+}
+
+// overwriteACL replaces the ACL with the given name, which could not
+// be set because of the given error, with one holding the given users.
+// If the store implements ACLResetter, the ACL is overwritten without
+// being read; otherwise it is deleted and created again, which may
+// also need to read it.
+func (m *Manager) overwriteACL(ctx context.Context, name string, users []string, setErr error) error {
+	if resetter, ok := m.p.Store.(ACLResetter); ok {
+		return errgo.Mask(resetter.ResetACL(ctx, name, users), errgo.Is(ErrBadUsername), isContextError)
+	}
+	deleter, ok := m.p.Store.(ACLDeleter)
+	if !ok {
+		return errgo.Notef(setErr, "cannot reset admin ACL")
+	}
+	if err := deleter.DeleteACL(ctx, name); err != nil && errgo.Cause(err) != ErrACLNotFound {
+		return errgo.Notef(err, "cannot delete admin ACL")
+	}
+	return errgo.Mask(m.recreateACL(ctx, name, users), errgo.Is(ErrBadUsername), isContextError)
+}
+
+// aclName is implemented by the request parameters for all endpoints
+// to return the associated ACL name.
+type aclName interface {
+	ACLName() string
+}
+
+// adminRequest is implemented by the parameters of requests
+// that only administrators may make.
+type adminRequest interface {
+	AdminOnly() bool
+}
+
+// HandlerParams holds the parameters for a NewHandler call.
+type HandlerParams struct {
+	// RootPath holds the root URL path prefix to use
+	// for the ACL endpoints. All the endpoints will be
+	// prefixed with this path. It is normalized to have
+	// a single leading slash and no trailing slash, so
+	// "root", "/root/" and "//root" are all equivalent
+	// to "/root", and "/" is equivalent to "".
+	//
+	// A GET request for the root path itself, with or without
+	// a trailing slash, always lists the ACLs, and a DELETE
+	// request for it deletes ACLs by prefix. Other requests for
+	// the root path, and requests with an empty path element
+	// directly after it, refer to an ACL with an empty name
+	// and fail with an "empty ACL name" error. Paths outside
+	// RootPath are passed to NotFoundHandler.
+	RootPath string
+
+	// Authenticate authenticates the given HTTP request and returns
+	// the resulting authenticated identity. If authentication
+	// fails, Authenticate should either write its own response and
+	// return an error, or return an error with an ErrUnauthorized
+	// cause without writing a response, in which case a 401
+	// Unauthorized response is written for it.
+	Authenticate func(ctx context.Context, w http.ResponseWriter, req *http.Request) (Identity, error)
+
+	// WWWAuthenticate holds the value of the WWW-Authenticate
+	// header sent with the response written when Authenticate
+	// returns an error with an ErrUnauthorized cause.
+	WWWAuthenticate string
+
+	// UsersField, if non-empty, holds the name of the JSON field
+	// that holds the users of an ACL in GetACL responses and in
+	// the bodies of SetACL requests, for compatibility with clients
+	// that expect a different name. If it is empty,
+	// params.DefaultUsersField is used. Clients created by
+	// aclclient.New must be given the same name.
+	UsersField string
+
+	// MaxBodyBytes holds the maximum size of a request body.
+	// Requests with larger bodies fail with an
+	// http.StatusRequestEntityTooLarge error. If this is zero,
+	// DefaultMaxBodyBytes is used; if it is negative, request
+	// bodies are not limited. Bodies streamed to the import
+	// endpoint are read incrementally, so are not limited.
+	MaxBodyBytes int64
+
+	// RateLimit, if non-nil, limits the rate at which each ACL
+	// may be changed and the rate at which each caller may make
+	// requests that read many ACLs at once. Requests that exceed the limit fail with an
+	// http.StatusTooManyRequests error and a Retry-After header.
+	RateLimit *RateLimit
+
+	// MaxConcurrentMutations, if positive, holds the maximum number
+	// of requests that may change each ACL at the same time, so that
+	// contended ACLs do not overload the store. Requests beyond the
+	// limit wait until they can proceed; if the request's context is
+	// done first, they fail with an http.StatusTooManyRequests error.
+	MaxConcurrentMutations int
+
+	// Authorize, if non-nil, is called to decide whether an
+	// authenticated identity may perform the given operation on
+	// the ACL with the given name. If it is nil, Manager.Authorize
+	// is used. A custom policy may call Manager.Authorize to
+	// fall back to the default.
+	Authorize func(ctx context.Context, identity Identity, aclName string, op Operation) (bool, error)
+
+	// NotFoundHandler, if non-nil, is used to respond to requests
+	// for URL paths that are not served by any endpoint. By default,
+	// a 404 response with a JSON httprequest.RemoteError body is
+	// written.
+	NotFoundHandler http.Handler
+
+	// GzipMinBytes holds the minimum size of a response body
+	// that is compressed with gzip when the client accepts it.
+	// If this is zero, DefaultGzipMinBytes is used; if it is
+	// negative, responses are never compressed.
+	GzipMinBytes int
+
+	// Tracer, if non-nil, is used to trace each request.
+	// Tracing is disabled by default.
+	Tracer Tracer
+
+	// MaxDetailACLs holds the maximum number of ACLs whose members
+	// are returned by a single GetACLs request with the detail flag
+	// set. If this is zero, DefaultMaxDetailACLs is used.
+	MaxDetailACLs int
+
+	// TenantFromRequest, if non-nil, is called before each request
+	// is authenticated to find the tenant that it is made for. The
+	// tenant is attached to the request context, where it is
+	// available from TenantFromContext, so that a store such as one
+	// created with StoreParams.TenantNamespace can keep the ACLs of
+	// each tenant apart. The first time a tenant is seen in an
+	// authenticated request, its admin ACL and other system ACLs
+	// are created as they are by NewManager. If it returns an error,
+	// or a tenant that is empty, longer than 128 bytes or holds
+	// control characters, the request fails with a bad request
+	// error.
+	TenantFromRequest func(req *http.Request) (string, error)
+
+	// StrictContentType specifies that requests that change ACLs
+	// must declare the media type of their bodies. A PUT, POST or
+	// PATCH request with a body whose Content-Type header is
+	// missing, or names a type that its endpoint does not accept,
+	// fails with an http.StatusUnsupportedMediaType error before it
+	// is authenticated. By default, such requests are passed to
+	// their endpoints, which fail with an http.StatusBadRequest
+	// error when they cannot parse the body, like they do for
+	// malformed bodies.
+	StrictContentType bool
+
+	// UserIdentity, if non-nil, is called to find the identity of a
+	// user named in a request to the authorize endpoint, so that,
+	// for example, the groups that the user is in can be taken into
+	// account. If it is nil, the user is only allowed by entries
+	// that hold their name.
+	UserIdentity func(ctx context.Context, user string) (Identity, error)
+
+	// TokenIdentity, if non-nil, is called to find the identity of
+	// the user identified by a token in a request to the authorize
+	// endpoint. If it returns an error with an ErrUnauthorized cause,
+	// the request fails with a bad request error. If it is nil,
+	// tokens are not accepted.
+	TokenIdentity func(ctx context.Context, token string) (Identity, error)
+}
+
+// NewHandler creates an ACL administration interface that allows clients
+// to manipulate the ACLs. The set of ACLs that can be manipulated can be
+// changed with the Manager.CreateACL method.
+//
+// NewHandler panics if the parameters are not valid: p.MaxDetailACLs
+// must not be negative and, if Params.DisableMetaACLs was set when the
+// Manager was created, p.Authorize must be set.
+func (m *Manager) NewHandler(p HandlerParams) http.Handler {
+	if err := m.checkHandlerParams(p); err != nil {
+		panic(err)
+	}
+	if p.MaxBodyBytes == 0 {
+		p.MaxBodyBytes = DefaultMaxBodyBytes
+	}
+	if p.GzipMinBytes == 0 {
+		p.GzipMinBytes = DefaultGzipMinBytes
+	}
+	if p.MaxDetailACLs == 0 {
+		p.MaxDetailACLs = DefaultMaxDetailACLs
+	}
+	p.RootPath = normalizeRootPath(p.RootPath)
+	h := &handler{
+		p:        p,
+		m:        m,
+		router:   httprouter.New(),
+		reserved: httprouter.New(),
+		totals:   newListingTotals(m.p.Clock.Now),
+	}
+	if p.RateLimit != nil {
+		h.limiter = newRateLimiter(*p.RateLimit, m.p.Clock.Now)
+		h.callerLimiter = newRateLimiter(*p.RateLimit, m.p.Clock.Now)
+	}
+	if p.MaxConcurrentMutations > 0 {
+		h.mutations = newMutationLimiter(p.MaxConcurrentMutations)
+	}
+	h.router.NotFound = p.NotFoundHandler
+	if h.router.NotFound == nil {
+		h.router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			httprequest.WriteJSON(w, http.StatusNotFound, &httprequest.RemoteError{
+				Message: "URL path not found",
+				Code:    httprequest.CodeNotFound,
+			})
+		})
+	}
+	for _, ep := range reqServer.Handlers(h.newHandler) {
+		router := h.router
+		if isReservedPath(ep.Path) {
+			router = h.reserved
+		}
+		router.Handle(ep.Method, path.Join(p.RootPath, ep.Path), ep.Handle)
+	}
+	return h
+}
+
+// normalizeRootPath returns the given root path with a single
+// leading slash and no trailing slash. An empty root path
+// and "/" both result in "".
+func normalizeRootPath(rootPath string) string {
+	rootPath = path.Join("/", rootPath)
+	if rootPath == "/" {
+		return ""
+	}
+	return rootPath
+}
+
+type handler struct {
+	p      HandlerParams
+	m      *Manager
+	router *httprouter.Router
+
+	// reserved holds the routes that start with a fixed path
+	// element. These would conflict with the ACL name wildcard in
+	// router, so they are kept separately and take precedence.
+	reserved *httprouter.Router
+
+	// limiter holds the rate limiter for changes to ACLs,
+	// or nil if changes are not limited.
+	limiter *rateLimiter
+
+	// callerLimiter holds the rate limiter for requests that may
+	// read many ACLs at once, keyed by caller, or nil if they are
+	// not limited.
+	callerLimiter *rateLimiter
+
+	// mutations limits the number of concurrent changes
+	// to each ACL, or is nil if they are not limited.
+	mutations *mutationLimiter
+
+	// totals holds the totals of recent listings.
+	totals *listingTotals
+}
+
+// ServeHTTP implements http.Handler.
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.p.Tracer != nil {
+		var end func()
+		w, req, end = h.startTrace(w, req)
+		defer end()
+	}
+	if h.p.GzipMinBytes > 0 && req.Method != "HEAD" && acceptsGzip(req) {
+		gw := &gzipResponseWriter{
+			w:        w,
+			minBytes: h.p.GzipMinBytes,
+		}
+		defer gw.Close()
+		w = gw
+	}
+	if h.p.MaxBodyBytes > 0 && req.Body != nil && !h.isStreamedBody(req) {
+		b := &limitedBody{
+			r:     req.Body,
+			limit: h.p.MaxBodyBytes,
+		}
+		req.Body = http.MaxBytesReader(w, b, h.p.MaxBodyBytes)
+		req = req.WithContext(context.WithValue(req.Context(), limitedBodyKey{}, b))
+	}
+	if h.p.StrictContentType {
+		if err := h.checkContentType(req); err != nil {
+			reqServer.WriteError(req.Context(), w, err)
+			return
+		}
+	}
+	if req.Method == "POST" && req.ContentLength == 0 && req.Header.Get("Content-Type") == "" {
+		// Allow requests such as POST /name?action=clear to be
+		// made without a body.
+		req.Body = ioutil.NopCloser(strings.NewReader("{}"))
+		req.Header.Set("Content-Type", "application/json")
+	}
+	renameUsers := h.p.UsersField != "" && h.p.UsersField != params.DefaultUsersField && h.isSetACL(req)
+	fields := formBodyFields
+	if renameUsers {
+		// Keep the configured field in the converted body so
+		// that it is renamed below exactly as in a JSON body.
+		fields = append(fields[:len(fields):len(fields)], h.p.UsersField)
+	}
+	if err := jsonFromFormBody(req, fields); err != nil {
+		reqServer.WriteError(req.Context(), w, err)
+		return
+	}
+	if renameUsers {
+		if err := renameBodyField(req, h.p.UsersField, params.DefaultUsersField); err != nil {
+			reqServer.WriteError(req.Context(), w, err)
+			return
+		}
+	}
+	if h.isGetACLCSV(req) {
+		req = csvRequest(req)
+	}
+	req, err := h.checkRootPath(req)
+	if err != nil {
+		reqServer.WriteError(req.Context(), w, err)
+		return
+	}
+	if handle, ps, _ := h.reserved.Lookup(req.Method, req.URL.Path); handle != nil {
+		handle(w, req, ps)
+		return
+	}
+	h.router.ServeHTTP(w, req)
+}
+
+// checkRootPath handles requests that would otherwise be ambiguous
+// between the ACL listing endpoints and an ACL with an empty name,
+// rather than relying on the router's redirects. GET and DELETE
+// requests for the root path are rewritten to the canonical root path.
+// It returns an error with an ErrBadACLName cause if the request
+// refers to an ACL with an empty name.
+func (h *handler) checkRootPath(req *http.Request) (*http.Request, error) {
+	root := path.Join(h.p.RootPath, "/")
+	prefix := strings.TrimSuffix(root, "/")
+	p := req.URL.Path
+	switch {
+	case p == prefix || p == prefix+"/":
+		switch req.Method {
+		case "GET", "DELETE":
+			if p != root {
+				req = req.Clone(req.Context())
+				req.URL.Path = root
+				req.URL.RawPath = ""
+			}
+		case "PUT", "POST":
+			return req, errgo.WithCausef(nil, ErrBadACLName, "empty ACL name")
+		}
+	case strings.HasPrefix(p, prefix+"//"):
+		return req, errgo.WithCausef(nil, ErrBadACLName, "empty ACL name")
+	}
+	return req, nil
+}
+
+type limitedBodyKey struct{}
+
+// limitedBody wraps a request body that is limited by
+// http.MaxBytesReader and records how much of it has been read,
+// so that errors caused by an oversized body can be recognized.
+type limitedBody struct {
+	r     io.ReadCloser
+	limit int64
+	n     int64
+}
+
+// Read implements io.Reader.
+func (b *limitedBody) Read(buf []byte) (int, error) {
+	n, err := b.r.Read(buf)
+	b.n += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer.
+func (b *limitedBody) Close() error {
+	return b.r.Close()
+}
+
+// exceeded reports whether more than the allowed
+// number of bytes has been read from the body.
+func (b *limitedBody) exceeded() bool {
+	return b.n > b.limit
+}
+
+// isStreamedBody reports whether the body of the given request is
+// read incrementally by its handler, so need not be limited. Only
+// the import endpoint streams its body, and it limits the length of
+// each line instead.
+func (h *handler) isStreamedBody(req *http.Request) bool {
+	return req.Method == "POST" && h.isImportPath(req.URL.Path)
+}
+
+// isSetACL reports whether the given request is for the PUT /:name
+// endpoint, the only one whose body holds the users of an ACL in
+// the field named by HandlerParams.UsersField.
+func (h *handler) isSetACL(req *http.Request) bool {
+	if req.Method != "PUT" {
+		return false
+	}
+	prefix := strings.TrimSuffix(path.Join(h.p.RootPath, "/"), "/")
+	rest := strings.TrimPrefix(req.URL.Path, prefix+"/")
+	return rest != "" && !strings.Contains(rest, "/")
+}
+
+// isGetACLCSV reports whether the given request is for the GET /:name
+// endpoint in the CSV format without the meta-ACL, which is served by
+// the GET /:name/csv endpoint so that GetACL always returns the JSON
+// envelope.
+func (h *handler) isGetACLCSV(req *http.Request) bool {
+	if req.Method != "GET" {
+		return false
+	}
+	prefix := strings.TrimSuffix(path.Join(h.p.RootPath, "/"), "/")
+	rest := strings.TrimPrefix(req.URL.Path, prefix+"/")
+	if rest == "" || strings.Contains(rest, "/") {
+		return false
+	}
+	q := req.URL.Query()
+	withMeta, _ := strconv.ParseBool(q.Get("withMeta"))
+	return q.Get("format") == params.FormatCSV && !withMeta
+}
+
+// csvRequest returns a copy of the given GET /:name request
+// for the GET /:name/csv endpoint.
+func csvRequest(req *http.Request) *http.Request {
+	u := *req.URL
+	u.Path += "/csv"
+	if u.RawPath != "" {
+		u.RawPath += "/csv"
+	}
+	req = req.WithContext(req.Context())
+	req.URL = &u
+	return req
+}
+
+// formBodyFields holds the request body fields that
+// may be provided as form values instead of JSON.
+var formBodyFields = []string{"users", "add", "remove"}
+
+// jsonFromFormBody replaces a form-encoded request body with the
+// equivalent JSON body, so that clients can use either encoding. Each
+// of the given fields found in the form is converted to a JSON array
+// holding all its values.
+func jsonFromFormBody(req *http.Request, fields []string) error {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/x-www-form-urlencoded" {
+		return nil
+	}
+	if err := req.ParseForm(); err != nil {
+		return httprequest.Errorf(httprequest.CodeBadRequest, "cannot parse form body: %v", err)
+	}
+	body := make(map[string][]string)
+	for _, f := range fields {
+		if vs, ok := req.PostForm[f]; ok {
+			body[f] = vs
+		}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	req.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// renameBodyField renames the given top-level field of a JSON
+// request body so that the body can be unmarshaled into the
+// params types. Bodies that are not JSON objects are left
+// for the handler to reject.
+func renameBodyField(req *http.Request, from, to string) error {
+	mediaType, _, _ := mime.ParseMediaType(req.Header.Get("Content-Type"))
+	if mediaType != "application/json" || req.Body == nil {
+		return nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		return errgo.Notef(err, "cannot read request body")
+	}
+	var body map[string]json.RawMessage
+	if err := json.Unmarshal(data, &body); err == nil {
+		if v, ok := body[from]; ok {
+			delete(body, from)
+			body[to] = v
+			data, err = json.Marshal(body)
+			if err != nil {
+				return errgo.Mask(err)
+			}
+		}
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	req.ContentLength = int64(len(data))
+	return nil
+}
+
+// isReservedPath reports whether the given route path starts with a
+// fixed path element rather than a wildcard.
+func isReservedPath(p string) bool {
+	p = strings.TrimPrefix(p, "/")
+	return p != "" && p[0] != ':' && p[0] != '*'
+}
+
+type handler1 struct {
+	h *handler
+
+	// release is called when the request is complete.
+	release func()
+}
+
+// Close implements io.Closer by releasing any resources
+// held for the request.
+func (h handler1) Close() error {
+	if h.release != nil {
+		h.release()
+	}
+	return nil
+}
+
+// newHandler returns a handler instance to serve a particular HTTP request.
+func (h *handler) newHandler(p httprequest.Params, arg aclName) (handler1, context.Context, error) {
+	if h.p.TenantFromRequest != nil {
+		ctx, err := h.tenantContext(p.Context, p.Request)
+		if err != nil {
+			return handler1{}, nil, errgo.Mask(err, errgo.Any)
+		}
+		p.Context = ctx
+	}
+	if op, ok := selfServiceOperation(arg); ok {
+		// Any authenticated user may find out their own permissions.
+		setTraceInfo(p.Context, op, "")
+		ctx, err := h.authenticate(p.Context, p)
+		if err != nil {
+			return handler1{}, nil, errgo.Mask(err, errgo.Any)
+		}
+		return handler1{
+			h: h,
+		}, ctx, nil
+	}
+	name := h.requestACLName(arg)
+	op := requestOperation(arg, p.Request.Method)
+	setTraceInfo(p.Context, op, name)
+	ctx, err := h.authorizeRequest(p.Context, p, name, op)
+	if err != nil {
+		return handler1{}, nil, errgo.Mask(err, errgo.Any)
+	}
+	changed, err := h.changedACLName(ctx, arg, name)
+	if err != nil {
+		return handler1{}, nil, errgo.Mask(err)
+	}
+	if err := h.checkRateLimit(p.Response, op, changed); err != nil {
+		return handler1{}, nil, errgo.Mask(err, errgo.Is(errRateLimited))
+	}
+	release, err := h.acquireMutation(ctx, op, changed)
+	if err != nil {
+		return handler1{}, nil, errgo.Mask(err, errgo.Is(errRateLimited))
+	}
+	return handler1{
+		h:       h,
+		release: release,
+	}, ctx, nil
+}
+
+// selfServiceOperation reports whether the request with the given
+// parameters may be made by any authenticated user, because it only
+// reveals what the user may do themselves, and if so returns the
+// operation it performs.
+func selfServiceOperation(arg aclName) (Operation, bool) {
+	switch arg := arg.(type) {
+	case *params.WhoAmIRequest, *params.IsAdminRequest:
+		return OperationRead, true
+	case *params.AuthorizeRequest:
+		// Each ACL is authorized separately when other
+		// users are asked about.
+		return OperationCheck, true
+	case *params.GetACLsRequest:
+		// Each ACL is authorized separately as it is listed.
+		return OperationList, arg.Manageable
+	}
+	return "", false
+}
+
+// changedACLName returns the name of the ACL that the request with
+// the given parameters, authorized against the ACL with the given
+// name, operates on, with any alias resolved. Requests that don't
+// refer to a single ACL operate on the authorizing ACL.
+func (h *handler) changedACLName(ctx context.Context, arg aclName, authName string) (string, error) {
+	name := arg.ACLName()
+	if name == "" {
+		name = authName
+	}
+	return h.m.resolveAlias(ctx, name)
+}
+
+// requestACLName returns the name of the ACL that is used to
+// authorize the request with the given parameters. Requests that
+// only administrators may make refer to the configured admin ACL,
+// whatever its name.
+func (h *handler) requestACLName(arg aclName) string {
+	if arg, ok := arg.(adminRequest); ok && arg.AdminOnly() {
+		return h.m.p.AdminACLName
+	}
+	return arg.ACLName()
+}
+
+// requestOperation returns the operation performed by
+// the request with the given parameters and method.
+func requestOperation(arg aclName, method string) Operation {
+	switch arg.(type) {
+	case *params.IsMemberRequest, *params.MembersInRequest:
+		return OperationCheck
+	case *params.GetACLsRequest:
+		return OperationList
+	}
+	switch {
+	case method == "DELETE":
+		return OperationDelete
+	case isMutation(method):
+		return OperationModify
+	}
+	return OperationRead
+}
+
+// authorizeRequest checks that an HTTP request that performs the given
+// operation on the ACL with the given name is authorized. On success,
+// it returns the given context with the authenticated identity
+// attached. If the authorization failed because Authenticate failed,
+// it returns an error with an errAuthenticationFailed cause to signal
+// that the desired error response has already been written.
+func (h *handler) authorizeRequest(ctx context.Context, p httprequest.Params, aclName string, op Operation) (context.Context, error) {
+	if err := h.m.validateAccessedACLName(aclName); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrBadACLName))
+	}
+	ctx, err := h.authenticate(ctx, p)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Any)
+	}
+	identity, _ := IdentityFromContext(ctx)
+	ok, err := h.authorize(ctx, identity, aclName, op)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	if !ok {
+		return nil, httprequest.Errorf(httprequest.CodeForbidden, "")
+	}
+	return ctx, nil
+}
+
+// authenticate authenticates the HTTP request and returns the given
+// context with the authenticated identity attached, initializing the
+// tenant attached to the context if there is one. If Authenticate
+// has written its own response, it returns an error with an
+// errAuthenticationFailed cause.
+func (h *handler) authenticate(ctx context.Context, p httprequest.Params) (context.Context, error) {
+	identity, err := h.p.Authenticate(ctx, p.Response, p.Request)
+	if err != nil {
+		if errgo.Cause(err) == ErrUnauthorized {
+			if h.p.WWWAuthenticate != "" {
+				p.Response.Header().Set("WWW-Authenticate", h.p.WWWAuthenticate)
+			}
+			return nil, errgo.Mask(err, errgo.Is(ErrUnauthorized))
+		}
+		return nil, errAuthenticationFailed
+	}
+	if tenant, ok := TenantFromContext(ctx); ok && h.p.TenantFromRequest != nil {
+		// Only authenticated requests may create the
+		// system ACLs of a new tenant.
+		if err := h.m.initTenant(ctx, tenant); err != nil {
+			return nil, errgo.Mask(err, isContextError)
+		}
+	}
+	return ContextWithIdentity(ctx, identity), nil
+}
+
+// authorize reports whether the given identity may perform the given
+// operation on the ACL with the given name, using the configured
+// authorization policy.
+func (h *handler) authorize(ctx context.Context, identity Identity, aclName string, op Operation) (bool, error) {
+	if h.p.Authorize != nil {
+		return h.p.Authorize(ctx, identity, aclName, op)
+	}
+	return h.m.Authorize(ctx, identity, aclName, op)
+}
+
+// GetACL returns the members of the ACL with the requested name.
+// If WithMeta is set, the members of its meta-ACL are returned too.
+// The ETag response header holds a version token for the
+// returned members. It depends only on the members, so it is the same
+// from every handler that shares the store, including after a restart.
+// The v parameter selects the version of the response envelope:
+// version 1, the default, holds only the members, and version 2
+// also holds the version token and the number of members.
+// If the format parameter is "csv", the request is served by GetACLCSV
+// instead; WithMeta may not be set with it.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+// If WithMeta is set, the meta-ACL must also be readable, so
+// only administrators may use it.
+func (h handler1) GetACL(p httprequest.Params, req *params.GetACLRequest) (*params.GetACLResponse, error) {
+	version := req.Version
+	if version == 0 {
+		version = params.ResponseVersion1
+	}
+	if version < params.ResponseVersion1 || version > params.LatestResponseVersion {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "unsupported response version %d", req.Version)
+	}
+	switch req.Format {
+	case "":
+	case params.FormatCSV:
+		// Only requests with WithMeta set get here;
+		// the others are served by GetACLCSV.
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "cannot return meta-ACL members as CSV")
+	default:
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "unknown format %q", req.Format)
+	}
+	var managers []string
+	if req.WithMeta {
+		var err error
+		managers, err = h.metaMembers(p.Context, req.Name)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+	}
+	users, err := h.h.m.ACL(p.Context, req.Name)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	etag := aclETag(users)
+	p.Response.Header().Set("ETag", etag)
+	resp := &params.GetACLResponse{
+		Users:      users,
+		Managers:   managers,
+		UsersField: h.h.p.UsersField,
+	}
+	if version >= params.ResponseVersion2 {
+		resp.Version = version
+		resp.ETag = etag
+		count := len(users)
+		resp.Count = &count
+	}
+	return resp, nil
+}
+
+// GetACLCSV returns the members of the ACL with the requested name as
+// CSV, with a header row followed by a row for each member, and the
+// Content-Disposition header suggests a file name for them. Members
+// that start with a character that spreadsheets treat as the start of
+// a formula are prefixed with a single quote. GetACL requests with the
+// format parameter set to "csv" are served by this endpoint too.
+// Access is as for GetACL.
+func (h handler1) GetACLCSV(p httprequest.Params, req *params.GetACLCSVRequest) error {
+	users, err := h.h.m.ACL(p.Context, req.Name)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	p.Response.Header().Set("ETag", aclETag(users))
+	writeCSV(p.Response, req.Name, users)
+	return nil
+}
+
+// metaMembers returns the members of the meta-ACL for the ACL with
+// the given name, after checking that the authenticated identity
+// may read it. A missing meta-ACL is treated as empty.
+func (h handler1) metaMembers(ctx context.Context, aclName string) ([]string, error) {
+	aclName, err := h.h.m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	metaACLName := metaName(aclName)
+	identity, _ := IdentityFromContext(ctx)
+	ok, err := h.h.authorize(ctx, identity, metaACLName, OperationRead)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if !ok {
+		return nil, httprequest.Errorf(httprequest.CodeForbidden, "")
+	}
+	users, err := h.h.m.ACL(ctx, metaACLName)
+	if err != nil && errgo.Cause(err) != ErrACLNotFound {
+		return nil, errgo.Mask(err)
+	}
+	return users, nil
+}
+
+// CreateACL creates an ACL with the requested name and initial
+// members, as Manager.CreateACL does. Creating an ACL that already
+// exists does nothing unless the failIfExists flag is set, in which
+// case a 409 Conflict error is returned.
+// Only administrators may access this endpoint.
+func (h handler1) CreateACL(p httprequest.Params, req *params.CreateACLRequest) error {
+	err := h.h.m.createACL(p.Context, req.Name, req.Body.Users, createOptions{
+		failIfExists: req.FailIfExists,
+	})
+	return errgo.Mask(err, errgo.Is(ErrBadACLName), errgo.Is(ErrTooManyACLs), errgo.Is(ErrBadUsername), errgo.Is(ErrACLExists), errgo.Is(ErrAliasExists))
+}
+
+// SetACL sets the members of the ACL with the requested name.
+// If the If-Match header is set, the members are only changed
+// if it matches the current version token of the ACL.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) SetACL(p httprequest.Params, req *params.SetACLRequest) error {
+	name, err := h.h.m.resolveAlias(p.Context, req.Name)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	if err := h.h.m.checkUsersAllowed(p.Context, req.Body.Users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername))
+	}
+	if req.IfMatch != "" {
+		err = h.h.m.setIfMatch(p.Context, name, req.Body.Users, req.IfMatch)
+	} else {
+		err = h.h.m.p.Store.Set(p.Context, name, req.Body.Users)
+	}
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrPreconditionFailed))
+	}
+	h.h.m.changed(p.Context, name, OpSet, req.Body.Users)
+	return nil
+}
+
+// ModifyACL modifies the members of the ACL with the requested name.
+// If the action parameter is "clear", all the members are removed.
+// If it is "swap", the old user is atomically replaced with the new
+// user; unless force is set, the old user must be a member.
+// When users are added, the response holds the number of them that
+// were not already members; when users are removed, it holds those
+// of them that were members.
+// A request that is well formed but cannot be carried out, because it
+// asks for conflicting changes, for no change at all, or omits the
+// users that its action requires, fails with a 422 Unprocessable Entity
+// response whose code is CodeConflictingChanges, CodeNoChanges or
+// CodeMissingUsers respectively.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) ModifyACL(p httprequest.Params, req *params.ModifyACLRequest) (*params.ModifyACLResponse, error) {
+	switch req.Action {
+	case "":
+	case params.ActionClear:
+		if len(req.Body.Add) > 0 || len(req.Body.Remove) > 0 {
+			return nil, errgo.WithCausef(nil, errConflictingChanges, "cannot add or remove users when clearing an ACL")
+		}
+		if err := h.h.m.ClearACL(p.Context, req.Name); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+		}
+		return &params.ModifyACLResponse{}, nil
+	case params.ActionSwap:
+		if len(req.Body.Add) > 0 || len(req.Body.Remove) > 0 {
+			return nil, errgo.WithCausef(nil, errConflictingChanges, "cannot add or remove users when swapping users")
+		}
+		if req.Body.OldUser == "" || req.Body.NewUser == "" {
+			return nil, errgo.WithCausef(nil, errMissingUsers, "old and new users must both be specified")
+		}
+		err := h.h.m.SwapMember(p.Context, req.Name, req.Body.OldUser, req.Body.NewUser, req.Body.Force)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrUserNotFound))
+		}
+		return &params.ModifyACLResponse{}, nil
+	default:
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "unknown action %q", req.Action)
+	}
+	name, err := h.h.m.resolveAlias(p.Context, req.Name)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	switch {
+	case len(req.Body.Add) > 0 && len(req.Body.Remove) > 0:
+		return nil, errgo.WithCausef(nil, errConflictingChanges, "cannot add and remove users at the same time")
+	case len(req.Body.Add) > 0:
+		added, _, err := h.h.m.AddReport(p.Context, name, req.Body.Add)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
+		}
+		return &params.ModifyACLResponse{
+			Added: len(added),
+		}, nil
+	case len(req.Body.Remove) > 0:
+		removed, _, err := h.h.m.RemoveReport(p.Context, name, req.Body.Remove)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrUserNotFound))
+		}
+		return &params.ModifyACLResponse{
+			Removed: removed,
+		}, nil
+	default:
+		return nil, errgo.WithCausef(nil, errNoChanges, "no users to add or remove")
+	}
+}
+
+// GetACLs returns the list of all ACLs. Meta-ACLs are
+// only included if the IncludeMeta flag is set, and only
+// ACLs starting with the prefix parameter are included if it
+// is set. The ACLs are sorted by name unless the sort parameter
+// is "size", in which case they are sorted by number of members,
+// which requires every ACL to be read. If the desc flag is set,
+// the order is reversed, except that ACLs with the same number of
+// members are still sorted by name. If the detail flag is set, the members
+// of each ACL are also returned; if too many ACLs match, only the
+// first of them are returned and the response is marked as
+// truncated. If the counts flag is set, the number of members of
+// each ACL is returned, which is cheaper than returning the
+// members themselves.
+// If the offset or limit parameters are set, only that page of the
+// matching ACLs, in the requested order, is returned. If the count
+// flag is set, the total number of matching ACLs is returned too, so
+// that clients need not make a separate request to find it. The total
+// is computed when the first page of a listing is read and reported
+// again for its later pages for a while, so it may be approximate
+// under concurrent changes.
+// The ETag response header holds a version token for the response;
+// if it matches the If-None-Match header, a 304 Not Modified response
+// with no body is returned instead.
+// Only administrators may access this endpoint unless the manageable
+// flag is set, in which case any authenticated user may, and only the
+// ACLs that the user may change are included. This requires each
+// matching ACL to be authorized separately, so it is more expensive,
+// and such requests are rate limited per caller.
+func (h handler1) GetACLs(p httprequest.Params, req *params.GetACLsRequest) (*params.GetACLsResponse, error) {
+	if req.Manageable {
+		caller, _ := IdentityFromContext(p.Context)
+		if err := h.h.checkCallerRateLimit(p.Response, caller); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(errRateLimited))
+		}
+	}
+	if req.Sort != "" && req.Sort != params.SortByName && req.Sort != params.SortBySize {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "unknown sort order %q", req.Sort)
+	}
+	if req.Offset < 0 || req.Limit < 0 {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "negative offset or limit")
+	}
+	acls, err := h.h.m.ACLNames(p.Context, req.IncludeMeta)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if req.Prefix != "" {
+		matched := acls[:0]
+		for _, name := range acls {
+			if strings.HasPrefix(name, req.Prefix) {
+				matched = append(matched, name)
+			}
+		}
+		acls = matched
+	}
+	if req.Manageable {
+		if acls, err = h.manageable(p.Context, acls); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	if req.Sort == params.SortBySize {
+		if acls, err = h.h.m.sortBySize(p.Context, acls, req.Desc); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	} else if req.Desc {
+		for i, j := 0, len(acls)-1; i < j; i, j = i+1, j-1 {
+			acls[i], acls[j] = acls[j], acls[i]
+		}
+	}
+	resp := &params.GetACLsResponse{}
+	if req.Count {
+		total := h.listingTotal(p.Context, req, len(acls))
+		resp.Total = &total
+	}
+	resp.ACLs = page(acls, req.Offset, req.Limit)
+	if req.Detail {
+		if err := h.addMembers(p.Context, resp); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	if req.Counts {
+		if err := h.addCounts(p.Context, resp); err != nil {
+			return nil, errgo.Mask(err)
+		}
+	}
+	etag, err := listETag(resp)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	p.Response.Header().Set("ETag", etag)
+	if etagMatches(req.IfNoneMatch, etag) {
+		return nil, errNotModified
+	}
+	return resp, nil
+}
+
+// listingTotal returns the total number of ACLs to report for the
+// listing requested by req, given the number that were found for
+// this request. The total found when the first page of a listing
+// is read is reported for the later pages of the same listing for
+// a while, so that they agree on it.
+func (h handler1) listingTotal(ctx context.Context, req *params.GetACLsRequest, found int) int {
+	key := listingKey{
+		prefix:      req.Prefix,
+		includeMeta: req.IncludeMeta,
+		manageable:  req.Manageable,
+	}
+	key.tenant, _ = TenantFromContext(ctx)
+	if req.Manageable {
+		identity, _ := IdentityFromContext(ctx)
+		named, ok := identity.(NamedIdentity)
+		if !ok {
+			// The listing can't be told apart from
+			// those of other callers.
+			return found
+		}
+		key.caller = named.Name()
+	}
+	if req.Offset > 0 {
+		if total, ok := h.h.totals.get(key); ok {
+			return total
+		}
+	}
+	h.h.totals.set(key, found)
+	return found
+}
+
+// manageable returns those of the given ACL names that the
+// authenticated identity may change. ACLs without a meta-ACL
+// are left out.
+func (h handler1) manageable(ctx context.Context, names []string) ([]string, error) {
+	identity, _ := IdentityFromContext(ctx)
+	matched := names[:0]
+	for _, name := range names {
+		ok, err := h.h.authorize(ctx, identity, name, OperationModify)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				continue
+			}
+			return nil, errgo.Mask(err)
+		}
+		if ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// page returns the ACLs in the page of the given names that starts
+// at the given offset and holds at most limit names, or all the
+// remaining names if limit is zero.
+func page(names []string, offset, limit int) []string {
+	if offset >= len(names) {
+		return []string{}
+	}
+	names = names[offset:]
+	if limit > 0 && limit < len(names) {
+		names = names[:limit]
+	}
+	return names
+}
+
+// addMembers sets resp.Members to hold the members of the ACLs in
+// resp.ACLs, truncating resp.ACLs if there are too many of them.
+func (h handler1) addMembers(ctx context.Context, resp *params.GetACLsResponse) error {
+	names := resp.ACLs
+	if len(names) > h.h.p.MaxDetailACLs {
+		names = names[:h.h.p.MaxDetailACLs]
+		resp.Truncated = true
+	}
+	resp.Members = make(map[string][]string, len(names))
+	resp.ACLs = make([]string, 0, len(names))
+	for _, name := range names {
+		users, err := h.h.m.ACL(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				// The ACL has been removed since it was listed.
+				continue
+			}
+			return errgo.Mask(err)
+		}
+		if users == nil {
+			users = []string{}
+		}
+		resp.ACLs = append(resp.ACLs, name)
+		resp.Members[name] = users
+	}
+	return nil
+}
+
+// addCounts sets resp.Counts to hold the number of members of the
+// ACLs in resp.ACLs. If the members have already been retrieved,
+// they are counted rather than being retrieved again.
+func (h handler1) addCounts(ctx context.Context, resp *params.GetACLsResponse) error {
+	resp.Counts = make(map[string]int, len(resp.ACLs))
+	if resp.Members != nil {
+		for name, users := range resp.Members {
+			resp.Counts[name] = len(users)
+		}
+		return nil
+	}
+	names := resp.ACLs
+	resp.ACLs = make([]string, 0, len(names))
+	for _, name := range names {
+		n, err := h.h.m.Count(ctx, name)
+		if err != nil {
+			if errgo.Cause(err) == ErrACLNotFound {
+				// The ACL has been removed since it was listed.
+				continue
+			}
+			return errgo.Mask(err)
+		}
+		resp.ACLs = append(resp.ACLs, name)
+		resp.Counts[name] = n
+	}
+	return nil
+}
+
+// DeleteACLs deletes all the ACLs whose names start with the requested
+// prefix, together with their meta-ACLs, and returns the names of
+// those deleted. Any ACLs that could not be deleted are reported in
+// the response. The prefix must not match the admin ACL.
+// Only administrators may access this endpoint.
+func (h handler1) DeleteACLs(p httprequest.Params, req *params.DeleteACLsRequest) (*params.DeleteACLsResponse, error) {
+	result, err := h.h.m.DeleteACLs(p.Context, req.Prefix)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrAdminLockout), errgo.Is(ErrBadACLName))
+	}
+	resp := &params.DeleteACLsResponse{
+		Count:   len(result.Deleted),
+		Deleted: result.Deleted,
+	}
+	for name, err := range result.Failed {
+		if resp.Failed == nil {
+			resp.Failed = make(map[string]string)
+		}
+		resp.Failed[name] = err.Error()
+	}
+	return resp, nil
+}
+
+// GetMemberOrigins returns the members of the ACL with the requested
+// name together with the users that may change it, each annotated with
+// how it is related to the ACL: as a member, a member of the meta-ACL
+// or an administrator.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) GetMemberOrigins(p httprequest.Params, req *params.GetMemberOriginsRequest) (*params.GetMemberOriginsResponse, error) {
+	origins, err := h.h.m.MemberOrigins(p.Context, req.Name)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	resp := &params.GetMemberOriginsResponse{
+		Members: make([]params.MemberOrigin, len(origins)),
+	}
+	for i, o := range origins {
+		resp.Members[i] = params.MemberOrigin{
+			User:    o.User,
+			Origins: o.Origins,
+		}
+	}
+	return resp, nil
+}
+
+// GetManagers returns the users that may change the membership of
+// the ACL with the requested name: the members of its meta-ACL
+// and the administrators.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) GetManagers(p httprequest.Params, req *params.GetManagersRequest) (*params.GetManagersResponse, error) {
+	users, err := h.h.m.Managers(p.Context, req.Name)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	return &params.GetManagersResponse{
+		Users: users,
+	}, nil
+}
+
+// GetEffectiveACLs returns the names of the ACLs that the requested
+// user is a direct member of. If the user is an administrator, the
+// list also includes "*", signifying access to every ACL.
+// Only administrators may access this endpoint.
+func (h handler1) GetEffectiveACLs(p httprequest.Params, req *params.GetEffectiveACLsRequest) (*params.GetEffectiveACLsResponse, error) {
+	acls, err := h.h.m.EffectiveACLs(p.Context, req.User)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	if acls == nil {
+		acls = []string{}
+	}
+	return &params.GetEffectiveACLsResponse{
+		ACLs: acls,
+	}, nil
+}
+
+// IsMember reports whether the requested user is a direct member
+// of the ACL with the requested name.
+// Only administrators, members of the meta-ACL for the name and members
+// of the checker ACL may access this endpoint. The meta-ACL for
+// meta-ACLs is "admin".
+func (h handler1) IsMember(p httprequest.Params, req *params.IsMemberRequest) (*params.IsMemberResponse, error) {
+	ok, err := h.h.m.IsMember(p.Context, req.Name, req.User)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	return &params.IsMemberResponse{
+		Member: ok,
+	}, nil
+}
+
+// SearchMembers returns the members of the ACL with the requested name
+// that contain the query, ignoring case. If there are more than the
+// configured maximum number of matching members, only that many are
+// returned and the truncated flag is set in the response.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) SearchMembers(p httprequest.Params, req *params.SearchMembersRequest) (*params.SearchMembersResponse, error) {
+	users, truncated, err := h.h.m.searchMembers(p.Context, req.Name, req.Query)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	return &params.SearchMembersResponse{
+		Users:     users,
+		Truncated: truncated,
+	}, nil
+}
+
+// MembersIn returns those of the candidate users in the request
+// body that are direct members of the ACL with the requested name.
+// Only administrators, members of the meta-ACL for the name and members
+// of the checker ACL may access this endpoint. The meta-ACL for
+// meta-ACLs is "admin".
+func (h handler1) MembersIn(p httprequest.Params, req *params.MembersInRequest) (*params.MembersInResponse, error) {
+	users, err := h.h.m.MembersIn(p.Context, req.Name, req.Body.Candidates)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound))
+	}
+	return &params.MembersInResponse{
+		Users: users,
+	}, nil
+}
+
+// GetStats returns statistics about the ACLs in the store.
+// Only administrators may access this endpoint.
+func (h handler1) GetStats(p httprequest.Params, req *params.GetStatsRequest) (*params.GetStatsResponse, error) {
+	stats, err := h.h.m.Stats(p.Context)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return &params.GetStatsResponse{
+		ACLs:        stats.ACLs,
+		Users:       stats.Users,
+		AverageSize: stats.AverageSize,
+	}, nil
+}
+
+// WhoAmI returns the names of the ACLs that the authenticated
+// caller may read and those that they may change. Any
+// authenticated user may access this endpoint.
+func (h handler1) WhoAmI(p httprequest.Params, req *params.WhoAmIRequest) (*params.WhoAmIResponse, error) {
+	identity, _ := IdentityFromContext(p.Context)
+	names, err := h.h.m.ACLNames(p.Context, true)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	resp := &params.WhoAmIResponse{
+		Read:   []string{},
+		Modify: []string{},
+	}
+	for _, name := range names {
+		for _, op := range []Operation{OperationRead, OperationModify} {
+			ok, err := h.h.authorize(p.Context, identity, name, op)
+			if err != nil {
+				if errgo.Cause(err) == ErrACLNotFound {
+					// The meta-ACL that guards the ACL does not exist.
+					continue
+				}
+				return nil, errgo.Mask(err)
+			}
+			if !ok {
+				continue
+			}
+			if op == OperationRead {
+				resp.Read = append(resp.Read, name)
+			} else {
+				resp.Modify = append(resp.Modify, name)
+			}
+		}
+	}
+	return resp, nil
+}
+
+// IsAdmin reports whether the authenticated caller is allowed by the
+// admin ACL, so that, for example, a user interface can decide whether
+// to show administrative controls. The members of the admin ACL are
+// not returned. Any authenticated user may access this endpoint.
+func (h handler1) IsAdmin(p httprequest.Params, req *params.IsAdminRequest) (*params.IsAdminResponse, error) {
+	identity, _ := IdentityFromContext(p.Context)
+	admins, err := h.h.m.ACL(p.Context, h.h.m.p.AdminACLName)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get admin ACL")
+	}
+	ok, err := h.h.m.allow(p.Context, identity, admins)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot check permissions")
+	}
+	return &params.IsAdminResponse{
+		Admin: ok,
+	}, nil
+}
+
+// ReplaceAdmins atomically replaces the members of the admin ACL.
+// Unless the Force flag is set, at least one of the current admin
+// users must remain. Only administrators may access this endpoint.
+func (h handler1) ReplaceAdmins(p httprequest.Params, req *params.ReplaceAdminsRequest) error {
+	err := h.h.m.ReplaceAdmins(p.Context, req.Body.Users, req.Body.Force)
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrAdminLockout))
+}
+
+// RebuildIndex regenerates the index of the ACLs that hold each
+// user, returning when it is complete. Only administrators may
+// access this endpoint.
+func (h handler1) RebuildIndex(p httprequest.Params, req *params.RebuildIndexRequest) error {
+	return errgo.Mask(h.h.m.RebuildIndex(p.Context))
 }
 
 func metaName(aclName string) string {
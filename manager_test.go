@@ -10,13 +10,19 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
+	"regexp"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv"
 	"github.com/juju/simplekv/memsimplekv"
 	"gopkg.in/errgo.v1"
 	httprequest "gopkg.in/httprequest.v1"
@@ -112,6 +118,35 @@ var getACLTests = []struct {
 	expectResponse: map[string][]string{
 		"users": {"claire", "ed"},
 	},
+}, {
+	testName: "get_ACL_with_meta",
+	rootPath: "/root",
+	users: map[string][]string{
+		"admin":    {"alice", "bob"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {"claire", "ed"},
+	},
+	path:           "/root/someacl?withMeta=true",
+	expectCheckACL: []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: map[string][]string{
+		"users":    {"charlie", "daisy"},
+		"managers": {"claire", "ed"},
+	},
+}, {
+	testName: "get_ACL_with_empty_meta",
+	rootPath: "/root",
+	users: map[string][]string{
+		"admin":    {"alice", "bob"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {},
+	},
+	path:           "/root/someacl?withMeta=true",
+	expectCheckACL: []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: map[string][]string{
+		"users": {"charlie", "daisy"},
+	},
 }, {
 	testName: "get_all_ACLs",
 	rootPath: "/root",
@@ -119,12 +154,27 @@ var getACLTests = []struct {
 	users: map[string][]string{
 		"admin": {"alice", "bob"},
 		"read":  {"eve"},
+		"_read": {"eve"},
 	},
 	expectCheckACL: []string{"alice", "bob"},
 	expectStatus:   http.StatusOK,
 	expectResponse: map[string][]string{
 		"acls": {"admin", "read"},
 	},
+}, {
+	testName: "get_all_ACLs_including_meta_ACLs",
+	rootPath: "/root",
+	path:     "/root/?includeMeta=true",
+	users: map[string][]string{
+		"admin": {"alice", "bob"},
+		"read":  {"eve"},
+		"_read": {"eve"},
+	},
+	expectCheckACL: []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: map[string][]string{
+		"acls": {"_read", "admin", "read"},
+	},
 }}
 
 func TestGetACL(t *testing.T) {
@@ -142,6 +192,189 @@ func TestGetACL(t *testing.T) {
 	}
 }
 
+var rootPathTests = []struct {
+	testName       string
+	rootPath       string
+	method         string
+	path           string
+	expectStatus   int
+	expectResponse interface{}
+}{{
+	testName:     "get_root_with_empty_root_path",
+	method:       "GET",
+	path:         "/",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLsResponse{
+		ACLs: []string{"admin", "someacl"},
+	},
+}, {
+	testName:     "get_root",
+	rootPath:     "/root",
+	method:       "GET",
+	path:         "/root",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLsResponse{
+		ACLs: []string{"admin", "someacl"},
+	},
+}, {
+	testName:     "get_root_with_trailing_slash",
+	rootPath:     "/root",
+	method:       "GET",
+	path:         "/root/",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLsResponse{
+		ACLs: []string{"admin", "someacl"},
+	},
+}, {
+	testName:     "put_root_with_empty_root_path",
+	method:       "PUT",
+	path:         "/",
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "empty ACL name",
+	},
+}, {
+	testName:     "put_root",
+	rootPath:     "/root",
+	method:       "PUT",
+	path:         "/root/",
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "empty ACL name",
+	},
+}, {
+	testName:     "post_root",
+	rootPath:     "/root",
+	method:       "POST",
+	path:         "/root",
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "empty ACL name",
+	},
+}, {
+	testName:     "empty_name_element_with_empty_root_path",
+	method:       "GET",
+	path:         "//managers",
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "empty ACL name",
+	},
+}, {
+	testName:     "empty_name_element",
+	rootPath:     "/root",
+	method:       "GET",
+	path:         "/root//managers",
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: "empty ACL name",
+	},
+}, {
+	testName:     "outside_root_path",
+	rootPath:     "/root",
+	method:       "GET",
+	path:         "/",
+	expectStatus: http.StatusNotFound,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeNotFound,
+		Message: "URL path not found",
+	},
+}}
+
+func TestRootPath(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range rootPathTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var checkedACL []string
+			_, h := managerWithACLs(c, test.rootPath, map[string][]string{
+				"admin":   {"alice"},
+				"someacl": {"bob"},
+			}, &checkedACL)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			var body interface{}
+			if test.method == "PUT" {
+				body = params.SetACLRequestBody{
+					Users: []string{"bob"},
+				}
+			}
+			assertJSONCall(c, test.method, srv.URL+test.path, body, test.expectStatus, test.expectResponse)
+		})
+	}
+}
+
+func TestRootPathNormalization(t *testing.T) {
+	c := qt.New(t)
+	for _, rootPath := range []string{"root", "/root/", "//root", "/root"} {
+		c.Run(rootPath, func(c *qt.C) {
+			var checkedACL []string
+			_, h := managerWithACLs(c, rootPath, map[string][]string{
+				"admin":    {"alice"},
+				"someacl":  {"bob"},
+				"_someacl": {},
+			}, &checkedACL)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			assertJSONCall(c, "GET", srv.URL+"/root", nil, http.StatusOK, params.GetACLsResponse{
+				ACLs: []string{"admin", "someacl"},
+			})
+			assertJSONCall(c, "PUT", srv.URL+"/root/someacl", params.SetACLRequestBody{
+				Users: []string{"charlie"},
+			}, http.StatusOK, nil)
+			assertJSONCall(c, "GET", srv.URL+"/root/someacl", nil, http.StatusOK, params.GetACLResponse{
+				Users: []string{"charlie"},
+			})
+			assertJSONCall(c, "GET", srv.URL+"/root/stats", nil, http.StatusOK, params.GetStatsResponse{
+				ACLs:        2,
+				Users:       2,
+				AverageSize: 1,
+			})
+			assertJSONCall(c, "GET", srv.URL+"/someacl", nil, http.StatusNotFound, &httprequest.RemoteError{
+				Code:    httprequest.CodeNotFound,
+				Message: "URL path not found",
+			})
+		})
+	}
+}
+
+func TestGetACLWithMetaForbidden(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/_someacl", params.SetACLRequestBody{
+		Users: []string{"claire"},
+	}, http.StatusOK, nil)
+
+	// A manager of the ACL may read it, but not its meta-ACL.
+	assertJSONCallAs(c, "claire", "GET", srv.URL+"/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice"},
+	})
+	assertJSONCallAs(c, "claire", "GET", srv.URL+"/someacl?withMeta=true", nil, http.StatusForbidden, &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/someacl?withMeta=true", nil, http.StatusOK, params.GetACLResponse{
+		Users:    []string{"alice"},
+		Managers: []string{"claire"},
+	})
+}
+
 var setACLTests = []struct {
 	testName       string
 	path           string
@@ -255,6 +488,21 @@ var modifyACLTests = []struct {
 	expectACLName:  "admin",
 	expectACL:      []string{"alice", "bar", "bob", "foo"},
 	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{
+		Added: 2,
+	},
+}, {
+	testName: "add_existing_users",
+	users: map[string][]string{
+		"admin": {"alice", "bob"},
+	},
+	path:           "/root/admin",
+	addUsers:       []string{"bob", "alice", "bob"},
+	expectCheckACL: []string{"alice", "bob"},
+	expectACLName:  "admin",
+	expectACL:      []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{},
 }, {
 	testName: "remove_admin_ACL",
 	users: map[string][]string{
@@ -266,6 +514,21 @@ var modifyACLTests = []struct {
 	expectACLName:  "admin",
 	expectACL:      []string{"bob"},
 	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{
+		Removed: []string{"alice"},
+	},
+}, {
+	testName: "remove_absent_users",
+	users: map[string][]string{
+		"admin": {"alice", "bob"},
+	},
+	path:           "/root/admin",
+	removeUsers:    []string{"bar", "foo"},
+	expectCheckACL: []string{"alice", "bob"},
+	expectACLName:  "admin",
+	expectACL:      []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{},
 }, {
 	testName:     "set_nonexistent_ACL",
 	path:         "/root/nonexistent",
@@ -283,10 +546,10 @@ var modifyACLTests = []struct {
 	addUsers:       []string{"edward"},
 	removeUsers:    []string{"bar"},
 	expectCheckACL: []string{"alice", "bob"},
-	expectStatus:   http.StatusBadRequest,
+	expectStatus:   http.StatusUnprocessableEntity,
 	expectResponse: &httprequest.RemoteError{
 		Message: `cannot add and remove users at the same time`,
-		Code:    httprequest.CodeBadRequest,
+		Code:    aclstore.CodeConflictingChanges,
 	},
 }, {
 	testName: "add_to_non_admin_ACL",
@@ -301,6 +564,9 @@ var modifyACLTests = []struct {
 	expectACLName:  "someacl",
 	expectACL:      []string{"charlie", "daisy", "elouise", "fred"},
 	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{
+		Added: 2,
+	},
 }, {
 	testName: "add_to_meta_ACL",
 	users: map[string][]string{
@@ -314,6 +580,9 @@ var modifyACLTests = []struct {
 	expectACLName:  "_someacl",
 	expectACL:      []string{"a", "b", "charlie"},
 	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{
+		Added: 1,
+	},
 }, {
 	testName: "add_invalid_user",
 	users: map[string][]string{
@@ -327,6 +596,52 @@ var modifyACLTests = []struct {
 		Message: `invalid user name ""`,
 		Code:    httprequest.CodeBadRequest,
 	},
+}, {
+	testName: "clear_ACL",
+	users: map[string][]string{
+		"admin":    {"boss"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {"a", "b"},
+	},
+	path:           "/root/someacl?action=clear",
+	expectCheckACL: []string{"a", "b", "boss"},
+	expectACLName:  "someacl",
+	expectACL:      nil,
+	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{},
+}, {
+	testName: "clear_and_add",
+	users: map[string][]string{
+		"admin":    {"boss"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {"a", "b"},
+	},
+	path:           "/root/someacl?action=clear",
+	addUsers:       []string{"edward"},
+	expectCheckACL: []string{"a", "b", "boss"},
+	expectACLName:  "someacl",
+	expectACL:      []string{"charlie", "daisy"},
+	expectStatus:   http.StatusUnprocessableEntity,
+	expectResponse: &httprequest.RemoteError{
+		Message: `cannot add or remove users when clearing an ACL`,
+		Code:    aclstore.CodeConflictingChanges,
+	},
+}, {
+	testName: "unknown_action",
+	users: map[string][]string{
+		"admin":    {"boss"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {"a", "b"},
+	},
+	path:           "/root/someacl?action=destroy",
+	expectCheckACL: []string{"a", "b", "boss"},
+	expectACLName:  "someacl",
+	expectACL:      []string{"charlie", "daisy"},
+	expectStatus:   http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Message: `unknown action "destroy"`,
+		Code:    httprequest.CodeBadRequest,
+	},
 }}
 
 func TestModifyACL(t *testing.T) {
@@ -351,208 +666,3740 @@ func TestModifyACL(t *testing.T) {
 	}
 }
 
-func TestWithAuthenticate(t *testing.T) {
-	ctx := context.Background()
+func TestClearACLWithoutBody(t *testing.T) {
 	c := qt.New(t)
-	m, err := aclstore.NewManager(ctx, aclstore.Params{
-		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
-		InitialAdminUsers: []string{"bob"},
-	})
-	c.Assert(err, qt.Equals, nil)
-	h := m.NewHandler(aclstore.HandlerParams{
-		RootPath: "/rootpath",
-		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
-			req.ParseForm()
-			user := req.Form.Get("auth")
-			if user == "" {
-				w.Header().Set("Content-Type", "application/json")
-				w.WriteHeader(http.StatusTeapot)
-				fmt.Fprintf(w, `"go away: %s"`, req.URL.Path)
-				return nil, errgo.Newf("no auth header found")
-			}
-			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
-				for _, a := range acl {
-					if a == user {
-						return true, nil
-					}
-				}
-				return false, nil
-			}), nil
-		},
-	})
+	var checkedACL []string
+	m, h := managerWithACLs(c, "", map[string][]string{
+		"admin":    {"boss"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {},
+	}, &checkedACL)
 	srv := httptest.NewServer(h)
 	defer srv.Close()
+	resp, err := http.Post(srv.URL+"/someacl?action=clear", "", nil)
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
 
-	assertJSONCall(c, "GET", srv.URL+"/rootpath/admin", nil, http.StatusTeapot, "go away: /rootpath/admin")
-	assertJSONCall(c, "GET", srv.URL+"/rootpath/admin?auth=bob", nil, http.StatusOK, params.GetACLResponse{
-		Users: []string{"bob"},
-	})
+	// The ACL and its meta-ACL still exist.
+	acl, err := m.ACL(context.Background(), "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.HasLen, 0)
+	_, err = m.ACL(context.Background(), "_someacl")
+	c.Assert(err, qt.Equals, nil)
 }
 
-func TestForbidden(t *testing.T) {
-	ctx := context.Background()
-	c := qt.New(t)
-	m, err := aclstore.NewManager(ctx, aclstore.Params{
-		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
-		InitialAdminUsers: []string{"bob"},
-	})
-	c.Assert(err, qt.Equals, nil)
-	h := m.NewHandler(aclstore.HandlerParams{
-		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
-			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
-				return false, nil
-			}), nil
-		},
-	})
-	srv := httptest.NewServer(h)
-	defer srv.Close()
+var modifyACLBodyTests = []struct {
+	testName     string
+	body         string
+	expectStatus int
+	expectCode   string
+}{{
+	testName:     "malformed_JSON",
+	body:         `{"add": [`,
+	expectStatus: http.StatusBadRequest,
+	expectCode:   httprequest.CodeBadRequest,
+}, {
+	testName:     "wrong_type",
+	body:         `{"add": "edward"}`,
+	expectStatus: http.StatusBadRequest,
+	expectCode:   httprequest.CodeBadRequest,
+}, {
+	testName:     "empty_object",
+	body:         `{}`,
+	expectStatus: http.StatusUnprocessableEntity,
+	expectCode:   aclstore.CodeNoChanges,
+}, {
+	testName:     "empty_lists",
+	body:         `{"add": [], "remove": []}`,
+	expectStatus: http.StatusUnprocessableEntity,
+	expectCode:   aclstore.CodeNoChanges,
+}, {
+	testName:     "add_and_remove",
+	body:         `{"add": ["edward"], "remove": ["charlie"]}`,
+	expectStatus: http.StatusUnprocessableEntity,
+	expectCode:   aclstore.CodeConflictingChanges,
+}, {
+	testName:     "add",
+	body:         `{"add": ["edward"]}`,
+	expectStatus: http.StatusOK,
+}}
 
-	assertJSONCall(c, "GET", srv.URL+"/admin", nil, http.StatusForbidden, &httprequest.RemoteError{
-		Code:    httprequest.CodeForbidden,
-		Message: httprequest.CodeForbidden,
-	})
+func TestModifyACLBody(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range modifyACLBodyTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var checkedACL []string
+			m, h := managerWithACLs(c, "", map[string][]string{
+				"admin":    {"boss"},
+				"someacl":  {"charlie", "daisy"},
+				"_someacl": {},
+			}, &checkedACL)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			resp, err := http.Post(srv.URL+"/someacl", "application/json", strings.NewReader(test.body))
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus)
+			if test.expectCode != "" {
+				var rerr httprequest.RemoteError
+				err = json.NewDecoder(resp.Body).Decode(&rerr)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(rerr.Code, qt.Equals, test.expectCode)
+				// The ACL is left unchanged.
+				acl, err := m.ACL(context.Background(), "someacl")
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(acl, qt.DeepEquals, []string{"charlie", "daisy"})
+			}
+		})
+	}
 }
 
-func TestManagerCreateACL(t *testing.T) {
+func TestManagerClearACL(t *testing.T) {
 	c := qt.New(t)
-	var checkedACL []string
-
 	ctx := context.Background()
-
-	m, _ := managerWithACLs(c, "", nil, &checkedACL)
-
-	err := m.CreateACL(ctx, "foo", "x", "y")
+	var checkedACL []string
+	m, _ := managerWithACLs(c, "", map[string][]string{
+		"admin": {"boss"},
+	}, &checkedACL)
+	err := m.CreateACL(ctx, "someacl", "alice", "bob")
 	c.Assert(err, qt.Equals, nil)
-
-	acl, err := m.ACL(ctx, "foo")
+	err = m.ClearACL(ctx, "someacl")
 	c.Assert(err, qt.Equals, nil)
-	c.Assert(acl, qt.DeepEquals, []string{"x", "y"})
-
-	err = m.CreateACL(ctx, "foo", "z", "w")
+	acl, err := m.ACL(ctx, "someacl")
 	c.Assert(err, qt.Equals, nil)
-
-	acl, err = m.ACL(ctx, "foo")
+	c.Assert(acl, qt.HasLen, 0)
+	names, err := m.ACLNames(ctx, true)
 	c.Assert(err, qt.Equals, nil)
-	c.Assert(acl, qt.DeepEquals, []string{"x", "y"})
+	sort.Strings(names)
+	c.Assert(names, qt.DeepEquals, []string{"_someacl", "admin", "someacl"})
 
-	// Check that the meta ACL is created too.
-	acl, err = m.ACL(ctx, "_foo")
-	c.Assert(err, qt.Equals, nil)
-	c.Assert(acl, qt.DeepEquals, []string(nil))
+	err = m.ClearACL(ctx, "nonexistent")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
 }
 
-func TestManagerCreateACLWithInvalidACLName(t *testing.T) {
+var formBodyTests = []struct {
+	testName     string
+	method       string
+	jsonBody     interface{}
+	formBody     url.Values
+	expectStatus int
+	expectACL    []string
+}{{
+	testName: "set",
+	method:   "PUT",
+	jsonBody: params.SetACLRequestBody{
+		Users: []string{"x", "y"},
+	},
+	formBody: url.Values{
+		"users": {"x", "y"},
+	},
+	expectStatus: http.StatusOK,
+	expectACL:    []string{"x", "y"},
+}, {
+	testName: "add",
+	method:   "POST",
+	jsonBody: params.ModifyACLRequestBody{
+		Add: []string{"x", "y"},
+	},
+	formBody: url.Values{
+		"add": {"x", "y"},
+	},
+	expectStatus: http.StatusOK,
+	expectACL:    []string{"a", "b", "x", "y"},
+}, {
+	testName: "remove",
+	method:   "POST",
+	jsonBody: params.ModifyACLRequestBody{
+		Remove: []string{"a"},
+	},
+	formBody: url.Values{
+		"remove": {"a"},
+	},
+	expectStatus: http.StatusOK,
+	expectACL:    []string{"b"},
+}, {
+	testName: "invalid_user",
+	method:   "PUT",
+	jsonBody: params.SetACLRequestBody{
+		Users: []string{""},
+	},
+	formBody: url.Values{
+		"users": {""},
+	},
+	expectStatus: http.StatusBadRequest,
+	expectACL:    []string{"a", "b"},
+}}
+
+func TestFormBody(t *testing.T) {
 	c := qt.New(t)
-	var checkedACL []string
+	ctx := context.Background()
+	for _, test := range formBodyTests {
+		c.Run(test.testName, func(c *qt.C) {
+			jsonData, err := json.Marshal(test.jsonBody)
+			c.Assert(err, qt.Equals, nil)
+			bodies := []struct {
+				contentType string
+				data        string
+			}{{
+				contentType: "application/json",
+				data:        string(jsonData),
+			}, {
+				contentType: "application/x-www-form-urlencoded",
+				data:        test.formBody.Encode(),
+			}}
+			var results []string
+			for _, body := range bodies {
+				var checkedACL []string
+				m, h := managerWithACLs(c, "/root", map[string][]string{
+					"admin":    {"boss"},
+					"someacl":  {"a", "b"},
+					"_someacl": {},
+				}, &checkedACL)
+				srv := httptest.NewServer(h)
+				req, err := http.NewRequest(test.method, srv.URL+"/root/someacl", strings.NewReader(body.data))
+				c.Assert(err, qt.Equals, nil)
+				req.Header.Set("Content-Type", body.contentType)
+				resp, err := http.DefaultClient.Do(req)
+				c.Assert(err, qt.Equals, nil)
+				respData, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				srv.Close()
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(resp.StatusCode, qt.Equals, test.expectStatus, qt.Commentf("body: %s", respData))
+				acl, err := m.ACL(ctx, "someacl")
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(acl, qt.DeepEquals, test.expectACL)
+				results = append(results, string(respData))
+			}
+			// Both content types should produce identical responses.
+			c.Assert(results[1], qt.Equals, results[0])
+		})
+	}
+}
+
+var formBodyUsersFieldTests = []struct {
+	testName  string
+	jsonBody  string
+	formBody  url.Values
+	expectACL []string
+}{{
+	testName: "users_field",
+	jsonBody: `{"members":["x","y"]}`,
+	formBody: url.Values{
+		"members": {"x", "y"},
+	},
+	expectACL: []string{"x", "y"},
+}, {
+	testName: "default_field",
+	jsonBody: `{"users":["x"]}`,
+	formBody: url.Values{
+		"users": {"x"},
+	},
+	expectACL: []string{"x"},
+}, {
+	testName: "both_fields",
+	jsonBody: `{"users":["x"],"members":["y"]}`,
+	formBody: url.Values{
+		"users":   {"x"},
+		"members": {"y"},
+	},
+	expectACL: []string{"y"},
+}}
 
+func TestFormBodyUsersField(t *testing.T) {
+	c := qt.New(t)
 	ctx := context.Background()
+	for _, test := range formBodyUsersFieldTests {
+		c.Run(test.testName, func(c *qt.C) {
+			bodies := []struct {
+				contentType string
+				data        string
+			}{{
+				contentType: "application/json",
+				data:        test.jsonBody,
+			}, {
+				contentType: "application/x-www-form-urlencoded",
+				data:        test.formBody.Encode(),
+			}}
+			for _, body := range bodies {
+				store := aclstore.NewACLStore(memsimplekv.NewStore())
+				m, err := aclstore.NewManager(ctx, aclstore.Params{
+					Store: store,
+				})
+				c.Assert(err, qt.Equals, nil)
+				err = m.CreateACL(ctx, "someacl", "a")
+				c.Assert(err, qt.Equals, nil)
+				srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+					Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+						return allowed{}, nil
+					},
+					UsersField: "members",
+				}))
+				req, err := http.NewRequest("PUT", srv.URL+"/someacl", strings.NewReader(body.data))
+				c.Assert(err, qt.Equals, nil)
+				req.Header.Set("Content-Type", body.contentType)
+				resp, err := http.DefaultClient.Do(req)
+				c.Assert(err, qt.Equals, nil)
+				respData, err := ioutil.ReadAll(resp.Body)
+				resp.Body.Close()
+				srv.Close()
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(resp.StatusCode, qt.Equals, http.StatusOK, qt.Commentf("%s body: %s", body.contentType, respData))
+				acl, err := m.ACL(ctx, "someacl")
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(acl, qt.DeepEquals, test.expectACL, qt.Commentf("%s", body.contentType))
+			}
+		})
+	}
+}
 
-	m, _ := managerWithACLs(c, "", nil, &checkedACL)
+var maxBodyBytesTests = []struct {
+	testName     string
+	maxBodyBytes int64
+	numUsers     int
+	expectStatus int
+}{{
+	testName:     "default_limit_small_body",
+	numUsers:     10,
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "default_limit_oversized_body",
+	numUsers:     100000,
+	expectStatus: http.StatusRequestEntityTooLarge,
+}, {
+	testName:     "configured_limit_oversized_body",
+	maxBodyBytes: 50,
+	numUsers:     10,
+	expectStatus: http.StatusRequestEntityTooLarge,
+}, {
+	testName:     "no_limit",
+	maxBodyBytes: -1,
+	numUsers:     100000,
+	expectStatus: http.StatusOK,
+}}
 
-	err := m.CreateACL(ctx, "_foo", "x", "y")
-	c.Assert(err, qt.ErrorMatches, `invalid ACL name "_foo"`)
+func TestMaxBodyBytes(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range maxBodyBytesTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+			})
+			c.Assert(err, qt.Equals, nil)
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return allowed{}, nil
+				},
+				MaxBodyBytes: test.maxBodyBytes,
+			}))
+			defer srv.Close()
+			users := make([]string, test.numUsers)
+			for i := range users {
+				users[i] = fmt.Sprintf("user%d", i)
+			}
+			var expectResponse interface{}
+			if test.expectStatus != http.StatusOK {
+				expectResponse = &httprequest.RemoteError{
+					Message: "request body too large",
+					Code:    aclstore.CodeRequestTooLarge,
+				}
+			}
+			assertJSONCall(c, "PUT", srv.URL+"/admin", params.SetACLRequestBody{
+				Users: users,
+			}, test.expectStatus, expectResponse)
+			acl, err := m.ACL(ctx, aclstore.AdminACL)
+			c.Assert(err, qt.Equals, nil)
+			if test.expectStatus == http.StatusOK {
+				c.Assert(acl, qt.HasLen, test.numUsers)
+			} else {
+				c.Assert(acl, qt.HasLen, 0)
+			}
+		})
+	}
 }
 
-func TestGetACLs(t *testing.T) {
+func TestStrictRemoveEndpoint(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
-
 	m, err := aclstore.NewManager(ctx, aclstore.Params{
-		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
-		InitialAdminUsers: []string{"test-admin"},
+		Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:           memsimplekv.NewStore(),
+			StrictRemove: true,
+		}),
+		InitialAdminUsers: []string{"alice", "bob"},
 	})
 	c.Assert(err, qt.Equals, nil)
-	h := m.NewHandler(aclstore.HandlerParams{
-		RootPath: "/rootpath",
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
 		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
 			return allowed{}, nil
 		},
+	}))
+	defer srv.Close()
+
+	assertJSONCall(c, "POST", srv.URL+"/admin", params.ModifyACLRequestBody{
+		Remove: []string{"bob", "charlie"},
+	}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `user "charlie" not found`,
+		Code:    aclstore.CodeUserNotFound,
 	})
+	acl, err := m.ACL(ctx, aclstore.AdminACL)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice", "bob"})
+}
+
+func TestSetACLIfMatch(t *testing.T) {
+	c := qt.New(t)
+	var checkedACL []string
+	m, h := managerWithACLs(c, "", map[string][]string{
+		"admin":    {"alice"},
+		"someacl":  {"bob", "charlie"},
+		"_someacl": {},
+	}, &checkedACL)
 	srv := httptest.NewServer(h)
 	defer srv.Close()
 
-	client := aclclient.New(aclclient.NewParams{
-		BaseURL: srv.URL + "/rootpath",
-		Doer:    srv.Client(),
+	getETag := func() string {
+		resp, err := http.Get(srv.URL + "/someacl")
+		c.Assert(err, qt.Equals, nil)
+		resp.Body.Close()
+		c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+		return resp.Header.Get("ETag")
+	}
+	setIfMatch := func(etag string, users ...string) *http.Response {
+		data, err := json.Marshal(params.SetACLRequestBody{Users: users})
+		c.Assert(err, qt.Equals, nil)
+		req, err := http.NewRequest("PUT", srv.URL+"/someacl", bytes.NewReader(data))
+		c.Assert(err, qt.Equals, nil)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", etag)
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, qt.Equals, nil)
+		resp.Body.Close()
+		return resp
+	}
+	etag := getETag()
+	c.Assert(etag, qt.Matches, `"[0-9a-f]+"`)
+
+	// Setting the same members in a different order
+	// does not change the version.
+	resp := setIfMatch(etag, "charlie", "bob")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(getETag(), qt.Equals, etag)
+
+	resp = setIfMatch(etag, "daisy")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	newETag := getETag()
+	c.Assert(newETag, qt.Not(qt.Equals), etag)
+
+	resp = setIfMatch(etag, "edward")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusPreconditionFailed)
+	acl, err := m.ACL(context.Background(), "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"daisy"})
+
+	resp = setIfMatch("*", "edward")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	acl, err = m.ACL(context.Background(), "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"edward"})
+}
+
+var managersTests = []struct {
+	testName       string
+	path           string
+	expectCheckACL []string
+	expectStatus   int
+	expectResponse interface{}
+}{{
+	testName:       "normal_ACL",
+	path:           "/someacl/managers",
+	expectCheckACL: []string{"bob", "claire", "alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.GetManagersResponse{
+		Users: []string{"alice", "bob", "claire"},
+	},
+}, {
+	testName:       "admin_ACL",
+	path:           "/admin/managers",
+	expectCheckACL: []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.GetManagersResponse{
+		Users: []string{"alice", "bob"},
+	},
+}, {
+	testName:       "meta_ACL",
+	path:           "/_someacl/managers",
+	expectCheckACL: []string{"alice", "bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.GetManagersResponse{
+		Users: []string{"alice", "bob"},
+	},
+}, {
+	testName:     "nonexistent_ACL",
+	path:         "/nonexistent/managers",
+	expectStatus: http.StatusNotFound,
+	expectResponse: httprequest.RemoteError{
+		Message: "ACL not found",
+		Code:    aclstore.CodeACLNotFound,
+	},
+}}
+
+var responseVersionTests = []struct {
+	testName     string
+	path         string
+	query        string
+	expectStatus int
+	expectBody   string
+}{{
+	testName:     "default",
+	expectStatus: http.StatusOK,
+	expectBody:   `{"users":["bob","charlie"]}`,
+}, {
+	testName:     "v1",
+	query:        "?v=1",
+	expectStatus: http.StatusOK,
+	expectBody:   `{"users":["bob","charlie"]}`,
+}, {
+	testName:     "v2",
+	query:        "?v=2",
+	expectStatus: http.StatusOK,
+	expectBody:   `{"users":["bob","charlie"],"version":2,"etag":"\"%s\"","count":2}`,
+}, {
+	testName:     "v2_empty_ACL",
+	path:         "/emptyacl",
+	query:        "?v=2",
+	expectStatus: http.StatusOK,
+	expectBody:   `{"users":null,"version":2,"etag":"\"%s\"","count":0}`,
+}, {
+	testName:     "unsupported_version",
+	query:        "?v=3",
+	expectStatus: http.StatusBadRequest,
+	expectBody:   `{"Message":"unsupported response version 3","Code":"bad request"}`,
+}}
+
+func TestResponseVersion(t *testing.T) {
+	c := qt.New(t)
+	var checkedACL []string
+	_, h := managerWithACLs(c, "", map[string][]string{
+		"admin":     {"alice"},
+		"someacl":   {"bob", "charlie"},
+		"_someacl":  {},
+		"emptyacl":  {},
+		"_emptyacl": {},
+	}, &checkedACL)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for _, test := range responseVersionTests {
+		c.Run(test.testName, func(c *qt.C) {
+			path := test.path
+			if path == "" {
+				path = "/someacl"
+			}
+			resp, err := http.Get(srv.URL + path + test.query)
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus)
+			data, err := ioutil.ReadAll(resp.Body)
+			c.Assert(err, qt.Equals, nil)
+			expectBody := test.expectBody
+			if etag := resp.Header.Get("ETag"); etag != "" {
+				expectBody = strings.Replace(expectBody, "%s", strings.Trim(etag, `"`), 1)
+			}
+			c.Assert(strings.TrimSpace(string(data)), qt.Equals, expectBody)
+		})
+	}
+}
+
+func TestManagers(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range managersTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var checkedACL []string
+			m, h := managerWithACLs(c, "", map[string][]string{
+				"admin":    {"alice", "bob"},
+				"someacl":  {"charlie", "daisy"},
+				"_someacl": {"bob", "claire"},
+				"other":    {"edward"},
+				"_other":   {"fred"},
+			}, &checkedACL)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			assertJSONCall(c, "GET", srv.URL+test.path, nil, test.expectStatus, test.expectResponse)
+			c.Assert(checkedACL, qt.DeepEquals, test.expectCheckACL)
+			if test.expectStatus == http.StatusOK {
+				name := strings.TrimSuffix(strings.TrimPrefix(test.path, "/"), "/managers")
+				users, err := m.Managers(context.Background(), name)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(users, qt.DeepEquals, test.expectResponse.(params.GetManagersResponse).Users)
+			}
+		})
+	}
+}
+
+func TestManagersWithDenyEntries(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"alice", "bob"},
+		DenyPrefix:        "-",
 	})
-	err = m.CreateACL(ctx, "test1", "user1")
 	c.Assert(err, qt.Equals, nil)
-	err = m.CreateACL(ctx, "test2", "user1")
+	err = m.CreateACL(ctx, "someacl")
 	c.Assert(err, qt.Equals, nil)
-	err = m.CreateACL(ctx, "test3", "user1")
+	err = store.Set(ctx, "_someacl", []string{"claire", "daisy", "-bob"})
 	c.Assert(err, qt.Equals, nil)
-
-	acls, err := client.GetACLs(ctx, &params.GetACLsRequest{})
+	users, err := m.Managers(ctx, "someacl")
 	c.Assert(err, qt.Equals, nil)
-	sort.Strings(acls.ACLs)
-	c.Assert(acls.ACLs, qt.DeepEquals, []string{"_test1", "_test2", "_test3", "admin", "test1", "test2", "test3"})
+	c.Assert(users, qt.DeepEquals, []string{"alice", "claire", "daisy"})
 }
 
-type allowed struct{}
+func TestNotFoundHandler(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	var notFoundPath string
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		RootPath: "/root",
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		NotFoundHandler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			notFoundPath = req.URL.Path
+			http.Error(w, "nothing here", http.StatusNotFound)
+		}),
+	}))
+	defer srv.Close()
 
-func (allowed) Allow(context.Context, []string) (bool, error) {
-	return true, nil
+	resp, err := http.Get(srv.URL + "/blah/foo")
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotFound)
+	body, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(body), qt.Equals, "nothing here\n")
+	c.Assert(notFoundPath, qt.Equals, "/blah/foo")
+
+	// Matched routes are not affected.
+	assertJSONCall(c, "GET", srv.URL+"/root/admin", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice"},
+	})
 }
 
-// managerWithACLs returns a Manager instance running an ACL manager
-// primed with the given ACLs. When an ACL is checked, *checkedACL is set
-// to the ACL that's checked.
-func managerWithACLs(c *qt.C, rootPath string, acls map[string][]string, checkedACL *[]string) (*aclstore.Manager, http.Handler) {
+func TestWithAuthenticate(t *testing.T) {
 	ctx := context.Background()
-	store := aclstore.NewACLStore(memsimplekv.NewStore())
-	for aclName, users := range acls {
-		err := store.CreateACL(ctx, aclName, users)
-		c.Assert(err, qt.Equals, nil)
-	}
+	c := qt.New(t)
 	m, err := aclstore.NewManager(ctx, aclstore.Params{
-		Store: store,
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"bob"},
 	})
+	c.Assert(err, qt.Equals, nil)
 	h := m.NewHandler(aclstore.HandlerParams{
-		RootPath: rootPath,
+		RootPath: "/rootpath",
 		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			req.ParseForm()
+			user := req.Form.Get("auth")
+			if user == "" {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTeapot)
+				fmt.Fprintf(w, `"go away: %s"`, req.URL.Path)
+				return nil, errgo.Newf("no auth header found")
+			}
 			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
-				*checkedACL = acl
-				return true, nil
+				for _, a := range acl {
+					if a == user {
+						return true, nil
+					}
+				}
+				return false, nil
 			}), nil
 		},
 	})
-	c.Assert(err, qt.Equals, nil)
-	return m, h
-}
-
-type identityFunc func(ctx context.Context, acl []string) (bool, error)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
 
-func (f identityFunc) Allow(ctx context.Context, acl []string) (bool, error) {
-	return f(ctx, acl)
+	assertJSONCall(c, "GET", srv.URL+"/rootpath/admin", nil, http.StatusTeapot, "go away: /rootpath/admin")
+	assertJSONCall(c, "GET", srv.URL+"/rootpath/admin?auth=bob", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"bob"},
+	})
 }
 
-// assertJSONCall asserts that when the given handler is called with
-// the given parameters, the result is as specified.
-func assertJSONCall(c *qt.C, method, url string, body interface{}, expectStatus int, expectResponse interface{}) {
-	var bodyr io.Reader
-	if body != nil {
-		bodyData, err := json.Marshal(body)
-		c.Assert(err, qt.Equals, nil)
-		bodyr = bytes.NewReader(bodyData)
-	}
-	req, err := http.NewRequest(method, url, bodyr)
-	c.Assert(err, qt.Equals, nil)
-	if body != nil {
-		req.Header.Set("Content-Type", "application/json")
-	}
-	resp, err := http.DefaultClient.Do(req)
+func TestWithAuthenticateUnauthorized(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"bob"},
+	})
 	c.Assert(err, qt.Equals, nil)
-	defer resp.Body.Close()
+	h := m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			user, _, ok := req.BasicAuth()
+			if !ok {
+				return nil, errgo.WithCausef(nil, aclstore.ErrUnauthorized, "no credentials provided")
+			}
+			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+				for _, a := range acl {
+					if a == user {
+						return true, nil
+					}
+				}
+				return false, nil
+			}), nil
+		},
+		WWWAuthenticate: `Basic realm="acls"`,
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/admin")
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusUnauthorized)
+	c.Assert(resp.Header.Get("WWW-Authenticate"), qt.Equals, `Basic realm="acls"`)
+	assertJSONCall(c, "GET", srv.URL+"/admin", nil, http.StatusUnauthorized, &httprequest.RemoteError{
+		Message: "no credentials provided",
+		Code:    httprequest.CodeUnauthorized,
+	})
+
+	req, err := http.NewRequest("GET", srv.URL+"/admin", nil)
+	c.Assert(err, qt.Equals, nil)
+	req.SetBasicAuth("bob", "")
+	resp, err = http.DefaultClient.Do(req)
+	c.Assert(err, qt.Equals, nil)
+	resp.Body.Close()
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("WWW-Authenticate"), qt.Equals, "")
+}
+
+func TestForbidden(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"bob"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	h := m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+				return false, nil
+			}), nil
+		},
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	assertJSONCall(c, "GET", srv.URL+"/admin", nil, http.StatusForbidden, &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+}
+
+func TestManagerCreateACL(t *testing.T) {
+	c := qt.New(t)
+	var checkedACL []string
+
+	ctx := context.Background()
+
+	m, _ := managerWithACLs(c, "", nil, &checkedACL)
+
+	err := m.CreateACL(ctx, "foo", "x", "y")
+	c.Assert(err, qt.Equals, nil)
+
+	acl, err := m.ACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"x", "y"})
+
+	err = m.CreateACL(ctx, "foo", "z", "w")
+	c.Assert(err, qt.Equals, nil)
+
+	acl, err = m.ACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"x", "y"})
+
+	// Check that the meta ACL is created too.
+	acl, err = m.ACL(ctx, "_foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string(nil))
+}
+
+func TestManagerCreateACLWithCancelledContext(t *testing.T) {
+	c := qt.New(t)
+	var checkedACL []string
+	m, _ := managerWithACLs(c, "", nil, &checkedACL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := m.CreateACL(ctx, "foo", "x", "y")
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+
+	err = m.ReplaceAdmins(ctx, []string{"x"}, true)
+	c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+
+	names, err := m.ACLNames(context.Background(), true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.DeepEquals, []string{"admin"})
+	acl, err := m.ACL(context.Background(), aclstore.AdminACL)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.HasLen, 0)
+}
+
+func TestManagerCreateACLWithMaxACLs(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		MaxACLs:           3,
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	// The admin ACL counts towards the limit but meta-ACLs do not.
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "baz")
+	c.Assert(err, qt.ErrorMatches, `limit of 3 ACLs reached`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrTooManyACLs)
+	_, err = m.ACL(ctx, "baz")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+	// Creating an existing ACL still succeeds.
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+
+	// Deleting an ACL makes room for another.
+	err = m.DeleteACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "baz")
+	c.Assert(err, qt.Equals, nil)
+
+	status, body := aclstore.ErrorMapper(ctx, errgo.WithCausef(nil, aclstore.ErrTooManyACLs, "limit of 3 ACLs reached"))
+	c.Assert(status, qt.Equals, http.StatusForbidden)
+	c.Assert(body, qt.DeepEquals, &httprequest.RemoteError{
+		Code:    aclstore.CodeTooManyACLs,
+		Message: "limit of 3 ACLs reached",
+	})
+}
+
+func TestManagerCreateACLWithInvalidACLName(t *testing.T) {
+	c := qt.New(t)
+	var checkedACL []string
+
+	ctx := context.Background()
+
+	m, _ := managerWithACLs(c, "", nil, &checkedACL)
+
+	err := m.CreateACL(ctx, "_foo", "x", "y")
+	c.Assert(err, qt.ErrorMatches, `invalid ACL name "_foo"`)
+}
+
+var validateACLNameTests = []struct {
+	testName    string
+	name        string
+	expectError string
+}{{
+	testName: "valid",
+	name:     "some-acl",
+}, {
+	testName:    "empty",
+	name:        "",
+	expectError: `empty ACL name`,
+}, {
+	testName:    "underscore_prefix",
+	name:        "_foo",
+	expectError: `invalid ACL name "_foo"`,
+}, {
+	testName:    "bad_characters",
+	name:        "Some ACL",
+	expectError: `invalid ACL name "Some ACL": does not match "\^\[a-z-\]\+\$"`,
+}}
+
+func TestValidateACLName(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:          aclstore.NewACLStore(memsimplekv.NewStore()),
+		ACLNamePattern: regexp.MustCompile(`^[a-z-]+$`),
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, test := range validateACLNameTests {
+		c.Run(test.testName, func(c *qt.C) {
+			err := m.ValidateACLName(test.name)
+			if test.expectError == "" {
+				c.Assert(err, qt.Equals, nil)
+				return
+			}
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadACLName)
+			err = m.CreateACL(ctx, test.name)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+		})
+	}
+}
+
+var pathUnsafeACLNameTests = []struct {
+	testName string
+	name     string
+	unsafe   bool
+}{{
+	testName: "safe_punctuation",
+	name:     "team:foo@example.com+x",
+}, {
+	testName: "non_ascii",
+	name:     "équipe",
+}, {
+	testName: "slash",
+	name:     "team/foo",
+	unsafe:   true,
+}, {
+	testName: "space",
+	name:     "team foo",
+	unsafe:   true,
+}, {
+	testName: "tab",
+	name:     "team\tfoo",
+	unsafe:   true,
+}, {
+	testName: "percent",
+	name:     "team%2Ffoo",
+	unsafe:   true,
+}, {
+	testName: "question_mark",
+	name:     "team?foo",
+	unsafe:   true,
+}, {
+	testName: "hash",
+	name:     "team#foo",
+	unsafe:   true,
+}, {
+	testName: "backslash",
+	name:     `team\foo`,
+	unsafe:   true,
+}, {
+	testName: "dot_dot",
+	name:     "..",
+	unsafe:   true,
+}}
+
+func TestValidateACLNamePathUnsafe(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range pathUnsafeACLNameTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.ValidateACLName(test.name)
+			if !test.unsafe {
+				c.Assert(err, qt.Equals, nil)
+				err = m.CreateACL(ctx, test.name)
+				c.Assert(err, qt.Equals, nil)
+				return
+			}
+			expectError := fmt.Sprintf(`invalid ACL name %q: not safe to use in a URL path`, test.name)
+			c.Assert(err, qt.ErrorMatches, regexp.QuoteMeta(expectError))
+			c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadACLName)
+			err = m.CreateACL(ctx, test.name)
+			c.Assert(err, qt.ErrorMatches, regexp.QuoteMeta(expectError))
+			_, err = m.ACL(ctx, test.name)
+			c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+			// The check can be disabled.
+			m, err = aclstore.NewManager(ctx, aclstore.Params{
+				Store:                   aclstore.NewACLStore(memsimplekv.NewStore()),
+				AllowPathUnsafeACLNames: true,
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, test.name, "alice")
+			c.Assert(err, qt.Equals, nil)
+			acl, err := m.ACL(ctx, test.name)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, []string{"alice"})
+		})
+	}
+}
+
+func TestPathUnsafeSystemACLName(t *testing.T) {
+	c := qt.New(t)
+	_, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store:        aclstore.NewACLStore(memsimplekv.NewStore()),
+		AdminACLName: "the admins",
+	})
+	c.Assert(err, qt.ErrorMatches, `system ACL name "the admins" is not safe to use in a URL path`)
+}
+
+func TestReservedACLNames(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"authorize", "stats", "whoami"} {
+		err := m.CreateACL(ctx, name)
+		c.Assert(err, qt.ErrorMatches, fmt.Sprintf(`invalid ACL name %q: reserved for another endpoint`, name))
+		c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadACLName)
+	}
+	// Names that only start longer endpoint paths don't conflict.
+	err = m.CreateACL(ctx, "users")
+	c.Assert(err, qt.Equals, nil)
+
+	_, err = aclstore.NewManager(ctx, aclstore.Params{
+		Store:        aclstore.NewACLStore(memsimplekv.NewStore()),
+		AdminACLName: "stats",
+	})
+	c.Assert(err, qt.ErrorMatches, `system ACL name "stats" is reserved for another endpoint`)
+}
+
+func TestHandlerValidatesACLName(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:          aclstore.NewACLStore(memsimplekv.NewStore()),
+		ACLNamePattern: regexp.MustCompile(`^[a-z]+$`),
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCall(c, "GET", srv.URL+"/_foo", nil, http.StatusOK, params.GetACLResponse{})
+	assertJSONCall(c, "GET", srv.URL+"/foo1", nil, http.StatusBadRequest, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `invalid ACL name "foo1": does not match "^[a-z]+$"`,
+	})
+	assertJSONCall(c, "GET", srv.URL+"/__foo", nil, http.StatusBadRequest, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `invalid ACL name "__foo"`,
+	})
+}
+
+var allowAnyTests = []struct {
+	testName      string
+	user          string
+	aclNames      []string
+	expectAllowed bool
+	expectChecked [][]string
+	expectError   string
+}{{
+	testName:      "allowed_by_second_ACL",
+	user:          "charlie",
+	aclNames:      []string{"acl1", "acl2", "acl3"},
+	expectAllowed: true,
+	expectChecked: [][]string{
+		{"alice", "boss"},
+		{"charlie", "boss"},
+	},
+}, {
+	testName:      "not_allowed",
+	user:          "zach",
+	aclNames:      []string{"acl1", "acl2", "acl3"},
+	expectAllowed: false,
+	expectChecked: [][]string{
+		{"alice", "boss"},
+		{"charlie", "boss"},
+		{"daisy", "boss"},
+	},
+}, {
+	testName:      "admin_allowed_by_first_ACL",
+	user:          "boss",
+	aclNames:      []string{"acl1", "acl2", "acl3"},
+	expectAllowed: true,
+	expectChecked: [][]string{
+		{"alice", "boss"},
+	},
+}, {
+	testName:      "admin_ACL",
+	user:          "boss",
+	aclNames:      []string{"admin"},
+	expectAllowed: true,
+	expectChecked: [][]string{
+		{"boss"},
+	},
+}, {
+	testName:      "no_ACLs",
+	user:          "boss",
+	expectAllowed: false,
+}, {
+	testName:    "nonexistent_ACL",
+	user:        "daisy",
+	aclNames:    []string{"acl1", "nonexistent", "acl3"},
+	expectError: `ACL not found`,
+	expectChecked: [][]string{
+		{"alice", "boss"},
+	},
+}}
+
+func TestAllowAny(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range allowAnyTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var checkedACL []string
+			m, _ := managerWithACLs(c, "", map[string][]string{
+				"admin": {"boss"},
+				"acl1":  {"alice"},
+				"acl2":  {"charlie"},
+				"acl3":  {"daisy"},
+			}, &checkedACL)
+			var checked [][]string
+			identity := identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+				checked = append(checked, acl)
+				for _, a := range acl {
+					if a == test.user {
+						return true, nil
+					}
+				}
+				return false, nil
+			})
+			ok, err := m.AllowAny(ctx, identity, test.aclNames)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+			} else {
+				c.Assert(err, qt.Equals, nil)
+			}
+			c.Assert(ok, qt.Equals, test.expectAllowed)
+			c.Assert(checked, qt.DeepEquals, test.expectChecked)
+		})
+	}
+}
+
+var checkTests = []struct {
+	testName      string
+	aclName       string
+	user          string
+	expectAllowed bool
+	expectError   string
+}{{
+	testName:      "member",
+	aclName:       "acl1",
+	user:          "alice",
+	expectAllowed: true,
+}, {
+	testName:      "meta_acl_member",
+	aclName:       "acl1",
+	user:          "claire",
+	expectAllowed: true,
+}, {
+	testName:      "admin",
+	aclName:       "acl1",
+	user:          "boss",
+	expectAllowed: true,
+}, {
+	testName:      "non_member",
+	aclName:       "acl1",
+	user:          "charlie",
+	expectAllowed: false,
+}, {
+	testName:      "admin_acl_member",
+	aclName:       "admin",
+	user:          "boss",
+	expectAllowed: true,
+}, {
+	testName:      "admin_acl_non_member",
+	aclName:       "admin",
+	user:          "alice",
+	expectAllowed: false,
+}, {
+	testName:    "acl_not_found",
+	aclName:     "nothere",
+	user:        "boss",
+	expectError: "ACL not found",
+}}
+
+func TestCheck(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, _ := managerWithACLs(c, "", map[string][]string{
+		"admin": {"boss"},
+		"acl1":  {"alice"},
+		"_acl1": {"claire"},
+		"acl2":  {"charlie"},
+	}, nil)
+	for _, test := range checkTests {
+		c.Run(test.testName, func(c *qt.C) {
+			ok, err := m.Check(ctx, &namedIdentity{test.user}, test.aclName)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+			} else {
+				c.Assert(err, qt.Equals, nil)
+			}
+			c.Assert(ok, qt.Equals, test.expectAllowed)
+		})
+	}
+}
+
+var denyTests = []struct {
+	testName      string
+	denyPrefix    string
+	user          string
+	expectAllowed bool
+}{{
+	testName:      "allowed_and_denied",
+	denyPrefix:    "-",
+	user:          "alice",
+	expectAllowed: false,
+}, {
+	testName:      "admin_denied",
+	denyPrefix:    "-",
+	user:          "boss",
+	expectAllowed: false,
+}, {
+	testName:      "allowed",
+	denyPrefix:    "-",
+	user:          "bob",
+	expectAllowed: true,
+}, {
+	testName:      "deny_disabled",
+	user:          "alice",
+	expectAllowed: true,
+}}
+
+func TestDenyEntries(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range denyTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStore(memsimplekv.NewStore())
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             store,
+				InitialAdminUsers: []string{"boss"},
+				DenyPrefix:        test.denyPrefix,
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl", "alice", "bob", "-alice", "-boss")
+			c.Assert(err, qt.Equals, nil)
+			err = store.Set(ctx, "_someacl", []string{"alice", "bob", "-alice", "-boss"})
+			c.Assert(err, qt.Equals, nil)
+
+			// Check that deny entries round-trip through the store.
+			acl, err := m.ACL(ctx, "someacl")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, []string{"-alice", "-boss", "alice", "bob"})
+
+			identity := identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+				for _, a := range acl {
+					if a == test.user {
+						return true, nil
+					}
+				}
+				return false, nil
+			})
+			ok, err := m.AllowAny(ctx, identity, []string{"someacl"})
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, test.expectAllowed)
+
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return identity, nil
+				},
+			}))
+			defer srv.Close()
+			if test.expectAllowed {
+				assertJSONCall(c, "GET", srv.URL+"/someacl", nil, http.StatusOK, params.GetACLResponse{
+					Users: []string{"-alice", "-boss", "alice", "bob"},
+				})
+			} else {
+				assertJSONCall(c, "GET", srv.URL+"/someacl", nil, http.StatusForbidden, &httprequest.RemoteError{
+					Code:    httprequest.CodeForbidden,
+					Message: httprequest.CodeForbidden,
+				})
+			}
+		})
+	}
+}
+
+var effectiveACLsTests = []struct {
+	testName   string
+	user       string
+	expectACLs []string
+}{{
+	testName:   "plain_member",
+	user:       "bob",
+	expectACLs: []string{"_bar", "foo"},
+}, {
+	testName:   "admin",
+	user:       "alice",
+	expectACLs: []string{aclstore.AllACLs, "admin", "bar"},
+}, {
+	testName:   "member_of_nothing",
+	user:       "charlie",
+	expectACLs: []string{},
+}}
+
+func TestEffectiveACLs(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	var checkedACL []string
+	m, h := managerWithACLs(c, "", map[string][]string{
+		"admin": {"alice"},
+		"foo":   {"bob", "daisy"},
+		"_foo":  {"daisy"},
+		"bar":   {"alice", "daisy"},
+		"_bar":  {"bob"},
+	}, &checkedACL)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	for _, test := range effectiveACLsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			acls, err := m.EffectiveACLs(ctx, test.user)
+			c.Assert(err, qt.Equals, nil)
+			if len(test.expectACLs) == 0 {
+				c.Assert(acls, qt.HasLen, 0)
+			} else {
+				c.Assert(acls, qt.DeepEquals, test.expectACLs)
+			}
+			assertJSONCall(c, "GET", srv.URL+"/users/"+test.user+"/acls", nil, http.StatusOK, params.GetEffectiveACLsResponse{
+				ACLs: test.expectACLs,
+			})
+			c.Assert(checkedACL, qt.DeepEquals, []string{"alice"})
+		})
+	}
+}
+
+func TestACLsForUserWithDenyEntries(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"alice", "-alice"},
+		DenyPrefix:        "-",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "alice")
+	c.Assert(err, qt.Equals, nil)
+	acls, err := m.EffectiveACLs(ctx, "alice")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"foo"})
+}
+
+func TestCaseInsensitiveAllow(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:              memsimplekv.NewStore(),
+			CaseInsensitive: true,
+		}),
+		InitialAdminUsers: []string{"Boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "Alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	acl, err := m.ACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"Alice", "bob"})
+
+	for _, user := range []string{"Alice", "alice", "ALICE", "Bob", "boss"} {
+		// The identity compares the folded form of its user.
+		identity := identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+			for _, a := range acl {
+				if a == aclstore.FoldUser(user) {
+					return true, nil
+				}
+			}
+			return false, nil
+		})
+		ok, err := m.AllowAny(ctx, identity, []string{"foo"})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, true, qt.Commentf("user %q", user))
+	}
+	acls, err := m.ACLsForUser(ctx, "ALICE")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"foo"})
+}
+
+func TestStats(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	var checkedACL []string
+	m, h := managerWithACLs(c, "", map[string][]string{
+		"admin": {"alice"},
+		"foo":   {"alice", "bob", "charlie"},
+		"_foo":  {"daisy", "edward"},
+		"bar":   {"bob", "frank"},
+		"_bar":  {},
+		"baz":   {},
+		"_baz":  {},
+	}, &checkedACL)
+	stats, err := m.Stats(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(stats, qt.DeepEquals, aclstore.Stats{
+		ACLs:        4,
+		Users:       4,
+		AverageSize: 1.5,
+	})
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	assertJSONCall(c, "GET", srv.URL+"/stats", nil, http.StatusOK, params.GetStatsResponse{
+		ACLs:        4,
+		Users:       4,
+		AverageSize: 1.5,
+	})
+	c.Assert(checkedACL, qt.DeepEquals, []string{"alice"})
+}
+
+func TestStatsWithOnlyEmptyAdminACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	var checkedACL []string
+	m, _ := managerWithACLs(c, "", nil, &checkedACL)
+	stats, err := m.Stats(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(stats, qt.DeepEquals, aclstore.Stats{
+		ACLs: 1,
+	})
+}
+
+var checkerACLTests = []struct {
+	testName       string
+	user           string
+	method         string
+	path           string
+	expectStatus   int
+	expectResponse interface{}
+}{{
+	testName:     "checker_can_check_membership",
+	user:         "gateway",
+	method:       "GET",
+	path:         "/someacl/members/alice",
+	expectStatus: http.StatusOK,
+	expectResponse: params.IsMemberResponse{
+		Member: true,
+	},
+}, {
+	testName:     "checker_can_check_non_membership",
+	user:         "gateway",
+	method:       "GET",
+	path:         "/someacl/members/edward",
+	expectStatus: http.StatusOK,
+	expectResponse: params.IsMemberResponse{
+		Member: false,
+	},
+}, {
+	testName:     "checker_cannot_read_members",
+	user:         "gateway",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "checker_cannot_change_members",
+	user:         "gateway",
+	method:       "PUT",
+	path:         "/someacl",
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "checker_can_check_meta_ACL",
+	user:         "gateway",
+	method:       "GET",
+	path:         "/_someacl/members/bob",
+	expectStatus: http.StatusOK,
+	expectResponse: params.IsMemberResponse{
+		Member: true,
+	},
+}, {
+	testName:     "manager_can_check_membership",
+	user:         "bob",
+	method:       "GET",
+	path:         "/someacl/members/alice",
+	expectStatus: http.StatusOK,
+	expectResponse: params.IsMemberResponse{
+		Member: true,
+	},
+}, {
+	testName:     "manager_can_read_members",
+	user:         "bob",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLResponse{
+		Users: []string{"alice", "charlie"},
+	},
+}, {
+	testName:     "other_user_cannot_check_membership",
+	user:         "alice",
+	method:       "GET",
+	path:         "/someacl/members/alice",
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "checker_ACL_is_guarded_by_admin",
+	user:         "gateway",
+	method:       "GET",
+	path:         "/checkers",
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}}
+
+func TestCheckerACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		CheckerACL:        "checkers",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			user := req.Header.Get("User")
+			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+				for _, a := range acl {
+					if a == user {
+						return true, nil
+					}
+				}
+				return false, nil
+			}), nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/checkers", params.SetACLRequestBody{
+		Users: []string{"gateway"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/_someacl", params.SetACLRequestBody{
+		Users: []string{"bob"},
+	}, http.StatusOK, nil)
+	for _, test := range checkerACLTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var body interface{}
+			if test.method == "PUT" {
+				body = params.SetACLRequestBody{
+					Users: []string{"edward"},
+				}
+			}
+			assertJSONCallAs(c, test.user, test.method, srv.URL+test.path, body, test.expectStatus, test.expectResponse)
+		})
+	}
+}
+
+var readOnlyAdminACLTests = []struct {
+	testName       string
+	user           string
+	method         string
+	path           string
+	body           interface{}
+	expectStatus   int
+	expectResponse interface{}
+}{{
+	testName:     "auditor_can_read_members",
+	user:         "auditor",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLResponse{
+		Users: []string{"alice", "charlie"},
+	},
+}, {
+	testName:     "auditor_can_check_membership",
+	user:         "auditor",
+	method:       "GET",
+	path:         "/someacl/members/alice",
+	expectStatus: http.StatusOK,
+	expectResponse: params.IsMemberResponse{
+		Member: true,
+	},
+}, {
+	testName:     "auditor_can_read_meta_ACL",
+	user:         "auditor",
+	method:       "GET",
+	path:         "/_someacl",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLResponse{
+		Users: []string{"bob"},
+	},
+}, {
+	testName:     "auditor_can_read_admin_ACL",
+	user:         "auditor",
+	method:       "GET",
+	path:         "/admin",
+	expectStatus: http.StatusOK,
+	expectResponse: params.GetACLResponse{
+		Users: []string{"boss"},
+	},
+}, {
+	testName:     "auditor_cannot_set_members",
+	user:         "auditor",
+	method:       "PUT",
+	path:         "/someacl",
+	body:         params.SetACLRequestBody{Users: []string{"edward"}},
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "auditor_cannot_add_members",
+	user:         "auditor",
+	method:       "POST",
+	path:         "/someacl",
+	body:         params.ModifyACLRequestBody{Add: []string{"edward"}},
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "auditor_cannot_add_themselves_to_read_only_admin_ACL",
+	user:         "auditor",
+	method:       "POST",
+	path:         "/auditors",
+	body:         params.ModifyACLRequestBody{Add: []string{"edward"}},
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}, {
+	testName:     "admin_can_set_members",
+	user:         "boss",
+	method:       "PUT",
+	path:         "/someacl",
+	body:         params.SetACLRequestBody{Users: []string{"alice", "charlie"}},
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "other_user_cannot_read_members",
+	user:         "alice",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	},
+}}
+
+func TestReadOnlyAdminACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		ReadOnlyAdminACL:  "auditors",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/auditors", params.SetACLRequestBody{
+		Users: []string{"auditor"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/_someacl", params.SetACLRequestBody{
+		Users: []string{"bob"},
+	}, http.StatusOK, nil)
+	for _, test := range readOnlyAdminACLTests {
+		c.Run(test.testName, func(c *qt.C) {
+			assertJSONCallAs(c, test.user, test.method, srv.URL+test.path, test.body, test.expectStatus, test.expectResponse)
+		})
+	}
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice", "charlie"})
+}
+
+var operationTests = []struct {
+	testName      string
+	method        string
+	path          string
+	body          interface{}
+	expectACLName string
+	expectOp      aclstore.Operation
+}{{
+	testName:      "get_ACL",
+	method:        "GET",
+	path:          "/someacl",
+	expectACLName: "someacl",
+	expectOp:      aclstore.OperationRead,
+}, {
+	testName:      "set_ACL",
+	method:        "PUT",
+	path:          "/someacl",
+	body:          params.SetACLRequestBody{Users: []string{"bob"}},
+	expectACLName: "someacl",
+	expectOp:      aclstore.OperationModify,
+}, {
+	testName:      "modify_ACL",
+	method:        "POST",
+	path:          "/someacl",
+	body:          params.ModifyACLRequestBody{Add: []string{"bob"}},
+	expectACLName: "someacl",
+	expectOp:      aclstore.OperationModify,
+}, {
+	testName:      "list_ACLs",
+	method:        "GET",
+	path:          "/",
+	expectACLName: "admin",
+	expectOp:      aclstore.OperationList,
+}, {
+	testName:      "check_membership",
+	method:        "GET",
+	path:          "/someacl/members/alice",
+	expectACLName: "someacl",
+	expectOp:      aclstore.OperationCheck,
+}, {
+	testName:      "members_in",
+	method:        "POST",
+	path:          "/someacl/members",
+	body:          params.MembersInRequestBody{Candidates: []string{"alice"}},
+	expectACLName: "someacl",
+	expectOp:      aclstore.OperationCheck,
+}, {
+	testName:      "get_managers",
+	method:        "GET",
+	path:          "/someacl/managers",
+	expectACLName: "someacl",
+	expectOp:      aclstore.OperationRead,
+}, {
+	testName:      "replace_admins",
+	method:        "PUT",
+	path:          "/admin/replace",
+	body:          params.ReplaceAdminsRequestBody{Users: []string{"boss"}},
+	expectACLName: "admin",
+	expectOp:      aclstore.OperationModify,
+}}
+
+func TestAuthorizeOperation(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	var gotACLName string
+	var gotOp aclstore.Operation
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		Authorize: func(ctx context.Context, identity aclstore.Identity, aclName string, op aclstore.Operation) (bool, error) {
+			gotACLName, gotOp = aclName, op
+			return true, nil
+		},
+	}))
+	defer srv.Close()
+	for _, test := range operationTests {
+		c.Run(test.testName, func(c *qt.C) {
+			gotACLName, gotOp = "", ""
+			var bodyr io.Reader
+			if test.body != nil {
+				data, err := json.Marshal(test.body)
+				c.Assert(err, qt.Equals, nil)
+				bodyr = bytes.NewReader(data)
+			}
+			req, err := http.NewRequest(test.method, srv.URL+test.path, bodyr)
+			c.Assert(err, qt.Equals, nil)
+			req.Header.Set("Content-Type", "application/json")
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+			c.Assert(gotACLName, qt.Equals, test.expectACLName)
+			c.Assert(gotOp, qt.Equals, test.expectOp)
+		})
+	}
+}
+
+func TestCustomAuthorizerDenies(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		// Allow reads only, deferring to the default policy.
+		Authorize: func(ctx context.Context, identity aclstore.Identity, aclName string, op aclstore.Operation) (bool, error) {
+			if op != aclstore.OperationRead {
+				return false, nil
+			}
+			return m.Authorize(ctx, identity, aclName, op)
+		},
+	}))
+	defer srv.Close()
+	assertJSONCall(c, "GET", srv.URL+"/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice"},
+	})
+	assertJSONCall(c, "PUT", srv.URL+"/someacl", params.SetACLRequestBody{
+		Users: []string{"bob"},
+	}, http.StatusForbidden, &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+}
+
+var membersInTests = []struct {
+	testName    string
+	candidates  []string
+	expectUsers []string
+}{{
+	testName:    "overlapping",
+	candidates:  []string{"daisy", "alice", "edward", "charlie"},
+	expectUsers: []string{"alice", "charlie"},
+}, {
+	testName:    "disjoint",
+	candidates:  []string{"daisy", "edward"},
+	expectUsers: []string{},
+}, {
+	testName:    "all_members",
+	candidates:  []string{"charlie", "alice", "alice"},
+	expectUsers: []string{"charlie", "alice"},
+}, {
+	testName:    "denied",
+	candidates:  []string{"bob", "alice"},
+	expectUsers: []string{"alice"},
+}, {
+	testName:    "no_candidates",
+	expectUsers: []string{},
+}}
+
+func TestMembersIn(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		DenyPrefix:        "-",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice", "bob", "-bob", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+	}))
+	defer srv.Close()
+	for _, test := range membersInTests {
+		c.Run(test.testName, func(c *qt.C) {
+			users, err := m.MembersIn(ctx, "someacl", test.candidates)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, test.expectUsers)
+			assertJSONCall(c, "POST", srv.URL+"/someacl/members", params.MembersInRequestBody{
+				Candidates: test.candidates,
+			}, http.StatusOK, params.MembersInResponse{
+				Users: test.expectUsers,
+			})
+		})
+	}
+	_, err = m.MembersIn(ctx, "nonexistent", []string{"alice"})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+var searchMembersTests = []struct {
+	testName        string
+	query           string
+	expectUsers     []string
+	expectTruncated bool
+}{{
+	testName:    "several_matches",
+	query:       "ali",
+	expectUsers: []string{"Alicia", "MALICE", "alice"},
+}, {
+	testName:    "case_insensitive_query",
+	query:       "ALIC",
+	expectUsers: []string{"Alicia", "MALICE", "alice"},
+}, {
+	testName:    "no_matches",
+	query:       "zed",
+	expectUsers: []string{},
+}, {
+	testName:    "denied_entries_excluded",
+	query:       "bob",
+	expectUsers: []string{"bob"},
+}, {
+	testName:        "truncated",
+	query:           "a",
+	expectUsers:     []string{"Alicia", "MALICE", "alice", "charlie"},
+	expectTruncated: true,
+}}
+
+func TestSearchMembers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		DenyPrefix:        "-",
+		MaxSearchResults:  4,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice", "Alicia", "MALICE", "bob", "-bob", "charlie", "dave")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	for _, test := range searchMembersTests {
+		c.Run(test.testName, func(c *qt.C) {
+			users, err := m.SearchMembers(ctx, "someacl", test.query)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, test.expectUsers)
+			assertJSONCallAs(c, "boss", "GET", srv.URL+"/someacl/members?q="+url.QueryEscape(test.query), nil, http.StatusOK, params.SearchMembersResponse{
+				Users:     test.expectUsers,
+				Truncated: test.expectTruncated,
+			})
+		})
+	}
+	// Searching requires the same access as reading the ACL.
+	assertJSONCallAs(c, "alice", "GET", srv.URL+"/someacl/members?q=ali", nil, http.StatusForbidden, &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+	_, err = m.SearchMembers(ctx, "nonexistent", "alice")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestIdentityFromContext(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	_, ok := aclstore.IdentityFromContext(ctx)
+	c.Assert(ok, qt.Equals, false)
+
+	var audited []string
+	var auditIdentities []aclstore.Identity
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		Audit: func(ctx context.Context, change *params.ACLChange) {
+			identity, _ := aclstore.IdentityFromContext(ctx)
+			audited = append(audited, change.Name+" "+change.Operation)
+			auditIdentities = append(auditIdentities, identity)
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	// Changes made directly through the Manager have no identity.
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+
+	identity := &namedIdentity{name: "boss"}
+	var authorizeIdentity aclstore.Identity
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return identity, nil
+		},
+		Authorize: func(ctx context.Context, id aclstore.Identity, aclName string, op aclstore.Operation) (bool, error) {
+			authorizeIdentity, _ = aclstore.IdentityFromContext(ctx)
+			return m.Authorize(ctx, id, aclName, op)
+		},
+	}))
+	defer srv.Close()
+	assertJSONCall(c, "POST", srv.URL+"/someacl", params.ModifyACLRequestBody{
+		Add: []string{"bob"},
+	}, http.StatusOK, params.ModifyACLResponse{
+		Added: 1,
+	})
+	c.Assert(authorizeIdentity, qt.Equals, aclstore.Identity(identity))
+	c.Assert(audited, qt.DeepEquals, []string{"someacl create", "someacl add"})
+	c.Assert(auditIdentities, qt.HasLen, 2)
+	c.Assert(auditIdentities[0], qt.IsNil)
+	c.Assert(auditIdentities[1], qt.Equals, aclstore.Identity(identity))
+}
+
+var whoAmITests = []struct {
+	testName     string
+	user         string
+	expectResult params.WhoAmIResponse
+}{{
+	testName: "admin",
+	user:     "boss",
+	expectResult: params.WhoAmIResponse{
+		Read:   []string{"_otheracl", "_someacl", "admin", "otheracl", "someacl"},
+		Modify: []string{"_otheracl", "_someacl", "admin", "otheracl", "someacl"},
+	},
+}, {
+	testName: "meta_ACL_member",
+	user:     "claire",
+	expectResult: params.WhoAmIResponse{
+		Read:   []string{"someacl"},
+		Modify: []string{"someacl"},
+	},
+}, {
+	testName: "plain_user",
+	user:     "alice",
+	expectResult: params.WhoAmIResponse{
+		Read:   []string{},
+		Modify: []string{},
+	},
+}}
+
+func TestWhoAmI(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "otheracl", "alice", "claire")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/_someacl", params.SetACLRequestBody{
+		Users: []string{"claire"},
+	}, http.StatusOK, nil)
+	for _, test := range whoAmITests {
+		c.Run(test.testName, func(c *qt.C) {
+			assertJSONCallAs(c, test.user, "GET", srv.URL+"/whoami", nil, http.StatusOK, test.expectResult)
+		})
+	}
+}
+
+func TestMemberDetails(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:            memsimplekv.NewStore(),
+			RecordMembers: true,
+			Clock:         clock,
+		}),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	clock.advance(time.Hour)
+	assertJSONCallAs(c, "boss", "POST", srv.URL+"/someacl", params.ModifyACLRequestBody{
+		Add: []string{"bob"},
+	}, http.StatusOK, params.ModifyACLResponse{
+		Added: 1,
+	})
+	members, err := m.MemberDetails(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, []aclstore.Member{{
+		User:    "alice",
+		AddedAt: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC),
+	}, {
+		User:    "bob",
+		AddedBy: "boss",
+		AddedAt: time.Date(2018, 1, 1, 1, 0, 0, 0, time.UTC),
+	}})
+}
+
+func TestACLWithVersion(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	users, v1, err := m.ACLWithVersion(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	// Reading the ACL does not change its version.
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	_, v, err := m.ACLWithVersion(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(v, qt.Equals, v1)
+
+	// Every change advances the version.
+	err = store.Add(ctx, "someacl", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	users, v2, err := m.ACLWithVersion(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice", "bob"})
+	c.Assert(v2 > v1, qt.Equals, true)
+	err = store.Add(ctx, "someacl", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	_, v3, err := m.ACLWithVersion(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(v3 > v2, qt.Equals, true)
+
+	// A recreated ACL does not reuse earlier versions.
+	err = m.DeleteACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.ACLWithVersion(ctx, "someacl")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	_, v4, err := m.ACLWithVersion(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(v4 > v3, qt.Equals, true)
+}
+
+type namedIdentity struct {
+	name string
+}
+
+func (id *namedIdentity) Name() string {
+	return id.name
+}
+
+func (id *namedIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	for _, a := range acl {
+		if a == id.name {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func TestAdminACLName(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m1, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"alice"},
+		AdminACLName:      "admin-one",
+	})
+	c.Assert(err, qt.Equals, nil)
+	m2, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"bob"},
+		AdminACLName:      "admin-two",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m1.CreateACL(ctx, "someacl", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	_, err = store.Get(ctx, aclstore.AdminACL)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+	authenticate := func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+		return &namedIdentity{req.Header.Get("User")}, nil
+	}
+	srv1 := httptest.NewServer(m1.NewHandler(aclstore.HandlerParams{
+		Authenticate: authenticate,
+	}))
+	defer srv1.Close()
+	srv2 := httptest.NewServer(m2.NewHandler(aclstore.HandlerParams{
+		Authenticate: authenticate,
+	}))
+	defer srv2.Close()
+
+	forbidden := &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	}
+	assertJSONCallAs(c, "alice", "GET", srv1.URL+"/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"charlie"},
+	})
+	assertJSONCallAs(c, "bob", "GET", srv1.URL+"/someacl", nil, http.StatusForbidden, forbidden)
+	assertJSONCallAs(c, "bob", "GET", srv2.URL+"/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"charlie"},
+	})
+	assertJSONCallAs(c, "alice", "GET", srv2.URL+"/someacl", nil, http.StatusForbidden, forbidden)
+
+	// Each admin ACL is only managed by its own administrators.
+	assertJSONCallAs(c, "alice", "GET", srv1.URL+"/admin-one", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice"},
+	})
+	assertJSONCallAs(c, "alice", "GET", srv2.URL+"/admin-two", nil, http.StatusForbidden, forbidden)
+	assertJSONCallAs(c, "alice", "PUT", srv1.URL+"/admin/replace", params.ReplaceAdminsRequestBody{
+		Users: []string{"alice", "daisy"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "bob", "GET", srv2.URL+"/", nil, http.StatusOK, params.GetACLsResponse{
+		ACLs: []string{"admin-one", "admin-two", "someacl"},
+	})
+	assertJSONCallAs(c, "bob", "GET", srv1.URL+"/", nil, http.StatusForbidden, forbidden)
+	users, err := store.Get(ctx, "admin-one")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice", "daisy"})
+	users, err = store.Get(ctx, "admin-two")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+}
+
+func TestInvalidAdminACLName(t *testing.T) {
+	c := qt.New(t)
+	_, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store:        aclstore.NewACLStore(memsimplekv.NewStore()),
+		AdminACLName: "_admin",
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid admin ACL name "_admin"`)
+}
+
+func TestCheckerACLCannotBeAdmin(t *testing.T) {
+	c := qt.New(t)
+	_, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store:      aclstore.NewACLStore(memsimplekv.NewStore()),
+		CheckerACL: aclstore.AdminACL,
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid checker ACL name "admin"`)
+}
+
+func TestReadOnlyAdminACLName(t *testing.T) {
+	c := qt.New(t)
+	for _, name := range []string{aclstore.AdminACL, "checkers", "_auditors"} {
+		_, err := aclstore.NewManager(context.Background(), aclstore.Params{
+			Store:            aclstore.NewACLStore(memsimplekv.NewStore()),
+			CheckerACL:       "checkers",
+			ReadOnlyAdminACL: name,
+		})
+		c.Check(err, qt.ErrorMatches, fmt.Sprintf(`invalid read-only admin ACL name %q`, name))
+	}
+}
+
+var invalidParamsTests = []struct {
+	testName    string
+	params      aclstore.Params
+	expectError string
+	expectCause error
+}{{
+	testName:    "negative_max_search_results",
+	params:      aclstore.Params{MaxSearchResults: -1},
+	expectError: `invalid MaxSearchResults -1: must not be negative`,
+}, {
+	testName:    "negative_max_acls",
+	params:      aclstore.Params{MaxACLs: -5},
+	expectError: `invalid MaxACLs -5: must not be negative`,
+}, {
+	testName:    "negative_event_buffer",
+	params:      aclstore.Params{EventBuffer: -1},
+	expectError: `invalid EventBuffer -1: must not be negative`,
+}, {
+	testName: "negative_cache_ttl",
+	params: aclstore.Params{Cache: &aclstore.Cache{
+		TTL: -time.Second,
+	}},
+	expectError: `invalid cache durations: must not be negative`,
+}, {
+	testName: "negative_webhook_queue_size",
+	params: aclstore.Params{Webhook: &aclstore.Webhook{
+		URL:       "http://example.com",
+		QueueSize: -1,
+	}},
+	expectError: `invalid webhook parameters: must not be negative`,
+}, {
+	testName:    "unknown_empty_acl_policy",
+	params:      aclstore.Params{EmptyACLPolicy: "ajar"},
+	expectError: `invalid EmptyACLPolicy "ajar"`,
+}, {
+	testName:    "empty_initial_admin",
+	params:      aclstore.Params{InitialAdminUsers: []string{"boss", ""}},
+	expectError: `invalid initial admin users: invalid user name ""`,
+	expectCause: aclstore.ErrBadUsername,
+}, {
+	testName:    "initial_admin_with_newline",
+	params:      aclstore.Params{InitialAdminUsers: []string{"bo\nss"}},
+	expectError: `invalid initial admin users: invalid user name "bo\\nss"`,
+	expectCause: aclstore.ErrBadUsername,
+}, {
+	testName: "admin_name_not_matching_pattern",
+	params: aclstore.Params{
+		ACLNamePattern: regexp.MustCompile(`^team-`),
+	},
+	expectError: `system ACL name "admin" does not match "\^team-"`,
+}, {
+	testName: "checker_name_not_matching_pattern",
+	params: aclstore.Params{
+		AdminACLName:   "team-admin",
+		CheckerACL:     "checkers",
+		ACLNamePattern: regexp.MustCompile(`^team-`),
+	},
+	expectError: `system ACL name "checkers" does not match "\^team-"`,
+}}
+
+func TestNewManagerInvalidParams(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range invalidParamsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			kv := memsimplekv.NewStore()
+			p := test.params
+			p.Store = aclstore.NewACLStore(kv)
+			_, err := aclstore.NewManager(context.Background(), p)
+			c.Assert(err, qt.ErrorMatches, test.expectError)
+			if test.expectCause != nil {
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+			}
+			// Nothing is created in the store.
+			keys, err := kv.(simplekv.KeyLister).Keys(context.Background())
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(keys, qt.HasLen, 0)
+		})
+	}
+}
+
+func TestManagerIsMember(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	var checkedACL []string
+	m, _ := managerWithACLs(c, "", map[string][]string{
+		"admin":   {"boss"},
+		"someacl": {"alice", "-bob", "bob"},
+	}, &checkedACL)
+	ok, err := m.IsMember(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+	ok, err = m.IsMember(ctx, "someacl", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+	_, err = m.IsMember(ctx, "nonexistent", "alice")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestACLNames(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	var checkedACL []string
+	m, _ := managerWithACLs(c, "", map[string][]string{
+		"admin": {"boss"},
+	}, &checkedACL)
+	err := m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+
+	names, err := m.ACLNames(ctx, false)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(names)
+	c.Assert(names, qt.DeepEquals, []string{"admin", "bar", "foo"})
+
+	names, err = m.ACLNames(ctx, true)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(names)
+	c.Assert(names, qt.DeepEquals, []string{"_bar", "_foo", "admin", "bar", "foo"})
+}
+
+func TestGetACLs(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"test-admin"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	h := m.NewHandler(aclstore.HandlerParams{
+		RootPath: "/rootpath",
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+	})
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL + "/rootpath",
+		Doer:    srv.Client(),
+	})
+	err = m.CreateACL(ctx, "test1", "user1")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "test2", "user1")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "test3", "user1")
+	c.Assert(err, qt.Equals, nil)
+
+	acls, err := client.GetACLs(ctx, &params.GetACLsRequest{})
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(acls.ACLs)
+	c.Assert(acls.ACLs, qt.DeepEquals, []string{"admin", "test1", "test2", "test3"})
+
+	acls, err = client.GetACLs(ctx, &params.GetACLsRequest{
+		IncludeMeta: true,
+	})
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(acls.ACLs)
+	c.Assert(acls.ACLs, qt.DeepEquals, []string{"_test1", "_test2", "_test3", "admin", "test1", "test2", "test3"})
+}
+
+var getACLsManageableTests = []struct {
+	testName     string
+	user         string
+	url          string
+	expectStatus int
+	expectResp   interface{}
+}{{
+	testName:     "meta_member",
+	user:         "alice",
+	url:          "/?manageable=1",
+	expectStatus: http.StatusOK,
+	expectResp: &params.GetACLsResponse{
+		ACLs: []string{"team-a", "team-b"},
+	},
+}, {
+	testName:     "meta_member_with_prefix",
+	user:         "alice",
+	url:          "/?manageable=1&prefix=team-b",
+	expectStatus: http.StatusOK,
+	expectResp: &params.GetACLsResponse{
+		ACLs: []string{"team-b"},
+	},
+}, {
+	testName:     "meta_member_with_counts",
+	user:         "bob",
+	url:          "/?manageable=1&counts=1",
+	expectStatus: http.StatusOK,
+	expectResp: &params.GetACLsResponse{
+		ACLs:   []string{"team-b", "team-c"},
+		Counts: map[string]int{"team-b": 1, "team-c": 2},
+	},
+}, {
+	testName:     "member_but_not_manager",
+	user:         "charlie",
+	url:          "/?manageable=1",
+	expectStatus: http.StatusOK,
+	expectResp: &params.GetACLsResponse{
+		ACLs: []string{},
+	},
+}, {
+	testName:     "unrelated_user",
+	user:         "eve",
+	url:          "/?manageable=1",
+	expectStatus: http.StatusOK,
+	expectResp: &params.GetACLsResponse{
+		ACLs: []string{},
+	},
+}, {
+	testName:     "admin",
+	user:         "boss",
+	url:          "/?manageable=1",
+	expectStatus: http.StatusOK,
+	expectResp: &params.GetACLsResponse{
+		ACLs: []string{"admin", "team-a", "team-b", "team-c"},
+	},
+}, {
+	testName:     "not_manageable",
+	user:         "alice",
+	url:          "/",
+	expectStatus: http.StatusForbidden,
+	expectResp: &httprequest.RemoteError{
+		Message: "forbidden",
+		Code:    httprequest.CodeForbidden,
+	},
+}}
+
+func TestGetACLsManageable(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for name, users := range map[string][]string{
+		"team-a": {"charlie"},
+		"team-b": {"charlie"},
+		"team-c": {"charlie", "daisy"},
+	} {
+		err := m.CreateACL(ctx, name, users...)
+		c.Assert(err, qt.Equals, nil)
+	}
+	for name, users := range map[string][]string{
+		"_team-a": {"alice"},
+		"_team-b": {"alice", "bob"},
+		"_team-c": {"bob"},
+	} {
+		err := store.Set(ctx, name, users)
+		c.Assert(err, qt.Equals, nil)
+	}
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	for _, test := range getACLsManageableTests {
+		c.Run(test.testName, func(c *qt.C) {
+			assertJSONCallAs(c, test.user, "GET", srv.URL+test.url, nil, test.expectStatus, test.expectResp)
+		})
+	}
+}
+
+var getACLsSortTests = []struct {
+	testName   string
+	req        params.GetACLsRequest
+	expectACLs []string
+}{{
+	testName:   "default",
+	expectACLs: []string{"admin", "alsomid", "big", "mid", "small"},
+}, {
+	testName: "name_asc",
+	req: params.GetACLsRequest{
+		Sort: params.SortByName,
+	},
+	expectACLs: []string{"admin", "alsomid", "big", "mid", "small"},
+}, {
+	testName: "name_desc",
+	req: params.GetACLsRequest{
+		Sort: params.SortByName,
+		Desc: true,
+	},
+	expectACLs: []string{"small", "mid", "big", "alsomid", "admin"},
+}, {
+	testName: "size_asc",
+	req: params.GetACLsRequest{
+		Sort: params.SortBySize,
+	},
+	expectACLs: []string{"small", "admin", "alsomid", "mid", "big"},
+}, {
+	testName: "size_desc",
+	req: params.GetACLsRequest{
+		Sort: params.SortBySize,
+		Desc: true,
+	},
+	// ACLs of the same size are still sorted by name.
+	expectACLs: []string{"big", "alsomid", "mid", "admin", "small"},
+}}
+
+func TestGetACLsCounts(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	_, srv := deleteTestServer(c, aclstore.NewACLStore(memsimplekv.NewStore()))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+	err := client.Add(ctx, "team-foo", []string{"bob", "charlie"})
+	c.Assert(err, qt.Equals, nil)
+	err = client.Clear(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+
+	counts, err := client.CountMembers(ctx, "team-")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(counts, qt.DeepEquals, map[string]int{
+		"team-bar":   0,
+		"team-foo":   3,
+		"team-foo-a": 1,
+		"team-foo-b": 1,
+	})
+
+	// The counts match the members when both are requested.
+	resp, err := client.GetACLs(ctx, &params.GetACLsRequest{
+		Detail: true,
+		Counts: true,
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(resp.Counts, qt.HasLen, len(resp.ACLs))
+	for _, name := range resp.ACLs {
+		c.Assert(resp.Counts[name], qt.Equals, len(resp.Members[name]), qt.Commentf("ACL %q", name))
+	}
+
+	counts, err = client.CountMembers(ctx, "nothing")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(counts, qt.DeepEquals, map[string]int{})
+}
+
+var getACLsPageTests = []struct {
+	testName    string
+	req         params.GetACLsRequest
+	expectACLs  []string
+	expectTotal int
+}{{
+	testName:    "first_page",
+	req:         params.GetACLsRequest{Prefix: "team-", Limit: 2, Count: true},
+	expectACLs:  []string{"team-a", "team-b"},
+	expectTotal: 5,
+}, {
+	testName:    "last_page",
+	req:         params.GetACLsRequest{Prefix: "team-", Offset: 4, Limit: 2, Count: true},
+	expectACLs:  []string{"team-e"},
+	expectTotal: 5,
+}, {
+	testName:    "beyond_end",
+	req:         params.GetACLsRequest{Prefix: "team-", Offset: 10, Count: true},
+	expectACLs:  []string{},
+	expectTotal: 5,
+}, {
+	testName:    "descending",
+	req:         params.GetACLsRequest{Prefix: "team-", Desc: true, Offset: 1, Limit: 2, Count: true},
+	expectACLs:  []string{"team-d", "team-c"},
+	expectTotal: 5,
+}, {
+	testName:    "all_acls",
+	req:         params.GetACLsRequest{Limit: 1, Count: true},
+	expectACLs:  []string{"admin"},
+	expectTotal: 6,
+}, {
+	testName:    "no_matches",
+	req:         params.GetACLsRequest{Prefix: "nothing", Count: true},
+	expectACLs:  []string{},
+	expectTotal: 0,
+}, {
+	testName:   "without_count",
+	req:        params.GetACLsRequest{Prefix: "team-", Limit: 3},
+	expectACLs: []string{"team-a", "team-b", "team-c"},
+}}
+
+func TestGetACLsPages(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"team-c", "team-a", "team-e", "team-b", "team-d"} {
+		err := m.CreateACL(ctx, name, "alice")
+		c.Assert(err, qt.Equals, nil)
+	}
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{"boss"}, nil
+		},
+	}))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+	for _, test := range getACLsPageTests {
+		c.Run(test.testName, func(c *qt.C) {
+			req := test.req
+			resp, err := client.GetACLs(ctx, &req)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(resp.ACLs, qt.DeepEquals, test.expectACLs)
+			if !test.req.Count {
+				c.Assert(resp.Total, qt.IsNil)
+				return
+			}
+			c.Assert(resp.Total, qt.Not(qt.IsNil))
+			c.Assert(*resp.Total, qt.Equals, test.expectTotal)
+		})
+	}
+
+	// Reading every page gives the whole listing, whose size
+	// matches the total. The admin ACL has no meta-ACL.
+	var all []string
+	for offset := 0; ; offset += 2 {
+		resp, err := client.GetACLs(ctx, &params.GetACLsRequest{
+			IncludeMeta: true,
+			Offset:      offset,
+			Limit:       2,
+			Count:       true,
+		})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(*resp.Total, qt.Equals, 11)
+		if len(resp.ACLs) == 0 {
+			break
+		}
+		all = append(all, resp.ACLs...)
+	}
+	c.Assert(all, qt.HasLen, 11)
+
+	_, err = client.GetACLs(ctx, &params.GetACLsRequest{
+		Limit: -1,
+	})
+	c.Assert(err, qt.ErrorMatches, `.*negative offset or limit`)
+}
+
+func TestGetACLsPagesShareTotal(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Now()}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		Clock:             clock,
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"team-a", "team-b", "team-c"} {
+		err := m.CreateACL(ctx, name, "alice")
+		c.Assert(err, qt.Equals, nil)
+	}
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{"boss"}, nil
+		},
+	}))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+	total := func(offset int) int {
+		resp, err := client.GetACLs(ctx, &params.GetACLsRequest{
+			Prefix: "team-",
+			Offset: offset,
+			Limit:  2,
+			Count:  true,
+		})
+		c.Assert(err, qt.Equals, nil)
+		return *resp.Total
+	}
+	c.Assert(total(0), qt.Equals, 3)
+	err = m.CreateACL(ctx, "team-d", "alice")
+	c.Assert(err, qt.Equals, nil)
+
+	// Later pages of the listing report the same total...
+	c.Assert(total(2), qt.Equals, 3)
+
+	// ... until it expires.
+	clock.advance(2 * time.Minute)
+	c.Assert(total(2), qt.Equals, 4)
+
+	// Reading the first page again starts a new listing.
+	err = m.CreateACL(ctx, "team-e", "alice")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(total(0), qt.Equals, 5)
+	c.Assert(total(2), qt.Equals, 5)
+}
+
+func TestGetACLsNotModified(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, srv := deleteTestServer(c, aclstore.NewACLStore(memsimplekv.NewStore()))
+	defer srv.Close()
+	get := func(url, etag string) *http.Response {
+		req, err := http.NewRequest("GET", url, nil)
+		c.Assert(err, qt.Equals, nil)
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		c.Assert(err, qt.Equals, nil)
+		resp.Body.Close()
+		return resp
+	}
+
+	resp := get(srv.URL+"/", "")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	etag := resp.Header.Get("ETag")
+	c.Assert(etag, qt.Not(qt.Equals), "")
+
+	// Nothing has changed.
+	resp = get(srv.URL+"/", etag)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotModified)
+	c.Assert(resp.Header.Get("ETag"), qt.Equals, etag)
+	resp = get(srv.URL+"/", `"other", W/`+etag)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotModified)
+
+	// Changing members does not change the list of names...
+	err := m.SwapMember(ctx, "other", "alice", "bob", false)
+	c.Assert(err, qt.Equals, nil)
+	resp = get(srv.URL+"/", etag)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusNotModified)
+
+	// ... but it does change the detailed listing.
+	resp = get(srv.URL+"/?detail=true", "")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	detailETag := resp.Header.Get("ETag")
+	c.Assert(detailETag, qt.Not(qt.Equals), etag)
+	err = m.SwapMember(ctx, "other", "bob", "charlie", false)
+	c.Assert(err, qt.Equals, nil)
+	resp = get(srv.URL+"/?detail=true", detailETag)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+
+	// Creating an ACL changes the list.
+	err = m.CreateACL(ctx, "new")
+	c.Assert(err, qt.Equals, nil)
+	resp = get(srv.URL+"/", etag)
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(resp.Header.Get("ETag"), qt.Not(qt.Equals), etag)
+}
+
+func TestGetACLsSort(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "mid", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "alsomid", "daisy", "eve")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "big", "alice", "bob", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "small")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+	}))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+	for _, test := range getACLsSortTests {
+		c.Run(test.testName, func(c *qt.C) {
+			acls, err := client.GetACLs(ctx, &test.req)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acls.ACLs, qt.DeepEquals, test.expectACLs)
+		})
+	}
+	assertJSONCall(c, "GET", srv.URL+"/?sort=weight", nil, http.StatusBadRequest, &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `unknown sort order "weight"`,
+	})
+}
+
+func TestNewHandlerNegativeMaxDetailACLs(t *testing.T) {
+	c := qt.New(t)
+	m, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(func() {
+		m.NewHandler(aclstore.HandlerParams{
+			MaxDetailACLs: -1,
+		})
+	}, qt.PanicMatches, `aclstore: invalid HandlerParams.MaxDetailACLs -1: must not be negative`)
+}
+
+var replaceAdminsTests = []struct {
+	testName    string
+	admins      []string
+	users       []string
+	force       bool
+	expectError string
+	expectACL   []string
+}{{
+	testName:  "keep_one_admin",
+	admins:    []string{"alice", "bob"},
+	users:     []string{"bob", "charlie"},
+	expectACL: []string{"bob", "charlie"},
+}, {
+	testName:    "no_current_admin_remains",
+	admins:      []string{"alice", "bob"},
+	users:       []string{"charlie", "daisy"},
+	expectError: `new admin users do not include any current admin user`,
+	expectACL:   []string{"alice", "bob"},
+}, {
+	testName:  "forced_full_replacement",
+	admins:    []string{"alice", "bob"},
+	users:     []string{"charlie", "daisy"},
+	force:     true,
+	expectACL: []string{"charlie", "daisy"},
+}, {
+	testName:    "empty_users",
+	admins:      []string{"alice", "bob"},
+	force:       true,
+	expectError: `cannot replace admin ACL with no users`,
+	expectACL:   []string{"alice", "bob"},
+}, {
+	testName:  "empty_admin_ACL",
+	users:     []string{"charlie"},
+	expectACL: []string{"charlie"},
+}, {
+	testName:    "invalid_user",
+	admins:      []string{"alice"},
+	users:       []string{"alice", ""},
+	expectError: `invalid user name ""`,
+	expectACL:   []string{"alice"},
+}}
+
+func TestReplaceAdmins(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range replaceAdminsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: test.admins,
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.ReplaceAdmins(ctx, test.users, test.force)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+			} else {
+				c.Assert(err, qt.Equals, nil)
+			}
+			acl, err := m.ACL(ctx, aclstore.AdminACL)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectACL)
+		})
+	}
+}
+
+func TestReplaceAdminsEndpoint(t *testing.T) {
+	c := qt.New(t)
+	var checkedACL []string
+	m, h := managerWithACLs(c, "/root", map[string][]string{
+		"admin":    {"alice", "bob"},
+		"someacl":  {"charlie"},
+		"_someacl": {"daisy"},
+	}, &checkedACL)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	assertJSONCall(c, "PUT", srv.URL+"/root/admin/replace", params.ReplaceAdminsRequestBody{
+		Users: []string{"edward"},
+	}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `new admin users do not include any current admin user`,
+		Code:    httprequest.CodeBadRequest,
+	})
+	c.Assert(checkedACL, qt.DeepEquals, []string{"alice", "bob"})
+
+	assertJSONCall(c, "PUT", srv.URL+"/root/admin/replace", params.ReplaceAdminsRequestBody{
+		Users: []string{"edward"},
+		Force: true,
+	}, http.StatusOK, nil)
+	acl, err := m.ACL(context.Background(), aclstore.AdminACL)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"edward"})
+
+	// Check that other ACLs are still reachable.
+	assertJSONCall(c, "GET", srv.URL+"/root/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"charlie"},
+	})
+}
+
+type allowed struct{}
+
+func (allowed) Allow(context.Context, []string) (bool, error) {
+	return true, nil
+}
+
+// managerWithACLs returns a Manager instance running an ACL manager
+// primed with the given ACLs. When an ACL is checked, *checkedACL is set
+// to the ACL that's checked.
+func managerWithACLs(c *qt.C, rootPath string, acls map[string][]string, checkedACL *[]string) (*aclstore.Manager, http.Handler) {
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	for aclName, users := range acls {
+		err := store.CreateACL(ctx, aclName, users)
+		c.Assert(err, qt.Equals, nil)
+	}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: store,
+	})
+	h := m.NewHandler(aclstore.HandlerParams{
+		RootPath: rootPath,
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return identityFunc(func(ctx context.Context, acl []string) (bool, error) {
+				*checkedACL = acl
+				return true, nil
+			}), nil
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	return m, h
+}
+
+type identityFunc func(ctx context.Context, acl []string) (bool, error)
+
+func (f identityFunc) Allow(ctx context.Context, acl []string) (bool, error) {
+	return f(ctx, acl)
+}
+
+// assertJSONCall asserts that when the given handler is called with
+// the given parameters, the result is as specified.
+func assertJSONCall(c *qt.C, method, url string, body interface{}, expectStatus int, expectResponse interface{}) {
+	assertJSONCallAs(c, "", method, url, body, expectStatus, expectResponse)
+}
+
+// assertJSONCallAs is like assertJSONCall except that, if user is
+// non-empty, the request is sent with a User header holding it.
+func assertJSONCallAs(c *qt.C, user, method, url string, body interface{}, expectStatus int, expectResponse interface{}) {
+	var bodyr io.Reader
+	if body != nil {
+		bodyData, err := json.Marshal(body)
+		c.Assert(err, qt.Equals, nil)
+		bodyr = bytes.NewReader(bodyData)
+	}
+	req, err := http.NewRequest(method, url, bodyr)
+	c.Assert(err, qt.Equals, nil)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if user != "" {
+		req.Header.Set("User", user)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, qt.Equals, nil)
+	defer resp.Body.Close()
 	respData, err := ioutil.ReadAll(resp.Body)
 	c.Assert(err, qt.Equals, nil)
-	if expectResponse == nil {
-		c.Assert(respData, qt.HasLen, 0, qt.Commentf("body: %s", respData))
-		return
+	if expectResponse == nil {
+		c.Assert(respData, qt.HasLen, 0, qt.Commentf("body: %s", respData))
+		return
+	}
+	c.Assert(resp.StatusCode, qt.Equals, expectStatus, qt.Commentf("body: %s", respData))
+	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
+	respValue := reflect.New(reflect.TypeOf(expectResponse))
+	err = json.Unmarshal(respData, respValue.Interface())
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(respValue.Elem().Interface(), qt.DeepEquals, expectResponse)
+}
+
+var adminBypassTests = []struct {
+	testName     string
+	adminBypass  *bool
+	user         string
+	method       string
+	path         string
+	expectStatus int
+}{{
+	testName:     "bypass_default_admin_reads_acl",
+	user:         "boss",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "bypass_on_admin_reads_acl",
+	adminBypass:  newBool(true),
+	user:         "boss",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "bypass_off_admin_cannot_read_acl",
+	adminBypass:  newBool(false),
+	user:         "boss",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusForbidden,
+}, {
+	testName:     "bypass_off_admin_reads_granted_acl",
+	adminBypass:  newBool(false),
+	user:         "boss",
+	method:       "GET",
+	path:         "/grantedacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "bypass_off_manager_reads_acl",
+	adminBypass:  newBool(false),
+	user:         "alice",
+	method:       "GET",
+	path:         "/someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "bypass_off_admin_reads_meta_acl",
+	adminBypass:  newBool(false),
+	user:         "boss",
+	method:       "GET",
+	path:         "/_someacl",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "bypass_off_admin_reads_admin_acl",
+	adminBypass:  newBool(false),
+	user:         "boss",
+	method:       "GET",
+	path:         "/admin",
+	expectStatus: http.StatusOK,
+}, {
+	testName:     "bypass_off_manager_cannot_read_meta_acl",
+	adminBypass:  newBool(false),
+	user:         "alice",
+	method:       "GET",
+	path:         "/_someacl",
+	expectStatus: http.StatusForbidden,
+}}
+
+func TestAdminBypass(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range adminBypassTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStore(memsimplekv.NewStore())
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             store,
+				InitialAdminUsers: []string{"boss"},
+				AdminBypass:       test.adminBypass,
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl", "bob")
+			c.Assert(err, qt.Equals, nil)
+			err = store.Set(ctx, "_someacl", []string{"alice"})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "grantedacl", "bob")
+			c.Assert(err, qt.Equals, nil)
+			err = store.Set(ctx, "_grantedacl", []string{"boss"})
+			c.Assert(err, qt.Equals, nil)
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return &namedIdentity{req.Header.Get("User")}, nil
+				},
+			}))
+			defer srv.Close()
+			req, err := http.NewRequest(test.method, srv.URL+test.path, nil)
+			c.Assert(err, qt.Equals, nil)
+			req.Header.Set("User", test.user)
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			resp.Body.Close()
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus)
+		})
+	}
+}
+
+func TestAllowAnyWithoutAdminBypass(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		AdminBypass:       newBool(false),
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "bob")
+	c.Assert(err, qt.Equals, nil)
+	boss := &namedIdentity{"boss"}
+	ok, err := m.AllowAny(ctx, boss, []string{"someacl"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+	ok, err = m.AllowAny(ctx, boss, []string{"someacl", "admin"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+	ok, err = m.AllowAny(ctx, &namedIdentity{"bob"}, []string{"someacl"})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+}
+
+func newBool(b bool) *bool {
+	return &b
+}
+
+func TestUserAllowed(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	directory := map[string]bool{
+		"boss":  true,
+		"alice": true,
+		"bob":   true,
 	}
-	c.Assert(resp.StatusCode, qt.Equals, expectStatus, qt.Commentf("body: %s", respData))
-	c.Assert(resp.Header.Get("Content-Type"), qt.Equals, "application/json")
-	respValue := reflect.New(reflect.TypeOf(expectResponse))
-	err = json.Unmarshal(respData, respValue.Interface())
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		UserAllowed: func(ctx context.Context, user string) (bool, error) {
+			if user == "broken" {
+				return false, errgo.Newf("directory unavailable")
+			}
+			return directory[user], nil
+		},
+	})
 	c.Assert(err, qt.Equals, nil)
-	c.Assert(respValue.Elem().Interface(), qt.DeepEquals, expectResponse)
+
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "otheracl", "alice", "mallory")
+	c.Assert(err, qt.ErrorMatches, `user "mallory" is not allowed`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	err = m.SwapMember(ctx, "someacl", "alice", "mallory", false)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	err = m.SwapMember(ctx, "someacl", "alice", "broken", false)
+	c.Assert(err, qt.ErrorMatches, `cannot check user "broken": directory unavailable`)
+	err = m.ReplaceAdmins(ctx, []string{"boss", "mallory"}, false)
+	c.Assert(err, qt.ErrorMatches, `user "mallory" is not allowed`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	admins, err := m.ACL(ctx, "admin")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(admins, qt.DeepEquals, []string{"boss"})
+
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "boss", "POST", srv.URL+"/someacl", params.ModifyACLRequestBody{
+		Add: []string{"bob"},
+	}, http.StatusOK, params.ModifyACLResponse{
+		Added: 1,
+	})
+	assertJSONCallAs(c, "boss", "POST", srv.URL+"/someacl", params.ModifyACLRequestBody{
+		Add: []string{"mallory"},
+	}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `user "mallory" is not allowed`,
+		Code:    httprequest.CodeBadRequest,
+	})
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/someacl", params.SetACLRequestBody{
+		Users: []string{"alice", "mallory"},
+	}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `user "mallory" is not allowed`,
+		Code:    httprequest.CodeBadRequest,
+	})
+	assertJSONCallAs(c, "boss", "PATCH", srv.URL+"/someacl", []params.PatchOperation{{
+		Op:    "add",
+		Path:  "/users/-",
+		Value: json.RawMessage(`"mallory"`),
+	}}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `user "mallory" is not allowed`,
+		Code:    httprequest.CodeBadRequest,
+	})
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice", "bob"})
+}
+
+// shuffledStore is a store whose ACLs method returns
+// the names in a different random order each time.
+type shuffledStore struct {
+	aclstore.ACLStore
+	rand *rand.Rand
+}
+
+func (s *shuffledStore) ACLs(ctx context.Context) ([]string, error) {
+	names, err := s.ACLStore.(aclstore.ACLLister).ACLs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.rand.Shuffle(len(names), func(i, j int) {
+		names[i], names[j] = names[j], names[i]
+	})
+	return names, nil
+}
+
+func TestACLNamesSorted(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: &shuffledStore{
+			ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+			rand:     rand.New(rand.NewSource(1)),
+		},
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	for _, name := range []string{"delta", "alpha", "echo", "charlie", "bravo"} {
+		err := m.CreateACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+	}
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{"boss"}, nil
+		},
+	}))
+	defer srv.Close()
+	client := aclclient.New(aclclient.NewParams{
+		BaseURL: srv.URL,
+		Doer:    srv.Client(),
+	})
+	for i := 0; i < 5; i++ {
+		names, err := m.ACLNames(ctx, false)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(names, qt.DeepEquals, []string{"admin", "alpha", "bravo", "charlie", "delta", "echo"})
+		names, err = m.ACLNames(ctx, true)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(sort.StringsAreSorted(names), qt.Equals, true, qt.Commentf("%q", names))
+
+		resp, err := client.GetACLs(ctx, &params.GetACLsRequest{})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(resp.ACLs, qt.DeepEquals, []string{"admin", "alpha", "bravo", "charlie", "delta", "echo"})
+	}
+}
+
+func TestIsAdmin(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss", "deputy"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "me")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+
+	// Only the caller's own status is returned.
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/me/admin", nil, http.StatusOK, map[string]interface{}{
+		"admin": true,
+	})
+	assertJSONCallAs(c, "alice", "GET", srv.URL+"/me/admin", nil, http.StatusOK, map[string]interface{}{
+		"admin": false,
+	})
+
+	// An ACL named "me" can still be used.
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/me", nil, http.StatusOK, params.GetACLResponse{})
+}
+
+func TestForEachACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: &shuffledStore{
+			ACLStore: aclstore.NewACLStore(memsimplekv.NewStore()),
+			rand:     rand.New(rand.NewSource(1)),
+		},
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "one", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "two", "charlie")
+	c.Assert(err, qt.Equals, nil)
+
+	var names []string
+	acls := make(map[string][]string)
+	err = m.ForEachACL(ctx, func(name string, members []string) error {
+		names = append(names, name)
+		acls[name] = members
+		return nil
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.DeepEquals, []string{"_one", "_two", "admin", "one", "two"})
+	c.Assert(acls, qt.DeepEquals, map[string][]string{
+		"_one":  nil,
+		"_two":  nil,
+		"admin": {"boss"},
+		"one":   {"alice", "bob"},
+		"two":   {"charlie"},
+	})
+
+	// The iteration stops at the first error.
+	errStop := errgo.Newf("stop")
+	names = nil
+	err = m.ForEachACL(ctx, func(name string, members []string) error {
+		names = append(names, name)
+		if name == "admin" {
+			return errStop
+		}
+		return nil
+	})
+	c.Assert(errgo.Cause(err), qt.Equals, errStop)
+	c.Assert(names, qt.DeepEquals, []string{"_one", "_two", "admin"})
+}
+
+var createACLEndpointTests = []struct {
+	testName       string
+	user           string
+	url            string
+	checkACL       string
+	users          []string
+	expectStatus   int
+	expectResponse interface{}
+	expectUsers    []string
+}{{
+	testName:     "new_acl",
+	user:         "boss",
+	url:          "/newacl/create",
+	checkACL:     "newacl",
+	users:        []string{"alice"},
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"alice"},
+}, {
+	testName:     "new_acl_fail_if_exists",
+	user:         "boss",
+	url:          "/newacl/create?failIfExists=true",
+	checkACL:     "newacl",
+	users:        []string{"alice"},
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"alice"},
+}, {
+	testName:     "existing_acl_is_unchanged",
+	user:         "boss",
+	url:          "/existing/create",
+	checkACL:     "existing",
+	users:        []string{"alice"},
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"bob"},
+}, {
+	testName:     "existing_acl_conflict",
+	user:         "boss",
+	url:          "/existing/create?failIfExists=true",
+	checkACL:     "existing",
+	users:        []string{"alice"},
+	expectStatus: http.StatusConflict,
+	expectResponse: &httprequest.RemoteError{
+		Message: `ACL "existing" already exists`,
+		Code:    aclstore.CodeACLExists,
+	},
+	expectUsers: []string{"bob"},
+}, {
+	testName:     "bad_name",
+	user:         "boss",
+	url:          "/_newacl/create",
+	checkACL:     "newacl",
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Message: `invalid ACL name "_newacl"`,
+		Code:    httprequest.CodeBadRequest,
+	},
+}, {
+	testName:     "not_admin",
+	user:         "bob",
+	url:          "/existing/create",
+	checkACL:     "existing",
+	expectStatus: http.StatusForbidden,
+	expectResponse: &httprequest.RemoteError{
+		Message: "forbidden",
+		Code:    httprequest.CodeForbidden,
+	},
+	expectUsers: []string{"bob"},
+}}
+
+func TestCreateACLEndpoint(t *testing.T) {
+	testCreateACLEndpoint(t, func() aclstore.ACLStore {
+		return aclstore.NewACLStore(memsimplekv.NewStore())
+	})
+}
+
+func TestCreateACLEndpointWithoutACLCreator(t *testing.T) {
+	testCreateACLEndpoint(t, func() aclstore.ACLStore {
+		return plainStore{aclstore.NewACLStore(memsimplekv.NewStore())}
+	})
+}
+
+func testCreateACLEndpoint(t *testing.T, newStore func() aclstore.ACLStore) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range createACLEndpointTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             newStore(),
+				InitialAdminUsers: []string{"boss"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "existing", "bob")
+			c.Assert(err, qt.Equals, nil)
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return &namedIdentity{req.Header.Get("User")}, nil
+				},
+			}))
+			defer srv.Close()
+
+			assertJSONCallAs(c, test.user, "PUT", srv.URL+test.url, params.CreateACLRequestBody{
+				Users: test.users,
+			}, test.expectStatus, test.expectResponse)
+			users, err := m.ACL(ctx, test.checkACL)
+			if test.expectUsers == nil {
+				c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+				return
+			}
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, test.expectUsers)
+		})
+	}
+}
+
+var effectiveCheckACLTests = []struct {
+	testName       string
+	users          map[string][]string
+	aclName        string
+	expectCheckACL []string
+}{{
+	testName: "admin_ACL",
+	users: map[string][]string{
+		"admin": {"alice", "bob"},
+	},
+	aclName:        "admin",
+	expectCheckACL: []string{"alice", "bob"},
+}, {
+	testName: "nonadmin_ACL",
+	users: map[string][]string{
+		"admin":    {"alice", "bob"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {"claire", "ed"},
+	},
+	aclName:        "someacl",
+	expectCheckACL: []string{"claire", "ed", "alice", "bob"},
+}, {
+	testName: "nonadmin_meta_ACL",
+	users: map[string][]string{
+		"admin":    {"alice", "bob"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {"claire", "ed"},
+	},
+	aclName:        "_someacl",
+	expectCheckACL: []string{"alice", "bob"},
+}, {
+	testName: "empty_meta_ACL",
+	users: map[string][]string{
+		"admin":    {"alice", "bob"},
+		"someacl":  {"charlie", "daisy"},
+		"_someacl": {},
+	},
+	aclName:        "someacl",
+	expectCheckACL: []string{"alice", "bob"},
+}}
+
+func TestEffectiveCheckACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range effectiveCheckACLTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var checkedACL []string
+			m, h := managerWithACLs(c, "", test.users, &checkedACL)
+			acl, err := m.EffectiveCheckACL(ctx, test.aclName)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectCheckACL)
+
+			// The handler checks the same ACL for a modify request.
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			assertJSONCall(c, "PUT", srv.URL+"/"+test.aclName, params.SetACLRequestBody{
+				Users: test.users[test.aclName],
+			}, http.StatusOK, nil)
+			c.Assert(checkedACL, qt.DeepEquals, acl)
+		})
+	}
+}
+
+func TestEffectiveCheckACLWithOptions(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:              memsimplekv.NewStore(),
+		CaseInsensitive: true,
+	})
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"Boss"},
+		DenyPrefix:        "-",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	err = store.Set(ctx, "_someacl", []string{"Alice", "-bob", "bob"})
+	c.Assert(err, qt.Equals, nil)
+
+	// Folded forms are included and deny entries are not.
+	acl, err := m.EffectiveCheckACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"Alice", "bob", "Boss", "alice", "boss"})
+
+	_, err = m.EffectiveCheckACL(ctx, "nothere")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestDisableMetaACLs(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := memsimplekv.NewStore()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(kv),
+		InitialAdminUsers: []string{"boss"},
+		DisableMetaACLs:   true,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+
+	// No meta-ACLs are created.
+	keys, err := kv.(simplekv.KeyLister).Keys(ctx)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(keys)
+	c.Assert(keys, qt.DeepEquals, []string{"admin", "someacl"})
+
+	// Only administrators may change the ACL.
+	managers, err := m.Managers(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(managers, qt.DeepEquals, []string{"boss"})
+	ok, err := m.Authorize(ctx, &namedIdentity{"alice"}, "someacl", aclstore.OperationModify)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+	ok, err = m.Authorize(ctx, &namedIdentity{"boss"}, "someacl", aclstore.OperationModify)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+
+	// A handler needs a custom authorizer.
+	c.Assert(func() {
+		m.NewHandler(aclstore.HandlerParams{})
+	}, qt.PanicMatches, `aclstore: HandlerParams.Authorize must be set when meta-ACLs are disabled`)
+
+	// A custom authorizer can grant access to other users.
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+		Authorize: func(ctx context.Context, identity aclstore.Identity, aclName string, op aclstore.Operation) (bool, error) {
+			if identity.(*namedIdentity).name == "alice" && aclName == "someacl" {
+				return true, nil
+			}
+			return m.Authorize(ctx, identity, aclName, op)
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "alice", "PUT", srv.URL+"/someacl", params.SetACLRequestBody{
+		Users: []string{"alice", "bob"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "bob", "PUT", srv.URL+"/someacl", params.SetACLRequestBody{
+		Users: []string{"bob"},
+	}, http.StatusForbidden, &httprequest.RemoteError{
+		Code:    httprequest.CodeForbidden,
+		Message: httprequest.CodeForbidden,
+	})
+	assertJSONCallAs(c, "boss", "PUT", srv.URL+"/newacl/create", params.CreateACLRequestBody{
+		Users: []string{"charlie"},
+	}, http.StatusOK, nil)
+	assertJSONCallAs(c, "alice", "GET", srv.URL+"/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice", "bob"},
+	})
+	keys, err = kv.(simplekv.KeyLister).Keys(ctx)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(keys)
+	c.Assert(keys, qt.DeepEquals, []string{"admin", "newacl", "someacl"})
+
+	// ACLs without meta-ACLs can be deleted.
+	err = m.DeleteACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+var recoverAdminTests = []struct {
+	testName    string
+	storeParams aclstore.StoreParams
+	// setup breaks the admin ACL held in kv.
+	setup       func(c *qt.C, kv simplekv.Store, store aclstore.ACLStore)
+	users       []string
+	expectError string
+	expectCause error
+	expectACL   []string
+}{{
+	testName: "empty_admin_ACL",
+	setup: func(c *qt.C, kv simplekv.Store, store aclstore.ACLStore) {
+		err := store.Set(context.Background(), "admin", nil)
+		c.Assert(err, qt.Equals, nil)
+	},
+	users:     []string{"alice", "bob"},
+	expectACL: []string{"alice", "bob"},
+}, {
+	testName:  "current_admins_replaced",
+	users:     []string{"alice"},
+	expectACL: []string{"alice"},
+}, {
+	testName: "deleted_admin_ACL",
+	setup: func(c *qt.C, kv simplekv.Store, store aclstore.ACLStore) {
+		err := store.(aclstore.ACLDeleter).DeleteACL(context.Background(), "admin")
+		c.Assert(err, qt.Equals, nil)
+	},
+	users:     []string{"alice"},
+	expectACL: []string{"alice"},
+}, {
+	testName: "corrupt_admin_ACL",
+	setup: func(c *qt.C, kv simplekv.Store, store aclstore.ACLStore) {
+		err := kv.Set(context.Background(), "admin", []byte("\n{bad"), time.Time{})
+		c.Assert(err, qt.Equals, nil)
+		_, err = store.Get(context.Background(), "admin")
+		c.Assert(err, qt.Not(qt.IsNil))
+	},
+	users:     []string{"alice"},
+	expectACL: []string{"alice"},
+}, {
+	testName: "undecodable_admin_ACL_with_retention",
+	storeParams: aclstore.StoreParams{
+		DeleteRetention: time.Hour,
+	},
+	setup: func(c *qt.C, kv simplekv.Store, store aclstore.ACLStore) {
+		err := kv.Set(context.Background(), "admin", []byte("\n{bad"), time.Time{})
+		c.Assert(err, qt.Equals, nil)
+		err = store.(aclstore.ACLDeleter).DeleteACL(context.Background(), "admin")
+		c.Assert(err, qt.Not(qt.IsNil))
+	},
+	users:     []string{"alice"},
+	expectACL: []string{"alice"},
+}, {
+	testName: "no_users",
+	setup: func(c *qt.C, kv simplekv.Store, store aclstore.ACLStore) {
+		err := store.Set(context.Background(), "admin", nil)
+		c.Assert(err, qt.Equals, nil)
+	},
+	expectError: `cannot recover admin ACL with no users`,
+	expectCause: aclstore.ErrAdminLockout,
+}, {
+	testName:    "invalid_user",
+	users:       []string{"alice", "bad\nuser"},
+	expectError: `invalid user name "bad\\nuser"`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"boss"},
+}, {
+	testName:    "disallowed_user",
+	users:       []string{"alice", "mallory"},
+	expectError: `user "mallory" is not allowed`,
+	expectCause: aclstore.ErrBadUsername,
+	expectACL:   []string{"boss"},
+}}
+
+func TestRecoverAdmin(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range recoverAdminTests {
+		c.Run(test.testName, func(c *qt.C) {
+			kv := memsimplekv.NewStore()
+			sp := test.storeParams
+			sp.KV = kv
+			store := aclstore.NewACLStoreWithParams(sp)
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             store,
+				InitialAdminUsers: []string{"boss"},
+				UserAllowed: func(ctx context.Context, user string) (bool, error) {
+					return user != "mallory", nil
+				},
+			})
+			c.Assert(err, qt.Equals, nil)
+			if test.setup != nil {
+				test.setup(c, kv, store)
+			}
+			err = m.RecoverAdmin(ctx, test.users)
+			if test.expectError != "" {
+				c.Assert(err, qt.ErrorMatches, test.expectError)
+				c.Assert(errgo.Cause(err), qt.Equals, test.expectCause)
+			} else {
+				c.Assert(err, qt.Equals, nil)
+			}
+			if test.expectACL == nil {
+				return
+			}
+			acl, err := m.ACL(ctx, "admin")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectACL)
+			ok, err := m.Authorize(ctx, &namedIdentity{test.expectACL[0]}, "admin", aclstore.OperationModify)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, true)
+		})
+	}
 }
@@ -0,0 +1,244 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+)
+
+// OpenAPISpec returns an OpenAPI 3 document, encoded as JSON, that
+// describes the HTTP endpoints served by a handler created with
+// Manager.NewHandler with the given root path. The routes, parameters
+// and request and response bodies are derived from the types in the
+// params package.
+func OpenAPISpec(rootPath string) ([]byte, error) {
+	g := &specGenerator{
+		schemas: make(map[string]interface{}),
+	}
+	paths := make(map[string]map[string]interface{})
+	ht := reflect.TypeOf(handler1{})
+	for i := 0; i < ht.NumMethod(); i++ {
+		m := ht.Method(i)
+		if m.Name == "Close" {
+			// Close is called by httprequest after each
+			// request; it is not an endpoint.
+			continue
+		}
+		method, p, op, err := g.operation(m)
+		if err != nil {
+			return nil, errgo.Notef(err, "cannot describe %s", m.Name)
+		}
+		p = path.Join("/", rootPath, p)
+		if paths[p] == nil {
+			paths[p] = make(map[string]interface{})
+		}
+		paths[p][strings.ToLower(method)] = op
+	}
+	// Make sure the error schema referred to by every operation is defined.
+	g.schema(remoteErrorType)
+	return json.MarshalIndent(map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "ACL store",
+			"version": "2",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+		},
+	}, "", "\t")
+}
+
+var (
+	httprequestParamsType = reflect.TypeOf(httprequest.Params{})
+	httprequestRouteType  = reflect.TypeOf(httprequest.Route{})
+	remoteErrorType       = reflect.TypeOf(httprequest.RemoteError{})
+	rawMessageType        = reflect.TypeOf(json.RawMessage{})
+)
+
+// specGenerator holds the state used when generating an OpenAPI
+// document. It accumulates schemas for named types as they are
+// encountered.
+type specGenerator struct {
+	schemas map[string]interface{}
+}
+
+// operation returns the HTTP method, route path (in OpenAPI form) and
+// OpenAPI operation object for the given handler1 method.
+func (g *specGenerator) operation(m reflect.Method) (method, p string, op map[string]interface{}, err error) {
+	// The method type includes the receiver.
+	mt := m.Type
+	if mt.NumIn() != 3 || mt.In(1) != httprequestParamsType || mt.In(2).Kind() != reflect.Ptr || mt.In(2).Elem().Kind() != reflect.Struct {
+		return "", "", nil, errgo.Newf("unexpected method signature %s", mt)
+	}
+	reqt := mt.In(2).Elem()
+	op = map[string]interface{}{
+		"operationId": m.Name,
+	}
+	var parameters []interface{}
+	for i := 0; i < reqt.NumField(); i++ {
+		f := reqt.Field(i)
+		tag := f.Tag.Get("httprequest")
+		if f.Type == httprequestRouteType {
+			fields := strings.Fields(tag)
+			if len(fields) != 2 {
+				return "", "", nil, errgo.Newf("bad route tag %q", tag)
+			}
+			method, p = fields[0], openAPIPath(fields[1])
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		if len(parts) < 2 {
+			continue
+		}
+		name, source := parts[0], parts[1]
+		if name == "" {
+			name = f.Name
+		}
+		switch source {
+		case "body":
+			op["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  jsonContent(g.schema(f.Type)),
+			}
+		case "path", "form", "header":
+			in := source
+			if in == "form" {
+				in = "query"
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name":     name,
+				"in":       in,
+				"required": source == "path",
+				"schema":   g.schema(f.Type),
+			})
+		}
+	}
+	if method == "" {
+		return "", "", nil, errgo.Newf("no route found in %s", reqt)
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+	success := map[string]interface{}{
+		"description": "success",
+	}
+	if mt.NumOut() == 2 {
+		success["content"] = jsonContent(g.schema(mt.Out(0)))
+	}
+	op["responses"] = map[string]interface{}{
+		"200": success,
+		"default": map[string]interface{}{
+			"description": "error",
+			"content": jsonContent(map[string]interface{}{
+				"$ref": "#/components/schemas/Error",
+			}),
+		},
+	}
+	return method, p, op, nil
+}
+
+// schema returns an OpenAPI schema for values of the given type when
+// encoded as JSON. Named struct types are added to g.schemas and
+// referred to by name.
+func (g *specGenerator) schema(t reflect.Type) interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == rawMessageType {
+		// Any JSON value is allowed.
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": g.schema(t.Elem()),
+		}
+	case reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": g.schema(t.Elem()),
+		}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+		name := t.Name()
+		if t == remoteErrorType {
+			name = "Error"
+		}
+		if _, ok := g.schemas[name]; !ok {
+			// Add a placeholder first so that recursive
+			// types terminate.
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{
+			"$ref": "#/components/schemas/" + name,
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema returns an OpenAPI object schema for the given
+// struct type.
+func (g *specGenerator) structSchema(t reflect.Type) interface{} {
+	props := make(map[string]interface{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := f.Name
+		if tag := f.Tag.Get("json"); tag != "" {
+			if tag == "-" {
+				continue
+			}
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+		}
+		props[name] = g.schema(f.Type)
+	}
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+}
+
+// openAPIPath converts an httprouter path pattern to
+// the path template form used by OpenAPI.
+func openAPIPath(p string) string {
+	elems := strings.Split(p, "/")
+	for i, e := range elems {
+		if strings.HasPrefix(e, ":") || strings.HasPrefix(e, "*") {
+			elems[i] = "{" + e[1:] + "}"
+		}
+	}
+	return strings.Join(elems, "/")
+}
+
+func jsonContent(schema interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{
+			"schema": schema,
+		},
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestOpenAPISpec(t *testing.T) {
+	c := qt.New(t)
+	data, err := aclstore.OpenAPISpec("/root")
+	c.Assert(err, qt.Equals, nil)
+	var spec struct {
+		OpenAPI string `json:"openapi"`
+		Paths   map[string]map[string]struct {
+			OperationID string `json:"operationId"`
+			Parameters  []struct {
+				Name string `json:"name"`
+				In   string `json:"in"`
+			} `json:"parameters"`
+		} `json:"paths"`
+		Components struct {
+			Schemas map[string]json.RawMessage `json:"schemas"`
+		} `json:"components"`
+	}
+	err = json.Unmarshal(data, &spec)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(spec.OpenAPI, qt.Matches, `3\..*`)
+
+	ops := make(map[string]string)
+	for p, methods := range spec.Paths {
+		for method, op := range methods {
+			ops[method+" "+p] = op.OperationID
+		}
+	}
+	c.Assert(ops, qt.DeepEquals, map[string]string{
+		"get /root":                       "GetACLs",
+		"delete /root":                    "DeleteACLs",
+		"get /root/{name}":                "GetACL",
+		"put /root/{name}":                "SetACL",
+		"post /root/{name}":               "ModifyACL",
+		"patch /root/{name}":              "PatchACL",
+		"put /root/{name}/create":         "CreateACL",
+		"get /root/{name}/csv":            "GetACLCSV",
+		"get /root/{name}/managers":       "GetManagers",
+		"get /root/{name}/origins":        "GetMemberOrigins",
+		"post /root/{name}/import":        "ImportMembers",
+		"get /root/{name}/members/{user}": "IsMember",
+		"post /root/{name}/members":       "MembersIn",
+		"get /root/{name}/members":        "SearchMembers",
+		"get /root/users/{user}/acls":     "GetEffectiveACLs",
+		"get /root/stats":                 "GetStats",
+		"put /root/admin/replace":         "ReplaceAdmins",
+		"post /root/admin/rebuild-index":  "RebuildIndex",
+		"get /root/whoami":                "WhoAmI",
+		"get /root/me/admin":              "IsAdmin",
+		"post /root/authorize":            "Authorize",
+	})
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters, qt.HasLen, 4)
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[0].Name, qt.Equals, "name")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[0].In, qt.Equals, "path")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[1].Name, qt.Equals, "withMeta")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[1].In, qt.Equals, "query")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[2].Name, qt.Equals, "v")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[2].In, qt.Equals, "query")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[3].Name, qt.Equals, "format")
+	c.Assert(spec.Paths["/root/{name}"]["get"].Parameters[3].In, qt.Equals, "query")
+	for _, name := range []string{"Error", "GetACLResponse", "SetACLRequestBody", "ModifyACLRequestBody"} {
+		c.Assert(spec.Components.Schemas[name], qt.Not(qt.IsNil), qt.Commentf("schema %s", name))
+	}
+}
+
+func TestOpenAPISpecWithEmptyRootPath(t *testing.T) {
+	c := qt.New(t)
+	data, err := aclstore.OpenAPISpec("")
+	c.Assert(err, qt.Equals, nil)
+	var spec struct {
+		Paths map[string]interface{} `json:"paths"`
+	}
+	err = json.Unmarshal(data, &spec)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(spec.Paths["/"], qt.Not(qt.IsNil))
+	c.Assert(spec.Paths["/{name}"], qt.Not(qt.IsNil))
+}
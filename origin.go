@@ -0,0 +1,97 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// These constants describe how a user is related to an ACL,
+// as returned in MemberOrigin.Origins.
+const (
+	// OriginMember means that the user is a member of the ACL.
+	OriginMember = "member"
+
+	// OriginMeta means that the user is a member of the ACL's
+	// meta-ACL, so may change the ACL.
+	OriginMeta = "meta"
+
+	// OriginAdmin means that the user is a member of the admin
+	// ACL, so may change the ACL.
+	OriginAdmin = "admin"
+)
+
+// MemberOrigin holds a user related to an ACL
+// and the ways in which it is related.
+type MemberOrigin struct {
+	// User holds the user.
+	User string
+
+	// Origins holds how the user is related to the ACL: some of
+	// OriginMember, OriginMeta and OriginAdmin, in that order.
+	Origins []string
+}
+
+// MemberOrigins returns the members of the ACL with the given name
+// together with the users that may change it, each annotated with how
+// it is related to the ACL. This can be used to find out why a user
+// has access. Users appear once, tagged with every way they gain
+// access, and are sorted by name.
+//
+// Members of the admin ACL are included only if administrators may
+// access the ACL. The admin ACL, the other system ACLs and meta-ACLs
+// are guarded by the admin ACL, as are all ACLs when
+// Params.DisableMetaACLs is set, so there is no separate meta-ACL for
+// them. Deny entries, and the users they deny, are left out.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL or its
+// meta-ACL does not exist.
+func (m *Manager) MemberOrigins(ctx context.Context, aclName string) ([]MemberOrigin, error) {
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	members, err := m.ACL(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	origins := make(map[string][]string)
+	add := func(acl []string, origin string) {
+		for _, u := range m.withoutDenied(acl) {
+			if o := origins[u]; len(o) == 0 || o[len(o)-1] != origin {
+				origins[u] = append(o, origin)
+			}
+		}
+	}
+	add(members, OriginMember)
+	guardedByAdmin := m.isSystemACL(aclName) || isMetaName(aclName) || m.p.DisableMetaACLs
+	if !guardedByAdmin {
+		meta, err := m.ACL(ctx, metaName(aclName))
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+		}
+		add(meta, OriginMeta)
+	}
+	if guardedByAdmin || m.adminBypass() {
+		admins, err := m.ACL(ctx, m.p.AdminACLName)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot get admin ACL", isContextError)
+		}
+		add(admins, OriginAdmin)
+	}
+	result := make([]MemberOrigin, 0, len(origins))
+	for u, o := range origins {
+		result = append(result, MemberOrigin{
+			User:    u,
+			Origins: o,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].User < result[j].User
+	})
+	return result, nil
+}
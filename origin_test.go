@@ -0,0 +1,174 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var memberOriginsTests = []struct {
+	testName      string
+	adminBypass   *bool
+	aclName       string
+	expectOrigins []aclstore.MemberOrigin
+}{{
+	testName: "multiple_paths",
+	aclName:  "one",
+	expectOrigins: []aclstore.MemberOrigin{{
+		User:    "alice",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}, {
+		User:    "bob",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginMeta},
+	}, {
+		User:    "boss",
+		Origins: []string{aclstore.OriginAdmin},
+	}, {
+		User:    "charlie",
+		Origins: []string{aclstore.OriginMeta, aclstore.OriginAdmin},
+	}, {
+		User:    "dave",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginMeta, aclstore.OriginAdmin},
+	}},
+}, {
+	testName:    "no_admin_bypass",
+	adminBypass: newBool(false),
+	aclName:     "one",
+	expectOrigins: []aclstore.MemberOrigin{{
+		User:    "alice",
+		Origins: []string{aclstore.OriginMember},
+	}, {
+		User:    "bob",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginMeta},
+	}, {
+		User:    "charlie",
+		Origins: []string{aclstore.OriginMeta},
+	}, {
+		User:    "dave",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginMeta},
+	}},
+}, {
+	testName:    "admin_acl",
+	adminBypass: newBool(false),
+	aclName:     "admin",
+	expectOrigins: []aclstore.MemberOrigin{{
+		User:    "alice",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}, {
+		User:    "boss",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}, {
+		User:    "charlie",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}, {
+		User:    "dave",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}},
+}, {
+	testName: "meta_acl",
+	aclName:  "_one",
+	expectOrigins: []aclstore.MemberOrigin{{
+		User:    "alice",
+		Origins: []string{aclstore.OriginAdmin},
+	}, {
+		User:    "bob",
+		Origins: []string{aclstore.OriginMember},
+	}, {
+		User:    "boss",
+		Origins: []string{aclstore.OriginAdmin},
+	}, {
+		User:    "charlie",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}, {
+		User:    "dave",
+		Origins: []string{aclstore.OriginMember, aclstore.OriginAdmin},
+	}},
+}}
+
+func TestMemberOrigins(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range memberOriginsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStore(memsimplekv.NewStore())
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             store,
+				InitialAdminUsers: []string{"boss", "alice", "charlie", "dave"},
+				AdminBypass:       test.adminBypass,
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "one", "alice", "bob", "dave")
+			c.Assert(err, qt.Equals, nil)
+			err = store.Set(ctx, "_one", []string{"bob", "charlie", "dave"})
+			c.Assert(err, qt.Equals, nil)
+
+			origins, err := m.MemberOrigins(ctx, test.aclName)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(origins, qt.DeepEquals, test.expectOrigins)
+		})
+	}
+}
+
+func TestMemberOriginsDenied(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		DenyPrefix:        "!",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "one", "alice", "bob", "!bob")
+	c.Assert(err, qt.Equals, nil)
+	origins, err := m.MemberOrigins(ctx, "one")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(origins, qt.DeepEquals, []aclstore.MemberOrigin{{
+		User:    "alice",
+		Origins: []string{aclstore.OriginMember},
+	}, {
+		User:    "boss",
+		Origins: []string{aclstore.OriginAdmin},
+	}})
+
+	_, err = m.MemberOrigins(ctx, "nothere")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestGetMemberOrigins(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "one", "alice", "boss")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/one/origins", nil, http.StatusOK, params.GetMemberOriginsResponse{
+		Members: []params.MemberOrigin{{
+			User:    "alice",
+			Origins: []string{"member"},
+		}, {
+			User:    "boss",
+			Origins: []string{"member", "admin"},
+		}},
+	})
+}
@@ -0,0 +1,59 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package params
+
+// The following error codes are returned in the Code field of the
+// error responses from the HTTP endpoints, so that clients can tell
+// the errors apart without depending on the server package.
+const (
+	// CodeACLNotFound is returned when an ACL name has
+	// not been created.
+	CodeACLNotFound = "ACL not found"
+
+	// CodeUserNotFound is returned when a user to be
+	// removed is not a member of an ACL.
+	CodeUserNotFound = "user not found"
+
+	// CodeRequestTooLarge is returned when a request body is
+	// larger than the configured maximum.
+	CodeRequestTooLarge = "request too large"
+
+	// CodePreconditionFailed is returned when a conditional
+	// request's If-Match header does not match the current
+	// version of an ACL.
+	CodePreconditionFailed = "precondition failed"
+
+	// CodeTooManyACLs is returned when an ACL cannot be created
+	// because the limit on the number of ACLs has been reached.
+	CodeTooManyACLs = "too many ACLs"
+
+	// CodeACLExists is returned when an ACL that is to be
+	// created already exists.
+	CodeACLExists = "ACL already exists"
+
+	// CodeAliasExists is returned when an ACL cannot be created
+	// because its name is used by an alias.
+	CodeAliasExists = "alias already exists"
+
+	// CodeConflictingChanges is returned when a modify request
+	// asks for changes that cannot be made together, such as
+	// adding and removing users at the same time.
+	CodeConflictingChanges = "conflicting changes"
+
+	// CodeNoChanges is returned when a modify request does not
+	// ask for any change to be made.
+	CodeNoChanges = "no changes"
+
+	// CodeMissingUsers is returned when a modify request does not
+	// specify the users that its action requires.
+	CodeMissingUsers = "missing users"
+
+	// CodeUnsupportedMediaType is returned when a request body
+	// does not have a content type that its endpoint accepts.
+	CodeUnsupportedMediaType = "unsupported media type"
+
+	// CodeTooManyRequests is returned when a request is rejected
+	// because of the configured rate limits.
+	CodeTooManyRequests = "too many requests"
+)
@@ -0,0 +1,88 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package params
+
+import "encoding/json"
+
+// DefaultUsersField holds the name of the JSON field that holds
+// the users of an ACL when no other name has been configured.
+const DefaultUsersField = "users"
+
+// MarshalJSON implements json.Marshaler by encoding the users
+// in the field named by r.UsersField.
+func (r SetACLRequestBody) MarshalJSON() ([]byte, error) {
+	type plain SetACLRequestBody
+	return marshalWithUsersField(plain(r), r.UsersField)
+}
+
+// UnmarshalJSON implements json.Unmarshaler by decoding the users
+// from the field named by r.UsersField.
+func (r *SetACLRequestBody) UnmarshalJSON(data []byte) error {
+	type plain SetACLRequestBody
+	field := r.UsersField
+	if err := unmarshalWithUsersField(data, (*plain)(r), field); err != nil {
+		return err
+	}
+	r.UsersField = field
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler by encoding the users
+// in the field named by r.UsersField.
+func (r GetACLResponse) MarshalJSON() ([]byte, error) {
+	type plain GetACLResponse
+	return marshalWithUsersField(plain(r), r.UsersField)
+}
+
+// UnmarshalJSON implements json.Unmarshaler by decoding the users
+// from the field named by r.UsersField.
+func (r *GetACLResponse) UnmarshalJSON(data []byte) error {
+	type plain GetACLResponse
+	field := r.UsersField
+	if err := unmarshalWithUsersField(data, (*plain)(r), field); err != nil {
+		return err
+	}
+	r.UsersField = field
+	return nil
+}
+
+// marshalWithUsersField marshals v, which must encode as a JSON
+// object, with its users field renamed to the given field.
+func marshalWithUsersField(v interface{}, field string) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil || field == "" || field == DefaultUsersField {
+		return data, err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if users, ok := fields[DefaultUsersField]; ok {
+		delete(fields, DefaultUsersField)
+		fields[field] = users
+	}
+	return json.Marshal(fields)
+}
+
+// unmarshalWithUsersField unmarshals data into v, reading
+// the users from the given field.
+func unmarshalWithUsersField(data []byte, v interface{}, field string) error {
+	if field == "" || field == DefaultUsersField {
+		return json.Unmarshal(data, v)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	delete(fields, DefaultUsersField)
+	if users, ok := fields[field]; ok {
+		delete(fields, field)
+		fields[DefaultUsersField] = users
+	}
+	data, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
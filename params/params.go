@@ -3,7 +3,11 @@
 
 package params
 
-import "gopkg.in/httprequest.v1"
+import (
+	"encoding/json"
+
+	"gopkg.in/httprequest.v1"
+)
 
 // SetACLRequest holds parameters for an aclstore.Manager.SetACL call.
 type SetACLRequest struct {
@@ -11,6 +15,10 @@ type SetACLRequest struct {
 	Body              SetACLRequestBody `httprequest:",body"`
 	// Name holds the name of the ACL to change.
 	Name string `httprequest:"name,path"`
+	// IfMatch, if non-empty, holds the version token (as returned
+	// in the ETag header by GetACL) that the ACL must have for the
+	// change to be made. The special value "*" matches any version.
+	IfMatch string `httprequest:"If-Match,header,omitempty"`
 }
 
 // ACLName returns the name of the ACL that's being set.
@@ -21,6 +29,38 @@ func (r SetACLRequest) ACLName() string {
 // SetACLRequestBody holds the HTTP body for an aclstore.Manager.SetACL call.
 type SetACLRequestBody struct {
 	Users []string `json:"users"`
+	// UsersField, if non-empty, holds the name of the JSON
+	// field that holds Users. If it is empty,
+	// DefaultUsersField is used.
+	UsersField string `json:"-"`
+}
+
+// CreateACLRequest holds parameters for an aclstore.Manager.CreateACL call.
+type CreateACLRequest struct {
+	httprequest.Route `httprequest:"PUT /:name/create"`
+	Body              CreateACLRequestBody `httprequest:",body"`
+	// Name holds the name of the ACL to create.
+	Name string `httprequest:"name,path"`
+	// FailIfExists specifies that the request should fail
+	// with a conflict error if the ACL already exists, rather
+	// than doing nothing.
+	FailIfExists bool `httprequest:"failIfExists,form,omitempty"`
+}
+
+// ACLName returns the name of the ACL that's being created.
+func (r CreateACLRequest) ACLName() string {
+	return r.Name
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r CreateACLRequest) AdminOnly() bool {
+	return true
+}
+
+// CreateACLRequestBody holds the HTTP body for an aclstore.Manager.CreateACL call.
+type CreateACLRequestBody struct {
+	// Users holds the initial members of the ACL.
+	Users []string `json:"users"`
 }
 
 // ModifyACLRequest holds parameters for an aclstore.Manager.ModifyACL call.
@@ -29,8 +69,22 @@ type ModifyACLRequest struct {
 	Body              ModifyACLRequestBody `httprequest:",body"`
 	// Name holds the name of the ACL to change.
 	Name string `httprequest:"name,path"`
+	// Action, if non-empty, specifies an action to perform
+	// instead of adding or removing users: one of ActionClear
+	// or ActionSwap.
+	Action string `httprequest:"action,form,omitempty"`
 }
 
+const (
+	// ActionClear is the ModifyACLRequest action that removes
+	// all the members of an ACL.
+	ActionClear = "clear"
+
+	// ActionSwap is the ModifyACLRequest action that atomically
+	// replaces the member OldUser with NewUser.
+	ActionSwap = "swap"
+)
+
 // ACLName returns the name of the ACL that's being modified.
 func (r ModifyACLRequest) ACLName() string {
 	return r.Name
@@ -43,35 +97,606 @@ type ModifyACLRequestBody struct {
 	Add []string `json:"add,omitempty"`
 	// Remove specifies users to remove from the ACL.
 	Remove []string `json:"remove,omitempty"`
+	// OldUser and NewUser specify the users to swap
+	// when the action is ActionSwap.
+	OldUser string `json:"old-user,omitempty"`
+	NewUser string `json:"new-user,omitempty"`
+	// Force specifies that, when the action is ActionSwap,
+	// NewUser should be added even if OldUser is not
+	// a member of the ACL.
+	Force bool `json:"force,omitempty"`
+}
+
+// ModifyACLResponse holds the response body from an
+// aclstore.Manager.ModifyACL call.
+type ModifyACLResponse struct {
+	// Added holds the number of users that were added to the
+	// ACL, not counting those that were already members. It is
+	// only set when users are added.
+	Added int `json:"added,omitempty"`
+
+	// Removed holds the users that were removed from the ACL,
+	// not including those that were not members. It is only
+	// set when users are removed.
+	Removed []string `json:"removed,omitempty"`
+}
+
+// PatchACLRequest holds parameters for an aclstore.Manager.PatchACL call.
+type PatchACLRequest struct {
+	httprequest.Route `httprequest:"PATCH /:name"`
+	// Name holds the name of the ACL to change.
+	Name string `httprequest:"name,path"`
+	// ContentType holds the media type of the body, which should be
+	// JSONPatchContentType or MergePatchContentType. If it is
+	// "application/json", the type of patch is inferred from the body.
+	ContentType string `httprequest:"Content-Type,header,omitempty"`
+	// Body holds the patch: a JSON Patch document holding a
+	// list of PatchOperation values, or a JSON Merge Patch
+	// document holding an object like SetACLRequestBody.
+	Body json.RawMessage `httprequest:",body"`
+}
+
+// ACLName returns the name of the ACL that's being patched.
+func (r PatchACLRequest) ACLName() string {
+	return r.Name
+}
+
+// The following media types are accepted by PatchACL.
+const (
+	// JSONPatchContentType is the media type of a JSON Patch
+	// document, as defined by RFC 6902.
+	JSONPatchContentType = "application/json-patch+json"
+
+	// MergePatchContentType is the media type of a JSON Merge
+	// Patch document, as defined by RFC 7396.
+	MergePatchContentType = "application/merge-patch+json"
+)
+
+// PatchOperation holds a single operation in a JSON Patch document.
+// The path of each operation refers to the members of the ACL as
+// returned by GetACL: "/users" refers to the list of members and
+// "/users/N" to the Nth member, counting from zero. The path
+// "/users/-" may be used to add a member.
+type PatchOperation struct {
+	// Op holds the operation: "add", "remove", "replace" or "test".
+	Op string `json:"op"`
+	// Path holds the JSON Pointer to the value to operate on.
+	Path string `json:"path"`
+	// Value holds the value for the add, replace and test operations.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// ImportMembersRequest holds parameters for an
+// aclstore.Manager.ImportMembers call. The request body, which is
+// not held here so that it can be streamed, holds a JSON string for
+// each user to add, one per line, and must have the media type
+// NDJSONContentType.
+type ImportMembersRequest struct {
+	httprequest.Route `httprequest:"POST /:name/import"`
+	// Name holds the name of the ACL to add users to.
+	Name string `httprequest:"name,path"`
+	// ContentType holds the media type of the body.
+	ContentType string `httprequest:"Content-Type,header,omitempty"`
+}
+
+// ACLName returns the name of the ACL that users are being imported into.
+func (r ImportMembersRequest) ACLName() string {
+	return r.Name
+}
+
+// NDJSONContentType is the media type of a body holding
+// newline-delimited JSON values, as accepted by ImportMembers.
+const NDJSONContentType = "application/x-ndjson"
+
+// ImportMembersResponse holds the response body returned by an
+// aclstore.Manager.ImportMembers call.
+type ImportMembersResponse struct {
+	// Added holds the number of users that were added to the
+	// ACL, not counting those that were already members.
+	Added int `json:"added"`
 }
 
 // GetACLRequest holds parameters for an aclstore.Manager.GetACL call.
 type GetACLRequest struct {
 	httprequest.Route `httprequest:"GET /:name"`
 	Name              string `httprequest:"name,path"`
+	// WithMeta specifies that the members of the meta-ACL
+	// should be returned too.
+	WithMeta bool `httprequest:"withMeta,form,omitempty"`
+	// Version specifies the version of the response envelope to
+	// return. If it is zero, ResponseVersion1 is used.
+	Version int `httprequest:"v,form,omitempty"`
+	// Format, if non-empty, specifies the format of the response
+	// instead of the JSON envelope. The only format supported is
+	// FormatCSV, with which the request is served as a
+	// GetACLCSVRequest.
+	Format string `httprequest:"format,form,omitempty"`
 }
 
+const (
+	// FormatCSV is the GetACLRequest format that returns the
+	// members of an ACL as CSV, as GetACLCSVRequest does.
+	FormatCSV = "csv"
+
+	// CSVUserHeader holds the heading of the column of
+	// members in the CSV format.
+	CSVUserHeader = "user"
+
+	// CSVContentType is the media type of a response
+	// in the CSV format.
+	CSVContentType = "text/csv; charset=utf-8"
+)
+
+// These constants specify versions of the GetACL response envelope.
+const (
+	// ResponseVersion1 is the original response envelope, which
+	// holds only the members of the ACL and, if requested, the
+	// members of its meta-ACL.
+	ResponseVersion1 = 1
+
+	// ResponseVersion2 is the enriched response envelope, which
+	// also holds the version and its number of members.
+	ResponseVersion2 = 2
+
+	// LatestResponseVersion holds the newest response envelope
+	// version.
+	LatestResponseVersion = ResponseVersion2
+)
+
 // ACLName returns the name of the ACL that's being retrieved.
 func (r GetACLRequest) ACLName() string {
 	return r.Name
 }
 
+// GetACLCSVRequest holds parameters for an aclstore.Manager.GetACLCSV
+// call, which returns the members of an ACL as CSV, with a header row
+// holding CSVUserHeader followed by a row for each member.
+type GetACLCSVRequest struct {
+	httprequest.Route `httprequest:"GET /:name/csv"`
+	Name              string `httprequest:"name,path"`
+}
+
+// ACLName returns the name of the ACL that's being retrieved.
+func (r GetACLCSVRequest) ACLName() string {
+	return r.Name
+}
+
 // GetACLResponse holds the response body returned by an aclstore.Manager.GetACL call.
 type GetACLResponse struct {
 	Users []string `json:"users"`
+	// Managers holds the members of the meta-ACL when
+	// WithMeta was specified in the request. It is omitted
+	// if the meta-ACL is empty.
+	Managers []string `json:"managers,omitempty"`
+	// Version holds the version of the response envelope. It is
+	// omitted from ResponseVersion1 responses, so is zero for them.
+	Version int `json:"version,omitempty"`
+	// ETag holds the version token of the ACL, as also returned
+	// in the ETag header. It is only set in responses of
+	// ResponseVersion2 or later.
+	ETag string `json:"etag,omitempty"`
+	// Count holds the number of members of the ACL. It is only
+	// set in responses of ResponseVersion2 or later, so that
+	// ResponseVersion1 responses are unchanged.
+	Count *int `json:"count,omitempty"`
+	// UsersField, if non-empty, holds the name of the JSON
+	// field that holds Users. If it is empty,
+	// DefaultUsersField is used.
+	UsersField string `json:"-"`
+}
+
+// GetManagersRequest holds parameters for an aclstore.Manager.GetManagers call.
+type GetManagersRequest struct {
+	httprequest.Route `httprequest:"GET /:name/managers"`
+	Name              string `httprequest:"name,path"`
+}
+
+// ACLName returns the name of the ACL whose managers are being retrieved.
+func (r GetManagersRequest) ACLName() string {
+	return r.Name
+}
+
+// GetManagersResponse holds the response body returned by an aclstore.Manager.GetManagers call.
+type GetManagersResponse struct {
+	Users []string `json:"users"`
+}
+
+// GetMemberOriginsRequest holds parameters for an aclstore.Manager.GetMemberOrigins call.
+type GetMemberOriginsRequest struct {
+	httprequest.Route `httprequest:"GET /:name/origins"`
+	Name              string `httprequest:"name,path"`
+}
+
+// ACLName returns the name of the ACL whose member origins are being retrieved.
+func (r GetMemberOriginsRequest) ACLName() string {
+	return r.Name
+}
+
+// GetMemberOriginsResponse holds the response body returned by an aclstore.Manager.GetMemberOrigins call.
+type GetMemberOriginsResponse struct {
+	Members []MemberOrigin `json:"members"`
+}
+
+// MemberOrigin holds a user related to an ACL and
+// the ways in which it is related: some of "member",
+// "meta" and "admin".
+type MemberOrigin struct {
+	User    string   `json:"user"`
+	Origins []string `json:"origins"`
+}
+
+// IsMemberRequest holds parameters for an aclstore.Manager.IsMember call.
+type IsMemberRequest struct {
+	httprequest.Route `httprequest:"GET /:name/members/:user"`
+	// Name holds the name of the ACL to check.
+	Name string `httprequest:"name,path"`
+	// User holds the name of the user to look for.
+	User string `httprequest:"user,path"`
+}
+
+// ACLName returns the name of the ACL that's being checked.
+func (r IsMemberRequest) ACLName() string {
+	return r.Name
+}
+
+// IsMemberResponse holds the response body returned by an aclstore.Manager.IsMember call.
+type IsMemberResponse struct {
+	// Member holds whether the user is a member of the ACL.
+	Member bool `json:"member"`
+}
+
+// MembersInRequest holds parameters for an aclstore.Manager.MembersIn call.
+type MembersInRequest struct {
+	httprequest.Route `httprequest:"POST /:name/members"`
+	Body              MembersInRequestBody `httprequest:",body"`
+	// Name holds the name of the ACL to check.
+	Name string `httprequest:"name,path"`
+}
+
+// ACLName returns the name of the ACL that's being checked.
+func (r MembersInRequest) ACLName() string {
+	return r.Name
+}
+
+// MembersInRequestBody holds the HTTP body for an aclstore.Manager.MembersIn call.
+type MembersInRequestBody struct {
+	// Candidates holds the users to look for.
+	Candidates []string `json:"candidates"`
+}
+
+// MembersInResponse holds the response body returned by an aclstore.Manager.MembersIn call.
+type MembersInResponse struct {
+	// Users holds the candidates that are members of the ACL.
+	Users []string `json:"users"`
+}
+
+// SearchMembersRequest holds parameters for an
+// aclstore.Manager.SearchMembers call.
+type SearchMembersRequest struct {
+	httprequest.Route `httprequest:"GET /:name/members"`
+	// Name holds the name of the ACL to search.
+	Name string `httprequest:"name,path"`
+	// Query holds the text to search for.
+	Query string `httprequest:"q,form"`
+}
+
+// ACLName returns the name of the ACL that's being searched.
+func (r SearchMembersRequest) ACLName() string {
+	return r.Name
+}
+
+// SearchMembersResponse holds the response body returned
+// by an aclstore.Manager.SearchMembers call.
+type SearchMembersResponse struct {
+	// Users holds the members of the ACL that match the query.
+	Users []string `json:"users"`
+	// Truncated holds whether there were more matching
+	// members than were returned.
+	Truncated bool `json:"truncated,omitempty"`
 }
 
 // GetACLsRequest holds parameters for an aclstore.Manager.GetACLs call.
 type GetACLsRequest struct {
 	httprequest.Route `httprequest:"GET /"`
+	// IncludeMeta specifies that meta-ACLs should
+	// be included in the response.
+	IncludeMeta bool `httprequest:"includeMeta,form"`
+	// Sort specifies the order of the ACLs in the response: one of
+	// SortByName (the default) or SortBySize.
+	Sort string `httprequest:"sort,form,omitempty"`
+	// Desc specifies that the ACLs should be
+	// sorted in descending order.
+	Desc bool `httprequest:"desc,form"`
+	// Prefix, if non-empty, restricts the response to
+	// ACLs with names that start with it.
+	Prefix string `httprequest:"prefix,form,omitempty"`
+	// Detail specifies that the members of each
+	// ACL should be included in the response.
+	Detail bool `httprequest:"detail,form"`
+	// Counts specifies that the number of members of
+	// each ACL should be included in the response.
+	Counts bool `httprequest:"counts,form"`
+	// Offset holds the number of matching ACLs, in the
+	// requested order, to skip before the returned page.
+	Offset int `httprequest:"offset,form,omitempty"`
+	// Limit, if non-zero, holds the maximum number of
+	// ACLs in the returned page.
+	Limit int `httprequest:"limit,form,omitempty"`
+	// Count specifies that the total number of matching
+	// ACLs should be included in the response.
+	Count bool `httprequest:"count,form"`
+	// IfNoneMatch, if non-empty, holds version tokens (as returned
+	// in the ETag header of an earlier response). If the listing
+	// matches any of them, it is not returned again.
+	IfNoneMatch string `httprequest:"If-None-Match,header,omitempty"`
+	// Manageable specifies that only the ACLs that the
+	// authenticated user may change should be included in the
+	// response. Users that are not administrators may only
+	// list ACLs when it is set.
+	Manageable bool `httprequest:"manageable,form"`
 }
 
-// ACLName returns the name of the ACL that's being retrieved.
+// The following values may be used for GetACLsRequest.Sort.
+const (
+	// SortByName sorts ACLs lexically by name.
+	SortByName = "name"
+
+	// SortBySize sorts ACLs by the number of members,
+	// with ACLs of the same size sorted by name.
+	SortBySize = "size"
+)
+
+// ACLName returns the empty string because the request
+// does not refer to a single ACL.
 func (r GetACLsRequest) ACLName() string {
-	return "admin"
+	return ""
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r GetACLsRequest) AdminOnly() bool {
+	return true
 }
 
 // GetACLsResponse holds the response body returned by an aclstore.Manager.GetACLs call.
 type GetACLsResponse struct {
 	ACLs []string `json:"acls"`
+	// Members holds the members of each ACL in ACLs.
+	// It is only set when detail is requested.
+	Members map[string][]string `json:"members,omitempty"`
+	// Counts holds the number of members of each ACL in ACLs.
+	// It is only set when counts are requested.
+	Counts map[string]int `json:"counts,omitempty"`
+	// Truncated is set when detail is requested and more
+	// ACLs matched than could be included in the response.
+	Truncated bool `json:"truncated,omitempty"`
+	// Total holds the number of ACLs that matched the request
+	// before the page was taken from them. It is only set when
+	// the count is requested. It is computed when the first page
+	// of a listing is read and reused for the later pages for a
+	// while, so it may be approximate if ACLs are created or
+	// deleted while a client reads several pages.
+	Total *int `json:"total,omitempty"`
+}
+
+// DeleteACLsRequest holds parameters for an aclstore.Manager.DeleteACLs call.
+type DeleteACLsRequest struct {
+	httprequest.Route `httprequest:"DELETE /"`
+	// Prefix holds the prefix of the names of the ACLs to delete.
+	Prefix string `httprequest:"prefix,form"`
+}
+
+// ACLName returns the empty string because the request
+// does not refer to a single ACL.
+func (r DeleteACLsRequest) ACLName() string {
+	return ""
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r DeleteACLsRequest) AdminOnly() bool {
+	return true
+}
+
+// DeleteACLsResponse holds the response body returned by an aclstore.Manager.DeleteACLs call.
+type DeleteACLsResponse struct {
+	// Count holds the number of ACLs deleted.
+	Count int `json:"count"`
+	// Deleted holds the names of the ACLs deleted.
+	Deleted []string `json:"deleted"`
+	// Failed maps the name of each matching ACL that could
+	// not be deleted to an error message.
+	Failed map[string]string `json:"failed,omitempty"`
+}
+
+// GetEffectiveACLsRequest holds parameters for an aclstore.Manager.GetEffectiveACLs call.
+type GetEffectiveACLsRequest struct {
+	httprequest.Route `httprequest:"GET /users/:user/acls"`
+	// User holds the name of the user to look up.
+	User string `httprequest:"user,path"`
+}
+
+// ACLName returns the empty string because the request
+// does not refer to a single ACL.
+func (r GetEffectiveACLsRequest) ACLName() string {
+	return ""
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r GetEffectiveACLsRequest) AdminOnly() bool {
+	return true
+}
+
+// GetEffectiveACLsResponse holds the response body returned by an aclstore.Manager.GetEffectiveACLs call.
+type GetEffectiveACLsResponse struct {
+	// ACLs holds the names of the ACLs that the user is a member of.
+	// If the user is an administrator, it also holds "*".
+	ACLs []string `json:"acls"`
+}
+
+// GetStatsRequest holds parameters for an aclstore.Manager.GetStats call.
+type GetStatsRequest struct {
+	httprequest.Route `httprequest:"GET /stats"`
+}
+
+// ACLName returns the empty string because the request
+// does not refer to a single ACL.
+func (r GetStatsRequest) ACLName() string {
+	return ""
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r GetStatsRequest) AdminOnly() bool {
+	return true
+}
+
+// GetStatsResponse holds the response body returned by an aclstore.Manager.GetStats call.
+// Meta-ACLs are not included in the statistics.
+type GetStatsResponse struct {
+	// ACLs holds the number of ACLs.
+	ACLs int `json:"acls"`
+	// Users holds the number of distinct users that are
+	// members of any ACL.
+	Users int `json:"users"`
+	// AverageSize holds the average number of members of an ACL.
+	AverageSize float64 `json:"average-size"`
+}
+
+// WhoAmIRequest holds parameters for an aclstore.Manager.WhoAmI call.
+type WhoAmIRequest struct {
+	httprequest.Route `httprequest:"GET /whoami"`
+}
+
+// ACLName returns the empty string because the request
+// is not guarded by any ACL.
+func (r WhoAmIRequest) ACLName() string {
+	return ""
+}
+
+// WhoAmIResponse holds the response body returned by an aclstore.Manager.WhoAmI call.
+type WhoAmIResponse struct {
+	// Read holds the names of the ACLs that the caller may read.
+	Read []string `json:"read"`
+	// Modify holds the names of the ACLs that the caller may change.
+	Modify []string `json:"modify"`
+}
+
+// IsAdminRequest holds parameters for an aclstore.Manager.IsAdmin call.
+type IsAdminRequest struct {
+	httprequest.Route `httprequest:"GET /me/admin"`
+}
+
+// ACLName returns the empty string because the request
+// is not guarded by any ACL.
+func (r IsAdminRequest) ACLName() string {
+	return ""
+}
+
+// IsAdminResponse holds the response body returned by an aclstore.Manager.IsAdmin call.
+type IsAdminResponse struct {
+	// Admin reports whether the caller is an administrator.
+	Admin bool `json:"admin"`
+}
+
+// AuthorizeRequest holds parameters for an aclstore.Manager.Authorize call.
+type AuthorizeRequest struct {
+	httprequest.Route `httprequest:"POST /authorize"`
+	Body              AuthorizeRequestBody `httprequest:",body"`
+}
+
+// ACLName returns the empty string because each of the
+// requested ACLs is authorized separately.
+func (r AuthorizeRequest) ACLName() string {
+	return ""
+}
+
+// AuthorizeRequestBody holds the body of an AuthorizeRequest. At most
+// one of User and Token may be set; if neither is, the caller is
+// authorized.
+type AuthorizeRequestBody struct {
+	// User holds the name of the user to authorize.
+	User string `json:"user,omitempty"`
+	// Token holds a token that identifies the user to authorize.
+	Token string `json:"token,omitempty"`
+	// ACLs holds the names of the ACLs to authorize the user
+	// against. At most 1000 names may be given.
+	ACLs []string `json:"acls"`
+}
+
+// AuthorizeResponse holds the response body returned by an aclstore.Manager.Authorize call.
+type AuthorizeResponse struct {
+	// Decisions holds the decision for each of the requested
+	// ACLs, in the order that they were requested.
+	Decisions []AuthorizeDecision `json:"decisions"`
+}
+
+// AuthorizeDecision holds whether a user is allowed by an ACL.
+type AuthorizeDecision struct {
+	// ACL holds the name of the ACL.
+	ACL string `json:"acl"`
+	// Allowed holds whether the user is allowed by the ACL.
+	Allowed bool `json:"allowed"`
+	// NotFound holds whether the ACL does not exist, in
+	// which case Allowed is false. It is not set when
+	// users authorize themselves, so that they can't find
+	// out which ACLs exist.
+	NotFound bool `json:"not-found,omitempty"`
+}
+
+// ReplaceAdminsRequest holds parameters for an aclstore.Manager.ReplaceAdmins call.
+type ReplaceAdminsRequest struct {
+	httprequest.Route `httprequest:"PUT /admin/replace"`
+	Body              ReplaceAdminsRequestBody `httprequest:",body"`
+}
+
+// ACLName returns the empty string because the name of the
+// admin ACL is configured by the server.
+func (r ReplaceAdminsRequest) ACLName() string {
+	return ""
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r ReplaceAdminsRequest) AdminOnly() bool {
+	return true
+}
+
+// ReplaceAdminsRequestBody holds the HTTP body for an aclstore.Manager.ReplaceAdmins call.
+type ReplaceAdminsRequestBody struct {
+	// Users holds the new members of the admin ACL.
+	Users []string `json:"users"`
+	// Force allows the new members to exclude all
+	// the current members of the admin ACL.
+	Force bool `json:"force,omitempty"`
+}
+
+// RebuildIndexRequest holds parameters for an aclstore.Manager.RebuildIndex call.
+type RebuildIndexRequest struct {
+	httprequest.Route `httprequest:"POST /admin/rebuild-index"`
+}
+
+// ACLName returns the empty string because the request
+// does not refer to a single ACL.
+func (r RebuildIndexRequest) ACLName() string {
+	return ""
+}
+
+// AdminOnly reports that only administrators may make the request.
+func (r RebuildIndexRequest) AdminOnly() bool {
+	return true
+}
+
+// ACLChange holds the body of a notification sent to a webhook
+// when an ACL has been changed.
+type ACLChange struct {
+	// Name holds the name of the ACL that has changed.
+	Name string `json:"name"`
+	// Operation holds the kind of change that was made:
+	// one of "create", "set", "add", "remove", "clear", "delete", "restore" or "swap".
+	Operation string `json:"operation"`
+	// Users holds the users specified in the operation.
+	Users []string `json:"users"`
+	// Actor holds the name of the identity that made the change,
+	// if the identity has a name. It is omitted otherwise.
+	Actor string `json:"actor,omitempty"`
+	// Tenant holds the tenant whose ACL has changed, if the
+	// change was made for a tenant. It is omitted otherwise.
+	Tenant string `json:"tenant,omitempty"`
 }
@@ -0,0 +1,274 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime"
+	"strconv"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// errBadPatch is the error cause used when
+// a PATCH request body is not valid.
+var errBadPatch = errgo.Newf("bad patch")
+
+// patchUsersPath holds the JSON Pointer to the members of
+// an ACL in the JSON representation used for patches.
+const patchUsersPath = "/users"
+
+// PatchACL changes the members of the ACL with the requested name
+// as described by a JSON Patch or JSON Merge Patch document. The
+// patch is applied atomically to the members as returned by GetACL;
+// if a test operation fails, no change is made and a precondition
+// failed error is returned.
+// Only administrators and members of the meta-ACL for the name
+// may access this endpoint. The meta-ACL for meta-ACLs is "admin".
+func (h handler1) PatchACL(p httprequest.Params, req *params.PatchACLRequest) error {
+	patch, err := parsePatch(req.ContentType, req.Body)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(errBadPatch))
+	}
+	name, err := h.h.m.resolveAlias(p.Context, req.Name)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	err = h.h.m.patchACL(p.Context, name, patch)
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrPreconditionFailed), errgo.Is(errBadPatch))
+}
+
+// patchACL atomically replaces the members of the given ACL with the
+// result of applying the given patch function to them, and reports
+// the users that were added and removed.
+func (m *Manager) patchACL(ctx context.Context, aclName string, patch func(users []string) ([]string, error)) error {
+	updater, ok := m.p.Store.(ACLUpdater)
+	if !ok {
+		return errgo.Newf("cannot patch ACL atomically")
+	}
+	var before, after []string
+	err := updater.Update(ctx, aclName, func(current []string) ([]string, error) {
+		before = copyUsers(current)
+		users, err := patch(copyUsers(current))
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrPreconditionFailed), errgo.Is(errBadPatch))
+		}
+		if err := m.checkUsersAllowed(ctx, usersNotIn(users, current)); err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+		}
+		after = users
+		return users, nil
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrPreconditionFailed), errgo.Is(errBadPatch), isContextError)
+	}
+	if added := usersNotIn(after, before); len(added) > 0 {
+		m.changed(ctx, aclName, OpAdd, added)
+	}
+	if removed := usersNotIn(before, after); len(removed) > 0 {
+		m.changed(ctx, aclName, OpRemove, removed)
+	}
+	return nil
+}
+
+// usersNotIn returns the users in a that are not in b.
+func usersNotIn(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, u := range b {
+		inB[u] = true
+	}
+	var users []string
+	for _, u := range a {
+		if !inB[u] {
+			users = append(users, u)
+			inB[u] = true
+		}
+	}
+	return users
+}
+
+// parsePatch parses and validates a PATCH request body with the given
+// content type, and returns a function that applies it to the members
+// of an ACL. Errors have an errBadPatch cause.
+func parsePatch(contentType string, body []byte) (func(users []string) ([]string, error), error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case params.JSONPatchContentType:
+		return parseJSONPatch(body)
+	case params.MergePatchContentType:
+		return parseMergePatch(body)
+	case "application/json", "":
+		if bytes.HasPrefix(bytes.TrimSpace(body), []byte("[")) {
+			return parseJSONPatch(body)
+		}
+		return parseMergePatch(body)
+	}
+	return nil, errgo.WithCausef(nil, errBadPatch, "unsupported patch content type %q", mediaType)
+}
+
+// patchOp holds a parsed JSON Patch operation.
+type patchOp struct {
+	op   string
+	path string
+
+	// index holds the index of the member referred to
+	// by the path, or -1 if the path refers to all the
+	// members. It is len(users) for the "-" path element.
+	index int
+	end   bool
+
+	// users holds the value of the operation if the
+	// path refers to all the members, and user holds
+	// the value if it refers to a single member.
+	users []string
+	user  string
+}
+
+// parseJSONPatch parses a JSON Patch document.
+func parseJSONPatch(body []byte) (func(users []string) ([]string, error), error) {
+	var ops []params.PatchOperation
+	if err := json.Unmarshal(body, &ops); err != nil {
+		return nil, errgo.WithCausef(nil, errBadPatch, "cannot unmarshal JSON patch: %v", err)
+	}
+	parsed := make([]patchOp, len(ops))
+	for i, op := range ops {
+		p, err := parsePatchOp(op)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(errBadPatch))
+		}
+		parsed[i] = p
+	}
+	return func(users []string) ([]string, error) {
+		for _, op := range parsed {
+			var err error
+			users, err = op.apply(users)
+			if err != nil {
+				return nil, errgo.Mask(err, errgo.Is(ErrPreconditionFailed), errgo.Is(errBadPatch))
+			}
+		}
+		return users, nil
+	}, nil
+}
+
+// parsePatchOp parses and validates a single JSON Patch operation.
+func parsePatchOp(op params.PatchOperation) (patchOp, error) {
+	switch op.Op {
+	case "add", "remove", "replace", "test":
+	default:
+		return patchOp{}, errgo.WithCausef(nil, errBadPatch, "unsupported patch operation %q", op.Op)
+	}
+	p := patchOp{
+		op:    op.Op,
+		path:  op.Path,
+		index: -1,
+	}
+	switch {
+	case op.Path == patchUsersPath:
+	case op.Path == patchUsersPath+"/-" && op.Op == "add":
+		p.end = true
+	case strings.HasPrefix(op.Path, patchUsersPath+"/"):
+		s := strings.TrimPrefix(op.Path, patchUsersPath+"/")
+		i, err := strconv.Atoi(s)
+		if err != nil || i < 0 || s != strconv.Itoa(i) {
+			return patchOp{}, errgo.WithCausef(nil, errBadPatch, "invalid patch path %q", op.Path)
+		}
+		p.index = i
+	default:
+		return patchOp{}, errgo.WithCausef(nil, errBadPatch, "invalid patch path %q", op.Path)
+	}
+	if op.Op == "remove" {
+		if p.index < 0 {
+			return patchOp{}, errgo.WithCausef(nil, errBadPatch, "cannot remove %q", op.Path)
+		}
+		return p, nil
+	}
+	if len(op.Value) == 0 {
+		return patchOp{}, errgo.WithCausef(nil, errBadPatch, "missing value in %q operation", op.Op)
+	}
+	var v interface{} = &p.user
+	if p.index < 0 && !p.end {
+		v = &p.users
+	}
+	if err := json.Unmarshal(op.Value, v); err != nil {
+		return patchOp{}, errgo.WithCausef(nil, errBadPatch, "invalid value in %q operation on %q: %v", op.Op, op.Path, err)
+	}
+	return p, nil
+}
+
+// apply applies the operation to the given users.
+func (p patchOp) apply(users []string) ([]string, error) {
+	if p.index >= len(users) && !(p.op == "add" && p.index == len(users)) {
+		return nil, errgo.WithCausef(nil, errBadPatch, "patch path %q out of range", p.path)
+	}
+	switch {
+	case p.end:
+		return append(users, p.user), nil
+	case p.op == "test" && p.index < 0:
+		if !equalUsers(users, p.users) {
+			return nil, errgo.WithCausef(nil, ErrPreconditionFailed, "patch test failed at %q", p.path)
+		}
+	case p.op == "test":
+		if users[p.index] != p.user {
+			return nil, errgo.WithCausef(nil, ErrPreconditionFailed, "patch test failed at %q", p.path)
+		}
+	case p.index < 0:
+		// Adding or replacing the whole list replaces it.
+		return append([]string(nil), p.users...), nil
+	case p.op == "add":
+		users = append(users, "")
+		copy(users[p.index+1:], users[p.index:])
+		users[p.index] = p.user
+	case p.op == "replace":
+		users[p.index] = p.user
+	case p.op == "remove":
+		users = append(users[:p.index], users[p.index+1:]...)
+	}
+	return users, nil
+}
+
+// equalUsers reports whether a and b hold the same users in the same order.
+func equalUsers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMergePatch parses a JSON Merge Patch document. As the members
+// of an ACL are held in a list, a merge patch can only replace them.
+func parseMergePatch(body []byte) (func(users []string) ([]string, error), error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return nil, errgo.WithCausef(nil, errBadPatch, "merge patch is not a JSON object")
+	}
+	for name := range fields {
+		if name != params.DefaultUsersField {
+			return nil, errgo.WithCausef(nil, errBadPatch, "unsupported field %q in merge patch", name)
+		}
+	}
+	data, ok := fields[params.DefaultUsersField]
+	if !ok {
+		return func(users []string) ([]string, error) {
+			return users, nil
+		}, nil
+	}
+	var newUsers []string
+	if err := json.Unmarshal(data, &newUsers); err != nil || newUsers == nil {
+		return nil, errgo.WithCausef(nil, errBadPatch, "invalid %q field in merge patch", params.DefaultUsersField)
+	}
+	return func([]string) ([]string, error) {
+		return append([]string(nil), newUsers...), nil
+	}, nil
+}
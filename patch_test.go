@@ -0,0 +1,229 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var patchACLTests = []struct {
+	testName      string
+	contentType   string
+	body          string
+	expectStatus  int
+	expectError   string
+	expectUsers   []string
+	expectChanges []params.ACLChange
+}{{
+	testName:     "add",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "add", "path": "/users/-", "value": "charlie"}, {"op": "add", "path": "/users/0", "value": "aaron"}]`,
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"aaron", "alice", "bob", "charlie"},
+	expectChanges: []params.ACLChange{{
+		Name:      "someacl",
+		Operation: aclstore.OpAdd,
+		Users:     []string{"aaron", "charlie"},
+		Actor:     "boss",
+	}},
+}, {
+	testName:     "remove",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "test", "path": "/users/0", "value": "alice"}, {"op": "remove", "path": "/users/0"}]`,
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"bob"},
+	expectChanges: []params.ACLChange{{
+		Name:      "someacl",
+		Operation: aclstore.OpRemove,
+		Users:     []string{"alice"},
+		Actor:     "boss",
+	}},
+}, {
+	testName:     "replace",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "replace", "path": "/users/1", "value": "charlie"}]`,
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"alice", "charlie"},
+	expectChanges: []params.ACLChange{{
+		Name:      "someacl",
+		Operation: aclstore.OpAdd,
+		Users:     []string{"charlie"},
+		Actor:     "boss",
+	}, {
+		Name:      "someacl",
+		Operation: aclstore.OpRemove,
+		Users:     []string{"bob"},
+		Actor:     "boss",
+	}},
+}, {
+	testName:     "inferred_from_JSON_body",
+	contentType:  "application/json",
+	body:         `[{"op": "remove", "path": "/users/1"}]`,
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"alice"},
+	expectChanges: []params.ACLChange{{
+		Name:      "someacl",
+		Operation: aclstore.OpRemove,
+		Users:     []string{"bob"},
+		Actor:     "boss",
+	}},
+}, {
+	testName:     "merge_patch",
+	contentType:  params.MergePatchContentType,
+	body:         `{"users": ["bob", "daisy"]}`,
+	expectStatus: http.StatusOK,
+	expectUsers:  []string{"bob", "daisy"},
+	expectChanges: []params.ACLChange{{
+		Name:      "someacl",
+		Operation: aclstore.OpAdd,
+		Users:     []string{"daisy"},
+		Actor:     "boss",
+	}, {
+		Name:      "someacl",
+		Operation: aclstore.OpRemove,
+		Users:     []string{"alice"},
+		Actor:     "boss",
+	}},
+}, {
+	testName:     "failed_test",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "remove", "path": "/users/0"}, {"op": "test", "path": "/users", "value": ["alice"]}]`,
+	expectStatus: http.StatusPreconditionFailed,
+	expectError:  `patch test failed at "/users"`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "unsupported_operation",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "move", "from": "/users/0", "path": "/users/1"}]`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `unsupported patch operation "move"`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "invalid_path",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "add", "path": "/managers/-", "value": "charlie"}]`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `invalid patch path "/managers/-"`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "out_of_range",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "remove", "path": "/users/2"}]`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `patch path "/users/2" out of range`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "missing_value",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "add", "path": "/users/-"}]`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `missing value in "add" operation`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "remove_all_users",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "remove", "path": "/users"}]`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `cannot remove "/users"`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "bad_username",
+	contentType:  params.JSONPatchContentType,
+	body:         `[{"op": "add", "path": "/users/-", "value": ""}]`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `invalid user name ""`,
+	expectUsers:  []string{"alice", "bob"},
+}, {
+	testName:     "unsupported_merge_patch_field",
+	contentType:  params.MergePatchContentType,
+	body:         `{"managers": ["bob"]}`,
+	expectStatus: http.StatusBadRequest,
+	expectError:  `unsupported field "managers" in merge patch`,
+	expectUsers:  []string{"alice", "bob"},
+}}
+
+func TestPatchACL(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range patchACLTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var changes []params.ACLChange
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+				Audit: func(ctx context.Context, change *params.ACLChange) {
+					changes = append(changes, *change)
+				},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl", "alice", "bob")
+			c.Assert(err, qt.Equals, nil)
+			changes = nil
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return &namedIdentity{req.Header.Get("User")}, nil
+				},
+			}))
+			defer srv.Close()
+
+			req, err := http.NewRequest("PATCH", srv.URL+"/someacl", strings.NewReader(test.body))
+			c.Assert(err, qt.Equals, nil)
+			req.Header.Set("Content-Type", test.contentType)
+			req.Header.Set("User", "boss")
+			resp, err := http.DefaultClient.Do(req)
+			c.Assert(err, qt.Equals, nil)
+			defer resp.Body.Close()
+			data, err := ioutil.ReadAll(resp.Body)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(resp.StatusCode, qt.Equals, test.expectStatus, qt.Commentf("body: %s", data))
+			if test.expectError != "" {
+				var remoteErr httprequest.RemoteError
+				err := json.Unmarshal(data, &remoteErr)
+				c.Assert(err, qt.Equals, nil)
+				c.Assert(remoteErr.Message, qt.Matches, `.*`+test.expectError)
+			}
+			users, err := m.ACL(ctx, "someacl")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, test.expectUsers)
+			c.Assert(changes, qt.DeepEquals, test.expectChanges)
+		})
+	}
+}
+
+func TestPatchACLNotFound(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+	}))
+	defer srv.Close()
+	assertJSONCallAs(c, "boss", "PATCH", srv.URL+"/nothere", []params.PatchOperation{{
+		Op:    "add",
+		Path:  "/users/-",
+		Value: json.RawMessage(`"alice"`),
+	}}, http.StatusNotFound, &httprequest.RemoteError{
+		Message: "ACL not found",
+		Code:    aclstore.CodeACLNotFound,
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"strings"
+
+	"gopkg.in/errgo.v1"
+)
+
+// PrincipalSeparator separates the type of a principal from its
+// ID in the ACL entry that represents it.
+const PrincipalSeparator = ":"
+
+// Principal represents a typed ACL member, such as a user,
+// a service account or a group.
+type Principal struct {
+	// Type holds the kind of principal, for example "user" or
+	// "group". It must not contain PrincipalSeparator. An empty
+	// Type represents an untyped entry.
+	Type string
+
+	// ID identifies the principal among those of its type. The ID
+	// of an untyped principal must not contain PrincipalSeparator,
+	// as its entry would then be read as a typed principal.
+	ID string
+}
+
+// String returns the ACL entry that represents the principal: its type
+// and ID joined by PrincipalSeparator, or just its ID if it has no type.
+func (p Principal) String() string {
+	if p.Type == "" {
+		return p.ID
+	}
+	return p.Type + PrincipalSeparator + p.ID
+}
+
+// valid reports whether the principal can be stored as an ACL entry
+// and parsed back unchanged.
+func (p Principal) valid() bool {
+	if p.ID == "" || strings.Contains(p.Type, PrincipalSeparator) {
+		return false
+	}
+	return p.Type != "" || !strings.Contains(p.ID, PrincipalSeparator)
+}
+
+// ParsePrincipal returns the principal represented by the given ACL
+// entry. An entry without a PrincipalSeparator is an untyped principal
+// with the entry as its ID, so ACLs holding plain users can be read as
+// principals.
+func ParsePrincipal(entry string) Principal {
+	i := strings.Index(entry, PrincipalSeparator)
+	if i < 0 {
+		return Principal{ID: entry}
+	}
+	return Principal{
+		Type: entry[:i],
+		ID:   entry[i+len(PrincipalSeparator):],
+	}
+}
+
+// PrincipalIdentity may be implemented by an Identity to check typed
+// principals rather than plain ACL entries. When Params.TypedPrincipals
+// is set, AllowPrincipals is called instead of Allow, with each ACL
+// entry parsed by ParsePrincipal.
+type PrincipalIdentity interface {
+	Identity
+
+	// AllowPrincipals reports whether the identity should be allowed
+	// access by any of the given principals. A principal matches only
+	// if both its type and ID match.
+	AllowPrincipals(ctx context.Context, acl []Principal) (bool, error)
+}
+
+// Principals returns the members of the ACL with the given name as
+// principals.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist.
+func (m *Manager) Principals(ctx context.Context, aclName string) ([]Principal, error) {
+	acl, err := m.ACL(ctx, aclName)
+	if err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	return parsePrincipals(acl), nil
+}
+
+// SetPrincipals sets the members of the ACL with the given name to the
+// given principals. Each principal is stored as the entry returned by
+// its String method, so the ACL can also be read with ACL.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist, or with an ErrBadUsername cause if any of the principals is
+// not valid.
+func (m *Manager) SetPrincipals(ctx context.Context, aclName string, principals []Principal) error {
+	users := make([]string, len(principals))
+	for i, p := range principals {
+		if !p.valid() {
+			return errgo.WithCausef(nil, ErrBadUsername, "invalid principal %q", p)
+		}
+		users[i] = p.String()
+	}
+	if err := m.checkUsersAllowed(ctx, users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if err := m.p.Store.Set(ctx, aclName, users); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+	}
+	m.changed(ctx, aclName, OpSet, users)
+	return nil
+}
+
+// allowEntries reports whether the given identity is allowed by any of
+// the given ACL entries, checking them as principals if typed
+// principals are enabled and the identity supports them.
+func (m *Manager) allowEntries(ctx context.Context, identity Identity, acl []string) (bool, error) {
+	if pi, ok := identity.(PrincipalIdentity); ok && m.p.TypedPrincipals {
+		return pi.AllowPrincipals(ctx, parsePrincipals(acl))
+	}
+	return identity.Allow(ctx, acl)
+}
+
+func parsePrincipals(acl []string) []Principal {
+	ps := make([]Principal, len(acl))
+	for i, a := range acl {
+		ps[i] = ParsePrincipal(a)
+	}
+	return ps
+}
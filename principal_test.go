@@ -0,0 +1,145 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+var parsePrincipalTests = []struct {
+	entry  string
+	expect aclstore.Principal
+}{{
+	entry:  "user:alice",
+	expect: aclstore.Principal{Type: "user", ID: "alice"},
+}, {
+	entry:  "alice",
+	expect: aclstore.Principal{ID: "alice"},
+}, {
+	entry:  "service:ci:deploy",
+	expect: aclstore.Principal{Type: "service", ID: "ci:deploy"},
+}}
+
+func TestParsePrincipal(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range parsePrincipalTests {
+		c.Run(test.entry, func(c *qt.C) {
+			p := aclstore.ParsePrincipal(test.entry)
+			c.Assert(p, qt.Equals, test.expect)
+			c.Assert(p.String(), qt.Equals, test.entry)
+		})
+	}
+}
+
+func TestPrincipalsRoundTrip(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:           aclstore.NewACLStore(memsimplekv.NewStore()),
+		TypedPrincipals: true,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "bob")
+	c.Assert(err, qt.Equals, nil)
+
+	principals := []aclstore.Principal{
+		{Type: "user", ID: "alice"},
+		{Type: "service", ID: "ci"},
+		{Type: "group", ID: "ops"},
+	}
+	err = m.SetPrincipals(ctx, "someacl", principals)
+	c.Assert(err, qt.Equals, nil)
+	got, err := m.Principals(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(got, qt.DeepEquals, []aclstore.Principal{
+		{Type: "group", ID: "ops"},
+		{Type: "service", ID: "ci"},
+		{Type: "user", ID: "alice"},
+	})
+
+	// The flat API sees the encoded entries.
+	users, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"group:ops", "service:ci", "user:alice"})
+
+	err = m.SetPrincipals(ctx, "someacl", []aclstore.Principal{{Type: "user"}})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	err = m.SetPrincipals(ctx, "someacl", []aclstore.Principal{{Type: "user:x", ID: "alice"}})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	// An untyped ID containing the separator would be read
+	// back as a typed principal.
+	err = m.SetPrincipals(ctx, "someacl", []aclstore.Principal{{ID: "user:alice"}})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	users, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"group:ops", "service:ci", "user:alice"})
+	err = m.SetPrincipals(ctx, "nonexistent", principals)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+func TestPrincipalIdentity(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:           aclstore.NewACLStore(memsimplekv.NewStore()),
+		TypedPrincipals: true,
+		DenyPrefix:      "-",
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "user:alice", "service:bob", "charlie", "-user:daisy", "group:ops")
+	c.Assert(err, qt.Equals, nil)
+
+	for _, test := range []struct {
+		identity aclstore.Identity
+		expect   bool
+	}{
+		{principalIdentity{Type: "user", ID: "alice"}, true},
+		{principalIdentity{Type: "service", ID: "alice"}, false},
+		{principalIdentity{Type: "user", ID: "bob"}, false},
+		{principalIdentity{Type: "service", ID: "bob"}, true},
+		{principalIdentity{ID: "charlie"}, true},
+		{principalIdentity{Type: "user", ID: "charlie"}, false},
+		{principalIdentity{Type: "user", ID: "daisy", Groups: []string{"ops"}}, false},
+		{principalIdentity{Type: "user", ID: "edward", Groups: []string{"ops"}}, true},
+	} {
+		ok, err := m.AllowAny(ctx, test.identity, []string{"someacl"})
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(ok, qt.Equals, test.expect, qt.Commentf("identity %v", test.identity))
+	}
+}
+
+// principalIdentity implements aclstore.PrincipalIdentity
+// for a principal that may be a member of some groups.
+type principalIdentity struct {
+	Type   string
+	ID     string
+	Groups []string
+}
+
+func (id principalIdentity) Allow(ctx context.Context, acl []string) (bool, error) {
+	panic("unexpected call to Allow")
+}
+
+func (id principalIdentity) AllowPrincipals(ctx context.Context, acl []aclstore.Principal) (bool, error) {
+	for _, p := range acl {
+		if p.Type == id.Type && p.ID == id.ID {
+			return true, nil
+		}
+		if p.Type == "group" {
+			for _, g := range id.Groups {
+				if p.ID == g {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,190 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+)
+
+// SetQuorum implements ACLApprover.SetQuorum. Changing the quorum
+// does not change the generation of the ACL, as its members are
+// unchanged.
+func (s *kvStore) SetQuorum(ctx context.Context, aclName string, n int) error {
+	if n < 0 {
+		return errgo.Newf("negative quorum %d", n)
+	}
+	err := s.updateHeader(ctx, aclName, func(h *valueHeader, acl []string) error {
+		h.Quorum = n
+		h.Approvals = nil
+		return nil
+	})
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+}
+
+// Approve implements ACLApprover.Approve.
+func (s *kvStore) Approve(ctx context.Context, aclName, user string) error {
+	key := s.userKey(user)
+	err := s.updateHeader(ctx, aclName, func(h *valueHeader, acl []string) error {
+		if h.Quorum == 0 {
+			return errgo.Newf("ACL %q does not require a quorum", aclName)
+		}
+		if !s.userKeySet(acl)[key] {
+			return errgo.WithCausef(nil, ErrUserNotFound, "%q is not a member of ACL %q", user, aclName)
+		}
+		for _, k := range h.Approvals {
+			if k == key {
+				return nil
+			}
+		}
+		h.Approvals = append(h.Approvals, key)
+		sort.Strings(h.Approvals)
+		return nil
+	})
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrUserNotFound), isContextError)
+}
+
+// memberApprovals returns the approvals, held as user keys, that
+// were given by members of the given ACL.
+func (s *kvStore) memberApprovals(approvals, acl []string) []string {
+	members := s.userKeySet(acl)
+	var kept []string
+	for _, key := range approvals {
+		if members[key] {
+			kept = append(kept, key)
+		}
+	}
+	return kept
+}
+
+// Approvals implements ACLApprover.Approvals.
+func (s *kvStore) Approvals(ctx context.Context, aclName string) (int, []string, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, nil, err
+	}
+	val, err := s.kv.Get(ctx, s.key(ctx, aclName))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return 0, nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		return 0, nil, errgo.Mask(err, isContextError)
+	}
+	h, acl, err := decodeValue(val)
+	if err != nil {
+		return 0, nil, errgo.Notef(err, "cannot get ACL %q", aclName)
+	}
+	if h.Deleted {
+		return 0, nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+	}
+	approved := make(map[string]bool, len(h.Approvals))
+	for _, key := range h.Approvals {
+		approved[key] = true
+	}
+	var users []string
+	for _, u := range acl {
+		if key := s.userKey(u); approved[key] {
+			users = append(users, u)
+			// Only report each member once.
+			delete(approved, key)
+		}
+	}
+	sort.Strings(users)
+	return h.Quorum, users, nil
+}
+
+// updateHeader atomically changes the header of the ACL with the
+// given name by calling f, leaving its members unchanged.
+func (s *kvStore) updateHeader(ctx context.Context, aclName string, f func(h *valueHeader, acl []string) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	err := s.kv.Update(ctx, s.key(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if val == nil {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		h, acl, err := decodeValue(val)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if h.Deleted {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		if err := f(&h, acl); err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+		return s.encodeValue(h, acl)
+	})
+	return errgo.Mask(err, errgo.Any)
+}
+
+// SetQuorum makes the ACL with the given name require approval by n
+// distinct members before Satisfied reports that it is satisfied, and
+// discards any approvals already recorded, so that a new approval flow
+// can begin. A quorum of zero returns the ACL to normal. The quorum
+// does not affect whether identities are allowed by the ACL.
+//
+// The underlying store must implement ACLApprover.
+func (m *Manager) SetQuorum(ctx context.Context, aclName string, n int) error {
+	approver, ok := m.p.Store.(ACLApprover)
+	if !ok {
+		return errgo.Newf("cannot record approvals")
+	}
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	err = approver.SetQuorum(ctx, aclName, n)
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+}
+
+// RecordApproval records that the given user, who must be a member
+// of the ACL with the given name, has approved. It returns an error
+// with an ErrUserNotFound cause if the user is not a member, or an
+// error if the ACL does not require a quorum.
+//
+// The underlying store must implement ACLApprover.
+func (m *Manager) RecordApproval(ctx context.Context, aclName, user string) error {
+	approver, ok := m.p.Store.(ACLApprover)
+	if !ok {
+		return errgo.Newf("cannot record approvals")
+	}
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	err = approver.Approve(ctx, aclName, user)
+	return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrUserNotFound), isContextError)
+}
+
+// Satisfied reports whether at least as many distinct members of the
+// ACL with the given name as its quorum have approved since the quorum
+// was set. Approvals by users that are no longer members are not
+// counted. It returns an error if the ACL does not require a quorum.
+//
+// The underlying store must implement ACLApprover.
+func (m *Manager) Satisfied(ctx context.Context, aclName string) (bool, error) {
+	approver, ok := m.p.Store.(ACLApprover)
+	if !ok {
+		return false, errgo.Newf("cannot record approvals")
+	}
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, isContextError)
+	}
+	quorum, approvals, err := approver.Approvals(ctx, aclName)
+	if err != nil {
+		return false, errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	if quorum == 0 {
+		return false, errgo.Newf("ACL %q does not require a quorum", aclName)
+	}
+	return len(approvals) >= quorum, nil
+}
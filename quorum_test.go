@@ -0,0 +1,161 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+var quorumTests = []struct {
+	testName        string
+	quorum          int
+	approvers       []string
+	remove          []string
+	addBack         []string
+	expectSatisfied bool
+}{{
+	testName:        "no_approvals",
+	quorum:          2,
+	expectSatisfied: false,
+}, {
+	testName:        "not_reached",
+	quorum:          2,
+	approvers:       []string{"alice"},
+	expectSatisfied: false,
+}, {
+	testName:        "repeated_approval",
+	quorum:          2,
+	approvers:       []string{"alice", "alice"},
+	expectSatisfied: false,
+}, {
+	testName:        "reached",
+	quorum:          2,
+	approvers:       []string{"alice", "bob"},
+	expectSatisfied: true,
+}, {
+	testName:        "exceeded",
+	quorum:          2,
+	approvers:       []string{"charlie", "alice", "bob"},
+	expectSatisfied: true,
+}, {
+	testName:        "approver_removed",
+	quorum:          2,
+	approvers:       []string{"alice", "bob"},
+	remove:          []string{"bob"},
+	expectSatisfied: false,
+}, {
+	testName:        "approver_removed_and_added_back",
+	quorum:          2,
+	approvers:       []string{"alice", "bob"},
+	remove:          []string{"bob"},
+	addBack:         []string{"bob"},
+	expectSatisfied: false,
+}}
+
+func TestQuorum(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range quorumTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStore(memsimplekv.NewStore())
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             store,
+				InitialAdminUsers: []string{"boss"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "deploy", "alice", "bob", "charlie")
+			c.Assert(err, qt.Equals, nil)
+			err = m.SetQuorum(ctx, "deploy", test.quorum)
+			c.Assert(err, qt.Equals, nil)
+			for _, user := range test.approvers {
+				err := m.RecordApproval(ctx, "deploy", user)
+				c.Assert(err, qt.Equals, nil)
+			}
+			if len(test.remove) > 0 {
+				err := store.Remove(ctx, "deploy", test.remove)
+				c.Assert(err, qt.Equals, nil)
+			}
+			if len(test.addBack) > 0 {
+				err := store.Add(ctx, "deploy", test.addBack)
+				c.Assert(err, qt.Equals, nil)
+			}
+			ok, err := m.Satisfied(ctx, "deploy")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, test.expectSatisfied)
+
+			// The quorum does not change the members of the ACL.
+			ok, err = m.Check(ctx, &namedIdentity{"alice"}, "deploy")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, true)
+		})
+	}
+}
+
+func TestQuorumReset(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "deploy", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	err = m.SetQuorum(ctx, "deploy", 1)
+	c.Assert(err, qt.Equals, nil)
+	err = m.RecordApproval(ctx, "deploy", "alice")
+	c.Assert(err, qt.Equals, nil)
+	ok, err := m.Satisfied(ctx, "deploy")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+
+	// Setting the quorum starts a new approval flow.
+	err = m.SetQuorum(ctx, "deploy", 1)
+	c.Assert(err, qt.Equals, nil)
+	ok, err = m.Satisfied(ctx, "deploy")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+
+	// A quorum of zero returns the ACL to normal.
+	err = m.SetQuorum(ctx, "deploy", 0)
+	c.Assert(err, qt.Equals, nil)
+	_, err = m.Satisfied(ctx, "deploy")
+	c.Assert(err, qt.ErrorMatches, `ACL "deploy" does not require a quorum`)
+}
+
+func TestQuorumErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "deploy", "alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+
+	// Normal ACLs have no quorum.
+	_, err = m.Satisfied(ctx, "deploy")
+	c.Assert(err, qt.ErrorMatches, `ACL "deploy" does not require a quorum`)
+	err = m.RecordApproval(ctx, "deploy", "alice")
+	c.Assert(err, qt.ErrorMatches, `ACL "deploy" does not require a quorum`)
+
+	err = m.SetQuorum(ctx, "deploy", 1)
+	c.Assert(err, qt.Equals, nil)
+	err = m.RecordApproval(ctx, "deploy", "charlie")
+	c.Assert(err, qt.ErrorMatches, `"charlie" is not a member of ACL "deploy"`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrUserNotFound)
+
+	err = m.SetQuorum(ctx, "nothere", 1)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	_, err = m.Satisfied(ctx, "nothere")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
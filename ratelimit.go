@@ -0,0 +1,184 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// CodeTooManyRequests holds the error code returned from
+// the HTTP endpoints when a change to an ACL is rejected
+// because the ACL is being changed too often.
+const CodeTooManyRequests = params.CodeTooManyRequests
+
+// RateLimit holds the configuration for limiting the rate of changes
+// made to each ACL through the HTTP endpoints. Each ACL has its own
+// token bucket that holds up to Burst tokens and is refilled at Rate
+// tokens per second. Each request that changes an ACL uses a token
+// and is rejected if there are none left. Requests that only read
+// ACLs are not limited, except for those that may read many ACLs at
+// once, such as POST /authorize and listings of the ACLs that a user
+// may manage, which are limited in the same way
+// with a token bucket for each caller.
+type RateLimit struct {
+	// Rate holds the number of changes per second allowed
+	// to each ACL over the long term.
+	Rate float64
+
+	// Burst holds the maximum number of changes that can
+	// be made to an ACL in quick succession. If this
+	// is less than one, one is used.
+	Burst int
+}
+
+var errRateLimited = errgo.Newf("too many requests")
+
+// rateLimiter implements per-ACL token-bucket rate limiting.
+// Buckets that have been idle for long enough to be full are
+// discarded, so the number of buckets is bounded by the number
+// of ACLs changed recently.
+type rateLimiter struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	time   time.Time
+}
+
+func newRateLimiter(p RateLimit, now func() time.Time) *rateLimiter {
+	l := &rateLimiter{
+		rate:    p.Rate,
+		burst:   float64(p.Burst),
+		now:     now,
+		buckets: make(map[string]*tokenBucket),
+	}
+	if l.burst < 1 {
+		l.burst = 1
+	}
+	return l
+}
+
+// allow reports whether a change to the ACL with the given name is
+// allowed now. If it is not, it also returns the time to wait before
+// the change will be allowed.
+func (l *rateLimiter) allow(aclName string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	l.sweep(now)
+	b := l.buckets[aclName]
+	if b == nil {
+		b = &tokenBucket{
+			tokens: l.burst,
+			time:   now,
+		}
+		l.buckets[aclName] = b
+	} else {
+		b.tokens = l.tokensAt(b, now)
+		b.time = now
+	}
+	if b.tokens < 1 {
+		if l.rate <= 0 {
+			return false, time.Duration(math.MaxInt64)
+		}
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// tokensAt returns the number of tokens in the given bucket at the
+// given time.
+func (l *rateLimiter) tokensAt(b *tokenBucket, now time.Time) float64 {
+	tokens := b.tokens + now.Sub(b.time).Seconds()*l.rate
+	if tokens > l.burst {
+		tokens = l.burst
+	}
+	return tokens
+}
+
+// sweep discards buckets that would be full at the given time, at most
+// once for each period that it takes an empty bucket to fill up.
+// It must be called with l.mu held.
+func (l *rateLimiter) sweep(now time.Time) {
+	if l.rate <= 0 || now.Sub(l.lastSweep).Seconds() < l.burst/l.rate {
+		return
+	}
+	l.lastSweep = now
+	for name, b := range l.buckets {
+		if l.tokensAt(b, now) >= l.burst {
+			delete(l.buckets, name)
+		}
+	}
+}
+
+// checkRateLimit checks whether a request with the given operation on
+// the ACL with the given name is allowed by the configured rate limit.
+// Read operations are always allowed. If the request is not allowed,
+// it sets the Retry-After header and returns an error with an
+// errRateLimited cause.
+func (h *handler) checkRateLimit(w http.ResponseWriter, op Operation, aclName string) error {
+	if h.limiter == nil || isReadOperation(op) {
+		return nil
+	}
+	ok, wait := h.limiter.allow(aclName)
+	if ok {
+		return nil
+	}
+	setRetryAfter(w, wait)
+	return errgo.WithCausef(nil, errRateLimited, "too many changes to ACL %q", aclName)
+}
+
+// checkCallerRateLimit checks whether a request by the given identity
+// that may read many ACLs at once is allowed by the configured rate
+// limit. Such requests are limited per caller rather than per ACL;
+// callers without a name share a bucket. If the request is not
+// allowed, it sets the Retry-After header and returns an error with an
+// errRateLimited cause.
+func (h *handler) checkCallerRateLimit(w http.ResponseWriter, identity Identity) error {
+	if h.callerLimiter == nil {
+		return nil
+	}
+	var name string
+	if identity, ok := identity.(NamedIdentity); ok {
+		name = identity.Name()
+	}
+	ok, wait := h.callerLimiter.allow(name)
+	if ok {
+		return nil
+	}
+	setRetryAfter(w, wait)
+	return errgo.WithCausef(nil, errRateLimited, "too many requests")
+}
+
+// setRetryAfter sets the Retry-After header to tell the client
+// to wait for the given duration, rounded up to whole seconds.
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(wait.Seconds())), 10))
+}
+
+// isMutation reports whether requests with the given
+// HTTP method may change ACLs.
+func isMutation(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return false
+	}
+	return true
+}
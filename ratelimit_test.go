@@ -0,0 +1,248 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+func TestRateLimit(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"alice"},
+		Clock:             clock,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateAlias(ctx, "foo-alias", "foo")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+		RateLimit: &aclstore.RateLimit{
+			Rate:  0.5,
+			Burst: 2,
+		},
+	}))
+	defer srv.Close()
+
+	add := func(aclName string) *http.Response {
+		data, err := json.Marshal(params.ModifyACLRequestBody{
+			Add: []string{"bob"},
+		})
+		c.Assert(err, qt.Equals, nil)
+		resp, err := http.Post(srv.URL+"/"+aclName, "application/json", bytes.NewReader(data))
+		c.Assert(err, qt.Equals, nil)
+		defer resp.Body.Close()
+		return resp
+	}
+	// The burst is allowed.
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusOK)
+
+	// Further changes are rejected.
+	resp := add("foo")
+	c.Assert(resp.StatusCode, qt.Equals, http.StatusTooManyRequests)
+	c.Assert(resp.Header.Get("Retry-After"), qt.Equals, "2")
+	assertJSONCall(c, "POST", srv.URL+"/foo", params.ModifyACLRequestBody{
+		Add: []string{"bob"},
+	}, http.StatusTooManyRequests, &httprequest.RemoteError{
+		Message: `too many changes to ACL "foo"`,
+		Code:    aclstore.CodeTooManyRequests,
+	})
+
+	// Reads are not limited.
+	assertJSONCall(c, "GET", srv.URL+"/foo", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"bob"},
+	})
+
+	// Checks are not limited even though they use POST.
+	assertJSONCall(c, "POST", srv.URL+"/foo/members", params.MembersInRequestBody{
+		Candidates: []string{"bob"},
+	}, http.StatusOK, params.MembersInResponse{
+		Users: []string{"bob"},
+	})
+
+	// Changes through an alias count against the target ACL.
+	c.Assert(add("foo-alias").StatusCode, qt.Equals, http.StatusTooManyRequests)
+
+	// Other ACLs are limited independently.
+	c.Assert(add("bar").StatusCode, qt.Equals, http.StatusOK)
+
+	// After waiting, changes are allowed again.
+	clock.advance(time.Second)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusTooManyRequests)
+	clock.advance(time.Second)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusTooManyRequests)
+
+	// After a long idle period, the full burst is available again.
+	clock.advance(time.Hour)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusOK)
+	c.Assert(add("foo").StatusCode, qt.Equals, http.StatusTooManyRequests)
+}
+
+func TestRateLimitAuthorize(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		Clock:             clock,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "alice")
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+		RateLimit: &aclstore.RateLimit{
+			Rate:  0.5,
+			Burst: 1,
+		},
+	}))
+	defer srv.Close()
+
+	body := params.AuthorizeRequestBody{
+		ACLs: []string{"foo"},
+	}
+	assertJSONCallAs(c, "alice", "POST", srv.URL+"/authorize", body, http.StatusOK, params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{{ACL: "foo", Allowed: true}},
+	})
+	assertJSONCallAs(c, "alice", "POST", srv.URL+"/authorize", body, http.StatusTooManyRequests, &httprequest.RemoteError{
+		Message: "too many requests",
+		Code:    aclstore.CodeTooManyRequests,
+	})
+
+	// Other callers are limited independently.
+	assertJSONCallAs(c, "bob", "POST", srv.URL+"/authorize", body, http.StatusOK, params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{{ACL: "foo", Allowed: false}},
+	})
+
+	clock.advance(2 * time.Second)
+	assertJSONCallAs(c, "alice", "POST", srv.URL+"/authorize", body, http.StatusOK, params.AuthorizeResponse{
+		Decisions: []params.AuthorizeDecision{{ACL: "foo", Allowed: true}},
+	})
+}
+
+func TestRateLimitManageable(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		Clock:             clock,
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.AddReport(ctx, "_foo", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return &namedIdentity{req.Header.Get("User")}, nil
+		},
+		RateLimit: &aclstore.RateLimit{
+			Rate:  0.5,
+			Burst: 1,
+		},
+	}))
+	defer srv.Close()
+
+	assertJSONCallAs(c, "alice", "GET", srv.URL+"/?manageable=1", nil, http.StatusOK, params.GetACLsResponse{
+		ACLs: []string{"foo"},
+	})
+	assertJSONCallAs(c, "alice", "GET", srv.URL+"/?manageable=1", nil, http.StatusTooManyRequests, &httprequest.RemoteError{
+		Message: "too many requests",
+		Code:    aclstore.CodeTooManyRequests,
+	})
+
+	// Plain listings by administrators are not limited.
+	for i := 0; i < 3; i++ {
+		assertJSONCallAs(c, "boss", "GET", srv.URL+"/", nil, http.StatusOK, params.GetACLsResponse{
+			ACLs: []string{"admin", "foo"},
+		})
+	}
+}
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestRateLimiterEvictsIdleBuckets(t *testing.T) {
+	c := qt.New(t)
+	clock := &testClock{t: time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)}
+	l := aclstore.NewRateLimiter(aclstore.RateLimit{
+		Rate:  1,
+		Burst: 5,
+	}, clock.Now)
+	var wg sync.WaitGroup
+	for i := 0; i < 100; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, _ := aclstore.RateLimiterAllow(l, fmt.Sprint("acl", i))
+			c.Check(ok, qt.Equals, true)
+		}(i)
+	}
+	wg.Wait()
+	c.Assert(aclstore.RateLimiterBuckets(l), qt.Equals, 100)
+
+	// Buckets that are full again are discarded, but
+	// those that are still refilling are kept.
+	clock.advance(4 * time.Second)
+	for i := 0; i < 3; i++ {
+		ok, _ := aclstore.RateLimiterAllow(l, "acl0")
+		c.Assert(ok, qt.Equals, true)
+	}
+	c.Assert(aclstore.RateLimiterBuckets(l), qt.Equals, 100)
+	clock.advance(time.Second)
+	ok, _ := aclstore.RateLimiterAllow(l, "other")
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(aclstore.RateLimiterBuckets(l), qt.Equals, 2)
+
+	clock.advance(5 * time.Second)
+	ok, _ = aclstore.RateLimiterAllow(l, "other")
+	c.Assert(ok, qt.Equals, true)
+	c.Assert(aclstore.RateLimiterBuckets(l), qt.Equals, 1)
+}
@@ -0,0 +1,102 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+)
+
+// AddReport adds the given users to the ACL with the given name and
+// returns the users that were added and those that were already
+// members, in the order they were given. A user that is given more than
+// once is only reported once. This can be used to avoid acting on
+// additions that changed nothing. Only the added users are reported
+// to Params.Audit and Params.Webhook, and nothing is reported if no
+// users were added.
+//
+// If the underlying store implements ACLAddReporter, the report is
+// made atomically with the change; otherwise it is made from the
+// members read before the users are added, so it may be inaccurate if
+// the ACL is changed concurrently.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist, or with an ErrBadUsername cause if any of the users are not
+// valid or allowed.
+func (m *Manager) AddReport(ctx context.Context, aclName string, users []string) (added, present []string, err error) {
+	aclName, err = m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, isContextError)
+	}
+	if err := m.checkUsersAllowed(ctx, users); err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	if reporter, ok := m.p.Store.(ACLAddReporter); ok {
+		added, present, err = reporter.AddReport(ctx, aclName, users)
+	} else {
+		var current []string
+		current, err = m.p.Store.Get(ctx, aclName)
+		if err == nil {
+			present, added = partitionUsers(current, users, m.userKey())
+			err = m.p.Store.Add(ctx, aclName, users)
+		}
+	}
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+	}
+	if len(added) > 0 {
+		m.changed(ctx, aclName, OpAdd, added)
+	}
+	return added, present, nil
+}
+
+// RemoveReport removes the given users from the ACL with the given
+// name and returns the users that were removed and those that were not
+// members, in the order they were given. A user that is given more than
+// once is only reported once. Only the removed users are reported to
+// Params.Audit and Params.Webhook, and nothing is reported if no users
+// were removed.
+//
+// If the underlying store implements ACLRemoveReporter, the report is
+// made atomically with the change; otherwise it is made from the
+// members read before the users are removed, so it may be inaccurate
+// if the ACL is changed concurrently.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist. If the store performs strict removal, it returns an error with
+// an ErrUserNotFound cause if any of the users are not members, and the
+// ACL is left unchanged.
+func (m *Manager) RemoveReport(ctx context.Context, aclName string, users []string) (removed, absent []string, err error) {
+	aclName, err = m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return nil, nil, errgo.Mask(err, isContextError)
+	}
+	if reporter, ok := m.p.Store.(ACLRemoveReporter); ok {
+		removed, absent, err = reporter.RemoveReport(ctx, aclName, users)
+	} else {
+		var current []string
+		current, err = m.p.Store.Get(ctx, aclName)
+		if err == nil {
+			removed, absent = partitionUsers(current, users, m.userKey())
+			err = m.p.Store.Remove(ctx, aclName, users)
+		}
+	}
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrUserNotFound), isContextError)
+	}
+	if len(removed) > 0 {
+		m.changed(ctx, aclName, OpRemove, removed)
+	}
+	return removed, absent, nil
+}
+
+// userKey returns a function that returns the form of a user used to
+// compare it with other users.
+func (m *Manager) userKey() func(string) string {
+	if m.foldsCase() {
+		return FoldUser
+	}
+	return func(u string) string { return u }
+}
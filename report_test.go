@@ -0,0 +1,242 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+// plainStore hides the optional interfaces implemented by the store
+// it wraps, other than ACLCaseFolder, so that the Manager cannot use
+// them.
+type plainStore struct {
+	aclstore.ACLStore
+}
+
+func (s plainStore) FoldsCase() bool {
+	folder, ok := s.ACLStore.(aclstore.ACLCaseFolder)
+	return ok && folder.FoldsCase()
+}
+
+var addReportTests = []struct {
+	testName      string
+	storeParams   aclstore.StoreParams
+	users         []string
+	expectAdded   []string
+	expectPresent []string
+	expectACL     []string
+}{{
+	testName:      "some_new",
+	users:         []string{"daisy", "alice", "charlie"},
+	expectAdded:   []string{"daisy", "charlie"},
+	expectPresent: []string{"alice"},
+	expectACL:     []string{"alice", "bob", "charlie", "daisy"},
+}, {
+	testName:    "all_new",
+	users:       []string{"daisy", "charlie"},
+	expectAdded: []string{"daisy", "charlie"},
+	expectACL:   []string{"alice", "bob", "charlie", "daisy"},
+}, {
+	testName:      "all_present",
+	users:         []string{"bob", "alice"},
+	expectPresent: []string{"bob", "alice"},
+	expectACL:     []string{"alice", "bob"},
+}, {
+	testName:      "duplicates",
+	users:         []string{"daisy", "bob", "daisy", "bob"},
+	expectAdded:   []string{"daisy"},
+	expectPresent: []string{"bob"},
+	expectACL:     []string{"alice", "bob", "daisy"},
+}, {
+	testName: "case_insensitive",
+	storeParams: aclstore.StoreParams{
+		CaseInsensitive: true,
+	},
+	users:         []string{"ALICE", "Daisy", "daisy"},
+	expectAdded:   []string{"Daisy"},
+	expectPresent: []string{"ALICE"},
+	expectACL:     []string{"Daisy", "alice", "bob"},
+}}
+
+func TestAddReport(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range addReportTests {
+		c.Run(test.testName, func(c *qt.C) {
+			for _, plain := range []bool{false, true} {
+				c.Run(fmt.Sprintf("plain=%v", plain), func(c *qt.C) {
+					p := test.storeParams
+					p.KV = memsimplekv.NewStore()
+					store := aclstore.NewACLStoreWithParams(p)
+					if plain {
+						store = plainStore{store}
+					}
+					var changes []params.ACLChange
+					m, err := aclstore.NewManager(ctx, aclstore.Params{
+						Store:             store,
+						InitialAdminUsers: []string{"boss"},
+						Audit: func(ctx context.Context, change *params.ACLChange) {
+							changes = append(changes, *change)
+						},
+					})
+					c.Assert(err, qt.Equals, nil)
+					err = m.CreateACL(ctx, "someacl", "alice", "bob")
+					c.Assert(err, qt.Equals, nil)
+					changes = nil
+					added, present, err := m.AddReport(ctx, "someacl", test.users)
+					c.Assert(err, qt.Equals, nil)
+					c.Assert(added, qt.DeepEquals, test.expectAdded)
+					c.Assert(present, qt.DeepEquals, test.expectPresent)
+					// Only the users that were added are reported.
+					var expectChanges []params.ACLChange
+					if len(test.expectAdded) > 0 {
+						expectChanges = []params.ACLChange{{
+							Name:      "someacl",
+							Operation: aclstore.OpAdd,
+							Users:     test.expectAdded,
+						}}
+					}
+					c.Assert(changes, qt.DeepEquals, expectChanges)
+					acl, err := m.ACL(ctx, "someacl")
+					c.Assert(err, qt.Equals, nil)
+					c.Assert(acl, qt.DeepEquals, test.expectACL)
+				})
+			}
+		})
+	}
+}
+
+func TestAddReportErrors(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		UserAllowed: func(ctx context.Context, user string) (bool, error) {
+			return user != "mallory", nil
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+
+	_, _, err = m.AddReport(ctx, "nothere", []string{"bob"})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+	_, _, err = m.AddReport(ctx, "someacl", []string{"bob", ""})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	_, _, err = m.AddReport(ctx, "someacl", []string{"bob", "mallory"})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+	acl, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice"})
+}
+
+var removeReportTests = []struct {
+	testName      string
+	storeParams   aclstore.StoreParams
+	users         []string
+	expectRemoved []string
+	expectAbsent  []string
+	expectACL     []string
+	expectError   error
+}{{
+	testName:      "some_present",
+	users:         []string{"daisy", "bob", "charlie"},
+	expectRemoved: []string{"bob"},
+	expectAbsent:  []string{"daisy", "charlie"},
+	expectACL:     []string{"alice"},
+}, {
+	testName:      "all_present",
+	users:         []string{"bob", "alice"},
+	expectRemoved: []string{"bob", "alice"},
+}, {
+	testName:     "all_absent",
+	users:        []string{"daisy", "charlie"},
+	expectAbsent: []string{"daisy", "charlie"},
+	expectACL:    []string{"alice", "bob"},
+}, {
+	testName:      "duplicates",
+	users:         []string{"daisy", "bob", "daisy", "bob"},
+	expectRemoved: []string{"bob"},
+	expectAbsent:  []string{"daisy"},
+	expectACL:     []string{"alice"},
+}, {
+	testName: "case_insensitive",
+	storeParams: aclstore.StoreParams{
+		CaseInsensitive: true,
+	},
+	users:         []string{"ALICE", "Daisy", "alice"},
+	expectRemoved: []string{"ALICE"},
+	expectAbsent:  []string{"Daisy"},
+	expectACL:     []string{"bob"},
+}, {
+	testName: "strict",
+	storeParams: aclstore.StoreParams{
+		StrictRemove: true,
+	},
+	users:       []string{"bob", "daisy"},
+	expectACL:   []string{"alice", "bob"},
+	expectError: aclstore.ErrUserNotFound,
+}}
+
+func TestRemoveReport(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range removeReportTests {
+		c.Run(test.testName, func(c *qt.C) {
+			for _, plain := range []bool{false, true} {
+				c.Run(fmt.Sprintf("plain=%v", plain), func(c *qt.C) {
+					p := test.storeParams
+					p.KV = memsimplekv.NewStore()
+					store := aclstore.NewACLStoreWithParams(p)
+					if plain {
+						store = plainStore{store}
+					}
+					var changes []params.ACLChange
+					m, err := aclstore.NewManager(ctx, aclstore.Params{
+						Store:             store,
+						InitialAdminUsers: []string{"boss"},
+						Audit: func(ctx context.Context, change *params.ACLChange) {
+							changes = append(changes, *change)
+						},
+					})
+					c.Assert(err, qt.Equals, nil)
+					err = m.CreateACL(ctx, "someacl", "alice", "bob")
+					c.Assert(err, qt.Equals, nil)
+					changes = nil
+					removed, absent, err := m.RemoveReport(ctx, "someacl", test.users)
+					if test.expectError != nil {
+						c.Assert(errgo.Cause(err), qt.Equals, test.expectError)
+					} else {
+						c.Assert(err, qt.Equals, nil)
+						c.Assert(removed, qt.DeepEquals, test.expectRemoved)
+						c.Assert(absent, qt.DeepEquals, test.expectAbsent)
+					}
+					// Only the users that were removed are reported.
+					var expectChanges []params.ACLChange
+					if len(test.expectRemoved) > 0 {
+						expectChanges = []params.ACLChange{{
+							Name:      "someacl",
+							Operation: aclstore.OpRemove,
+							Users:     test.expectRemoved,
+						}}
+					}
+					c.Assert(changes, qt.DeepEquals, expectChanges)
+					acl, err := m.ACL(ctx, "someacl")
+					c.Assert(err, qt.Equals, nil)
+					c.Assert(acl, qt.DeepEquals, test.expectACL)
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Reset removes every ACL, including the admin ACL and all meta-ACLs,
+// and then recreates the admin ACL holding Params.InitialAdminUsers,
+// together with the checker and read-only admin ACLs, if configured,
+// which start empty. It is intended for setting up integration tests
+// and local development environments, so it fails without changing
+// anything unless Params.AllowReset is set. It is not available
+// through the HTTP handler.
+//
+// If the underlying store retains deleted ACLs, the removed ACLs may
+// still be restored until the retention period expires.
+//
+// The underlying store must implement ACLLister and ACLDeleter.
+func (m *Manager) Reset(ctx context.Context) error {
+	if !m.p.AllowReset {
+		return errgo.Newf("reset not allowed")
+	}
+	deleter, ok := m.p.Store.(ACLDeleter)
+	if !ok {
+		return errgo.Newf("cannot delete ACLs")
+	}
+	names, err := m.ACLNames(ctx, true)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	for _, name := range names {
+		if err := m.resetACL(ctx, deleter, name); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	if err := createSystemACLs(ctx, m.p); err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	// Discard anything read while the ACLs were being removed.
+	m.Reload()
+	m.changed(ctx, m.p.AdminACLName, OpCreate, m.p.InitialAdminUsers)
+	for _, name := range []string{m.p.CheckerACL, m.p.ReadOnlyAdminACL} {
+		if name != "" {
+			m.changed(ctx, name, OpCreate, nil)
+		}
+	}
+	return nil
+}
+
+// resetACL deletes the ACL with the given name on behalf of Reset.
+// Unlike deleteACL, it does not delete the meta-ACL, which Reset
+// deletes separately, and ACLs that have already gone are ignored.
+func (m *Manager) resetACL(ctx context.Context, deleter ACLDeleter, name string) error {
+	defer m.nameLocks.lock(name)()
+	err := deleter.DeleteACL(ctx, name)
+	if errgo.Cause(err) == ErrACLNotFound {
+		return nil
+	}
+	if err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("cannot delete ACL %q", name), isContextError)
+	}
+	if !isMetaName(name) {
+		m.changed(ctx, name, OpDelete, nil)
+	}
+	return nil
+}
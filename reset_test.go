@@ -0,0 +1,102 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+func TestReset(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	var changes []string
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		CheckerACL:        "checkers",
+		AllowReset:        true,
+		Cache: &aclstore.Cache{
+			TTL: time.Minute,
+		},
+		Audit: func(ctx context.Context, change *params.ACLChange) {
+			changes = append(changes, change.Operation+" "+change.Name)
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.AddReport(ctx, "admin", []string{"eve"})
+	c.Assert(err, qt.Equals, nil)
+	_, _, err = m.AddReport(ctx, "checkers", []string{"daisy"})
+	c.Assert(err, qt.Equals, nil)
+	// Make sure the ACL is cached.
+	acl, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice"})
+	changes = nil
+
+	err = m.Reset(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(changes, qt.DeepEquals, []string{
+		"delete admin",
+		"delete checkers",
+		"delete someacl",
+		"create admin",
+		"create checkers",
+	})
+
+	names, err := m.ACLNames(ctx, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.DeepEquals, []string{"admin", "checkers"})
+	acl, err = m.ACL(ctx, "admin")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"boss"})
+	acl, err = m.ACL(ctx, "checkers")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.HasLen, 0)
+	_, err = m.ACL(ctx, "someacl")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+	// The initial admin users can manage ACLs again.
+	ok, err := m.Authorize(ctx, &namedIdentity{"boss"}, "admin", aclstore.OperationModify)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, true)
+	ok, err = m.Authorize(ctx, &namedIdentity{"eve"}, "admin", aclstore.OperationModify)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(ok, qt.Equals, false)
+
+	// ACLs can be created afresh.
+	err = m.CreateACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	acl, err = m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.HasLen, 0)
+}
+
+func TestResetNotAllowed(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "alice")
+	c.Assert(err, qt.Equals, nil)
+
+	err = m.Reset(ctx)
+	c.Assert(err, qt.ErrorMatches, `reset not allowed`)
+	names, err := m.ACLNames(ctx, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.DeepEquals, []string{"_someacl", "admin", "someacl"})
+}
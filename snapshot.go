@@ -0,0 +1,91 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// ErrBadSignature is the error cause used when a signed
+// snapshot fails verification.
+var ErrBadSignature = errgo.Newf("bad snapshot signature")
+
+// signedSnapshot holds the serialized form of a signed snapshot.
+type signedSnapshot struct {
+	// ACLs holds the canonical JSON encoding of the ACLs: an
+	// object mapping each ACL name to its sorted list of users.
+	ACLs json.RawMessage `json:"acls"`
+
+	// Signature holds the ed25519 signature of ACLs.
+	Signature []byte `json:"signature"`
+}
+
+// SignedSnapshot returns a serialized snapshot of all the ACLs,
+// including meta-ACLs, signed with the given key. The ACLs are
+// encoded deterministically, with names and users sorted, so that
+// snapshots of identical ACLs are identical. The snapshot can be
+// checked with VerifySnapshot.
+//
+// The ACLs are read one at a time, so the snapshot may not reflect
+// a single point in time if the ACLs are being concurrently changed.
+func (m *Manager) SignedSnapshot(ctx context.Context, key ed25519.PrivateKey) ([]byte, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, errgo.Newf("invalid private key")
+	}
+	names, err := m.ACLNames(ctx, true)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	acls := make(map[string][]string, len(names))
+	for _, name := range names {
+		users, err := m.ACL(ctx, name)
+		if err != nil {
+			return nil, errgo.NoteMask(err, "cannot get ACL "+name, isContextError)
+		}
+		users = append([]string{}, users...)
+		sort.Strings(users)
+		acls[name] = users
+	}
+	// Note: json.Marshal sorts map keys, so the encoding is canonical.
+	data, err := json.Marshal(acls)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	snapshot, err := json.Marshal(signedSnapshot{
+		ACLs:      data,
+		Signature: ed25519.Sign(key, data),
+	})
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	return snapshot, nil
+}
+
+// VerifySnapshot checks that the given snapshot, as returned by
+// Manager.SignedSnapshot, was signed by the private key corresponding
+// to the given public key, and returns the ACLs held in it as a map
+// from ACL name to users. It returns an error with an ErrBadSignature
+// cause if the verification fails.
+func VerifySnapshot(snapshot []byte, key ed25519.PublicKey) (map[string][]string, error) {
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errgo.Newf("invalid public key")
+	}
+	var s signedSnapshot
+	if err := json.Unmarshal(snapshot, &s); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal snapshot")
+	}
+	if !ed25519.Verify(key, s.ACLs, s.Signature) {
+		return nil, errgo.WithCausef(nil, ErrBadSignature, "")
+	}
+	var acls map[string][]string
+	if err := json.Unmarshal(s.ACLs, &acls); err != nil {
+		return nil, errgo.Notef(err, "cannot unmarshal snapshot ACLs")
+	}
+	return acls, nil
+}
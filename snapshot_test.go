@@ -0,0 +1,89 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestSignedSnapshot(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.Equals, nil)
+
+	m := newSnapshotManager(c, aclstore.StoreParams{})
+	snapshot, err := m.SignedSnapshot(ctx, priv)
+	c.Assert(err, qt.Equals, nil)
+
+	acls, err := aclstore.VerifySnapshot(snapshot, pub)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, map[string][]string{
+		"admin": {"alice", "bob"},
+		"foo":   {"charlie", "daisy"},
+		"_foo":  {"edward"},
+		"bar":   {},
+		"_bar":  {},
+	})
+
+	// Snapshots of the same ACLs are identical, regardless of
+	// the order in which users are stored.
+	m1 := newSnapshotManager(c, aclstore.StoreParams{
+		InsertionOrder: true,
+	})
+	snapshot1, err := m1.SignedSnapshot(ctx, priv)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(snapshot1), qt.Equals, string(snapshot))
+}
+
+func TestSignedSnapshotTampered(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	pub, priv, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.Equals, nil)
+
+	m := newSnapshotManager(c, aclstore.StoreParams{})
+	snapshot, err := m.SignedSnapshot(ctx, priv)
+	c.Assert(err, qt.Equals, nil)
+
+	tampered := bytes.Replace(snapshot, []byte(`"charlie"`), []byte(`"mallory"`), 1)
+	c.Assert(string(tampered), qt.Not(qt.Equals), string(snapshot))
+	acls, err := aclstore.VerifySnapshot(tampered, pub)
+	c.Assert(err, qt.ErrorMatches, `bad snapshot signature`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadSignature)
+	c.Assert(acls, qt.IsNil)
+
+	// A snapshot signed by a different key fails too.
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	c.Assert(err, qt.Equals, nil)
+	_, err = aclstore.VerifySnapshot(snapshot, otherPub)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadSignature)
+}
+
+func newSnapshotManager(c *qt.C, p aclstore.StoreParams) *aclstore.Manager {
+	ctx := context.Background()
+	p.KV = memsimplekv.NewStore()
+	store := aclstore.NewACLStoreWithParams(p)
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"bob", "alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "daisy", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	err = store.Set(ctx, "_foo", []string{"edward"})
+	c.Assert(err, qt.Equals, nil)
+	return m
+}
@@ -4,7 +4,10 @@
 package aclstore
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"fmt"
 	"sort"
 	"strings"
 	"time"
@@ -14,8 +17,9 @@ import (
 )
 
 var (
-	ErrACLNotFound = errgo.Newf("ACL not found")
-	ErrBadUsername = errgo.Newf("bad username")
+	ErrACLNotFound  = errgo.Newf("ACL not found")
+	ErrBadUsername  = errgo.Newf("bad username")
+	ErrUserNotFound = errgo.Newf("user not found")
 )
 
 // separator is used as the character to divide usernames in the ACL.
@@ -23,6 +27,9 @@ var (
 const separator = "\n"
 
 // ACLStore is the persistent storage interface used by an ACLHandler.
+//
+// If the context passed to any method is cancelled, the method should
+// return the context's error without making any changes.
 type ACLStore interface {
 	// CreateACL creates an ACL with the given name and initial users.
 	// If the ACL already exists, this is a no-op and the initialUsers
@@ -40,9 +47,10 @@ type ACLStore interface {
 
 	// Remove removes users from the ACL with the given name.
 	// It returns an error with an ErrACLNotFound cause if the ACL
-	// does not exist. It returns an error with an ErrUserNotFound
-	// cause if any of the users do not exist.
-	// TODO should it do nothing in that case?
+	// does not exist. Users that are not in the ACL are ignored,
+	// unless the store performs strict removal, in which case
+	// it returns an error with an ErrUserNotFound cause and
+	// leaves the ACL unchanged.
 	Remove(ctx context.Context, aclName string, users []string) error
 
 	// Set sets the users held in the ACL with the given name.
@@ -52,203 +60,1525 @@ type ACLStore interface {
 	Set(ctx context.Context, aclName string, users []string) error
 
 	// Get returns the users held in the ACL with the given name,
-	// sorted lexically. It returns an error with an ErrACLNotFound cause
+	// sorted lexically, or in the order they were added if the
+	// store preserves insertion order. It returns an error with an ErrACLNotFound cause
 	// if the ACL does not exist.
 	Get(ctx context.Context, aclName string) ([]string, error)
 }
 
 // ACLLister enables clients to list stored ACLs.
 type ACLLister interface {
+	// ACLs returns the names of all the stored ACLs, including
+	// meta-ACLs. The names are unordered: their order depends on
+	// the store and may differ between calls. Manager.ACLNames
+	// sorts them.
 	ACLs(ctx context.Context) ([]string, error)
 }
 
+// ACLUpdater enables clients to atomically change the contents of an
+// ACL based on its current contents.
+type ACLUpdater interface {
+	// Update calls f with the current users held in the ACL with
+	// the given name and atomically replaces them with the users
+	// that it returns. The f function may be called several times,
+	// so should not have side-effects. If f returns an error, Update
+	// returns it with its cause unchanged.
+	//
+	// It returns an error with an ErrACLNotFound cause if the ACL
+	// does not exist, or with an ErrBadUsername cause if any of the
+	// returned usernames are not valid.
+	Update(ctx context.Context, aclName string, f func(users []string) ([]string, error)) error
+}
+
+// ACLDeleter is implemented by stores that can delete ACLs.
+type ACLDeleter interface {
+	// DeleteACL deletes the ACL with the given name. Once it has
+	// been deleted, the ACL behaves as if it had never been
+	// created. It returns an error with an ErrACLNotFound cause if
+	// the ACL does not exist.
+	DeleteACL(ctx context.Context, aclName string) error
+}
+
+// ACLResetter is implemented by stores that can replace an ACL
+// without reading it, so that an ACL whose stored value has been
+// corrupted can be recovered.
+type ACLResetter interface {
+	// ResetACL sets the members of the ACL with the given name to
+	// the given users, creating the ACL if it does not exist. Unlike
+	// ACLStore.Set, it succeeds even if the current value cannot be
+	// decoded, in which case anything recorded about the old
+	// members is discarded.
+	ResetACL(ctx context.Context, aclName string, users []string) error
+}
+
+// ACLCounter is implemented by stores that can find the number of
+// members of an ACL more cheaply than by retrieving them.
+type ACLCounter interface {
+	// CountACL returns the number of members of the ACL with the
+	// given name. It returns an error with an ErrACLNotFound cause
+	// if the ACL does not exist.
+	CountACL(ctx context.Context, aclName string) (int, error)
+}
+
+// ACLMembershipChecker is implemented by stores that can find out
+// whether a user is a member of an ACL more cheaply than by retrieving
+// all the members.
+type ACLMembershipChecker interface {
+	// Contains reports whether the given user is a member of the ACL
+	// with the given name, as returned by ACLStore.Get. If the store
+	// folds case, users that differ only in case are treated as the
+	// same. It returns an error with an ErrACLNotFound cause if the
+	// ACL does not exist.
+	Contains(ctx context.Context, aclName, user string) (bool, error)
+}
+
+// ACLDetailer is implemented by stores that record
+// who added each member of an ACL and when.
+type ACLDetailer interface {
+	// GetDetails is like ACLStore.Get except that it also returns
+	// the recorded details of each member. Members added before
+	// the details were recorded have empty details.
+	GetDetails(ctx context.Context, aclName string) ([]Member, error)
+}
+
+// ACLCreator is implemented by stores that can report whether an ACL
+// already existed when creating it.
+type ACLCreator interface {
+	// CreateACLIfNotExists is like ACLStore.CreateACL except that
+	// if the ACL already exists, it returns an error with an
+	// ErrACLExists cause.
+	CreateACLIfNotExists(ctx context.Context, aclName string, initialUsers []string) error
+}
+
+// ACLAliaser is implemented by stores that can hold aliases,
+// which are other names for ACLs.
+type ACLAliaser interface {
+	// SetAlias makes alias another name for the ACL with the
+	// target name, replacing any earlier target of the alias. It
+	// returns an error with an ErrACLExists cause if there is an
+	// ACL named alias. Once it has returned, CreateACL and
+	// CreateACLIfNotExists return an error with an ErrAliasExists
+	// cause for the alias name.
+	SetAlias(ctx context.Context, alias, target string) error
+
+	// AliasTarget returns the target of the alias with the given
+	// name, or the empty string if there is no such alias.
+	AliasTarget(ctx context.Context, name string) (string, error)
+}
+
+// ACLVersioner is implemented by stores that keep a generation
+// number for each ACL that increases every time the ACL is changed.
+type ACLVersioner interface {
+	// GetWithVersion is like ACLStore.Get except that it also
+	// returns the current generation of the ACL.
+	GetWithVersion(ctx context.Context, aclName string) ([]string, uint64, error)
+}
+
+// Member holds a member of an ACL together
+// with the details of when it was added.
+type Member struct {
+	// User holds the member itself.
+	User string
+
+	// AddedBy holds the name of the identity that added the
+	// member, or is empty if that is not known.
+	AddedBy string
+
+	// AddedAt holds the time that the member was added,
+	// or is zero if that is not known.
+	AddedAt time.Time
+}
+
+// ACLRestorer is implemented by stores that keep
+// deleted ACLs for a while so that they can be restored.
+type ACLRestorer interface {
+	// RestoreACL restores the ACL with the given name, which must
+	// have been deleted within the retention period, with the
+	// members it had when it was deleted. It returns an error with
+	// an ErrACLNotFound cause if there is no such deleted ACL.
+	RestoreACL(ctx context.Context, aclName string) error
+
+	// PurgeDeleted permanently removes the deleted ACLs whose
+	// retention period has expired, and returns how many
+	// were removed. It also reclaims any storage still used
+	// by ACLs deleted earlier, so it is worth calling
+	// periodically even when soft deletion is disabled.
+	PurgeDeleted(ctx context.Context) (int, error)
+}
+
+// ACLMigrator is implemented by stores that can upgrade
+// ACLs stored in an older format.
+type ACLMigrator interface {
+	// Migrate rewrites any ACLs that are stored in an older format
+	// in the current format, leaving their users unchanged. ACLs
+	// already in the current format are not rewritten, so it is
+	// safe to call Migrate more than once, and while the store
+	// is in use. ACLs that have been migrated can no longer be read by
+	// versions of the store that predate the current format.
+	Migrate(ctx context.Context) error
+}
+
+// ACLCaseFolder is implemented by stores that can compare users
+// without regard to case.
+type ACLCaseFolder interface {
+	// FoldsCase reports whether the store treats users that differ
+	// only in case as the same user. Such a store keeps the case
+	// that a user was first added with, and the Manager passes the
+	// FoldUser form of each entry to Identity.Allow as well as the
+	// entry itself.
+	FoldsCase() bool
+}
+
+// ACLUserEscaper is implemented by stores that can hold
+// users that contain any characters.
+type ACLUserEscaper interface {
+	// EscapesUsers reports whether the store escapes users when
+	// storing them, so that users containing newlines, which are
+	// otherwise invalid, may be stored. The Manager then accepts
+	// any non-empty user.
+	EscapesUsers() bool
+}
+
+// ACLIndexer is implemented by stores that can maintain an index of
+// the ACLs that hold each user, so that the ACLs for a user can be
+// found without reading every ACL.
+type ACLIndexer interface {
+	// IndexesUsers reports whether the store maintains the index.
+	IndexesUsers() bool
+
+	// IndexedACLs returns the sorted names of the ACLs that the
+	// index records as holding the given user. The index is updated
+	// after each change to an ACL, so it may briefly be out of date,
+	// and it may be inconsistent with the ACLs if the underlying
+	// store has been changed directly or an update has failed.
+	IndexedACLs(ctx context.Context, user string) ([]string, error)
+
+	// RebuildIndex regenerates the index from the ACLs in the
+	// store. It is safe to call while the store is in use.
+	RebuildIndex(ctx context.Context) error
+}
+
+// ACLApprover is implemented by stores that can require a quorum of
+// the members of an ACL to approve an operation, and that record the
+// approvals given.
+type ACLApprover interface {
+	// SetQuorum sets the number of distinct members of the ACL with
+	// the given name that must approve before it is satisfied, and
+	// discards any approvals already recorded. A quorum of zero
+	// returns the ACL to normal. It returns an error with an
+	// ErrACLNotFound cause if the ACL does not exist.
+	SetQuorum(ctx context.Context, aclName string, n int) error
+
+	// Approve records that the given member of the ACL with the
+	// given name has approved. Approving more than once is a no-op.
+	// It returns an error with an ErrACLNotFound cause if the ACL
+	// does not exist, or with an ErrUserNotFound cause if the user
+	// is not a member of it. It returns an error without recording
+	// the approval if the ACL does not require a quorum.
+	Approve(ctx context.Context, aclName, user string) error
+
+	// Approvals returns the quorum of the ACL with the given name,
+	// which is zero if it has none, and the sorted members of the
+	// ACL that have approved. Members that have been removed from
+	// the ACL since they approved are not included, even if they
+	// have been added back. It returns an
+	// error with an ErrACLNotFound cause if the ACL does not exist.
+	Approvals(ctx context.Context, aclName string) (int, []string, error)
+}
+
+// ACLAddReporter is implemented by stores that can report which
+// users an Add actually added.
+type ACLAddReporter interface {
+	// AddReport is like ACLStore.Add except that it also returns
+	// the users that were added and those that were already in the
+	// ACL, in the order they were given. A user that is given more
+	// than once is only reported once.
+	AddReport(ctx context.Context, aclName string, users []string) (added, present []string, err error)
+}
+
+// ACLRemoveReporter is implemented by stores that can report which
+// users a Remove actually removed.
+type ACLRemoveReporter interface {
+	// RemoveReport is like ACLStore.Remove except that it also
+	// returns the users that were removed and those that were not
+	// in the ACL, in the order they were given. A user that is
+	// given more than once is only reported once.
+	RemoveReport(ctx context.Context, aclName string, users []string) (removed, absent []string, err error)
+}
+
+// FoldUser returns the case-folded form of the given user, as used to
+// compare users in a store that folds case. Identity implementations
+// used with such a store should compare the folded forms of their own
+// users against the ACL entries.
+func FoldUser(u string) string {
+	return strings.ToLower(u)
+}
+
 // NewACLStore returns an ACLStore implementation that uses an underlying
 // key-value store for persistent storage.
 func NewACLStore(kv simplekv.Store) ACLStore {
-	return &kvStore{kv}
+	return NewACLStoreWithParams(StoreParams{
+		KV: kv,
+	})
+}
+
+// StoreParams holds the parameters for a NewACLStoreWithParams call.
+//
+// Whatever the parameters, the store writes each ACL in a format that
+// starts with a header recording the format version and metadata such
+// as the generation of the ACL. ACLs in the original format, which
+// holds only the users, are still read, and are rewritten in the
+// current format when they are next changed or when Migrate is called.
+// The change is one way: versions of this package that predate the
+// header read it as a member of the ACL, so every process that shares
+// KV must be upgraded before any of them writes to it, and none of
+// them can be downgraded afterwards.
+type StoreParams struct {
+	// KV holds the underlying key-value store used
+	// for persistent storage.
+	KV simplekv.Store
+
+	// InsertionOrder specifies that the users in an ACL are kept
+	// in the order they were first added rather than being sorted
+	// lexically. Duplicate users are still removed.
+	InsertionOrder bool
+
+	// StrictRemove specifies that Remove should fail with an
+	// ErrUserNotFound error if any of the users to be removed
+	// are not in the ACL. By default, such users are ignored.
+	StrictRemove bool
+
+	// CaseInsensitive specifies that users that differ only in
+	// case are treated as the same user. The case that a user
+	// was first added with is preserved, so, for example, Get
+	// can return "Alice" while adding "alice" is a no-op and
+	// removing "ALICE" removes her.
+	CaseInsensitive bool
+
+	// Namespace, if non-empty, is prefixed to the key of each ACL
+	// in KV, so that several independent sets of ACLs can share
+	// the same underlying store. The ACLs method only returns the
+	// ACLs in the namespace. No namespace should be a prefix of
+	// another, so it is conventional to end each one with a
+	// separator such as ":". A store with no namespace sees the
+	// keys from every namespace.
+	Namespace string
+
+	// TenantNamespace, if non-nil, is called with the tenant
+	// attached to the context of each operation, as returned by
+	// TenantFromContext, and returns the namespace to use for that
+	// operation instead of Namespace, so that a single store can
+	// keep the ACLs of many tenants apart. Operations whose context
+	// has no tenant use Namespace. The namespaces it returns are
+	// subject to the same rules as Namespace.
+	TenantNamespace func(tenant string) string
+
+	// RewriteUser, if non-nil, is used to transform each user
+	// before it is validated and stored, so that, for example,
+	// "alice@CORP" can be stored as "alice". It is applied to every
+	// ACL that the store writes, including those returned by Update
+	// functions, and to the users passed to Add and Remove before
+	// they are compared with the members. Users that have already
+	// been rewritten are rewritten again, so it must leave them
+	// unchanged. Unlike case folding, the rewritten user is what is
+	// stored.
+	RewriteUser func(user string) string
+
+	// DeleteRetention, if non-zero, enables soft deletion: an ACL
+	// deleted with DeleteACL is hidden but kept for this long, during
+	// which it can be brought back with RestoreACL. After that, it is
+	// removed by PurgeDeleted.
+	DeleteRetention time.Duration
+
+	// RecordMembers specifies that the store records who added each
+	// member of an ACL and when, so that they can be retrieved with
+	// GetDetails. The member is recorded as added by the identity
+	// returned by IdentityFromContext for the context of the change,
+	// if it implements NamedIdentity. Members that are already in
+	// an ACL keep their details when it is changed. The details are
+	// held in KV alongside the ACL rather than in it, so recording
+	// them does not make reading or counting the members slower,
+	// but every change to an ACL also updates its details. If this
+	// is disabled, any existing details are discarded when an ACL
+	// is changed.
+	RecordMembers bool
+
+	// IndexUsers specifies that the store maintains an index of the
+	// ACLs that hold each user, held in KV alongside the ACLs, so
+	// that they can be found with IndexedACLs in time proportional
+	// to the number of ACLs that hold the user. Every change to an
+	// ACL also updates the index entry of each user that is added
+	// or removed, so this makes changes more expensive.
+	//
+	// ACLs created before the index was enabled, or changed by a
+	// store without it, are not indexed until RebuildIndex is
+	// called.
+	IndexUsers bool
+
+	// EscapeUsers specifies that users are escaped when they are
+	// stored, so that users containing newlines, which separate
+	// the users in a stored value, can be stored and retrieved
+	// unchanged. Each newline is stored as a backslash followed by
+	// "n", and each backslash as two backslashes. Values record
+	// whether they are escaped, so a store may be switched to
+	// escaping users at any time; values are converted as they are
+	// changed. Values that hold escaped users cannot be read by
+	// versions of this package that do not support escaping.
+	EscapeUsers bool
+
+	// Clock is used to find the current time. If this is nil,
+	// WallClock is used.
+	Clock Clock
+}
+
+// NewACLStoreWithParams is like NewACLStore except that it
+// allows the behavior of the store to be configured.
+func NewACLStoreWithParams(p StoreParams) ACLStore {
+	if p.Clock == nil {
+		p.Clock = WallClock
+	}
+	return &kvStore{
+		kv: p.KV,
+		p:  p,
+	}
 }
 
 type kvStore struct {
 	kv simplekv.Store
+	p  StoreParams
 }
 
-var errAlreadyExists = errgo.Newf("ACL already exists")
+// FoldsCase implements ACLCaseFolder.FoldsCase.
+func (s *kvStore) FoldsCase() bool {
+	return s.p.CaseInsensitive
+}
 
-// ACLs implements the ACLLister interface.
-func (s *kvStore) ACLs(ctx context.Context) ([]string, error) {
+// EscapesUsers implements ACLUserEscaper.EscapesUsers.
+func (s *kvStore) EscapesUsers() bool {
+	return s.p.EscapeUsers
+}
+
+// userKey returns the form of the given user
+// used to compare it with other users.
+func (s *kvStore) userKey(u string) string {
+	if s.p.CaseInsensitive {
+		return FoldUser(u)
+	}
+	return u
+}
+
+// rewriteUsers returns the given users transformed by
+// s.p.RewriteUser. The original slice is not changed.
+func (s *kvStore) rewriteUsers(users []string) []string {
+	if s.p.RewriteUser == nil || len(users) == 0 {
+		return users
+	}
+	rewritten := make([]string, len(users))
+	for i, u := range users {
+		rewritten[i] = s.p.RewriteUser(u)
+	}
+	return rewritten
+}
+
+// namespace returns the namespace of the keys used by an
+// operation with the given context.
+func (s *kvStore) namespace(ctx context.Context) string {
+	if s.p.TenantNamespace != nil {
+		if tenant, ok := TenantFromContext(ctx); ok {
+			return s.p.TenantNamespace(tenant)
+		}
+	}
+	return s.p.Namespace
+}
+
+// key returns the key in s.kv of the ACL with the given name
+// for an operation with the given context.
+func (s *kvStore) key(ctx context.Context, aclName string) string {
+	return s.namespace(ctx) + aclName
+}
+
+// keys returns the keys in s.kv of all the ACLs in the
+// store's namespace. Index, details, alias and deleted
+// entries are not included.
+func (s *kvStore) keys(ctx context.Context) ([]string, error) {
 	lister, ok := s.kv.(simplekv.KeyLister)
 	if !ok {
 		return nil, errgo.Newf("cannot list ACLs")
 	}
-	acls, err := lister.Keys(ctx)
+	keys, err := lister.Keys(ctx)
 	if err != nil {
-		return nil, errgo.Mask(err)
+		return nil, errgo.Mask(err, isContextError)
+	}
+	ns := s.namespace(ctx)
+	nsKeys := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasPrefix(key, ns) && !s.isIndexKey(ctx, key) && !s.isDetailsKey(ctx, key) && !s.isAliasKey(ctx, key) && !s.isDeletedKey(ctx, key) {
+			nsKeys = append(nsKeys, key)
+		}
+	}
+	return nsKeys, nil
+}
+
+// ACLs implements the ACLLister interface. Because deleted ACLs
+// leave a marker in the underlying store until it is reclaimed, the
+// ACLs marked as deleted are read to check whether they have been
+// created again; other ACLs are listed without being read.
+func (s *kvStore) ACLs(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	keys, err := s.keys(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	deleted, err := s.deletedACLs(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	acls := make([]string, 0, len(keys))
+	for _, key := range keys {
+		aclName := strings.TrimPrefix(key, s.namespace(ctx))
+		if !deleted[aclName] {
+			acls = append(acls, aclName)
+			continue
+		}
+		val, err := s.kv.Get(ctx, key)
+		if err != nil {
+			if errgo.Cause(err) == simplekv.ErrNotFound {
+				continue
+			}
+			return nil, errgo.Mask(err, isContextError)
+		}
+		if isDeleted(val) {
+			continue
+		}
+		acls = append(acls, aclName)
 	}
 	return acls, nil
 }
 
-// CreateACL implements ACLStore.CreateACL.
-func (s *kvStore) CreateACL(ctx context.Context, aclName string, initialUsers []string) error {
-	err := s.kv.Update(ctx, aclName, time.Time{}, func(val []byte) ([]byte, error) {
+// ResetACL implements ACLResetter.ResetACL. The generation of the old
+// value is kept if its header can be decoded so that versions are not
+// reused.
+func (s *kvStore) ResetACL(ctx context.Context, aclName string, users []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var old, acl []string
+	var details *detailsChange
+	var wasDeleted bool
+	err := s.kv.Update(ctx, s.key(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		var h valueHeader
+		old = nil
+		wasDeleted = false
 		if val != nil {
-			return nil, errAlreadyExists
+			oldh, oldACL, err := decodeValue(val)
+			if err == nil && !oldh.Deleted {
+				old = oldACL
+			}
+			wasDeleted = err == nil && oldh.Deleted
+			h.Generation = oldh.Generation
 		}
-		newVal, err := s.aclToValue(initialUsers)
+		h.Generation++
+		acl = s.rewriteUsers(users)
+		details = s.recordMembers(&h, nil, acl, true)
+		newVal, err := s.encodeValue(h, acl)
 		if err != nil {
 			return nil, errgo.Mask(err, errgo.Is(ErrBadUsername))
 		}
 		return newVal, nil
 	})
 	if err != nil {
-		if errgo.Cause(err) == errAlreadyExists {
-			return nil
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	if err := s.updateIndex(ctx, aclName, old, acl); err != nil {
+		return errgo.NoteMask(err, "cannot update user index", isContextError)
+	}
+	if err := s.updateDetails(ctx, aclName, details); err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if wasDeleted {
+		if err := s.unmarkDeleted(ctx, aclName); err != nil {
+			return errgo.Mask(err, isContextError)
 		}
-		return errgo.Mask(err, errgo.Is(ErrBadUsername))
 	}
 	return nil
 }
 
-// Add implements ACLStore.Add.
-func (s *kvStore) Add(ctx context.Context, aclName string, users []string) error {
-	err := s.kv.Update(ctx, aclName, time.Time{}, func(val []byte) ([]byte, error) {
-		if val == nil {
-			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+// CreateACL implements ACLStore.CreateACL.
+func (s *kvStore) CreateACL(ctx context.Context, aclName string, initialUsers []string) error {
+	err := s.CreateACLIfNotExists(ctx, aclName, initialUsers)
+	if err != nil && errgo.Cause(err) != ErrACLExists {
+		return errgo.Mask(err, errgo.Is(ErrAliasExists), errgo.Is(ErrBadUsername), isContextError)
+	}
+	return nil
+}
+
+// CreateACLIfNotExists implements ACLCreator.CreateACLIfNotExists.
+func (s *kvStore) CreateACLIfNotExists(ctx context.Context, aclName string, initialUsers []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var created []string
+	var details *detailsChange
+	var oldVal, createdVal []byte
+	err := s.kv.Update(ctx, s.key(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if val != nil && !isDeleted(val) {
+			return nil, errgo.WithCausef(nil, ErrACLExists, "ACL %q already exists", aclName)
+		}
+		created = nil
+		oldVal = val
+		var h valueHeader
+		if val != nil {
+			// Carry on from the generation of the deleted
+			// ACL so that versions are never reused.
+			old, _, err := decodeValue(val)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			h.Generation = old.Generation
 		}
-		acl := s.valueToACL(val)
-		acl = append(acl, users...)
-		newVal, err := s.aclToValue(acl)
+		h.Generation++
+		users := s.rewriteUsers(initialUsers)
+		details = s.recordMembers(&h, nil, users, true)
+		newVal, err := s.encodeValue(h, users)
 		if err != nil {
 			return nil, errgo.Mask(err, errgo.Is(ErrBadUsername))
 		}
+		created = users
+		createdVal = newVal
 		return newVal, nil
 	})
 	if err != nil {
-		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
+		return errgo.Mask(err, errgo.Is(ErrACLExists), errgo.Is(ErrBadUsername), isContextError)
+	}
+	// See SetAlias for why the alias is checked
+	// only after the ACL has been created.
+	target, err := s.AliasTarget(ctx, aclName)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if target != "" {
+		if err := s.undoCreate(ctx, aclName, createdVal, oldVal); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+		return errgo.WithCausef(nil, ErrAliasExists, "cannot create ACL %q: name is an alias", aclName)
+	}
+	if err := s.updateIndex(ctx, aclName, nil, created); err != nil {
+		return errgo.NoteMask(err, "cannot update user index", isContextError)
+	}
+	if err := s.updateDetails(ctx, aclName, details); err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	if oldVal != nil {
+		if err := s.unmarkDeleted(ctx, aclName); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
 	}
 	return nil
 }
 
+// Add implements ACLStore.Add.
+func (s *kvStore) Add(ctx context.Context, aclName string, users []string) error {
+	_, _, err := s.AddReport(ctx, aclName, users)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+	}
+	return nil
+}
+
+// AddReport implements ACLAddReporter.AddReport.
+func (s *kvStore) AddReport(ctx context.Context, aclName string, users []string) (added, present []string, err error) {
+	users = s.rewriteUsers(users)
+	err = s.update(ctx, aclName, func(acl []string) ([]string, error) {
+		present, added = partitionUsers(acl, users, s.userKey)
+		return append(acl, added...), nil
+	})
+	if err != nil {
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
+	}
+	return added, present, nil
+}
+
 // Remove implements ACLStore.Remove.
 func (s *kvStore) Remove(ctx context.Context, aclName string, users []string) error {
-	err := s.kv.Update(ctx, aclName, time.Time{}, func(val []byte) ([]byte, error) {
-		if val == nil {
-			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+	_, _, err := s.RemoveReport(ctx, aclName, users)
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrUserNotFound), isContextError)
+	}
+	return nil
+}
+
+// RemoveReport implements ACLRemoveReporter.RemoveReport.
+func (s *kvStore) RemoveReport(ctx context.Context, aclName string, users []string) (removed, absent []string, err error) {
+	users = s.rewriteUsers(users)
+	err = s.update(ctx, aclName, func(acl []string) ([]string, error) {
+		if s.p.StrictRemove {
+			if u, ok := missingUser(acl, users, s.userKey); ok {
+				return nil, errgo.WithCausef(nil, ErrUserNotFound, "user %q not found", u)
+			}
+		}
+		removed, absent = partitionUsers(acl, users, s.userKey)
+		remove := make(map[string]bool, len(removed))
+		for _, r := range removed {
+			remove[s.userKey(r)] = true
 		}
-		acl := s.valueToACL(val)
 		newACL := make([]string, 0, len(acl))
 		for _, a := range acl {
-			remove := false
-			for _, r := range users {
-				if r == a {
-					remove = true
-					break
-				}
-			}
-			if !remove {
+			if !remove[s.userKey(a)] {
 				newACL = append(newACL, a)
 			}
 		}
-		newVal, err := s.aclToValue(newACL)
-		if err != nil {
-			return nil, errgo.Mask(err, errgo.Is(ErrBadUsername))
-		}
-		return newVal, nil
+		return newACL, nil
 	})
 	if err != nil {
-		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername))
+		return nil, nil, errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrUserNotFound), isContextError)
 	}
-	return nil
+	return removed, absent, nil
 }
 
 // Set implements ACLStore.Set.
 func (s *kvStore) Set(ctx context.Context, aclName string, users []string) error {
-	newVal, err := s.aclToValue(users)
+	err := s.update(ctx, aclName, func([]string) ([]string, error) {
+		return users, nil
+	})
 	if err != nil {
-		return errgo.Mask(err, errgo.Is(ErrBadUsername))
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), isContextError)
 	}
-	err = s.kv.Update(ctx, aclName, time.Time{}, func(val []byte) ([]byte, error) {
+	return nil
+}
+
+// Update implements ACLUpdater.Update.
+func (s *kvStore) Update(ctx context.Context, aclName string, f func(users []string) ([]string, error)) error {
+	err := s.update(ctx, aclName, f)
+	if err != nil {
+		return errgo.Mask(err, errgo.Any)
+	}
+	return nil
+}
+
+// update atomically replaces the users in the ACL with the given
+// name with the result of calling f on its current users, rewritten
+// by s.p.RewriteUser. Any error returned by f is returned with its
+// cause unchanged.
+func (s *kvStore) update(ctx context.Context, aclName string, f func(acl []string) ([]string, error)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var oldACL, newACL []string
+	var details *detailsChange
+	err := s.kv.Update(ctx, s.key(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
 		if val == nil {
 			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
 		}
+		h, acl, err := decodeValue(val)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if h.Deleted {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		if s.p.IndexUsers || s.p.RecordMembers {
+			oldACL = copyUsers(acl)
+		}
+		acl, err = f(acl)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Any)
+		}
+		acl = s.rewriteUsers(acl)
+		details = s.recordMembers(&h, oldACL, acl, false)
+		h.Generation++
+		newVal, err := s.encodeValue(h, acl)
+		if err != nil {
+			return nil, errgo.Mask(err, errgo.Is(ErrBadUsername))
+		}
+		newACL = acl
 		return newVal, nil
 	})
 	if err != nil {
-		return errgo.Mask(err, errgo.Is(ErrACLNotFound))
+		return errgo.Mask(err, errgo.Any)
+	}
+	if err := s.updateIndex(ctx, aclName, oldACL, newACL); err != nil {
+		return errgo.NoteMask(err, "cannot update user index", isContextError)
+	}
+	if err := s.updateDetails(ctx, aclName, details); err != nil {
+		return errgo.Mask(err, isContextError)
 	}
 	return nil
 }
 
 // Get implements ACLStore.Get.
 func (s *kvStore) Get(ctx context.Context, aclName string) ([]string, error) {
-	val, err := s.kv.Get(ctx, aclName)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	val, err := s.kv.Get(ctx, s.key(ctx, aclName))
 	if err != nil {
 		if errgo.Cause(err) == simplekv.ErrNotFound {
 			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
 		}
-		return nil, errgo.Mask(err)
+		return nil, errgo.Mask(err, isContextError)
+	}
+	h, acl, err := decodeValue(val)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get ACL %q", aclName)
 	}
-	return s.valueToACL(val), nil
+	if h.Deleted {
+		return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+	}
+	return acl, nil
 }
 
-func (*kvStore) aclToValue(acl []string) ([]byte, error) {
-	if len(acl) == 0 {
-		return nil, nil
+// GetWithVersion implements ACLVersioner.GetWithVersion. An ACL
+// stored before generations were recorded has generation zero
+// until it is next changed.
+func (s *kvStore) GetWithVersion(ctx context.Context, aclName string) ([]string, uint64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
 	}
-	acl = canonicalACL(acl)
-	size := 0
-	for _, a := range acl {
-		size += len(a)
-		if !validUser(a) {
-			return nil, errgo.WithCausef(nil, ErrBadUsername, "invalid user name %q", a)
+	val, err := s.kv.Get(ctx, s.key(ctx, aclName))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return nil, 0, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		return nil, 0, errgo.Mask(err, isContextError)
+	}
+	h, acl, err := decodeValue(val)
+	if err != nil {
+		return nil, 0, errgo.Notef(err, "cannot get ACL %q", aclName)
+	}
+	if h.Deleted {
+		return nil, 0, errgo.WithCausef(nil, ErrACLNotFound, "")
+	}
+	return acl, h.Generation, nil
+}
+
+// GetDetails implements ACLDetailer.GetDetails.
+func (s *kvStore) GetDetails(ctx context.Context, aclName string) ([]Member, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	val, err := s.kv.Get(ctx, s.key(ctx, aclName))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
 		}
+		return nil, errgo.Mask(err, isContextError)
 	}
-	out := make([]byte, 0, size+len(acl))
-	out = append(out, acl[0]...)
-	for _, a := range acl[1:] {
+	h, acl, err := decodeValue(val)
+	if err != nil {
+		return nil, errgo.Notef(err, "cannot get ACL %q", aclName)
+	}
+	if h.Deleted {
+		return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+	}
+	details, err := s.getDetails(ctx, aclName, h)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	members := make([]Member, len(acl))
+	for i, u := range acl {
+		r := details[s.userKey(u)]
+		members[i] = Member{
+			User:    u,
+			AddedBy: r.AddedBy,
+			AddedAt: r.AddedAt,
+		}
+	}
+	return members, nil
+}
+
+// CountACL implements ACLCounter.CountACL. The users in
+// the stored value are counted without being decoded.
+func (s *kvStore) CountACL(ctx context.Context, aclName string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	val, err := s.kv.Get(ctx, s.key(ctx, aclName))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return 0, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		return 0, errgo.Mask(err, isContextError)
+	}
+	h, n, err := countValue(val)
+	if err != nil {
+		return 0, errgo.Notef(err, "cannot count ACL %q", aclName)
+	}
+	if h.Deleted {
+		return 0, errgo.WithCausef(nil, ErrACLNotFound, "")
+	}
+	return n, nil
+}
+
+// Contains implements ACLMembershipChecker.Contains. The stored value
+// is searched for the user without being decoded.
+func (s *kvStore) Contains(ctx context.Context, aclName, user string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	val, err := s.kv.Get(ctx, s.key(ctx, aclName))
+	if err != nil {
+		if errgo.Cause(err) == simplekv.ErrNotFound {
+			return false, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		return false, errgo.Mask(err, isContextError)
+	}
+	h, ok, err := s.containsValue(val, user)
+	if err != nil {
+		return false, errgo.Notef(err, "cannot check ACL %q", aclName)
+	}
+	if h.Deleted {
+		return false, errgo.WithCausef(nil, ErrACLNotFound, "")
+	}
+	return ok, nil
+}
+
+// DeleteACL implements ACLDeleter.DeleteACL. As the underlying store
+// cannot delete keys, the ACL is replaced by a marker that is set to
+// expire so that the store may garbage collect it, and is recorded as
+// deleted so that it can be left out of listings without being read.
+// If soft deletion is enabled, the marker holds the members of the ACL
+// until the retention period expires; otherwise it expires
+// immediately. PurgeDeleted reclaims markers that have expired.
+func (s *kvStore) DeleteACL(ctx context.Context, aclName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	now := s.p.Clock.Now()
+	expire := now.Add(s.p.DeleteRetention)
+	var deleted []string
+	var hadDetails bool
+	err := s.kv.Update(ctx, s.key(ctx, aclName), expire, func(val []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if val == nil || isDeleted(val) {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		h, acl, err := decodeValue(val)
+		deleted = acl
+		hadDetails = h.Details
+		if s.p.DeleteRetention <= 0 {
+			// The members are discarded, so an undecodable
+			// value can still be deleted.
+			return s.encodeValue(valueHeader{
+				Deleted:    true,
+				Generation: h.Generation + 1,
+			}, nil)
+		}
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		h.Generation++
+		h.Deleted = true
+		h.DeletedAt = &now
+		return s.encodeValue(h, acl)
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	if err := s.updateIndex(ctx, aclName, deleted, nil); err != nil {
+		return errgo.NoteMask(err, "cannot update user index", isContextError)
+	}
+	if hadDetails && s.p.DeleteRetention <= 0 {
+		if err := s.discardDetails(ctx, aclName); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	if err := s.markDeleted(ctx, aclName); err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	return nil
+}
+
+// RestoreACL implements ACLRestorer.RestoreACL.
+func (s *kvStore) RestoreACL(ctx context.Context, aclName string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var restored []string
+	err := s.kv.Update(ctx, s.key(ctx, aclName), time.Time{}, func(val []byte) ([]byte, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if val == nil {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		h, acl, err := decodeValue(val)
+		if err != nil {
+			return nil, errgo.Mask(err)
+		}
+		if !h.Deleted {
+			return nil, errgo.Newf("ACL %q has not been deleted", aclName)
+		}
+		if !s.restorable(h) {
+			return nil, errgo.WithCausef(nil, ErrACLNotFound, "")
+		}
+		h.Deleted = false
+		h.DeletedAt = nil
+		h.Generation++
+		restored = acl
+		return s.encodeValue(h, acl)
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), isContextError)
+	}
+	if err := s.updateIndex(ctx, aclName, nil, restored); err != nil {
+		return errgo.NoteMask(err, "cannot update user index", isContextError)
+	}
+	if err := s.unmarkDeleted(ctx, aclName); err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	return nil
+}
+
+// PurgeDeleted implements ACLRestorer.PurgeDeleted. The members of
+// each expired ACL are discarded, leaving only a marker that the
+// underlying store may garbage collect. The markers of deleted ACLs
+// that can no longer be restored are then reclaimed: they are removed
+// if the underlying store implements KeyDeleter, and ACLs whose
+// markers have gone are no longer marked as deleted.
+func (s *kvStore) PurgeDeleted(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	deleted, err := s.deletedACLs(ctx)
+	if err != nil {
+		return 0, errgo.Mask(err, isContextError)
+	}
+	aclNames := make([]string, 0, len(deleted))
+	for aclName := range deleted {
+		aclNames = append(aclNames, aclName)
+	}
+	sort.Strings(aclNames)
+	n := 0
+	for _, aclName := range aclNames {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		hadDetails := false
+		err := s.kv.Update(ctx, s.key(ctx, aclName), s.p.Clock.Now(), func(val []byte) ([]byte, error) {
+			if !hasHeader(val) {
+				return nil, errNotPurgeable
+			}
+			h, _, err := decodeValue(val)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			if !h.Deleted || h.DeletedAt == nil || s.restorable(h) {
+				return nil, errNotPurgeable
+			}
+			hadDetails = h.Details
+			return s.encodeValue(valueHeader{
+				Deleted:    true,
+				Generation: h.Generation,
+			}, nil)
+		})
+		switch {
+		case err == nil:
+			n++
+		case errgo.Cause(err) != errNotPurgeable:
+			return n, errgo.NoteMask(err, fmt.Sprintf("cannot purge ACL %q", aclName), isContextError)
+		}
+		if hadDetails {
+			if err := s.discardDetails(ctx, aclName); err != nil {
+				return n, errgo.Mask(err, isContextError)
+			}
+		}
+	}
+	if err := s.reclaimDeleted(ctx, aclNames); err != nil {
+		return n, errgo.Mask(err, isContextError)
+	}
+	return n, nil
+}
+
+// restorable reports whether the deleted ACL with the given
+// header can still be restored.
+func (s *kvStore) restorable(h valueHeader) bool {
+	return h.DeletedAt != nil && s.p.Clock.Now().Before(h.DeletedAt.Add(s.p.DeleteRetention))
+}
+
+// Migrate implements ACLMigrator.Migrate.
+func (s *kvStore) Migrate(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	keys, err := s.keys(ctx)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		aclName := strings.TrimPrefix(key, s.namespace(ctx))
+		var details *detailsChange
+		err := s.kv.Update(ctx, key, time.Time{}, func(val []byte) ([]byte, error) {
+			if val == nil {
+				return nil, errAlreadyCurrent
+			}
+			h, acl, err := decodeValue(val)
+			if err != nil {
+				return nil, errgo.Mask(err)
+			}
+			// Member details held in the header are moved to
+			// the details entry if they are still recorded.
+			moveDetails := h.Members != nil && s.p.RecordMembers
+			if h.Version == valueVersion && !moveDetails {
+				return nil, errAlreadyCurrent
+			}
+			details = nil
+			if moveDetails {
+				details = s.recordMembers(&h, acl, acl, false)
+			}
+			return s.encodeValue(h, acl)
+		})
+		if err != nil && errgo.Cause(err) != errAlreadyCurrent {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot migrate ACL %q", aclName), isContextError)
+		}
+		if err := s.updateDetails(ctx, aclName, details); err != nil {
+			return errgo.Mask(err, isContextError)
+		}
+	}
+	return nil
+}
+
+var errAlreadyCurrent = errgo.Newf("value already in current format")
+
+var errNotPurgeable = errgo.Newf("ACL cannot be purged")
+
+// valueVersion holds the version of the current format of stored values.
+//
+// A value in the current format starts with the separator, followed by
+// a JSON-encoded valueHeader, followed by each user preceded by the
+// separator. A value in the original format holds only the users joined
+// by the separator; it never starts with the separator because
+// usernames cannot be empty. If the header records that the value is
+// escaped, each user is escaped with escapeUser.
+const valueVersion = 1
+
+// valueHeader holds the metadata stored at the start
+// of a value in any format other than the original.
+type valueHeader struct {
+	// Version holds the format version of the value.
+	Version int `json:"v"`
+
+	// Deleted records that the ACL has been deleted.
+	Deleted bool `json:"deleted,omitempty"`
+
+	// DeletedAt holds the time that the ACL was deleted if it
+	// was soft-deleted and its members have been kept.
+	DeletedAt *time.Time `json:"deleted-at,omitempty"`
+
+	// Members holds the details of each member of the ACL, keyed
+	// by the form of the user used to compare users, in values
+	// stored by versions of this package that recorded them in
+	// the header. They are moved to the details entry of the ACL
+	// when it is next changed or migrated.
+	Members map[string]memberRecord `json:"members,omitempty"`
+
+	// Details records that the details of the members of the
+	// ACL are held in its details entry.
+	Details bool `json:"details,omitempty"`
+
+	// Generation holds the number of times that the ACL has been
+	// changed. It is kept when the ACL is deleted so that an ACL
+	// that is created again does not reuse earlier generations.
+	Generation uint64 `json:"gen,omitempty"`
+
+	// Quorum holds the number of distinct members that must
+	// approve before the ACL is satisfied, or zero if the ACL
+	// does not require a quorum.
+	Quorum int `json:"quorum,omitempty"`
+
+	// Approvals holds the sorted keys, as returned by userKey,
+	// of the members that have approved since the quorum was set.
+	Approvals []string `json:"approvals,omitempty"`
+
+	// Escaped records that the users in the value have been
+	// escaped with escapeUser.
+	Escaped bool `json:"esc,omitempty"`
+}
+
+// encodeValue returns the stored form of an ACL with
+// the given header and users.
+func (s *kvStore) encodeValue(h valueHeader, acl []string) ([]byte, error) {
+	if s.p.InsertionOrder || s.p.CaseInsensitive {
+		acl = dedupACL(acl, s.userKey)
+	}
+	if !s.p.InsertionOrder {
+		acl = canonicalACL(acl)
+	}
+	if err := validateUsers(acl, s.p.EscapeUsers); err != nil {
+		return nil, errgo.Mask(err, errgo.Is(ErrBadUsername))
+	}
+	if len(h.Approvals) > 0 {
+		// Drop the approvals of users that are no longer members,
+		// so that they don't count again if the users are added
+		// back.
+		h.Approvals = s.memberApprovals(h.Approvals, acl)
+	}
+	h.Version = valueVersion
+	h.Escaped = s.p.EscapeUsers
+	if h.Escaped {
+		escaped := make([]string, len(acl))
+		for i, a := range acl {
+			escaped[i] = escapeUser(a)
+		}
+		acl = escaped
+	}
+	hdata, err := json.Marshal(h)
+	if err != nil {
+		return nil, errgo.Mask(err)
+	}
+	size := len(separator) + len(hdata)
+	for _, a := range acl {
+		size += len(separator) + len(a)
+	}
+	out := make([]byte, 0, size)
+	out = append(out, separator...)
+	out = append(out, hdata...)
+	for _, a := range acl {
 		out = append(out, separator...)
 		out = append(out, a...)
 	}
 	return out, nil
 }
 
-func (*kvStore) valueToACL(data []byte) []string {
-	if len(data) == 0 {
-		return nil
+// decodeValue returns the header and users held in the given stored
+// value. A value in the original format has a zero header.
+func decodeValue(data []byte) (valueHeader, []string, error) {
+	if !hasHeader(data) {
+		return valueHeader{}, splitUsers(data), nil
+	}
+	data = data[len(separator):]
+	hdata := data
+	var acl []string
+	if i := bytes.Index(data, []byte(separator)); i >= 0 {
+		hdata = data[:i]
+		acl = splitUsers(data[i+len(separator):])
+	}
+	var h valueHeader
+	if err := json.Unmarshal(hdata, &h); err != nil {
+		return valueHeader{}, nil, errgo.Notef(err, "cannot decode ACL header")
+	}
+	if h.Escaped {
+		for i, a := range acl {
+			acl[i] = unescapeUser(a)
+		}
+	}
+	return h, acl, nil
+}
+
+// escapeUser returns the given user with each backslash
+// and separator escaped with a backslash, so that the result
+// does not contain the separator.
+func escapeUser(u string) string {
+	if !strings.ContainsAny(u, `\`+separator) {
+		return u
+	}
+	var b strings.Builder
+	for i := 0; i < len(u); i++ {
+		switch u[i] {
+		case '\\':
+			b.WriteString(`\\`)
+		case separator[0]:
+			b.WriteString(`\n`)
+		default:
+			b.WriteByte(u[i])
+		}
+	}
+	return b.String()
+}
+
+// unescapeUser reverses escapeUser.
+func unescapeUser(u string) string {
+	if !strings.Contains(u, `\`) {
+		return u
+	}
+	var b strings.Builder
+	for i := 0; i < len(u); i++ {
+		if u[i] == '\\' && i+1 < len(u) {
+			i++
+			switch u[i] {
+			case 'n':
+				b.WriteString(separator)
+				continue
+			case '\\':
+				b.WriteByte('\\')
+				continue
+			}
+			b.WriteByte('\\')
+		}
+		b.WriteByte(u[i])
+	}
+	return b.String()
+}
+
+// countValue is like decodeValue except that it returns
+// only the number of users in the ACL.
+func countValue(data []byte) (valueHeader, int, error) {
+	sep := []byte(separator)
+	if !hasHeader(data) {
+		return valueHeader{}, countUsers(data), nil
+	}
+	data = data[len(sep):]
+	hdata := data
+	n := 0
+	if i := bytes.Index(data, sep); i >= 0 {
+		hdata = data[:i]
+		n = countUsers(data[i+len(sep):])
+	}
+	var h valueHeader
+	if err := json.Unmarshal(hdata, &h); err != nil {
+		return valueHeader{}, 0, errgo.Notef(err, "cannot decode ACL header")
+	}
+	return h, n, nil
+}
+
+// containsValue returns the header held in the given stored value
+// and reports whether the value holds the given user. When case is
+// folded, each user is compared in turn; otherwise the user is
+// searched for directly in the separated list.
+func (s *kvStore) containsValue(data []byte, user string) (valueHeader, bool, error) {
+	sep := []byte(separator)
+	var h valueHeader
+	if hasHeader(data) {
+		data = data[len(sep):]
+		hdata := data
+		if i := bytes.Index(data, sep); i >= 0 {
+			hdata, data = data[:i], data[i+len(sep):]
+		} else {
+			data = nil
+		}
+		if err := json.Unmarshal(hdata, &h); err != nil {
+			return valueHeader{}, false, errgo.Notef(err, "cannot decode ACL header")
+		}
+	}
+	if user == "" {
+		// Empty entries are ignored by splitUsers.
+		return h, false, nil
+	}
+	if s.p.CaseInsensitive {
+		key := s.userKey(user)
+		for len(data) > 0 {
+			i := bytes.Index(data, sep)
+			if i < 0 {
+				i = len(data)
+			}
+			u := string(data[:i])
+			if h.Escaped {
+				u = unescapeUser(u)
+			}
+			if s.userKey(u) == key {
+				return h, true, nil
+			}
+			data = data[i:]
+			if len(data) > 0 {
+				data = data[len(sep):]
+			}
+		}
+		return h, false, nil
+	}
+	u := []byte(user)
+	if h.Escaped {
+		u = []byte(escapeUser(user))
+	} else if bytes.Contains(u, sep) {
+		return h, false, nil
+	}
+	// The user must match a whole entry: the only one, the first,
+	// the last or one between two separators.
+	entry := make([]byte, 0, len(u)+2*len(sep))
+	entry = append(append(append(entry, sep...), u...), sep...)
+	ok := bytes.Equal(data, u) ||
+		bytes.HasPrefix(data, entry[len(sep):]) ||
+		bytes.HasSuffix(data, entry[:len(entry)-len(sep)]) ||
+		bytes.Contains(data, entry)
+	return h, ok, nil
+}
+
+// splitUsers returns the users held in the given separated list.
+// Empty entries, which valid values never hold, are ignored so that
+// values with stray separators cannot produce invalid users.
+func splitUsers(data []byte) []string {
+	var acl []string
+	for len(data) > 0 {
+		i := bytes.Index(data, []byte(separator))
+		if i < 0 {
+			i = len(data)
+		}
+		if i > 0 {
+			acl = append(acl, string(data[:i]))
+		}
+		data = data[i:]
+		if len(data) > 0 {
+			data = data[len(separator):]
+		}
 	}
-	return strings.Split(string(data), separator)
+	return acl
+}
+
+// countUsers returns the number of users that
+// splitUsers would return for the given data.
+func countUsers(data []byte) int {
+	n := 0
+	for len(data) > 0 {
+		i := bytes.Index(data, []byte(separator))
+		if i < 0 {
+			i = len(data)
+		}
+		if i > 0 {
+			n++
+		}
+		data = data[i:]
+		if len(data) > 0 {
+			data = data[len(separator):]
+		}
+	}
+	return n
+}
+
+// isDeleted reports whether the given stored
+// value marks a deleted ACL.
+func isDeleted(data []byte) bool {
+	if !hasHeader(data) {
+		return false
+	}
+	h, _, err := decodeValue(data)
+	return err == nil && h.Deleted
+}
+
+// hasHeader reports whether the given stored value starts with
+// a header. Only values in the original format have no header.
+func hasHeader(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(separator))
+}
+
+// validateUsers returns an error with an ErrBadUsername
+// cause if any of the given users is not valid. If escaped
+// is true, the users will be escaped when they are stored.
+func validateUsers(acl []string, escaped bool) error {
+	for _, a := range acl {
+		if !validUser(a, escaped) {
+			return errgo.WithCausef(nil, ErrBadUsername, "invalid user name %q", a)
+		}
+	}
+	return nil
 }
 
+// canonicalACL returns acl sorted with duplicates removed. If acl
+// is already in that form, it is returned unchanged; otherwise a new
+// slice is returned. Users are commonly appended to an ACL that is
+// already canonical, so only the users after the longest canonical
+// prefix are sorted, and each of them is then inserted into the
+// prefix by copying the runs of the prefix between them, which avoids
+// sorting the whole ACL each time.
 func canonicalACL(acl []string) []string {
-	if len(acl) < 2 {
+	n := 1
+	for n < len(acl) && acl[n-1] < acl[n] {
+		n++
+	}
+	if n >= len(acl) {
 		return acl
 	}
-	needSort := false
-	prev := acl[0]
-	for _, a := range acl[1:] {
-		if a <= prev {
-			needSort = true
-			break
+	prefix := acl[:n]
+	tail := make([]string, len(acl)-n)
+	copy(tail, acl[n:])
+	sort.Strings(tail)
+	acl1 := make([]string, 0, len(acl))
+	for i, a := range tail {
+		if i > 0 && tail[i-1] == a {
+			continue
+		}
+		j := sort.SearchStrings(prefix, a)
+		acl1 = append(acl1, prefix[:j]...)
+		prefix = prefix[j:]
+		if len(prefix) == 0 || prefix[0] != a {
+			acl1 = append(acl1, a)
 		}
-		prev = a
 	}
-	if !needSort {
+	return append(acl1, prefix...)
+}
+
+// missingUser returns the first of the given users that is
+// not in acl and reports whether there was one. Users
+// are compared by the result of calling key on them.
+func missingUser(acl, users []string, key func(string) string) (string, bool) {
+	for _, u := range users {
+		found := false
+		for _, a := range acl {
+			if key(a) == key(u) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return u, true
+		}
+	}
+	return "", false
+}
+
+// partitionUsers returns the given users that are in acl and those
+// that are not, in the order they were given and without duplicates.
+// Users are compared by the result of calling key on them.
+func partitionUsers(acl, users []string, key func(string) string) (in, out []string) {
+	found := make(map[string]bool, len(acl))
+	for _, a := range acl {
+		found[key(a)] = true
+	}
+	seen := make(map[string]bool, len(users))
+	for _, u := range users {
+		k := key(u)
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		if found[k] {
+			in = append(in, u)
+		} else {
+			out = append(out, u)
+		}
+	}
+	return in, out
+}
+
+// dedupACL returns acl with any duplicate users removed,
+// keeping the first occurrence of each. Users are
+// compared by the result of calling key on them.
+func dedupACL(acl []string, key func(string) string) []string {
+	if len(acl) < 2 {
 		return acl
 	}
-	acl1 := make([]string, len(acl))
-	copy(acl1, acl)
-	sort.Strings(acl1)
-	acl = acl1
-	j := 1
-	for i, a := range acl[1:] {
-		if acl[i] == a {
+	seen := make(map[string]bool, len(acl))
+	acl1 := make([]string, 0, len(acl))
+	for _, a := range acl {
+		k := key(a)
+		if seen[k] {
 			continue
 		}
-		acl[j] = a
-		j++
+		seen[k] = true
+		acl1 = append(acl1, a)
 	}
-	return acl[:j]
+	return acl1
+}
+
+// isContextError reports whether err is one of the errors
+// returned by context.Context.Err.
+func isContextError(err error) bool {
+	return err == context.Canceled || err == context.DeadlineExceeded
 }
 
-func validUser(u string) bool {
-	return len(u) > 0 && !strings.Contains(u, separator)
+func validUser(u string, escaped bool) bool {
+	return len(u) > 0 && (escaped || !strings.Contains(u, separator))
 }
@@ -5,16 +5,55 @@ package aclstore_test
 
 import (
 	"context"
+	"fmt"
+	"math/rand"
 	"sort"
+	"strings"
 	"testing"
+	"time"
 
 	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv"
 	"github.com/juju/simplekv/memsimplekv"
 	"gopkg.in/errgo.v1"
 
 	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/aclstoretest"
 )
 
+func TestStoreConformance(t *testing.T) {
+	aclstoretest.RunStoreTests(t, func() aclstore.ACLStore {
+		return aclstore.NewACLStore(memsimplekv.NewStore())
+	})
+}
+
+func TestStrictStoreConformance(t *testing.T) {
+	aclstoretest.RunStoreTests(t, func() aclstore.ACLStore {
+		return aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:           memsimplekv.NewStore(),
+			StrictRemove: true,
+		})
+	})
+}
+
+func TestIndexedStoreConformance(t *testing.T) {
+	aclstoretest.RunStoreTests(t, func() aclstore.ACLStore {
+		return aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:         memsimplekv.NewStore(),
+			IndexUsers: true,
+		})
+	})
+}
+
+func TestEscapedStoreConformance(t *testing.T) {
+	aclstoretest.RunStoreTests(t, func() aclstore.ACLStore {
+		return aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:          memsimplekv.NewStore(),
+			EscapeUsers: true,
+		})
+	})
+}
+
 func TestCreateACL(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
@@ -91,6 +130,49 @@ func TestRemove(t *testing.T) {
 	c.Assert(acl, qt.DeepEquals, []string{"a", "d"})
 }
 
+func TestRemoveAbsentUser(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+
+	err := store.CreateACL(ctx, "foo", []string{"a", "b"})
+	c.Assert(err, qt.Equals, nil)
+
+	err = store.Remove(ctx, "foo", []string{"a", "x"})
+	c.Assert(err, qt.Equals, nil)
+
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"b"})
+}
+
+func TestStrictRemoveAbsentUser(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:           memsimplekv.NewStore(),
+		StrictRemove: true,
+	})
+
+	err := store.CreateACL(ctx, "foo", []string{"a", "b", "c"})
+	c.Assert(err, qt.Equals, nil)
+
+	err = store.Remove(ctx, "foo", []string{"a", "x"})
+	c.Assert(err, qt.ErrorMatches, `user "x" not found`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrUserNotFound)
+
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"a", "b", "c"})
+
+	err = store.Remove(ctx, "foo", []string{"a", "c"})
+	c.Assert(err, qt.Equals, nil)
+
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"b"})
+}
+
 func TestSetNonExistingACL(t *testing.T) {
 	ctx := context.Background()
 	c := qt.New(t)
@@ -160,3 +242,1039 @@ func TestACLLister(t *testing.T) {
 	sort.Strings(acls)
 	c.Assert(acls, qt.DeepEquals, []string{"bar", "choo", "foo"})
 }
+
+var insertionOrderTests = []struct {
+	testName          string
+	insertionOrder    bool
+	expectAfterCreate []string
+	expectAfterAdd    []string
+	expectAfterRemove []string
+	expectAfterSet    []string
+}{{
+	testName:          "sorted",
+	expectAfterCreate: []string{"alice", "bob", "charlie"},
+	expectAfterAdd:    []string{"alice", "bob", "charlie", "daisy", "edward"},
+	expectAfterRemove: []string{"alice", "charlie", "daisy", "edward"},
+	expectAfterSet:    []string{"alice", "bob", "zach"},
+}, {
+	testName:          "insertion_order",
+	insertionOrder:    true,
+	expectAfterCreate: []string{"charlie", "alice", "bob"},
+	expectAfterAdd:    []string{"charlie", "alice", "bob", "edward", "daisy"},
+	expectAfterRemove: []string{"charlie", "alice", "edward", "daisy"},
+	expectAfterSet:    []string{"zach", "bob", "alice"},
+}}
+
+func TestInsertionOrder(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	for _, test := range insertionOrderTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+				KV:             memsimplekv.NewStore(),
+				InsertionOrder: test.insertionOrder,
+			})
+			err := store.CreateACL(ctx, "foo", []string{"charlie", "alice", "bob", "alice"})
+			c.Assert(err, qt.Equals, nil)
+			acl, err := store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectAfterCreate)
+
+			err = store.Add(ctx, "foo", []string{"edward", "alice", "daisy"})
+			c.Assert(err, qt.Equals, nil)
+			acl, err = store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectAfterAdd)
+
+			err = store.Remove(ctx, "foo", []string{"bob"})
+			c.Assert(err, qt.Equals, nil)
+			acl, err = store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectAfterRemove)
+
+			err = store.Set(ctx, "foo", []string{"zach", "bob", "zach", "alice"})
+			c.Assert(err, qt.Equals, nil)
+			acl, err = store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectAfterSet)
+		})
+	}
+}
+
+func TestNamespacedStoreConformance(t *testing.T) {
+	aclstoretest.RunStoreTests(t, func() aclstore.ACLStore {
+		return aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:        memsimplekv.NewStore(),
+			Namespace: "ns:",
+		})
+	})
+}
+
+func TestNamespace(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := memsimplekv.NewStore()
+	newManager := func(namespace, admin string) *aclstore.Manager {
+		m, err := aclstore.NewManager(ctx, aclstore.Params{
+			Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+				KV:        kv,
+				Namespace: namespace,
+			}),
+			InitialAdminUsers: []string{admin},
+		})
+		c.Assert(err, qt.Equals, nil)
+		return m
+	}
+	m1 := newManager("one:", "alice")
+	m2 := newManager("two:", "bob")
+
+	err := m1.CreateACL(ctx, "foo", "charlie")
+	c.Assert(err, qt.Equals, nil)
+	err = m2.CreateACL(ctx, "foo", "daisy")
+	c.Assert(err, qt.Equals, nil)
+	err = m2.CreateACL(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+
+	for _, test := range []struct {
+		m          *aclstore.Manager
+		acl        string
+		expectACL  []string
+		expectACLs []string
+	}{{
+		m:          m1,
+		acl:        "admin",
+		expectACL:  []string{"alice"},
+		expectACLs: []string{"admin", "foo"},
+	}, {
+		m:          m1,
+		acl:        "foo",
+		expectACL:  []string{"charlie"},
+		expectACLs: []string{"admin", "foo"},
+	}, {
+		m:          m2,
+		acl:        "admin",
+		expectACL:  []string{"bob"},
+		expectACLs: []string{"admin", "bar", "foo"},
+	}, {
+		m:          m2,
+		acl:        "foo",
+		expectACL:  []string{"daisy"},
+		expectACLs: []string{"admin", "bar", "foo"},
+	}} {
+		acl, err := test.m.ACL(ctx, test.acl)
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acl, qt.DeepEquals, test.expectACL)
+		acls, err := test.m.ACLNames(ctx, false)
+		c.Assert(err, qt.Equals, nil)
+		sort.Strings(acls)
+		c.Assert(acls, qt.DeepEquals, test.expectACLs)
+	}
+	_, err = m1.ACL(ctx, "bar")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+
+	// The keys in the underlying store are prefixed by the namespace.
+	keys, err := kv.(simplekv.KeyLister).Keys(ctx)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(keys)
+	c.Assert(keys, qt.DeepEquals, []string{
+		"one:_foo",
+		"one:admin",
+		"one:foo",
+		"two:_bar",
+		"two:_foo",
+		"two:admin",
+		"two:bar",
+		"two:foo",
+	})
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:              memsimplekv.NewStore(),
+		CaseInsensitive: true,
+		StrictRemove:    true,
+	})
+	c.Assert(store.(aclstore.ACLCaseFolder).FoldsCase(), qt.Equals, true)
+	err := store.CreateACL(ctx, "foo", []string{"Alice", "bob", "ALICE"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"Alice", "bob"})
+
+	// Adding a user that differs only in case is a no-op.
+	err = store.Add(ctx, "foo", []string{"alice", "Bob", "Charlie"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"Alice", "Charlie", "bob"})
+
+	// Users are removed regardless of case.
+	err = store.Remove(ctx, "foo", []string{"BOB", "charlie"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"Alice"})
+	err = store.Remove(ctx, "foo", []string{"bob"})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrUserNotFound)
+
+	err = store.Set(ctx, "foo", []string{"daisy", "Daisy"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"daisy"})
+}
+
+func TestCaseSensitiveByDefault(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	c.Assert(store.(aclstore.ACLCaseFolder).FoldsCase(), qt.Equals, false)
+	err := store.CreateACL(ctx, "foo", []string{"Alice", "alice"})
+	c.Assert(err, qt.Equals, nil)
+	err = store.Remove(ctx, "foo", []string{"ALICE"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"Alice", "alice"})
+}
+
+var cancelledContextTests = []struct {
+	testName string
+	run      func(ctx context.Context, store aclstore.ACLStore) error
+}{{
+	testName: "create",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		return store.CreateACL(ctx, "bar", []string{"x"})
+	},
+}, {
+	testName: "add",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		return store.Add(ctx, "foo", []string{"x"})
+	},
+}, {
+	testName: "remove",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		return store.Remove(ctx, "foo", []string{"a"})
+	},
+}, {
+	testName: "set",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		return store.Set(ctx, "foo", []string{"x"})
+	},
+}, {
+	testName: "update",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		return store.(aclstore.ACLUpdater).Update(ctx, "foo", func([]string) ([]string, error) {
+			return []string{"x"}, nil
+		})
+	},
+}, {
+	testName: "get",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		_, err := store.Get(ctx, "foo")
+		return err
+	},
+}, {
+	testName: "list",
+	run: func(ctx context.Context, store aclstore.ACLStore) error {
+		_, err := store.(aclstore.ACLLister).ACLs(ctx)
+		return err
+	},
+}}
+
+func TestRewriteUser(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV: kv,
+		RewriteUser: func(u string) string {
+			return strings.TrimSuffix(u, "@CORP")
+		},
+	})
+	users := []string{"alice@CORP", "bob"}
+	err := store.CreateACL(ctx, "foo", users)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice@CORP", "bob"})
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice", "bob"})
+
+	// The rewritten form is what is stored.
+	val, err := kv.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(val), qt.Not(qt.Contains), "@CORP")
+
+	err = store.Add(ctx, "foo", []string{"alice@CORP", "charlie@CORP"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice", "bob", "charlie"})
+
+	err = store.Remove(ctx, "foo", []string{"bob@CORP"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice", "charlie"})
+
+	err = store.Set(ctx, "foo", []string{"daisy@CORP"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"daisy"})
+
+	// Users are validated after they have been rewritten.
+	err = store.Set(ctx, "foo", []string{"@CORP"})
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+
+	// Users returned by Update functions are rewritten too.
+	err = store.(aclstore.ACLUpdater).Update(ctx, "foo", func(acl []string) ([]string, error) {
+		return append(acl, "edward@CORP"), nil
+	})
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"daisy", "edward"})
+
+	// So are users changed by Manager operations that use Update.
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.SwapMember(ctx, "foo", "daisy", "fred@CORP", false)
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"edward", "fred"})
+	err = m.ReplaceAdmins(ctx, []string{"boss", "george@CORP"}, false)
+	c.Assert(err, qt.Equals, nil)
+	acl, err = store.Get(ctx, aclstore.AdminACL)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"boss", "george"})
+}
+
+func TestCancelledContext(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range cancelledContextTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStore(memsimplekv.NewStore())
+			err := store.CreateACL(context.Background(), "foo", []string{"a", "b"})
+			c.Assert(err, qt.Equals, nil)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err = test.run(ctx, store)
+			c.Assert(errgo.Cause(err), qt.Equals, context.Canceled)
+
+			// Check that the store has not been changed.
+			acls, err := store.(aclstore.ACLLister).ACLs(context.Background())
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acls, qt.DeepEquals, []string{"foo"})
+			acl, err := store.Get(context.Background(), "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, []string{"a", "b"})
+		})
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStore(kv)
+
+	// Seed the store with values in the original format.
+	err := kv.Set(ctx, "foo", []byte("alice\nbob"), time.Time{})
+	c.Assert(err, qt.Equals, nil)
+	err = kv.Set(ctx, "empty", []byte{}, time.Time{})
+	c.Assert(err, qt.Equals, nil)
+	err = store.CreateACL(ctx, "bar", []string{"charlie"})
+	c.Assert(err, qt.Equals, nil)
+	current, err := kv.Get(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+
+	// Values in the original format can be read before migration.
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice", "bob"})
+
+	for i := 0; i < 2; i++ {
+		err = store.(aclstore.ACLMigrator).Migrate(ctx)
+		c.Assert(err, qt.Equals, nil)
+
+		val, err := kv.Get(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(string(val), qt.Equals, "\n{\"v\":1}\nalice\nbob")
+		val, err = kv.Get(ctx, "empty")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(string(val), qt.Equals, "\n{\"v\":1}")
+		val, err = kv.Get(ctx, "bar")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(string(val), qt.Equals, string(current))
+
+		acl, err := store.Get(ctx, "foo")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acl, qt.DeepEquals, []string{"alice", "bob"})
+		acl, err = store.Get(ctx, "empty")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acl, qt.HasLen, 0)
+		acl, err = store.Get(ctx, "bar")
+		c.Assert(err, qt.Equals, nil)
+		c.Assert(acl, qt.DeepEquals, []string{"charlie"})
+	}
+}
+
+func TestModifyOriginalFormat(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStore(kv)
+
+	err := kv.Set(ctx, "foo", []byte("alice\nbob"), time.Time{})
+	c.Assert(err, qt.Equals, nil)
+	err = store.Add(ctx, "foo", []string{"charlie"})
+	c.Assert(err, qt.Equals, nil)
+	acl, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"alice", "bob", "charlie"})
+}
+
+func TestCountOriginalFormat(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStore(kv)
+
+	err := kv.Set(ctx, "foo", []byte("alice\nbob"), time.Time{})
+	c.Assert(err, qt.Equals, nil)
+	err = kv.Set(ctx, "empty", []byte(""), time.Time{})
+	c.Assert(err, qt.Equals, nil)
+	n, err := store.(aclstore.ACLCounter).CountACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 2)
+	n, err = store.(aclstore.ACLCounter).CountACL(ctx, "empty")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 0)
+}
+
+var containsTests = []struct {
+	testName    string
+	storeParams aclstore.StoreParams
+	// users holds the initial members of the ACL. If value is
+	// set, it is stored directly instead.
+	users  []string
+	value  string
+	user   string
+	expect bool
+}{{
+	testName: "first",
+	users:    []string{"alice", "bob", "charlie"},
+	user:     "alice",
+	expect:   true,
+}, {
+	testName: "middle",
+	users:    []string{"alice", "bob", "charlie"},
+	user:     "bob",
+	expect:   true,
+}, {
+	testName: "last",
+	users:    []string{"alice", "bob", "charlie"},
+	user:     "charlie",
+	expect:   true,
+}, {
+	testName: "only",
+	users:    []string{"alice"},
+	user:     "alice",
+	expect:   true,
+}, {
+	testName: "prefix_of_member",
+	users:    []string{"alice", "bobby"},
+	user:     "bob",
+}, {
+	testName: "suffix_of_member",
+	users:    []string{"alice", "bobby"},
+	user:     "by",
+}, {
+	testName: "spanning_members",
+	users:    []string{"alice", "bob"},
+	user:     "alice\nbob",
+}, {
+	testName: "empty_user",
+	users:    []string{"alice", "bob"},
+	user:     "",
+}, {
+	testName: "empty_acl",
+	user:     "alice",
+}, {
+	testName: "case_sensitive",
+	users:    []string{"Alice"},
+	user:     "alice",
+}, {
+	testName: "case_insensitive",
+	storeParams: aclstore.StoreParams{
+		CaseInsensitive: true,
+	},
+	users:  []string{"Alice", "bob"},
+	user:   "aLICE",
+	expect: true,
+}, {
+	testName: "escaped",
+	storeParams: aclstore.StoreParams{
+		EscapeUsers: true,
+	},
+	users:  []string{"alice", "bob\nby", `c\d`},
+	user:   "bob\nby",
+	expect: true,
+}, {
+	testName: "escaped_backslash",
+	storeParams: aclstore.StoreParams{
+		EscapeUsers: true,
+	},
+	users:  []string{"alice", "bob\nby", `c\d`},
+	user:   `c\d`,
+	expect: true,
+}, {
+	testName: "escaped_not_split",
+	storeParams: aclstore.StoreParams{
+		EscapeUsers: true,
+	},
+	users: []string{"alice", "bob\nby"},
+	user:  "bob",
+}, {
+	testName: "original_format",
+	value:    "alice\nbob",
+	user:     "bob",
+	expect:   true,
+}, {
+	testName: "empty_entries",
+	value:    "\n{\"v\":1}\n\nalice\n\n\nbob\n",
+	user:     "bob",
+	expect:   true,
+}, {
+	testName: "header_only",
+	value:    "\n{\"v\":1}",
+	user:     "v",
+}}
+
+func TestContains(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range containsTests {
+		c.Run(test.testName, func(c *qt.C) {
+			p := test.storeParams
+			p.KV = memsimplekv.NewStore()
+			store := aclstore.NewACLStoreWithParams(p)
+			if test.value != "" {
+				err := p.KV.Set(ctx, "foo", []byte(test.value), time.Time{})
+				c.Assert(err, qt.Equals, nil)
+			} else {
+				err := store.CreateACL(ctx, "foo", test.users)
+				c.Assert(err, qt.Equals, nil)
+			}
+			ok, err := store.(aclstore.ACLMembershipChecker).Contains(ctx, "foo", test.user)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(ok, qt.Equals, test.expect)
+		})
+	}
+}
+
+func TestContainsDeleted(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	err := store.CreateACL(ctx, "foo", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	err = store.(aclstore.ACLDeleter).DeleteACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	_, err = store.(aclstore.ACLMembershipChecker).Contains(ctx, "foo", "alice")
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrACLNotFound)
+}
+
+var storedValueTests = []struct {
+	testName    string
+	value       string
+	expectUsers []string
+}{{
+	testName:    "original_format_trailing_separator",
+	value:       "alice\nbob\n",
+	expectUsers: []string{"alice", "bob"},
+}, {
+	testName:    "original_format_empty_entry",
+	value:       "alice\n\nbob",
+	expectUsers: []string{"alice", "bob"},
+}, {
+	testName:    "header_trailing_separator",
+	value:       "\n{\"v\":1}\n",
+	expectUsers: nil,
+}, {
+	testName:    "header_empty_entries",
+	value:       "\n{\"v\":1}\n\nalice\n\n\nbob\n",
+	expectUsers: []string{"alice", "bob"},
+}}
+
+func TestStoredValueEmptyEntries(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range storedValueTests {
+		c.Run(test.testName, func(c *qt.C) {
+			kv := memsimplekv.NewStore()
+			store := aclstore.NewACLStore(kv)
+			err := kv.Set(ctx, "foo", []byte(test.value), time.Time{})
+			c.Assert(err, qt.Equals, nil)
+			users, err := store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, test.expectUsers)
+			n, err := store.(aclstore.ACLCounter).CountACL(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(n, qt.Equals, len(test.expectUsers))
+
+			// The empty entries are removed when the ACL is next changed.
+			err = store.Add(ctx, "foo", []string{"charlie"})
+			c.Assert(err, qt.Equals, nil)
+			users, err = store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, append(test.expectUsers, "charlie"))
+		})
+	}
+}
+
+var escapeUsersTests = []struct {
+	testName string
+	user     string
+}{{
+	testName: "plain",
+	user:     "alice",
+}, {
+	testName: "separator",
+	user:     "alice\nbob",
+}, {
+	testName: "separators_only",
+	user:     "\n\n",
+}, {
+	testName: "backslash",
+	user:     `alice\bob`,
+}, {
+	testName: "trailing_backslash",
+	user:     `alice\`,
+}, {
+	testName: "escaped_separator",
+	user:     `alice\n`,
+}, {
+	testName: "backslash_and_separator",
+	user:     "\\\n\\n\\",
+}}
+
+func TestEscapeUsers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range escapeUsersTests {
+		c.Run(test.testName, func(c *qt.C) {
+			kv := memsimplekv.NewStore()
+			store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+				KV:          kv,
+				EscapeUsers: true,
+			})
+			c.Assert(store.(aclstore.ACLUserEscaper).EscapesUsers(), qt.Equals, true)
+			users := []string{test.user, "zed"}
+			err := store.CreateACL(ctx, "foo", users)
+			c.Assert(err, qt.Equals, nil)
+			got, err := store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(got, qt.DeepEquals, users)
+			n, err := store.(aclstore.ACLCounter).CountACL(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(n, qt.Equals, 2)
+
+			err = store.Remove(ctx, "foo", []string{test.user})
+			c.Assert(err, qt.Equals, nil)
+			got, err = store.Get(ctx, "foo")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(got, qt.DeepEquals, []string{"zed"})
+
+			data, err := aclstore.EncodeValue(store, users)
+			c.Assert(err, qt.Equals, nil)
+			got, err = aclstore.DecodeValue(data)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(got, qt.DeepEquals, users)
+		})
+	}
+}
+
+func TestEscapeUsersExistingValues(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStore(kv)
+	c.Assert(store.(aclstore.ACLUserEscaper).EscapesUsers(), qt.Equals, false)
+	err := store.CreateACL(ctx, "foo", []string{`a\n`})
+	c.Assert(err, qt.Equals, nil)
+
+	// Users with separators are not allowed by default.
+	err = store.Add(ctx, "foo", []string{"b\nc"})
+	c.Assert(err, qt.ErrorMatches, `invalid user name "b\\nc"`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+
+	// Values stored without escaping are read unchanged
+	// by a store that escapes users.
+	escaped := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:          kv,
+		EscapeUsers: true,
+	})
+	users, err := escaped.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{`a\n`})
+	err = escaped.Add(ctx, "foo", []string{"b\nc"})
+	c.Assert(err, qt.Equals, nil)
+	users, err = escaped.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{`a\n`, "b\nc"})
+
+	// Once escaped, the value can still be read by a store that
+	// does not escape users, and changing it stops it being escaped.
+	users, err = store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{`a\n`, "b\nc"})
+	err = store.Set(ctx, "foo", []string{`a\n`})
+	c.Assert(err, qt.Equals, nil)
+	data, err := kv.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(data), qt.Equals, "\n{\"v\":1,\"gen\":3}\n"+`a\n`)
+}
+
+func TestDecodeValueBadHeader(t *testing.T) {
+	c := qt.New(t)
+	for _, value := range []string{"\n", "\n\nalice", "\n{\nalice"} {
+		_, err := aclstore.DecodeValue([]byte(value))
+		c.Check(err, qt.ErrorMatches, `cannot decode ACL header: .*`, qt.Commentf("value %q", value))
+		_, err = aclstore.CountValue([]byte(value))
+		c.Check(err, qt.ErrorMatches, `cannot decode ACL header: .*`, qt.Commentf("value %q", value))
+	}
+}
+
+func TestRecordMembers(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &testClock{t: t0}
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:            memsimplekv.NewStore(),
+		RecordMembers: true,
+		Clock:         clock,
+	})
+	detailer := store.(aclstore.ACLDetailer)
+	asUser := func(name string) context.Context {
+		return aclstore.ContextWithIdentity(ctx, &namedIdentity{name})
+	}
+
+	err := store.CreateACL(asUser("boss"), "foo", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	clock.advance(time.Minute)
+	err = store.Add(asUser("alice"), "foo", []string{"bob", "alice"})
+	c.Assert(err, qt.Equals, nil)
+	members, err := detailer.GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, []aclstore.Member{{
+		User:    "alice",
+		AddedBy: "boss",
+		AddedAt: t0,
+	}, {
+		User:    "bob",
+		AddedBy: "alice",
+		AddedAt: t0.Add(time.Minute),
+	}})
+
+	// Unrelated changes leave the details of existing members unchanged.
+	clock.advance(time.Minute)
+	err = store.Set(asUser("boss"), "foo", []string{"alice", "bob", "charlie"})
+	c.Assert(err, qt.Equals, nil)
+	clock.advance(time.Minute)
+	err = store.Remove(ctx, "foo", []string{"charlie"})
+	c.Assert(err, qt.Equals, nil)
+	err = store.Add(ctx, "foo", []string{"david"})
+	c.Assert(err, qt.Equals, nil)
+	members, err = detailer.GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, []aclstore.Member{{
+		User:    "alice",
+		AddedBy: "boss",
+		AddedAt: t0,
+	}, {
+		User:    "bob",
+		AddedBy: "alice",
+		AddedAt: t0.Add(time.Minute),
+	}, {
+		User:    "david",
+		AddedAt: t0.Add(3 * time.Minute),
+	}})
+
+	// A member that is removed and added again gets new details.
+	err = store.Remove(ctx, "foo", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	clock.advance(time.Minute)
+	err = store.Add(asUser("boss"), "foo", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	members, err = detailer.GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members[1], qt.DeepEquals, aclstore.Member{
+		User:    "bob",
+		AddedBy: "boss",
+		AddedAt: t0.Add(4 * time.Minute),
+	})
+
+	// The plain form still returns just the users.
+	users, err := store.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice", "bob", "david"})
+}
+
+func TestRecordMembersDisabled(t *testing.T) {
+	c := qt.New(t)
+	ctx := aclstore.ContextWithIdentity(context.Background(), &namedIdentity{"boss"})
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:            kv,
+		RecordMembers: true,
+	})
+	err := store.CreateACL(ctx, "foo", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+
+	// When details are no longer recorded, they are
+	// discarded the next time the ACL is changed.
+	store = aclstore.NewACLStore(kv)
+	err = store.Add(ctx, "foo", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	members, err := store.(aclstore.ACLDetailer).GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, []aclstore.Member{{
+		User: "alice",
+	}, {
+		User: "bob",
+	}})
+}
+
+func TestRecordMembersStoredSeparately(t *testing.T) {
+	c := qt.New(t)
+	ctx := aclstore.ContextWithIdentity(context.Background(), &namedIdentity{"boss"})
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:            kv,
+		RecordMembers: true,
+	})
+	err := store.CreateACL(ctx, "foo", []string{"alice", "bob"})
+	c.Assert(err, qt.Equals, nil)
+
+	// The details are not held in the value of the ACL.
+	val, err := kv.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(val), qt.Not(qt.Contains), "boss")
+	n, err := aclstore.CountValue(val)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(n, qt.Equals, 2)
+
+	// Nor are they listed as an ACL.
+	acls, err := store.(aclstore.ACLLister).ACLs(ctx)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acls, qt.DeepEquals, []string{"foo"})
+}
+
+func TestRecordMembersInHeader(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	t0 := time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := &testClock{t: t0.Add(time.Hour)}
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:            kv,
+		RecordMembers: true,
+		Clock:         clock,
+	})
+	detailer := store.(aclstore.ACLDetailer)
+
+	// Values stored by earlier versions hold the
+	// details of the members in their header.
+	for _, name := range []string{"foo", "bar"} {
+		err := kv.Set(ctx, name, []byte("\n"+`{"v":1,"members":{"alice":{"by":"boss","at":"2018-01-01T00:00:00Z"}}}`+"\nalice"), time.Time{})
+		c.Assert(err, qt.Equals, nil)
+	}
+	expect := []aclstore.Member{{
+		User:    "alice",
+		AddedBy: "boss",
+		AddedAt: t0,
+	}}
+	members, err := detailer.GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, expect)
+
+	// They are moved out of the header when the ACL is changed...
+	err = store.Add(ctx, "foo", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+	val, err := kv.Get(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(val), qt.Not(qt.Contains), "boss")
+	members, err = detailer.GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, append(expect, aclstore.Member{
+		User:    "bob",
+		AddedAt: t0.Add(time.Hour),
+	}))
+
+	// ... or migrated.
+	err = store.(aclstore.ACLMigrator).Migrate(ctx)
+	c.Assert(err, qt.Equals, nil)
+	val, err = kv.Get(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(string(val), qt.Not(qt.Contains), "boss")
+	members, err = detailer.GetDetails(ctx, "bar")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.DeepEquals, expect)
+}
+
+func TestRecordMembersRecreated(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:            memsimplekv.NewStore(),
+		RecordMembers: true,
+	})
+	asUser := func(name string) context.Context {
+		return aclstore.ContextWithIdentity(ctx, &namedIdentity{name})
+	}
+	err := store.CreateACL(asUser("boss"), "foo", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	err = store.(aclstore.ACLDeleter).DeleteACL(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+
+	// An ACL that is created again doesn't
+	// get the details of the deleted one.
+	err = store.CreateACL(asUser("carol"), "foo", []string{"alice"})
+	c.Assert(err, qt.Equals, nil)
+	members, err := store.(aclstore.ACLDetailer).GetDetails(ctx, "foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(members, qt.HasLen, 1)
+	c.Assert(members[0].AddedBy, qt.Equals, "carol")
+}
+
+// sortedACL is the straightforward form of canonicalACL: it sorts a
+// copy of the whole ACL and removes duplicates.
+func sortedACL(acl []string) []string {
+	acl1 := make([]string, len(acl))
+	copy(acl1, acl)
+	sort.Strings(acl1)
+	j := 0
+	for i, a := range acl1 {
+		if i > 0 && acl1[i-1] == a {
+			continue
+		}
+		acl1[j] = a
+		j++
+	}
+	return acl1[:j]
+}
+
+var canonicalACLTests = []struct {
+	testName string
+	acl      []string
+}{{
+	testName: "nil",
+}, {
+	testName: "single",
+	acl:      []string{"bob"},
+}, {
+	testName: "canonical",
+	acl:      []string{"alice", "bob", "charlie"},
+}, {
+	testName: "appended",
+	acl:      []string{"alice", "charlie", "edward", "bob", "daisy"},
+}, {
+	testName: "appended_duplicates",
+	acl:      []string{"alice", "charlie", "charlie", "alice", "bob", "bob"},
+}, {
+	testName: "appended_after_end",
+	acl:      []string{"alice", "bob", "zed", "yvonne"},
+}, {
+	testName: "reversed",
+	acl:      []string{"daisy", "charlie", "bob", "alice"},
+}, {
+	testName: "all_same",
+	acl:      []string{"bob", "bob", "bob"},
+}}
+
+func TestCanonicalACL(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range canonicalACLTests {
+		c.Run(test.testName, func(c *qt.C) {
+			acl := append([]string(nil), test.acl...)
+			expect := sortedACL(test.acl)
+			got := aclstore.CanonicalACL(acl)
+			c.Assert(got, qt.HasLen, len(expect))
+			if len(expect) > 0 {
+				c.Assert(got, qt.DeepEquals, expect)
+			}
+			// The argument is not changed.
+			c.Assert(acl, qt.DeepEquals, test.acl)
+		})
+	}
+}
+
+func TestCanonicalACLRandom(t *testing.T) {
+	c := qt.New(t)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 1000; i++ {
+		// Make a canonical ACL with some random users appended.
+		acl := randomUsers(r, r.Intn(20))
+		acl = append(sortedACL(acl), randomUsers(r, r.Intn(5))...)
+		if len(acl) > 0 && r.Intn(4) == 0 {
+			r.Shuffle(len(acl), func(i, j int) {
+				acl[i], acl[j] = acl[j], acl[i]
+			})
+		}
+		orig := make([]string, len(acl))
+		copy(orig, acl)
+		expect := sortedACL(acl)
+		got := aclstore.CanonicalACL(acl)
+		c.Assert(got, qt.HasLen, len(expect), qt.Commentf("acl %q", orig))
+		if len(expect) > 0 {
+			c.Assert(got, qt.DeepEquals, expect, qt.Commentf("acl %q", orig))
+		}
+		c.Assert(acl, qt.DeepEquals, orig)
+	}
+}
+
+// randomUsers returns n users drawn from a small set of names,
+// so that duplicates are common.
+func randomUsers(r *rand.Rand, n int) []string {
+	users := make([]string, n)
+	for i := range users {
+		users[i] = fmt.Sprintf("user%02d", r.Intn(30))
+	}
+	return users
+}
+
+func BenchmarkCanonicalACLAppend(b *testing.B) {
+	benchmarkCanonicalACL(b, aclstore.CanonicalACL)
+}
+
+func BenchmarkSortedACLAppend(b *testing.B) {
+	benchmarkCanonicalACL(b, sortedACL)
+}
+
+// benchmarkCanonicalACL benchmarks canonicalizing large ACLs that
+// have had a few users appended, as happens when users are added.
+func benchmarkCanonicalACL(b *testing.B, canonical func([]string) []string) {
+	for _, size := range []int{100, 10000, 100000} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			acl := make([]string, size)
+			for i := range acl {
+				acl[i] = fmt.Sprintf("user%08d", i*2)
+			}
+			acl = append(acl, "user00000001", fmt.Sprintf("user%08d", size+1), "user00000003")
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				canonical(acl)
+			}
+		})
+	}
+}
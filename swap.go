@@ -0,0 +1,61 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+
+	"gopkg.in/errgo.v1"
+)
+
+// SwapMember atomically replaces oldUser with newUser in the ACL with
+// the given name. Unless force is true, oldUser must be a member of the
+// ACL; if it is not, an error with an ErrUserNotFound cause is returned
+// and the ACL is left unchanged. If force is true, newUser is added
+// whether or not oldUser was a member.
+//
+// The underlying store must implement ACLUpdater.
+//
+// It returns an error with an ErrACLNotFound cause if the ACL does not
+// exist.
+func (m *Manager) SwapMember(ctx context.Context, aclName, oldUser, newUser string, force bool) error {
+	aclName, err := m.resolveAlias(ctx, aclName)
+	if err != nil {
+		return errgo.Mask(err, isContextError)
+	}
+	updater, ok := m.p.Store.(ACLUpdater)
+	if !ok {
+		return errgo.Newf("cannot update ACL atomically")
+	}
+	if err := m.checkUsersAllowed(ctx, []string{newUser}); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	key := func(u string) string { return u }
+	if m.foldsCase() {
+		key = FoldUser
+	}
+	err = updater.Update(ctx, aclName, func(current []string) ([]string, error) {
+		users := make([]string, 0, len(current)+1)
+		found := false
+		for _, u := range current {
+			if key(u) == key(oldUser) {
+				found = true
+				continue
+			}
+			users = append(users, u)
+		}
+		if !found && !force {
+			return nil, errgo.WithCausef(nil, ErrUserNotFound, "user %q not found", oldUser)
+		}
+		return append(users, newUser), nil
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrUserNotFound), isContextError)
+	}
+	m.changed(ctx, aclName, OpSwap, []string{oldUser, newUser})
+	return nil
+}
@@ -0,0 +1,167 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+var swapMemberTests = []struct {
+	testName       string
+	path           string
+	body           params.ModifyACLRequestBody
+	expectACL      []string
+	expectStatus   int
+	expectResponse interface{}
+}{{
+	testName: "present_old_user",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "alice",
+		NewUser: "daisy",
+	},
+	expectACL:      []string{"bob", "daisy"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{},
+}, {
+	testName: "absent_old_user",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "charlie",
+		NewUser: "daisy",
+	},
+	expectACL:    []string{"alice", "bob"},
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    aclstore.CodeUserNotFound,
+		Message: `user "charlie" not found`,
+	},
+}, {
+	testName: "absent_old_user_with_force",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "charlie",
+		NewUser: "daisy",
+		Force:   true,
+	},
+	expectACL:      []string{"alice", "bob", "daisy"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{},
+}, {
+	testName: "new_user_already_present",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "alice",
+		NewUser: "bob",
+	},
+	expectACL:      []string{"bob"},
+	expectStatus:   http.StatusOK,
+	expectResponse: params.ModifyACLResponse{},
+}, {
+	testName: "bad_new_user",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "alice",
+		NewUser: "bad\nuser",
+	},
+	expectACL:    []string{"alice", "bob"},
+	expectStatus: http.StatusBadRequest,
+	expectResponse: &httprequest.RemoteError{
+		Code:    httprequest.CodeBadRequest,
+		Message: `invalid user name "bad\nuser"`,
+	},
+}, {
+	testName: "missing_new_user",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "alice",
+	},
+	expectACL:    []string{"alice", "bob"},
+	expectStatus: http.StatusUnprocessableEntity,
+	expectResponse: &httprequest.RemoteError{
+		Code:    aclstore.CodeMissingUsers,
+		Message: "old and new users must both be specified",
+	},
+}, {
+	testName: "swap_with_add",
+	path:     "/someacl?action=swap",
+	body: params.ModifyACLRequestBody{
+		Add:     []string{"eve"},
+		OldUser: "alice",
+		NewUser: "daisy",
+	},
+	expectACL:    []string{"alice", "bob"},
+	expectStatus: http.StatusUnprocessableEntity,
+	expectResponse: &httprequest.RemoteError{
+		Code:    aclstore.CodeConflictingChanges,
+		Message: "cannot add or remove users when swapping users",
+	},
+}, {
+	testName: "nonexistent_ACL",
+	path:     "/nonexistent?action=swap",
+	body: params.ModifyACLRequestBody{
+		OldUser: "alice",
+		NewUser: "daisy",
+	},
+	expectACL:    []string{"alice", "bob"},
+	expectStatus: http.StatusNotFound,
+	expectResponse: &httprequest.RemoteError{
+		Code:    aclstore.CodeACLNotFound,
+		Message: "ACL not found",
+	},
+}}
+
+func TestSwapMember(t *testing.T) {
+	c := qt.New(t)
+	for _, test := range swapMemberTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var checkedACL []string
+			m, h := managerWithACLs(c, "", map[string][]string{
+				"admin":        {"boss"},
+				"someacl":      {"alice", "bob"},
+				"_someacl":     {},
+				"_nonexistent": {},
+			}, &checkedACL)
+			srv := httptest.NewServer(h)
+			defer srv.Close()
+			assertJSONCall(c, "POST", srv.URL+test.path, test.body, test.expectStatus, test.expectResponse)
+			acl, err := m.ACL(context.Background(), "someacl")
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(acl, qt.DeepEquals, test.expectACL)
+		})
+	}
+}
+
+func TestSwapMemberCaseInsensitive(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+			KV:              memsimplekv.NewStore(),
+			CaseInsensitive: true,
+		}),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "someacl", "Alice", "bob")
+	c.Assert(err, qt.Equals, nil)
+	err = m.SwapMember(ctx, "someacl", "alice", "charlie", false)
+	c.Assert(err, qt.Equals, nil)
+	acl, err := m.ACL(ctx, "someacl")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(acl, qt.DeepEquals, []string{"bob", "charlie"})
+	err = m.SwapMember(ctx, "someacl", "alice", "daisy", false)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrUserNotFound)
+}
@@ -0,0 +1,341 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Plan holds the changes needed to reconcile the ACLs in the store
+// with a desired state, as returned by Manager.PlanSync. All the
+// lists of users are sorted.
+type Plan struct {
+	// Create maps the name of each ACL to be created
+	// to its initial members.
+	Create map[string][]string
+
+	// Delete holds the sorted names of the ACLs to be deleted.
+	Delete []string
+
+	// Add maps the name of each existing ACL to
+	// the users to be added to it.
+	Add map[string][]string
+
+	// Remove maps the name of each existing ACL to
+	// the users to be removed from it.
+	Remove map[string][]string
+
+	// AdminLockout reports whether the plan would leave the admin
+	// ACL with no members or without any of its current members.
+	// ApplySync refuses to apply such a plan unless it is forced.
+	AdminLockout bool
+}
+
+// IsEmpty reports whether the plan makes no changes.
+func (p Plan) IsEmpty() bool {
+	return len(p.Create) == 0 && len(p.Delete) == 0 && len(p.Add) == 0 && len(p.Remove) == 0
+}
+
+// PlanSync returns the changes needed to make the ACLs in the store
+// match desired, which maps ACL names to their members. ACLs that are
+// not in desired are deleted, except for the admin ACL, the checker
+// ACL and the read-only admin ACL, which are left alone. Meta-ACLs are
+// not included in the plan and may not be named in desired.
+//
+// PlanSync does not change the store; the plan can be executed with
+// ApplySync. If the plan would lock out the current members of the
+// admin ACL, as ReplaceAdmins would refuse to, plan.AdminLockout is
+// set. It returns an error with an ErrBadACLName or
+// ErrBadUsername cause if desired holds an invalid ACL or user name.
+func (m *Manager) PlanSync(ctx context.Context, desired map[string][]string) (Plan, error) {
+	escaped := escapesUsers(m.p.Store)
+	for name, users := range desired {
+		if err := m.ValidateACLName(name); err != nil {
+			return Plan{}, errgo.Mask(err, errgo.Is(ErrBadACLName))
+		}
+		for _, u := range users {
+			if !validUser(u, escaped) {
+				return Plan{}, errgo.WithCausef(nil, ErrBadUsername, "invalid user name %q in ACL %q", u, name)
+			}
+		}
+	}
+	names, err := m.ACLNames(ctx, false)
+	if err != nil {
+		return Plan{}, errgo.Mask(err, isContextError)
+	}
+	var plan Plan
+	existing := make(map[string]bool, len(names))
+	for _, name := range names {
+		want, ok := desired[name]
+		if !ok {
+			if !m.isSystemACL(name) {
+				plan.Delete = append(plan.Delete, name)
+			}
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return Plan{}, err
+		}
+		current, err := m.p.Store.Get(ctx, name)
+		if errgo.Cause(err) == ErrACLNotFound {
+			// The ACL has been deleted since it was listed.
+			continue
+		}
+		if err != nil {
+			return Plan{}, errgo.NoteMask(err, fmt.Sprintf("cannot get ACL %q", name), isContextError)
+		}
+		existing[name] = true
+		if add := m.missingUsers(current, want); len(add) > 0 {
+			plan.Add = addToPlan(plan.Add, name, add)
+		}
+		if remove := m.missingUsers(want, current); len(remove) > 0 {
+			plan.Remove = addToPlan(plan.Remove, name, remove)
+		}
+		if name == m.p.AdminACLName && m.checkAdminLockout(current, want, false) != nil {
+			plan.AdminLockout = true
+		}
+	}
+	for name, users := range desired {
+		if !existing[name] {
+			plan.Create = addToPlan(plan.Create, name, m.missingUsers(nil, users))
+		}
+	}
+	return plan, nil
+}
+
+// missingUsers returns the sorted users in want that are not in acl,
+// without duplicates. If the store folds case, users that differ only
+// in case are considered the same.
+func (m *Manager) missingUsers(acl, want []string) []string {
+	key := func(u string) string { return u }
+	if m.foldsCase() {
+		key = FoldUser
+	}
+	found := make(map[string]bool, len(acl)+len(want))
+	for _, a := range acl {
+		found[key(a)] = true
+	}
+	var missing []string
+	for _, u := range want {
+		if !found[key(u)] {
+			found[key(u)] = true
+			missing = append(missing, u)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// addToPlan adds the given entry to the plan map p,
+// creating it if necessary, and returns it.
+func addToPlan(p map[string][]string, name string, users []string) map[string][]string {
+	if p == nil {
+		p = make(map[string][]string)
+	}
+	p[name] = users
+	return p
+}
+
+// ApplySync executes the given plan, as returned by PlanSync. ACLs are
+// created first, then members are added and removed, then ACLs are
+// deleted, each in name order, with the same effect as the
+// corresponding Manager and HTTP operations, except that templates
+// do not apply to the created ACLs, which hold exactly the planned
+// members.
+//
+// Before anything else, the members of the admin ACL are added and
+// removed atomically, which requires the underlying store to implement
+// ACLUpdater. As with ReplaceAdmins, the admin ACL must be left with
+// at least one member and, unless force is true, with at least one of
+// its current members; otherwise an error with an ErrAdminLockout
+// cause is returned and nothing is changed.
+//
+// The changes are not made atomically: if a change fails, ApplySync
+// stops and returns its error, leaving the earlier changes in place.
+// The store may also have changed since the plan was made, so
+// PlanSync should be called again to check the result.
+func (m *Manager) ApplySync(ctx context.Context, plan Plan, force bool) error {
+	admin := m.p.AdminACLName
+	if add, remove := plan.Add[admin], plan.Remove[admin]; len(add) > 0 || len(remove) > 0 {
+		if err := m.syncAdmins(ctx, add, remove, force); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot update ACL %q", admin), errgo.Any)
+		}
+	}
+	for _, name := range sortedKeys(plan.Create) {
+		if err := m.createACL(ctx, name, plan.Create[name], createOptions{noTemplate: true}); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot create ACL %q", name), errgo.Any)
+		}
+	}
+	for _, name := range sortedKeys(plan.Add) {
+		if name == admin {
+			continue
+		}
+		users := plan.Add[name]
+		if err := m.checkUsersAllowed(ctx, users); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot add users to ACL %q", name), errgo.Any)
+		}
+		if err := m.p.Store.Add(ctx, name, users); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot add users to ACL %q", name), errgo.Any)
+		}
+		m.changed(ctx, name, OpAdd, users)
+	}
+	for _, name := range sortedKeys(plan.Remove) {
+		if name == admin {
+			continue
+		}
+		users := plan.Remove[name]
+		if err := m.p.Store.Remove(ctx, name, users); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot remove users from ACL %q", name), errgo.Any)
+		}
+		m.changed(ctx, name, OpRemove, users)
+	}
+	for _, name := range plan.Delete {
+		if err := m.DeleteACL(ctx, name); err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot delete ACL %q", name), errgo.Any)
+		}
+	}
+	return nil
+}
+
+// syncAdmins atomically adds and removes the given members of the
+// admin ACL, refusing to lock out its current members as
+// ReplaceAdmins does.
+func (m *Manager) syncAdmins(ctx context.Context, add, remove []string, force bool) error {
+	updater, ok := m.p.Store.(ACLUpdater)
+	if !ok {
+		return errgo.Newf("cannot update admin ACL atomically")
+	}
+	if err := m.checkUsersAllowed(ctx, add); err != nil {
+		return errgo.Mask(err, errgo.Is(ErrBadUsername), isContextError)
+	}
+	err := updater.Update(ctx, m.p.AdminACLName, func(current []string) ([]string, error) {
+		users := m.changedUsers(current, add, remove)
+		if err := m.checkAdminLockout(current, users, force); err != nil {
+			return nil, err
+		}
+		return users, nil
+	})
+	if err != nil {
+		return errgo.Mask(err, errgo.Is(ErrACLNotFound), errgo.Is(ErrBadUsername), errgo.Is(ErrAdminLockout), isContextError)
+	}
+	if len(add) > 0 {
+		m.changed(ctx, m.p.AdminACLName, OpAdd, add)
+	}
+	if len(remove) > 0 {
+		m.changed(ctx, m.p.AdminACLName, OpRemove, remove)
+	}
+	return nil
+}
+
+// changedUsers returns the members of acl without the users in remove
+// and with the users in add. If the store folds case, users that
+// differ only in case are considered the same.
+func (m *Manager) changedUsers(acl, add, remove []string) []string {
+	key := func(u string) string { return u }
+	if m.foldsCase() {
+		key = FoldUser
+	}
+	removed := make(map[string]bool, len(remove))
+	for _, u := range remove {
+		removed[key(u)] = true
+	}
+	users := make([]string, 0, len(acl)+len(add))
+	for _, u := range acl {
+		if !removed[key(u)] {
+			users = append(users, u)
+		}
+	}
+	return append(users, m.missingUsers(users, add)...)
+}
+
+// checkAdminLockout returns an error with an ErrAdminLockout cause if
+// replacing the current members of the admin ACL with users would
+// leave it with no members or, unless force is true, without any of
+// its current members.
+func (m *Manager) checkAdminLockout(current, users []string, force bool) error {
+	if len(users) == 0 {
+		return errgo.WithCausef(nil, ErrAdminLockout, "cannot remove all members of the admin ACL")
+	}
+	if force || len(current) == 0 {
+		return nil
+	}
+	// Some current members remain if fewer of them are
+	// missing from users than there are altogether.
+	if len(m.missingUsers(users, current)) < len(m.missingUsers(nil, current)) {
+		return nil
+	}
+	return errgo.WithCausef(nil, ErrAdminLockout, "new admin users do not include any current admin user")
+}
+
+// sortedKeys returns the keys of m in sorted order.
+func sortedKeys(m map[string][]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// SyncOptions holds options for a Manager.Sync call.
+type SyncOptions struct {
+	// Prune specifies that ACLs that are not in the desired
+	// state should be deleted. If it is false, they are left
+	// alone.
+	Prune bool
+
+	// IncludeAdmin specifies that the members of the admin ACL
+	// should be changed to match the desired state if it holds
+	// the admin ACL. If it is false, the admin ACL is left alone.
+	IncludeAdmin bool
+
+	// Force specifies that the admin ACL may be changed even
+	// if none of its current members would remain, as for
+	// ReplaceAdmins. It is ignored unless IncludeAdmin is true.
+	Force bool
+}
+
+// Sync changes the ACLs in the store to match desired, as PlanSync
+// and ApplySync do, and returns the plan that was applied, restricted
+// according to opts. Running it again with the same arguments does
+// nothing, unless the ACLs have been changed in the meantime, so it
+// may be run repeatedly to keep the store converged with the desired
+// state.
+//
+// If opts.IncludeAdmin is true and desired would leave the admin ACL
+// with no members or, unless opts.Force is true, without any of its
+// current members, it returns an error with an ErrAdminLockout cause
+// and nothing is changed. If applying the plan fails, the plan is
+// returned with the error; some of its changes may have been made.
+func (m *Manager) Sync(ctx context.Context, desired map[string][]string, opts SyncOptions) (applied Plan, err error) {
+	if users, ok := desired[m.p.AdminACLName]; ok && opts.IncludeAdmin && len(users) == 0 {
+		return Plan{}, errgo.WithCausef(nil, ErrAdminLockout, "cannot remove all members of the admin ACL")
+	}
+	plan, err := m.PlanSync(ctx, desired)
+	if err != nil {
+		return Plan{}, errgo.Mask(err, errgo.Is(ErrBadACLName), errgo.Is(ErrBadUsername), isContextError)
+	}
+	if !opts.Prune {
+		plan.Delete = nil
+	}
+	if !opts.IncludeAdmin {
+		plan.AdminLockout = false
+		delete(plan.Add, m.p.AdminACLName)
+		delete(plan.Remove, m.p.AdminACLName)
+		if len(plan.Add) == 0 {
+			plan.Add = nil
+		}
+		if len(plan.Remove) == 0 {
+			plan.Remove = nil
+		}
+	}
+	if err := m.ApplySync(ctx, plan, opts.Force); err != nil {
+		return plan, errgo.Mask(err, errgo.Any)
+	}
+	return plan, nil
+}
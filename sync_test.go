@@ -0,0 +1,422 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+var planSyncTests = []struct {
+	testName   string
+	desired    map[string][]string
+	expectPlan aclstore.Plan
+}{{
+	testName: "no_changes",
+	desired: map[string][]string{
+		"one": {"bob", "alice"},
+		"two": {"charlie"},
+	},
+}, {
+	testName: "additions",
+	desired: map[string][]string{
+		"one": {"alice", "bob", "eve", "dave", "dave"},
+		"two": {"charlie"},
+	},
+	expectPlan: aclstore.Plan{
+		Add: map[string][]string{
+			"one": {"dave", "eve"},
+		},
+	},
+}, {
+	testName: "removals",
+	desired: map[string][]string{
+		"one": {"alice"},
+		"two": {},
+	},
+	expectPlan: aclstore.Plan{
+		Remove: map[string][]string{
+			"one": {"bob"},
+			"two": {"charlie"},
+		},
+	},
+}, {
+	testName: "additions_and_removals",
+	desired: map[string][]string{
+		"admin": {"boss", "deputy"},
+		"one":   {"alice", "dave"},
+		"two":   {"charlie"},
+	},
+	expectPlan: aclstore.Plan{
+		Add: map[string][]string{
+			"admin": {"deputy"},
+			"one":   {"dave"},
+		},
+		Remove: map[string][]string{
+			"one": {"bob"},
+		},
+	},
+}, {
+	testName: "only_in_store",
+	desired: map[string][]string{
+		"one": {"alice", "bob"},
+	},
+	expectPlan: aclstore.Plan{
+		Delete: []string{"two"},
+	},
+}, {
+	testName: "only_desired",
+	desired: map[string][]string{
+		"one":   {"alice", "bob"},
+		"two":   {"charlie"},
+		"three": {"eve", "dave"},
+		"four":  nil,
+	},
+	expectPlan: aclstore.Plan{
+		Create: map[string][]string{
+			"three": {"dave", "eve"},
+			"four":  nil,
+		},
+	},
+}, {
+	testName: "empty",
+	desired:  map[string][]string{},
+	expectPlan: aclstore.Plan{
+		Delete: []string{"one", "two"},
+	},
+}}
+
+func TestPlanSync(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range planSyncTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "one", "alice", "bob")
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "two", "charlie")
+			c.Assert(err, qt.Equals, nil)
+			before := allACLs(c, m)
+
+			plan, err := m.PlanSync(ctx, test.desired)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(plan, qt.DeepEquals, test.expectPlan)
+			c.Assert(plan.IsEmpty(), qt.Equals, test.testName == "no_changes")
+
+			// Making the plan does not change anything.
+			c.Assert(allACLs(c, m), qt.DeepEquals, before)
+
+			err = m.ApplySync(ctx, plan, false)
+			c.Assert(err, qt.Equals, nil)
+			after := allACLs(c, m)
+			for name, users := range test.desired {
+				c.Assert(after[name], qt.ContentEquals, dedup(users), qt.Commentf("ACL %q", name))
+			}
+			for name := range after {
+				if _, ok := test.desired[name]; !ok {
+					c.Assert(name, qt.Equals, "admin")
+				}
+			}
+
+			// Once applied, there is nothing more to do.
+			plan, err = m.PlanSync(ctx, test.desired)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(plan.IsEmpty(), qt.Equals, true, qt.Commentf("plan %#v", plan))
+		})
+	}
+}
+
+func TestApplySyncIgnoresTemplates(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+		Templates: []aclstore.Template{{
+			Pattern: "team-*",
+			Source:  "defaults",
+		}},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "defaults", "auditor")
+	c.Assert(err, qt.Equals, nil)
+	desired := map[string][]string{
+		"defaults": {"auditor"},
+		"team-a":   {"alice"},
+	}
+	plan, err := m.PlanSync(ctx, desired)
+	c.Assert(err, qt.Equals, nil)
+	err = m.ApplySync(ctx, plan, false)
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "team-a")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	plan, err = m.PlanSync(ctx, desired)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(plan.IsEmpty(), qt.Equals, true, qt.Commentf("plan %#v", plan))
+}
+
+func TestPlanSyncInvalid(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	_, err = m.PlanSync(ctx, map[string][]string{
+		"_admin": {"boss"},
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid ACL name "_admin"`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadACLName)
+
+	_, err = m.PlanSync(ctx, map[string][]string{
+		"one": {""},
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid user name "" in ACL "one"`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrBadUsername)
+}
+
+// allACLs returns the members of all the non-meta ACLs managed by m.
+func allACLs(c *qt.C, m *aclstore.Manager) map[string][]string {
+	ctx := context.Background()
+	names, err := m.ACLNames(ctx, false)
+	c.Assert(err, qt.Equals, nil)
+	acls := make(map[string][]string)
+	for _, name := range names {
+		users, err := m.ACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+		acls[name] = users
+	}
+	return acls
+}
+
+// dedup returns users without any duplicates.
+func dedup(users []string) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, u := range users {
+		if !seen[u] {
+			seen[u] = true
+			result = append(result, u)
+		}
+	}
+	return result
+}
+
+var syncTests = []struct {
+	testName   string
+	opts       aclstore.SyncOptions
+	expectPlan aclstore.Plan
+	expectACLs map[string][]string
+}{{
+	testName: "default",
+	expectPlan: aclstore.Plan{
+		Create: map[string][]string{
+			"three": {"dave"},
+		},
+		Add: map[string][]string{
+			"one": {"eve"},
+		},
+		Remove: map[string][]string{
+			"one": {"bob"},
+		},
+	},
+	expectACLs: map[string][]string{
+		"admin": {"boss"},
+		"one":   {"alice", "eve"},
+		"two":   {"charlie"},
+		"three": {"dave"},
+	},
+}, {
+	testName: "prune",
+	opts: aclstore.SyncOptions{
+		Prune: true,
+	},
+	expectPlan: aclstore.Plan{
+		Create: map[string][]string{
+			"three": {"dave"},
+		},
+		Delete: []string{"two"},
+		Add: map[string][]string{
+			"one": {"eve"},
+		},
+		Remove: map[string][]string{
+			"one": {"bob"},
+		},
+	},
+	expectACLs: map[string][]string{
+		"admin": {"boss"},
+		"one":   {"alice", "eve"},
+		"three": {"dave"},
+	},
+}, {
+	testName: "include_admin",
+	opts: aclstore.SyncOptions{
+		IncludeAdmin: true,
+		Force:        true,
+	},
+	expectPlan: aclstore.Plan{
+		Create: map[string][]string{
+			"three": {"dave"},
+		},
+		Add: map[string][]string{
+			"admin": {"deputy"},
+			"one":   {"eve"},
+		},
+		Remove: map[string][]string{
+			"admin": {"boss"},
+			"one":   {"bob"},
+		},
+		AdminLockout: true,
+	},
+	expectACLs: map[string][]string{
+		"admin": {"deputy"},
+		"one":   {"alice", "eve"},
+		"two":   {"charlie"},
+		"three": {"dave"},
+	},
+}}
+
+func TestSync(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	desired := map[string][]string{
+		"admin": {"deputy"},
+		"one":   {"alice", "eve"},
+		"three": {"dave"},
+	}
+	for _, test := range syncTests {
+		c.Run(test.testName, func(c *qt.C) {
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "one", "alice", "bob")
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "two", "charlie")
+			c.Assert(err, qt.Equals, nil)
+
+			plan, err := m.Sync(ctx, desired, test.opts)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(plan, qt.DeepEquals, test.expectPlan)
+			c.Assert(allACLs(c, m), qt.DeepEquals, test.expectACLs)
+
+			// Running it again does nothing.
+			plan, err = m.Sync(ctx, desired, test.opts)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(plan, qt.DeepEquals, aclstore.Plan{})
+			c.Assert(allACLs(c, m), qt.DeepEquals, test.expectACLs)
+		})
+	}
+}
+
+func TestSyncAdminLockout(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	desired := map[string][]string{
+		"admin": {},
+		"one":   {"alice"},
+	}
+	_, err = m.Sync(ctx, desired, aclstore.SyncOptions{
+		IncludeAdmin: true,
+	})
+	c.Assert(err, qt.ErrorMatches, `cannot remove all members of the admin ACL`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrAdminLockout)
+	c.Assert(allACLs(c, m), qt.DeepEquals, map[string][]string{
+		"admin": {"boss"},
+	})
+
+	// Replacing all the current admins is refused unless forced.
+	_, err = m.Sync(ctx, map[string][]string{
+		"admin": {"deputy"},
+	}, aclstore.SyncOptions{
+		IncludeAdmin: true,
+	})
+	c.Assert(err, qt.ErrorMatches, `cannot update ACL "admin": new admin users do not include any current admin user`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrAdminLockout)
+	c.Assert(allACLs(c, m), qt.DeepEquals, map[string][]string{
+		"admin": {"boss"},
+	})
+
+	// Without IncludeAdmin, the admin ACL is left alone.
+	_, err = m.Sync(ctx, desired, aclstore.SyncOptions{})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(allACLs(c, m), qt.DeepEquals, map[string][]string{
+		"admin": {"boss"},
+		"one":   {"alice"},
+	})
+}
+
+func TestApplySyncAdminLockout(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"boss"},
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	// A plan that removes every admin is reported and refused,
+	// even when forced.
+	plan, err := m.PlanSync(ctx, map[string][]string{
+		"admin": {},
+		"one":   {"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(plan.AdminLockout, qt.Equals, true)
+	err = m.ApplySync(ctx, plan, true)
+	c.Assert(err, qt.ErrorMatches, `cannot update ACL "admin": cannot remove all members of the admin ACL`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrAdminLockout)
+	c.Assert(allACLs(c, m), qt.DeepEquals, map[string][]string{
+		"admin": {"boss"},
+	})
+
+	// A plan that replaces all the current admins is reported
+	// and refused unless forced.
+	desired := map[string][]string{
+		"admin": {"deputy"},
+		"one":   {"alice"},
+	}
+	plan, err = m.PlanSync(ctx, desired)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(plan.AdminLockout, qt.Equals, true)
+	err = m.ApplySync(ctx, plan, false)
+	c.Assert(err, qt.ErrorMatches, `cannot update ACL "admin": new admin users do not include any current admin user`)
+	c.Assert(errgo.Cause(err), qt.Equals, aclstore.ErrAdminLockout)
+	c.Assert(allACLs(c, m), qt.DeepEquals, map[string][]string{
+		"admin": {"boss"},
+	})
+	err = m.ApplySync(ctx, plan, true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(allACLs(c, m), qt.DeepEquals, desired)
+
+	// A plan that keeps a current admin is not a lockout.
+	plan, err = m.PlanSync(ctx, map[string][]string{
+		"admin": {"deputy", "boss"},
+		"one":   {"alice"},
+	})
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(plan.AdminLockout, qt.Equals, false)
+	err = m.ApplySync(ctx, plan, false)
+	c.Assert(err, qt.Equals, nil)
+}
@@ -0,0 +1,64 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"gopkg.in/errgo.v1"
+)
+
+// Template specifies the ACL whose members new ACLs
+// with matching names start with.
+type Template struct {
+	// Pattern holds a pattern, in the syntax used by path.Match,
+	// that the names of the ACLs that use the template match.
+	// For example, "team-*" matches every ACL whose name starts
+	// with "team-".
+	Pattern string
+
+	// Source holds the name of the ACL whose members are used.
+	// The source ACL is not itself changed when it is created,
+	// even if its name matches the pattern.
+	Source string
+}
+
+// template returns the first template that applies to the ACL with
+// the given name, and reports whether there is one. Meta-ACLs never
+// use templates.
+func (m *Manager) template(aclName string) (Template, bool) {
+	if isMetaName(aclName) {
+		return Template{}, false
+	}
+	for _, t := range m.p.Templates {
+		if t.Source == aclName {
+			continue
+		}
+		if ok, _ := path.Match(t.Pattern, aclName); ok {
+			return t, true
+		}
+	}
+	return Template{}, false
+}
+
+// withTemplateUsers returns the given initial users of the ACL with
+// the given name together with the members of the source ACL of its
+// template, if it has one. If the source ACL does not exist, the ACL
+// is created with only the given users.
+func (m *Manager) withTemplateUsers(ctx context.Context, aclName string, users []string) ([]string, error) {
+	t, ok := m.template(aclName)
+	if !ok {
+		return users, nil
+	}
+	templateUsers, err := m.ACL(ctx, t.Source)
+	if err != nil {
+		if errgo.Cause(err) == ErrACLNotFound {
+			return users, nil
+		}
+		return nil, errgo.NoteMask(err, fmt.Sprintf("cannot get template ACL %q", t.Source), isContextError)
+	}
+	return append(templateUsers, users...), nil
+}
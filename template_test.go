@@ -0,0 +1,135 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+var templateTests = []struct {
+	testName     string
+	aclName      string
+	initialUsers []string
+	expectUsers  []string
+}{{
+	testName:     "matching_name",
+	aclName:      "team-foo",
+	initialUsers: []string{"zoe"},
+	expectUsers:  []string{"alice", "bob", "zoe"},
+}, {
+	testName:    "non_matching_name",
+	aclName:     "project-foo",
+	expectUsers: nil,
+}, {
+	testName:    "first_matching_template_used",
+	aclName:     "team-ops",
+	expectUsers: []string{"alice", "bob"},
+}, {
+	testName:    "later_template",
+	aclName:     "ops-foo",
+	expectUsers: []string{"oscar"},
+}, {
+	testName:     "missing_source",
+	aclName:      "guest-foo",
+	initialUsers: []string{"zoe"},
+	expectUsers:  []string{"zoe"},
+}}
+
+func TestTemplates(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range templateTests {
+		c.Run(test.testName, func(c *qt.C) {
+			store := aclstore.NewACLStore(memsimplekv.NewStore())
+			err := store.CreateACL(ctx, "team-default", []string{"alice", "bob"})
+			c.Assert(err, qt.Equals, nil)
+			err = store.CreateACL(ctx, "ops-default", []string{"oscar"})
+			c.Assert(err, qt.Equals, nil)
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             store,
+				InitialAdminUsers: []string{"boss"},
+				Templates: []aclstore.Template{{
+					Pattern: "team-*",
+					Source:  "team-default",
+				}, {
+					Pattern: "*-ops",
+					Source:  "ops-default",
+				}, {
+					Pattern: "ops-*",
+					Source:  "ops-default",
+				}, {
+					Pattern: "guest-*",
+					Source:  "guest-default",
+				}},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, test.aclName, test.initialUsers...)
+			c.Assert(err, qt.Equals, nil)
+			users, err := m.ACL(ctx, test.aclName)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.DeepEquals, test.expectUsers)
+
+			// Meta-ACLs are not populated from templates.
+			users, err = m.ACL(ctx, "_"+test.aclName)
+			c.Assert(err, qt.Equals, nil)
+			c.Assert(users, qt.HasLen, 0)
+		})
+	}
+}
+
+func TestTemplatesOnlyApplyToNewACLs(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	store := aclstore.NewACLStore(memsimplekv.NewStore())
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+		Templates: []aclstore.Template{{
+			Pattern: "team-*",
+			Source:  "team-default",
+		}},
+	})
+	c.Assert(err, qt.Equals, nil)
+
+	// The source ACL matches its own template but
+	// does not take members from itself.
+	err = m.CreateACL(ctx, "team-default", "alice")
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	err = store.Set(ctx, "team-default", []string{"bob"})
+	c.Assert(err, qt.Equals, nil)
+
+	// Creating an existing ACL leaves it unchanged.
+	err = m.CreateACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	users, err := m.ACL(ctx, "team-foo")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"alice"})
+
+	// New ACLs use the current members of the source.
+	err = m.CreateACL(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+	users, err = m.ACL(ctx, "team-bar")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(users, qt.DeepEquals, []string{"bob"})
+}
+
+func TestInvalidTemplate(t *testing.T) {
+	c := qt.New(t)
+	_, err := aclstore.NewManager(context.Background(), aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+		Templates: []aclstore.Template{{
+			Pattern: "team-[",
+			Source:  "team-default",
+		}},
+	})
+	c.Assert(err, qt.ErrorMatches, `invalid template "team-\[" for "team-default"`)
+}
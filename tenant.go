@@ -0,0 +1,84 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+)
+
+type tenantKey struct{}
+
+// TenantFromContext returns the tenant attached to the given
+// context, and reports whether there is one.
+func TenantFromContext(ctx context.Context) (string, bool) {
+	tenant, ok := ctx.Value(tenantKey{}).(string)
+	return tenant, ok
+}
+
+// ContextWithTenant returns a context with the given tenant attached,
+// as returned by TenantFromContext. The HTTP handler attaches the
+// tenant returned by HandlerParams.TenantFromRequest; it can be
+// attached explicitly when using the Manager directly.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// maxTenantLen holds the maximum length of a tenant
+// returned by HandlerParams.TenantFromRequest.
+const maxTenantLen = 128
+
+// maxInitializedTenants holds the maximum number of tenants that a
+// Manager remembers having initialized. When there are more, they are
+// forgotten and initialized again when next seen, which is harmless.
+var maxInitializedTenants = 10000
+
+// tenantContext returns the given context with the tenant of the given
+// request attached. The tenant is not initialized until the request
+// has been authenticated; see authenticate.
+func (h *handler) tenantContext(ctx context.Context, req *http.Request) (context.Context, error) {
+	tenant, err := h.p.TenantFromRequest(req)
+	if err != nil {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "cannot determine tenant: %v", err)
+	}
+	if !validTenant(tenant) {
+		return nil, httprequest.Errorf(httprequest.CodeBadRequest, "invalid tenant %q", tenant)
+	}
+	return ContextWithTenant(ctx, tenant), nil
+}
+
+// validTenant reports whether the given tenant is non-empty, not too
+// long and free of control characters.
+func validTenant(tenant string) bool {
+	return tenant != "" && len(tenant) <= maxTenantLen && strings.IndexFunc(tenant, unicode.IsControl) == -1
+}
+
+// initTenant creates the system ACLs of the given tenant, whose
+// context is given, unless they have already been created through
+// the Manager. Concurrent calls for a new tenant may both create
+// them, which is harmless as CreateACL does nothing for an existing
+// ACL.
+func (m *Manager) initTenant(ctx context.Context, tenant string) error {
+	m.tenantMu.Lock()
+	done := m.tenants[tenant]
+	m.tenantMu.Unlock()
+	if done {
+		return nil
+	}
+	if err := createSystemACLs(ctx, m.p); err != nil {
+		return errgo.NoteMask(err, "cannot initialize tenant", isContextError)
+	}
+	m.tenantMu.Lock()
+	defer m.tenantMu.Unlock()
+	if m.tenants == nil || len(m.tenants) >= maxInitializedTenants {
+		m.tenants = make(map[string]bool)
+	}
+	m.tenants[tenant] = true
+	return nil
+}
@@ -0,0 +1,148 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	"gopkg.in/errgo.v1"
+	"gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+func TestTenants(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	kv := memsimplekv.NewStore()
+	store := aclstore.NewACLStoreWithParams(aclstore.StoreParams{
+		KV:        kv,
+		Namespace: "default:",
+		TenantNamespace: func(tenant string) string {
+			return "tenant-" + tenant + ":"
+		},
+	})
+	var changes []*params.ACLChange
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             store,
+		InitialAdminUsers: []string{"boss"},
+		Audit: func(ctx context.Context, change *params.ACLChange) {
+			changes = append(changes, change)
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			user := req.Header.Get("User")
+			if user == "" {
+				return nil, errgo.WithCausef(nil, aclstore.ErrUnauthorized, "no user")
+			}
+			return &namedIdentity{user}, nil
+		},
+		TenantFromRequest: func(req *http.Request) (string, error) {
+			tenant := req.URL.Query().Get("tenant")
+			if tenant == "" {
+				return "", errgo.Newf("no tenant specified")
+			}
+			return tenant, nil
+		},
+	}))
+	defer srv.Close()
+
+	call := func(tenant, method, path string, body interface{}, expectStatus int, expectResponse interface{}) {
+		c.Helper()
+		assertJSONCallAs(c, "boss", method, srv.URL+path+"?tenant="+tenant, body, expectStatus, expectResponse)
+	}
+	// Each tenant gets its own admin ACL.
+	call("one", "GET", "/admin", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"boss"},
+	})
+	call("two", "GET", "/admin", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"boss"},
+	})
+
+	// ACLs with the same name are kept apart.
+	err = m.CreateACL(aclstore.ContextWithTenant(ctx, "one"), "someacl")
+	c.Assert(err, qt.Equals, nil)
+	call("one", "PUT", "/someacl", params.SetACLRequestBody{
+		Users: []string{"alice"},
+	}, http.StatusOK, nil)
+	// The tenant is recorded with the change.
+	c.Assert(changes[len(changes)-1], qt.DeepEquals, &params.ACLChange{
+		Name:      "someacl",
+		Operation: aclstore.OpSet,
+		Users:     []string{"alice"},
+		Actor:     "boss",
+		Tenant:    "one",
+	})
+	call("two", "GET", "/someacl", nil, http.StatusNotFound, &httprequest.RemoteError{
+		Message: "ACL not found",
+		Code:    aclstore.CodeACLNotFound,
+	})
+	err = m.CreateACL(aclstore.ContextWithTenant(ctx, "two"), "someacl", "bob")
+	c.Assert(err, qt.Equals, nil)
+	call("one", "GET", "/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice"},
+	})
+	call("two", "GET", "/someacl", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"bob"},
+	})
+
+	// Changing the admins of one tenant does not
+	// affect the other.
+	call("two", "PUT", "/admin", params.SetACLRequestBody{
+		Users: []string{"boss", "alice"},
+	}, http.StatusOK, nil)
+	call("one", "GET", "/admin", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"boss"},
+	})
+
+	// The ACLs without a tenant are separate too.
+	names, err := m.ACLNames(ctx, false)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.DeepEquals, []string{"admin"})
+	names, err = m.ACLNames(aclstore.ContextWithTenant(ctx, "one"), false)
+	c.Assert(err, qt.Equals, nil)
+	sort.Strings(names)
+	c.Assert(names, qt.DeepEquals, []string{"admin", "someacl"})
+
+	// Aliases are kept apart too.
+	err = m.CreateAlias(aclstore.ContextWithTenant(ctx, "one"), "somealias", "someacl")
+	c.Assert(err, qt.Equals, nil)
+	call("one", "GET", "/somealias", nil, http.StatusOK, params.GetACLResponse{
+		Users: []string{"alice"},
+	})
+	call("two", "GET", "/somealias", nil, http.StatusNotFound, &httprequest.RemoteError{
+		Message: "ACL not found",
+		Code:    aclstore.CodeACLNotFound,
+	})
+
+	// Unauthenticated requests do not initialize a tenant.
+	assertJSONCall(c, "GET", srv.URL+"/admin?tenant=three", nil, http.StatusUnauthorized, &httprequest.RemoteError{
+		Message: "no user",
+		Code:    httprequest.CodeUnauthorized,
+	})
+	names, err = m.ACLNames(aclstore.ContextWithTenant(ctx, "three"), true)
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(names, qt.HasLen, 0)
+
+	// Invalid tenants are rejected.
+	call("%01", "GET", "/admin", nil, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `invalid tenant "\x01"`,
+		Code:    httprequest.CodeBadRequest,
+	})
+
+	// A request whose tenant cannot be found fails.
+	assertJSONCallAs(c, "boss", "GET", srv.URL+"/admin", nil, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: "cannot determine tenant: no tenant specified",
+		Code:    httprequest.CodeBadRequest,
+	})
+}
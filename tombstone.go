@@ -0,0 +1,169 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/juju/simplekv"
+	"gopkg.in/errgo.v1"
+)
+
+// deletedPrefix is prefixed, after the namespace, to the key of the
+// entry that marks each ACL that has been deleted, so that ACLs can be
+// listed without reading each one to check whether it is just the
+// marker left by a deleted ACL. As with indexPrefix, no ACL created by
+// a Manager can start with it.
+const deletedPrefix = "__deleted:"
+
+// deletedMark holds the value of the entry that marks a deleted ACL.
+var deletedMark = []byte("deleted")
+
+// KeyDeleter may be implemented by the simplekv store given to
+// NewACLStoreWithParams so that PurgeDeleted can remove the markers
+// left by deleted ACLs once they can no longer be restored. Otherwise
+// the markers are kept until the underlying store garbage collects
+// them.
+type KeyDeleter interface {
+	// DeleteIfUnchanged removes the entry with the given key if it
+	// still holds the given value, and reports whether it did.
+	DeleteIfUnchanged(ctx context.Context, key string, value []byte) (bool, error)
+}
+
+// deletedKey returns the key in s.kv of the entry that marks the ACL
+// with the given name as deleted for an operation with the given
+// context.
+func (s *kvStore) deletedKey(ctx context.Context, aclName string) string {
+	return s.namespace(ctx) + deletedPrefix + aclName
+}
+
+// isDeletedKey reports whether the given key in s.kv marks a deleted
+// ACL rather than holding an ACL for an operation with the given
+// context.
+func (s *kvStore) isDeletedKey(ctx context.Context, key string) bool {
+	return strings.HasPrefix(key, s.namespace(ctx)+deletedPrefix)
+}
+
+// deletedACLs returns the names of the ACLs that may have been
+// deleted. Every ACL that has been deleted is included, but an ACL
+// that has since been created again may be too.
+func (s *kvStore) deletedACLs(ctx context.Context) (map[string]bool, error) {
+	lister, ok := s.kv.(simplekv.KeyLister)
+	if !ok {
+		return nil, errgo.Newf("cannot list ACLs")
+	}
+	keys, err := lister.Keys(ctx)
+	if err != nil {
+		return nil, errgo.Mask(err, isContextError)
+	}
+	prefix := s.namespace(ctx) + deletedPrefix
+	deleted := make(map[string]bool)
+	for _, key := range keys {
+		if strings.HasPrefix(key, prefix) {
+			deleted[key[len(prefix):]] = true
+		}
+	}
+	return deleted, nil
+}
+
+// markDeleted marks the ACL with the given name as deleted. It must be
+// called after the ACL has been replaced by its marker.
+func (s *kvStore) markDeleted(ctx context.Context, aclName string) error {
+	if err := s.kv.Set(ctx, s.deletedKey(ctx, aclName), deletedMark, time.Time{}); err != nil {
+		return errgo.NoteMask(err, fmt.Sprintf("cannot mark ACL %q as deleted", aclName), isContextError)
+	}
+	return nil
+}
+
+// unmarkDeleted removes the marks from the ACLs with the given names.
+// If s.kv implements KeyDeleter, the entries are removed; otherwise
+// they are left empty to expire. Each ACL is then read again, and
+// marked again if it has been deleted meanwhile.
+func (s *kvStore) unmarkDeleted(ctx context.Context, aclNames ...string) error {
+	deleter, _ := s.kv.(KeyDeleter)
+	for _, aclName := range aclNames {
+		key := s.deletedKey(ctx, aclName)
+		var err error
+		if deleter != nil {
+			_, err = deleter.DeleteIfUnchanged(ctx, key, deletedMark)
+		} else {
+			err = s.kv.Set(ctx, key, []byte{}, s.p.Clock.Now())
+		}
+		if err != nil {
+			return errgo.NoteMask(err, fmt.Sprintf("cannot unmark deleted ACL %q", aclName), isContextError)
+		}
+		val, err := s.kv.Get(ctx, s.key(ctx, aclName))
+		if err != nil {
+			if errgo.Cause(err) == simplekv.ErrNotFound {
+				continue
+			}
+			return errgo.Mask(err, isContextError)
+		}
+		if isDeleted(val) {
+			if err := s.markDeleted(ctx, aclName); err != nil {
+				return errgo.Mask(err, isContextError)
+			}
+		}
+	}
+	return nil
+}
+
+// reclaimDeleted reclaims the markers of the ACLs with the given names
+// that can no longer be restored. If s.kv implements KeyDeleter, the
+// markers are removed; ACLs that no longer have a marker are no longer
+// marked as deleted.
+func (s *kvStore) reclaimDeleted(ctx context.Context, aclNames []string) error {
+	deleter, _ := s.kv.(KeyDeleter)
+	var gone []string
+	for _, aclName := range aclNames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		key := s.key(ctx, aclName)
+		val, err := s.kv.Get(ctx, key)
+		if err != nil {
+			if errgo.Cause(err) != simplekv.ErrNotFound {
+				return errgo.Mask(err, isContextError)
+			}
+			// The store has garbage collected the marker.
+			gone = append(gone, aclName)
+			continue
+		}
+		if !hasHeader(val) {
+			gone = append(gone, aclName)
+			continue
+		}
+		h, _, err := decodeValue(val)
+		if err != nil {
+			return errgo.Notef(err, "cannot reclaim ACL %q", aclName)
+		}
+		switch {
+		case !h.Deleted:
+			// The ACL has been created again.
+			gone = append(gone, aclName)
+		case deleter != nil && !s.restorable(h):
+			ok, err := deleter.DeleteIfUnchanged(ctx, key, val)
+			if err != nil {
+				return errgo.NoteMask(err, fmt.Sprintf("cannot reclaim ACL %q", aclName), isContextError)
+			}
+			if !ok {
+				// The ACL has changed since it was read.
+				continue
+			}
+			gone = append(gone, aclName)
+			// Remove any details entry left empty
+			// by discardDetails.
+			if _, err := deleter.DeleteIfUnchanged(ctx, s.detailsKey(ctx, aclName), []byte("{}")); err != nil {
+				return errgo.NoteMask(err, fmt.Sprintf("cannot reclaim ACL %q", aclName), isContextError)
+			}
+		}
+	}
+	if len(gone) == 0 {
+		return nil
+	}
+	return errgo.Mask(s.unmarkDeleted(ctx, gone...), isContextError)
+}
@@ -0,0 +1,91 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"context"
+	"net/http"
+)
+
+// Tracer is implemented by tracing systems to trace the HTTP requests
+// served by a handler. The aclotel package provides an implementation
+// that uses OpenTelemetry.
+type Tracer interface {
+	// StartRequest starts tracing the given request. It returns
+	// the context to use for handling the request, which is passed
+	// to the ACL store, and a function that is called when the
+	// request has been handled, with the operation and ACL name of
+	// the request, if known, and the HTTP status of the response.
+	StartRequest(req *http.Request) (ctx context.Context, end func(op Operation, aclName string, status int))
+}
+
+type traceInfoKey struct{}
+
+// traceInfo holds the details of a request that are reported to a
+// Tracer once the request has been handled.
+type traceInfo struct {
+	op      Operation
+	aclName string
+}
+
+// setTraceInfo records the operation and ACL name of the request
+// with the given context, if it is being traced.
+func setTraceInfo(ctx context.Context, op Operation, aclName string) {
+	if info, _ := ctx.Value(traceInfoKey{}).(*traceInfo); info != nil {
+		info.op = op
+		info.aclName = aclName
+	}
+}
+
+// startTrace starts tracing the given request with h.p.Tracer, returning
+// the response writer and request to use for handling the request and a
+// function that must be called when it has been handled.
+func (h *handler) startTrace(w http.ResponseWriter, req *http.Request) (http.ResponseWriter, *http.Request, func()) {
+	ctx, end := h.p.Tracer.StartRequest(req)
+	info := &traceInfo{}
+	req = req.WithContext(context.WithValue(ctx, traceInfoKey{}, info))
+	sw := &statusResponseWriter{
+		ResponseWriter: w,
+	}
+	return sw, req, func() {
+		status := sw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		end(info.op, info.aclName, status)
+	}
+}
+
+// statusResponseWriter is an http.ResponseWriter
+// that records the status of the response.
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader implements http.ResponseWriter.WriteHeader.
+func (w *statusResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write implements io.Writer.
+func (w *statusResponseWriter) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush implements http.Flusher.
+func (w *statusResponseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
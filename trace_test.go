@@ -0,0 +1,24 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	qt "github.com/frankban/quicktest"
+
+	aclstore "github.com/juju/aclstore/v2"
+)
+
+func TestStatusResponseWriterFlush(t *testing.T) {
+	c := qt.New(t)
+	rec := httptest.NewRecorder()
+	w := aclstore.NewStatusResponseWriter(rec)
+	_, err := w.Write([]byte("hello"))
+	c.Assert(err, qt.Equals, nil)
+	w.Flush()
+	c.Assert(rec.Flushed, qt.Equals, true)
+	c.Assert(rec.Body.String(), qt.Equals, "hello")
+}
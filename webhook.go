@@ -0,0 +1,251 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"gopkg.in/errgo.v1"
+	httprequest "gopkg.in/httprequest.v1"
+
+	"github.com/juju/aclstore/v2/params"
+)
+
+// The following operations are reported in ACL change notifications.
+const (
+	OpCreate  = "create"
+	OpSet     = "set"
+	OpAdd     = "add"
+	OpRemove  = "remove"
+	OpClear   = "clear"
+	OpDelete  = "delete"
+	OpRestore = "restore"
+	OpSwap    = "swap"
+)
+
+// Webhook holds the configuration for an HTTP endpoint that is
+// notified when ACLs are changed.
+type Webhook struct {
+	// URL holds the URL that change notifications are POSTed to.
+	// Each notification has a params.ACLChange JSON body.
+	URL string
+
+	// Authorization, if non-empty, holds the value of the
+	// Authorization header sent with each notification.
+	Authorization string
+
+	// Retries holds the number of times that delivery of a
+	// notification is retried after a failure.
+	Retries int
+
+	// RetryDelay holds the time to wait between delivery attempts.
+	// If this is zero, DefaultWebhookRetryDelay is used.
+	RetryDelay time.Duration
+
+	// Timeout holds the time allowed for each delivery attempt.
+	// If this is zero, DefaultWebhookTimeout is used.
+	Timeout time.Duration
+
+	// QueueSize holds the maximum number of notifications that
+	// may wait to be delivered. Notifications are delivered one
+	// at a time, in order, and any that would take the queue over
+	// this size are dropped and counted by
+	// Manager.DroppedNotifications. If this is zero,
+	// DefaultWebhookQueueSize is used.
+	QueueSize int
+
+	// Doer is used to make the HTTP requests. If this is nil,
+	// http.DefaultClient is used.
+	Doer httprequest.Doer
+}
+
+// DefaultWebhookRetryDelay holds the time to wait between attempts
+// to deliver a webhook notification when Webhook.RetryDelay is zero.
+const DefaultWebhookRetryDelay = time.Second
+
+// DefaultWebhookTimeout holds the time allowed for each attempt to
+// deliver a webhook notification when Webhook.Timeout is zero.
+const DefaultWebhookTimeout = 10 * time.Second
+
+// DefaultWebhookQueueSize holds the maximum number of webhook
+// notifications waiting to be delivered when Webhook.QueueSize
+// is zero.
+const DefaultWebhookQueueSize = 1000
+
+// webhookQueue holds the notifications waiting to be delivered
+// to a webhook. A single goroutine delivers them, which exits when
+// the queue is empty.
+type webhookQueue struct {
+	w    *Webhook
+	size int
+
+	// mu guards the fields below it.
+	mu sync.Mutex
+
+	// changes holds the notifications that have not
+	// been delivered yet.
+	changes []*params.ACLChange
+
+	// running holds whether the delivery
+	// goroutine is running.
+	running bool
+
+	// dropped holds the number of notifications that
+	// have been dropped because the queue was full.
+	dropped uint64
+}
+
+// newWebhookQueue returns a queue that delivers
+// notifications to the given webhook.
+func newWebhookQueue(w *Webhook) *webhookQueue {
+	size := w.QueueSize
+	if size == 0 {
+		size = DefaultWebhookQueueSize
+	}
+	return &webhookQueue{
+		w:    w,
+		size: size,
+	}
+}
+
+// add adds the given change to the queue, starting the
+// delivery goroutine if needed.
+func (q *webhookQueue) add(change *params.ACLChange) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.changes) >= q.size {
+		q.dropped++
+		return
+	}
+	q.changes = append(q.changes, change)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+// run delivers the queued notifications until there are none left.
+func (q *webhookQueue) run() {
+	for {
+		q.mu.Lock()
+		if len(q.changes) == 0 {
+			q.running = false
+			q.changes = nil
+			q.mu.Unlock()
+			return
+		}
+		change := q.changes[0]
+		q.changes[0] = nil
+		q.changes = q.changes[1:]
+		q.mu.Unlock()
+		q.w.notify(change)
+	}
+}
+
+// droppedCount returns the number of notifications
+// that have been dropped.
+func (q *webhookQueue) droppedCount() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// DroppedNotifications returns the number of webhook notifications
+// that have not been delivered because too many were waiting to be
+// delivered. Notifications whose delivery failed are not counted.
+func (m *Manager) DroppedNotifications() uint64 {
+	if m.webhook == nil {
+		return 0
+	}
+	return m.webhook.droppedCount()
+}
+
+// changed is called after an ACL has been successfully changed by
+// the given operation, which involved the given users. The ACL and
+// its meta-ACL are removed from the cache so that the change is seen
+// by later reads, and the change is reported to the audit function,
+// the webhook and the events channel.
+func (m *Manager) changed(ctx context.Context, aclName string, op string, users []string) {
+	m.invalidate(aclName, metaName(aclName))
+	change := &params.ACLChange{
+		Name:      aclName,
+		Operation: op,
+		Users:     users,
+	}
+	change.Tenant, _ = TenantFromContext(ctx)
+	if identity, ok := IdentityFromContext(ctx); ok {
+		if identity, ok := identity.(NamedIdentity); ok {
+			change.Actor = identity.Name()
+		}
+	}
+	if m.p.Audit != nil {
+		m.p.Audit(ctx, change)
+	}
+	if m.webhook != nil {
+		m.webhook.add(change)
+	}
+	m.sendEvent(change)
+}
+
+// notify delivers the given change to the webhook,
+// retrying as configured.
+func (w *Webhook) notify(change *params.ACLChange) {
+	data, err := json.Marshal(change)
+	if err != nil {
+		// This should never happen.
+		return
+	}
+	delay := w.RetryDelay
+	if delay == 0 {
+		delay = DefaultWebhookRetryDelay
+	}
+	for i := 0; i <= w.Retries; i++ {
+		if i > 0 {
+			time.Sleep(delay)
+		}
+		if err := w.post(data); err == nil {
+			return
+		}
+	}
+}
+
+// post makes a single attempt to deliver the given notification body.
+func (w *Webhook) post(data []byte) error {
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(data))
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = DefaultWebhookTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	if w.Authorization != "" {
+		req.Header.Set("Authorization", w.Authorization)
+	}
+	doer := w.Doer
+	if doer == nil {
+		doer = http.DefaultClient
+	}
+	resp, err := doer.Do(req)
+	if err != nil {
+		return errgo.Mask(err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errgo.Newf("webhook returned status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,353 @@
+// Copyright 2018 Canonical Ltd.
+// Licensed under the LGPL, see LICENCE file for details.
+
+package aclstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	qt "github.com/frankban/quicktest"
+	"github.com/juju/simplekv/memsimplekv"
+	httprequest "gopkg.in/httprequest.v1"
+
+	aclstore "github.com/juju/aclstore/v2"
+	"github.com/juju/aclstore/v2/params"
+)
+
+func TestWebhook(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	hook := newWebhookServer(c, 0)
+	defer hook.Close()
+
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+		InitialAdminUsers: []string{"alice"},
+		Webhook: &aclstore.Webhook{
+			URL:           hook.URL,
+			Authorization: "Bearer secret",
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+		Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+			return allowed{}, nil
+		},
+	}))
+	defer srv.Close()
+
+	err = m.CreateACL(ctx, "foo", "bob")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(hook.next(c), qt.DeepEquals, params.ACLChange{
+		Name:      "foo",
+		Operation: "create",
+		Users:     []string{"bob"},
+	})
+
+	// Creating an ACL that already exists changes nothing.
+	err = m.CreateACL(ctx, "foo", "eve")
+	c.Assert(err, qt.Equals, nil)
+	hook.assertNoNotification(c)
+
+	assertJSONCall(c, "POST", srv.URL+"/foo", params.ModifyACLRequestBody{
+		Add: []string{"charlie"},
+	}, http.StatusOK, params.ModifyACLResponse{
+		Added: 1,
+	})
+	c.Assert(hook.next(c), qt.DeepEquals, params.ACLChange{
+		Name:      "foo",
+		Operation: "add",
+		Users:     []string{"charlie"},
+	})
+
+	assertJSONCall(c, "POST", srv.URL+"/foo", params.ModifyACLRequestBody{
+		Remove: []string{"bob"},
+	}, http.StatusOK, params.ModifyACLResponse{
+		Removed: []string{"bob"},
+	})
+	c.Assert(hook.next(c), qt.DeepEquals, params.ACLChange{
+		Name:      "foo",
+		Operation: "remove",
+		Users:     []string{"bob"},
+	})
+
+	assertJSONCall(c, "PUT", srv.URL+"/foo", params.SetACLRequestBody{
+		Users: []string{"daisy"},
+	}, http.StatusOK, nil)
+	c.Assert(hook.next(c), qt.DeepEquals, params.ACLChange{
+		Name:      "foo",
+		Operation: "set",
+		Users:     []string{"daisy"},
+	})
+
+	// Failed changes are not notified.
+	assertJSONCall(c, "PUT", srv.URL+"/foo", params.SetACLRequestBody{
+		Users: []string{""},
+	}, http.StatusBadRequest, &httprequest.RemoteError{
+		Message: `invalid user name ""`,
+		Code:    httprequest.CodeBadRequest,
+	})
+	hook.assertNoNotification(c)
+
+	c.Assert(hook.authorization(), qt.DeepEquals, []string{"Bearer secret", "Bearer secret", "Bearer secret", "Bearer secret"})
+}
+
+var auditActorTests = []struct {
+	testName    string
+	identity    aclstore.Identity
+	expectActor string
+}{{
+	testName:    "named",
+	identity:    &namedIdentity{"boss"},
+	expectActor: "boss",
+}, {
+	testName: "anonymous",
+	identity: allowed{},
+}}
+
+func TestAuditActor(t *testing.T) {
+	c := qt.New(t)
+	ctx := context.Background()
+	for _, test := range auditActorTests {
+		c.Run(test.testName, func(c *qt.C) {
+			var changes []params.ACLChange
+			m, err := aclstore.NewManager(ctx, aclstore.Params{
+				Store:             aclstore.NewACLStore(memsimplekv.NewStore()),
+				InitialAdminUsers: []string{"boss"},
+				Audit: func(ctx context.Context, change *params.ACLChange) {
+					changes = append(changes, *change)
+				},
+			})
+			c.Assert(err, qt.Equals, nil)
+			err = m.CreateACL(ctx, "someacl")
+			c.Assert(err, qt.Equals, nil)
+			srv := httptest.NewServer(m.NewHandler(aclstore.HandlerParams{
+				Authenticate: func(ctx context.Context, w http.ResponseWriter, req *http.Request) (aclstore.Identity, error) {
+					return test.identity, nil
+				},
+			}))
+			defer srv.Close()
+			assertJSONCall(c, "POST", srv.URL+"/someacl", params.ModifyACLRequestBody{
+				Add: []string{"alice"},
+			}, http.StatusOK, params.ModifyACLResponse{
+				Added: 1,
+			})
+			c.Assert(changes, qt.DeepEquals, []params.ACLChange{{
+				// Changes made directly through the Manager have no actor.
+				Name:      "someacl",
+				Operation: aclstore.OpCreate,
+			}, {
+				Name:      "someacl",
+				Operation: aclstore.OpAdd,
+				Users:     []string{"alice"},
+				Actor:     test.expectActor,
+			}})
+		})
+	}
+}
+
+func TestWebhookRetry(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	hook := newWebhookServer(c, 2)
+	defer hook.Close()
+
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+		Webhook: &aclstore.Webhook{
+			URL:        hook.URL,
+			Retries:    2,
+			RetryDelay: time.Millisecond,
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "bob")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(hook.next(c), qt.DeepEquals, params.ACLChange{
+		Name:      "foo",
+		Operation: "create",
+		Users:     []string{"bob"},
+	})
+	c.Assert(hook.authorization(), qt.HasLen, 3)
+}
+
+func TestWebhookRetriesExhausted(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	hook := newWebhookServer(c, 2)
+	defer hook.Close()
+
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+		Webhook: &aclstore.Webhook{
+			URL:        hook.URL,
+			Retries:    1,
+			RetryDelay: time.Millisecond,
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "bob")
+	c.Assert(err, qt.Equals, nil)
+	hook.assertNoNotification(c)
+	c.Assert(hook.authorization(), qt.HasLen, 2)
+}
+
+func TestWebhookQueueFull(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	received := make(chan string, 10)
+	release := make(chan struct{})
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var change params.ACLChange
+		json.NewDecoder(req.Body).Decode(&change)
+		received <- change.Name
+		<-release
+	}))
+	defer hook.Close()
+
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+		Webhook: &aclstore.Webhook{
+			URL:       hook.URL,
+			QueueSize: 1,
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "a")
+	c.Assert(err, qt.Equals, nil)
+	c.Assert(<-received, qt.Equals, "a")
+
+	// While the first notification is being delivered, one
+	// more can wait and the rest are dropped.
+	for _, name := range []string{"b", "c", "d"} {
+		err := m.CreateACL(ctx, name)
+		c.Assert(err, qt.Equals, nil)
+	}
+	c.Assert(m.DroppedNotifications(), qt.Equals, uint64(2))
+	close(release)
+	c.Assert(<-received, qt.Equals, "b")
+	select {
+	case name := <-received:
+		c.Fatalf("unexpected notification for %q", name)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWebhookTimeout(t *testing.T) {
+	ctx := context.Background()
+	c := qt.New(t)
+	hook := newWebhookServer(c, 0)
+	defer hook.Close()
+	stuck := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Never respond until the client gives up, which
+		// is only noticed once the body has been read.
+		ioutil.ReadAll(req.Body)
+		<-req.Context().Done()
+	}))
+	defer stuck.Close()
+
+	// attempts is only used by the webhook
+	// delivery goroutine.
+	attempts := 0
+	m, err := aclstore.NewManager(ctx, aclstore.Params{
+		Store: aclstore.NewACLStore(memsimplekv.NewStore()),
+		Webhook: &aclstore.Webhook{
+			URL:        hook.URL,
+			Retries:    1,
+			RetryDelay: time.Millisecond,
+			Timeout:    50 * time.Millisecond,
+			Doer: doerFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				if attempts == 1 {
+					// Send the first attempt to a server that
+					// doesn't respond.
+					req.URL.Host = stuck.Listener.Addr().String()
+				}
+				return http.DefaultClient.Do(req)
+			}),
+		},
+	})
+	c.Assert(err, qt.Equals, nil)
+	err = m.CreateACL(ctx, "foo", "bob")
+	c.Assert(err, qt.Equals, nil)
+	// The first attempt times out and the retry succeeds.
+	c.Assert(hook.next(c), qt.DeepEquals, params.ACLChange{
+		Name:      "foo",
+		Operation: "create",
+		Users:     []string{"bob"},
+	})
+}
+
+type doerFunc func(req *http.Request) (*http.Response, error)
+
+func (f doerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// webhookServer is a test HTTP server that captures
+// the ACL change notifications sent to it.
+type webhookServer struct {
+	*httptest.Server
+	changes chan params.ACLChange
+
+	mu       sync.Mutex
+	failures int
+	auth     []string
+}
+
+// newWebhookServer returns a new webhookServer that
+// fails the given number of requests before succeeding.
+func newWebhookServer(c *qt.C, failures int) *webhookServer {
+	s := &webhookServer{
+		changes:  make(chan params.ACLChange, 10),
+		failures: failures,
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.auth = append(s.auth, req.Header.Get("Authorization"))
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		var change params.ACLChange
+		if err := json.NewDecoder(req.Body).Decode(&change); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.changes <- change
+	}))
+	return s
+}
+
+func (s *webhookServer) next(c *qt.C) params.ACLChange {
+	select {
+	case change := <-s.changes:
+		return change
+	case <-time.After(5 * time.Second):
+		c.Fatalf("timed out waiting for webhook notification")
+	}
+	panic("unreachable")
+}
+
+func (s *webhookServer) assertNoNotification(c *qt.C) {
+	select {
+	case change := <-s.changes:
+		c.Fatalf("unexpected webhook notification %#v", change)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func (s *webhookServer) authorization() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.auth...)
+}